@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/julienschmidt/httprouter"
+
+	"mock-db-router/adminapi"
+)
+
+const adminPathPrefix = "/__admin/"
+
+func newAdminRouter() *httprouter.Router {
+	router := httprouter.New()
+	router.GET(adminPathPrefix+"mocks", listMocksHandler)
+	router.POST(adminPathPrefix+"mocks", createMocksHandler)
+	router.POST(adminPathPrefix+"mocks/bulk", bulkMocksHandler)
+	router.GET(adminPathPrefix+"mocks/:id", getMockHandler)
+	router.PUT(adminPathPrefix+"mocks/:id", updateMockHandler)
+	router.DELETE(adminPathPrefix+"mocks/:id", deleteMockHandler)
+	router.GET(adminPathPrefix+"mock-stats", mockStatsHandler)
+	router.DELETE(adminPathPrefix+"mock-stats", resetMockStatsHandler)
+	router.GET(adminPathPrefix+"stale-mocks", staleMocksHandler)
+	router.GET(adminPathPrefix+"lint", lintHandler)
+	router.GET(adminPathPrefix+"mock-schema.json", mockSchemaHandler)
+	router.DELETE(adminPathPrefix+"stale-mocks", deleteStaleMocksHandler)
+	router.GET(adminPathPrefix+"profiles", listProfilesHandler)
+	router.PUT(adminPathPrefix+"profiles/:name", putProfileHandler)
+	router.DELETE(adminPathPrefix+"profiles/:name", deleteProfileHandler)
+	router.PUT(adminPathPrefix+"profile", activateProfileHandler)
+	router.DELETE(adminPathPrefix+"profile", deactivateProfileHandler)
+	router.GET(adminPathPrefix+"network", getNetworkHandler)
+	router.PUT(adminPathPrefix+"network", setNetworkHandler)
+	router.DELETE(adminPathPrefix+"network", resetNetworkHandler)
+	router.PUT(adminPathPrefix+"network/profiles/:name", putNetworkProfileHandler)
+	router.GET(adminPathPrefix+"concurrency", getConcurrencyHandler)
+	router.PUT(adminPathPrefix+"concurrency", setConcurrencyHandler)
+	router.DELETE(adminPathPrefix+"concurrency", resetConcurrencyHandler)
+	router.POST(adminPathPrefix+"reset", resetHandler)
+	router.POST(adminPathPrefix+"cache/flush", flushCacheHandler)
+	router.GET(adminPathPrefix+"gitops", gitSyncStatusHandler)
+	router.POST(adminPathPrefix+"gitops/sync", gitSyncHandler)
+	router.GET(adminPathPrefix+"kubernetes", kubernetesStatusHandler)
+	router.GET(adminPathPrefix+"snapshot", getSnapshotHandler)
+	router.PUT(adminPathPrefix+"snapshot", restoreSnapshotHandler)
+	router.GET(adminPathPrefix+"trash", listTrashHandler)
+	router.DELETE(adminPathPrefix+"trash", emptyTrashHandler)
+	router.POST(adminPathPrefix+"trash/:id/restore", restoreMockHandler)
+	router.DELETE(adminPathPrefix+"trash/:id", purgeMockHandler)
+	router.GET(adminPathPrefix+"clock", getClockHandler)
+	router.PUT(adminPathPrefix+"clock", setClockHandler)
+	router.DELETE(adminPathPrefix+"clock", resetClockHandler)
+	router.PUT(adminPathPrefix+"seed", setSeedHandler)
+	router.DELETE(adminPathPrefix+"seed", resetSeedsHandler)
+	router.GET(adminPathPrefix+"contract/violations", contractViolationsHandler)
+	router.DELETE(adminPathPrefix+"contract/violations", clearContractViolationsHandler)
+	router.GET(adminPathPrefix+"contract/coverage", contractCoverageHandler)
+	router.POST(adminPathPrefix+"contract/coverage", contractCoverageHandler)
+	router.GET(adminPathPrefix+"mirror/discrepancies", mirrorDiscrepanciesHandler)
+	router.DELETE(adminPathPrefix+"mirror/discrepancies", clearMirrorDiscrepanciesHandler)
+	router.POST(adminPathPrefix+"journal/replay", replayHandler)
+	router.POST(adminPathPrefix+"journal/prune", pruneJournalHandler)
+	router.POST(adminPathPrefix+"journal/mocks", journalMocksHandler)
+	router.POST(adminPathPrefix+"verify", verifyHandler)
+	router.GET(adminPathPrefix+"report", reportHandler)
+	router.GET(adminPathPrefix+"requests/stream", journalStreamHandler)
+	router.DELETE(adminPathPrefix+"rate-limits", resetRateLimitsHandler)
+	router.DELETE(adminPathPrefix+"status-sequences", resetStatusSequencesHandler)
+	router.GET(adminPathPrefix+"circuit-breakers", listCircuitsHandler)
+	router.PUT(adminPathPrefix+"circuit-breakers", tripCircuitHandler)
+	router.DELETE(adminPathPrefix+"circuit-breakers", closeCircuitsHandler)
+	router.GET(adminPathPrefix+"metrics", metricsHandler)
+	router.GET(adminPathPrefix+"protobuf/messages", protobufMessagesHandler)
+	router.POST(adminPathPrefix+"soap/wsdl", importWSDLHandler)
+	router.POST(adminPathPrefix+"asyncapi", importAsyncAPIHandler)
+	router.GET(adminPathPrefix+"proxy/transforms", listProxyTransformsHandler)
+	router.PUT(adminPathPrefix+"proxy/transforms", putProxyTransformsHandler)
+	router.GET(adminPathPrefix+"rewrites", listRequestRewritesHandler)
+	router.PUT(adminPathPrefix+"rewrites", putRequestRewritesHandler)
+	router.GET(adminPathPrefix+"response-mutators", listResponseMutatorsHandler)
+	router.PUT(adminPathPrefix+"response-mutators", putResponseMutatorsHandler)
+	router.GET(adminPathPrefix+"workspace-defaults", listWorkspaceDefaultsHandler)
+	router.PUT(adminPathPrefix+"workspace-defaults/:workspace", putWorkspaceDefaultsHandler)
+	router.DELETE(adminPathPrefix+"workspace-defaults/:workspace", deleteWorkspaceDefaultsHandler)
+	router.GET(adminPathPrefix+"gateway", getGatewayHandler)
+	router.PUT(adminPathPrefix+"gateway", putGatewayHandler)
+	router.DELETE(adminPathPrefix+"gateway", deleteGatewayHandler)
+	router.GET(adminPathPrefix+"events", listAsyncEventsHandler)
+	router.DELETE(adminPathPrefix+"events", clearAsyncEventsHandler)
+	router.POST(adminPathPrefix+"events/:name/fire", fireAsyncEventHandler)
+	router.DELETE(adminPathPrefix+"events/:name", deleteAsyncEventHandler)
+	registerDebugHandlers(router)
+	router.GET(adminPathPrefix+"openapi.yaml", adminSpecYAMLHandler)
+	router.GET(adminPathPrefix+"openapi.json", adminSpecJSONHandler)
+	return router
+}
+
+var adminSpecJSON = sync.OnceValues(func() ([]byte, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(adminapi.OpenAPI)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+})
+
+func adminSpecYAMLHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(adminapi.OpenAPI)
+}
+
+func adminSpecJSONHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data, err := adminSpecJSON()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "invalid admin API document"})
+		log.Printf("Error loading admin API document: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,795 @@
+// Package adminapi is a typed client for the mock-db-router admin API. Its
+// types and methods mirror openapi.yaml, which the router also serves at
+// /__admin/openapi.yaml; change both together.
+package adminapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed openapi.yaml
+var OpenAPI []byte
+
+// MockSchema is the JSON Schema of mock definition files and imports.
+//
+//go:embed mock.schema.json
+var MockSchema []byte
+
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Header is sent with every request, e.g. for authentication.
+	Header http.Header
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTPClient: http.DefaultClient, Header: make(http.Header)}
+}
+
+// Error is returned for every response outside the 2xx range.
+type Error struct {
+	StatusCode int
+	Message    string
+	Conflicts  []MockConflict
+	// Violations locate the departures from MockSchema of rejected mocks.
+	Violations []SchemaViolation
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("admin API: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("admin API: %d: %s", e.StatusCode, e.Message)
+}
+
+// document is a request body sent as is rather than encoded as JSON.
+type document struct {
+	contentType string
+	data        []byte
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	endpoint := c.BaseURL + "/__admin/" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	contentType := "application/json"
+	if raw, ok := body.(json.RawMessage); ok {
+		reader = bytes.NewReader(raw)
+	} else if doc, ok := body.(document); ok {
+		reader, contentType = bytes.NewReader(doc.data), doc.contentType
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := responseError(resp.StatusCode, data)
+		if out != nil && len(data) > 0 && resp.StatusCode == http.StatusBadGateway {
+			// A failed sync still reports its status.
+			json.Unmarshal(data, out)
+		}
+		return apiErr
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if raw, ok := out.(*json.RawMessage); ok {
+		*raw = data
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func responseError(statusCode int, data []byte) *Error {
+	apiErr := &Error{StatusCode: statusCode}
+	var payload struct {
+		Error      string            `json:"error"`
+		Conflicts  []MockConflict    `json:"conflicts"`
+		Violations []SchemaViolation `json:"violations"`
+	}
+	if json.Unmarshal(data, &payload) == nil {
+		apiErr.Message, apiErr.Conflicts, apiErr.Violations = payload.Error, payload.Conflicts, payload.Violations
+	}
+	return apiErr
+}
+
+func forceQuery(force bool) url.Values {
+	if !force {
+		return nil
+	}
+	return url.Values{"force": {"true"}}
+}
+
+func workspaceQuery(workspace string) url.Values {
+	if workspace == "" {
+		return nil
+	}
+	return url.Values{"workspace": {workspace}}
+}
+
+func (c *Client) ListMocks(ctx context.Context, params ListMocksParams) (*MockList, error) {
+	query := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	set("q", params.Q)
+	set("method", params.Method)
+	set("workspace", params.Workspace)
+	set("sort", params.Sort)
+	set("cursor", params.Cursor)
+	if params.Status != 0 {
+		query.Set("status", strconv.Itoa(params.Status))
+	}
+	if params.Limit != 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Enabled != nil {
+		query.Set("enabled", strconv.FormatBool(*params.Enabled))
+	}
+	for _, label := range params.Labels {
+		query.Add("label", label)
+	}
+	var list MockList
+	return &list, c.do(ctx, http.MethodGet, "mocks", query, nil, &list)
+}
+
+func (c *Client) CreateMock(ctx context.Context, mock Mock, force bool) (*MockResult, error) {
+	var result MockResult
+	return &result, c.do(ctx, http.MethodPost, "mocks", forceQuery(force), mock, &result)
+}
+
+// ImportMocks creates all mocks in one transaction; none are created when
+// one is invalid or overlaps another without force.
+func (c *Client) ImportMocks(ctx context.Context, mocks []Mock, force bool) (*MockResult, error) {
+	if mocks == nil {
+		mocks = []Mock{}
+	}
+	var result MockResult
+	return &result, c.do(ctx, http.MethodPost, "mocks", forceQuery(force), mocks, &result)
+}
+
+func (c *Client) GetMock(ctx context.Context, id int) (*Mock, error) {
+	var mock Mock
+	return &mock, c.do(ctx, http.MethodGet, "mocks/"+strconv.Itoa(id), nil, nil, &mock)
+}
+
+func (c *Client) UpdateMock(ctx context.Context, id int, mock Mock, force bool) (*MockResult, error) {
+	var result MockResult
+	return &result, c.do(ctx, http.MethodPut, "mocks/"+strconv.Itoa(id), forceQuery(force), mock, &result)
+}
+
+func (c *Client) DeleteMock(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "mocks/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+func (c *Client) BulkMocks(ctx context.Context, req BulkRequest) (int64, error) {
+	var result struct {
+		Affected int64 `json:"affected"`
+	}
+	err := c.do(ctx, http.MethodPost, "mocks/bulk", nil, req, &result)
+	return result.Affected, err
+}
+
+func (c *Client) ListTrash(ctx context.Context) (*Trash, error) {
+	var trash Trash
+	return &trash, c.do(ctx, http.MethodGet, "trash", nil, nil, &trash)
+}
+
+func (c *Client) RestoreMock(ctx context.Context, id int, force bool) (*MockResult, error) {
+	var result MockResult
+	return &result, c.do(ctx, http.MethodPost, "trash/"+strconv.Itoa(id)+"/restore", forceQuery(force), nil, &result)
+}
+
+func (c *Client) PurgeMock(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "trash/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+func (c *Client) EmptyTrash(ctx context.Context) (int64, error) {
+	var result struct {
+		Purged int64 `json:"purged"`
+	}
+	err := c.do(ctx, http.MethodDelete, "trash", nil, nil, &result)
+	return result.Purged, err
+}
+
+func (c *Client) Reset(ctx context.Context, params ResetParams) (*ResetResult, error) {
+	query := url.Values{}
+	if len(params.Only) > 0 {
+		query.Set("only", strings.Join(params.Only, ","))
+	}
+	if params.Workspace != "" {
+		query.Set("workspace", params.Workspace)
+	}
+	var result ResetResult
+	return &result, c.do(ctx, http.MethodPost, "reset", query, nil, &result)
+}
+
+// FlushCache drops the cached mock lookups and upstream responses, so rows
+// changed directly in the database are used right away.
+func (c *Client) FlushCache(ctx context.Context) (*CacheFlushResult, error) {
+	var result CacheFlushResult
+	return &result, c.do(ctx, http.MethodPost, "cache/flush", nil, nil, &result)
+}
+
+// GetSnapshot returns the snapshot document unchanged, ready to be stored
+// and passed to RestoreSnapshot later.
+func (c *Client) GetSnapshot(ctx context.Context) (json.RawMessage, error) {
+	var snapshot json.RawMessage
+	err := c.do(ctx, http.MethodGet, "snapshot", nil, nil, &snapshot)
+	return snapshot, err
+}
+
+func (c *Client) RestoreSnapshot(ctx context.Context, snapshot json.RawMessage) (*RestoreResult, error) {
+	var result RestoreResult
+	return &result, c.do(ctx, http.MethodPut, "snapshot", nil, snapshot, &result)
+}
+
+func (c *Client) GetMockStats(ctx context.Context, params MockStatsParams) (*MockStats, error) {
+	query := url.Values{}
+	if params.Sort != "" {
+		query.Set("sort", params.Sort)
+	}
+	if params.Unused {
+		query.Set("unused", "true")
+	}
+	var stats MockStats
+	return &stats, c.do(ctx, http.MethodGet, "mock-stats", query, nil, &stats)
+}
+
+func (c *Client) ResetMockStats(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "mock-stats", nil, nil, nil)
+}
+
+func (p StaleMocksParams) query() url.Values {
+	query := url.Values{"days": {strconv.Itoa(p.Days)}, "label": p.Labels}
+	if p.Workspace != "" {
+		query.Set("workspace", p.Workspace)
+	}
+	if p.IncludeManaged {
+		query.Set("includeManaged", "true")
+	}
+	return query
+}
+
+func (c *Client) ListStaleMocks(ctx context.Context, params StaleMocksParams) (*StaleMocks, error) {
+	var stale StaleMocks
+	return &stale, c.do(ctx, http.MethodGet, "stale-mocks", params.query(), nil, &stale)
+}
+
+// DeleteStaleMocks moves the mocks ListStaleMocks reports for the same
+// parameters to the trash.
+func (c *Client) DeleteStaleMocks(ctx context.Context, params StaleMocksParams) (*StaleMocks, error) {
+	query := params.query()
+	query.Set("confirm", "true")
+	var stale StaleMocks
+	return &stale, c.do(ctx, http.MethodDelete, "stale-mocks", query, nil, &stale)
+}
+
+// GetMockSchema returns the mock schema a router validates imports with,
+// which MockSchema holds for the version of this package.
+func (c *Client) GetMockSchema(ctx context.Context) (json.RawMessage, error) {
+	var schema json.RawMessage
+	err := c.do(ctx, http.MethodGet, "mock-schema.json", nil, nil, &schema)
+	return schema, err
+}
+
+// Lint checks the stored mocks for errors that would otherwise show when a
+// request reaches them.
+func (c *Client) Lint(ctx context.Context) (*LintResult, error) {
+	var result LintResult
+	return &result, c.do(ctx, http.MethodGet, "lint", nil, nil, &result)
+}
+
+func (c *Client) ListProtobufMessages(ctx context.Context) ([]string, error) {
+	var result struct {
+		Messages []string `json:"messages"`
+	}
+	err := c.do(ctx, http.MethodGet, "protobuf/messages", nil, nil, &result)
+	return result.Messages, err
+}
+
+// ImportWSDL scaffolds one mock per SOAP operation of a WSDL document.
+func (c *Client) ImportWSDL(ctx context.Context, wsdl []byte, params ImportWSDLParams) (*MockResult, error) {
+	query := forceQuery(params.Force)
+	if query == nil {
+		query = url.Values{}
+	}
+	if params.Path != "" {
+		query.Set("path", params.Path)
+	}
+	if params.Workspace != "" {
+		query.Set("workspace", params.Workspace)
+	}
+	if params.DryRun {
+		query.Set("dryRun", "true")
+	}
+	var result MockResult
+	return &result, c.do(ctx, http.MethodPost, "soap/wsdl", query, document{"text/xml", wsdl}, &result)
+}
+
+// ImportAsyncAPI registers the events of an AsyncAPI document, in YAML or
+// JSON, replacing events of the same names.
+func (c *Client) ImportAsyncAPI(ctx context.Context, doc []byte, params ImportAsyncAPIParams) ([]AsyncEvent, error) {
+	query := url.Values{}
+	if params.Target != "" {
+		query.Set("target", params.Target)
+	}
+	if params.Interval > 0 {
+		query.Set("interval", params.Interval.String())
+	}
+	var result struct {
+		Events []AsyncEvent `json:"events"`
+	}
+	err := c.do(ctx, http.MethodPost, "asyncapi", query, document{"application/yaml", doc}, &result)
+	return result.Events, err
+}
+
+func (c *Client) ListEvents(ctx context.Context) ([]AsyncEvent, error) {
+	var result struct {
+		Events []AsyncEvent `json:"events"`
+	}
+	err := c.do(ctx, http.MethodGet, "events", nil, nil, &result)
+	return result.Events, err
+}
+
+// FireEvent sends an event now; data, when not nil, is the JSON body its
+// templates see.
+func (c *Client) FireEvent(ctx context.Context, name string, data interface{}) (*AsyncEvent, error) {
+	var event AsyncEvent
+	return &event, c.do(ctx, http.MethodPost, "events/"+url.PathEscape(name)+"/fire", nil, data, &event)
+}
+
+func (c *Client) DeleteEvent(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "events/"+url.PathEscape(name), nil, nil, nil)
+}
+
+func (c *Client) ClearEvents(ctx context.Context) (int, error) {
+	var result struct {
+		Removed int `json:"removed"`
+	}
+	err := c.do(ctx, http.MethodDelete, "events", nil, nil, &result)
+	return result.Removed, err
+}
+
+func (c *Client) ListProxyTransforms(ctx context.Context) (*ProxyTransforms, error) {
+	var result ProxyTransforms
+	return &result, c.do(ctx, http.MethodGet, "proxy/transforms", nil, nil, &result)
+}
+
+// PutProxyTransforms replaces all transforms of proxied responses.
+func (c *Client) PutProxyTransforms(ctx context.Context, transforms []ProxyTransform) (*ProxyTransforms, error) {
+	if transforms == nil {
+		transforms = []ProxyTransform{}
+	}
+	var result ProxyTransforms
+	return &result, c.do(ctx, http.MethodPut, "proxy/transforms", nil, transforms, &result)
+}
+
+func (c *Client) ListRequestRewrites(ctx context.Context) ([]RequestRewrite, error) {
+	var rewrites []RequestRewrite
+	return rewrites, c.do(ctx, http.MethodGet, "rewrites", nil, nil, &rewrites)
+}
+
+// PutRequestRewrites replaces all rules rewriting requests before matching.
+func (c *Client) PutRequestRewrites(ctx context.Context, rewrites []RequestRewrite) ([]RequestRewrite, error) {
+	if rewrites == nil {
+		rewrites = []RequestRewrite{}
+	}
+	var result []RequestRewrite
+	return result, c.do(ctx, http.MethodPut, "rewrites", nil, rewrites, &result)
+}
+
+func (c *Client) ListResponseMutators(ctx context.Context) ([]ResponseMutator, error) {
+	var mutators []ResponseMutator
+	return mutators, c.do(ctx, http.MethodGet, "response-mutators", nil, nil, &mutators)
+}
+
+// PutResponseMutators replaces all mutators of mock responses.
+func (c *Client) PutResponseMutators(ctx context.Context, mutators []ResponseMutator) ([]ResponseMutator, error) {
+	if mutators == nil {
+		mutators = []ResponseMutator{}
+	}
+	var result []ResponseMutator
+	return result, c.do(ctx, http.MethodPut, "response-mutators", nil, mutators, &result)
+}
+
+func (c *Client) GetGateway(ctx context.Context) (*GatewayConfig, error) {
+	var config GatewayConfig
+	return &config, c.do(ctx, http.MethodGet, "gateway", nil, nil, &config)
+}
+
+// PutGateway replaces the gateway emulation. With preset, config overrides
+// the preset section by section and may be nil.
+func (c *Client) PutGateway(ctx context.Context, config *GatewayConfig, preset bool) (*GatewayConfig, error) {
+	var query url.Values
+	if preset {
+		query = url.Values{"preset": {"true"}}
+	}
+	var body interface{}
+	if config != nil {
+		body = config
+	}
+	var result GatewayConfig
+	return &result, c.do(ctx, http.MethodPut, "gateway", query, body, &result)
+}
+
+func (c *Client) DeleteGateway(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "gateway", nil, nil, nil)
+}
+
+func (c *Client) ListWorkspaceDefaults(ctx context.Context) ([]WorkspaceDefaults, error) {
+	var list []WorkspaceDefaults
+	return list, c.do(ctx, http.MethodGet, "workspace-defaults", nil, nil, &list)
+}
+
+// PutWorkspaceDefaults defines the defaults of defaults.Workspace, replacing
+// any it had.
+func (c *Client) PutWorkspaceDefaults(ctx context.Context, defaults *WorkspaceDefaults) (*WorkspaceDefaults, error) {
+	var saved WorkspaceDefaults
+	return &saved, c.do(ctx, http.MethodPut, "workspace-defaults/"+url.PathEscape(defaults.Workspace), nil, defaults, &saved)
+}
+
+func (c *Client) DeleteWorkspaceDefaults(ctx context.Context, workspace string) error {
+	return c.do(ctx, http.MethodDelete, "workspace-defaults/"+url.PathEscape(workspace), nil, nil, nil)
+}
+
+func (c *Client) GetNetwork(ctx context.Context) (*NetworkSettings, error) {
+	var settings NetworkSettings
+	return &settings, c.do(ctx, http.MethodGet, "network", nil, nil, &settings)
+}
+
+// SetNetwork sets the profile for all requests and the per-path rules;
+// the profiles of settings are ignored.
+func (c *Client) SetNetwork(ctx context.Context, active string, rules []NetworkRule) (*NetworkSettings, error) {
+	body := struct {
+		Active string        `json:"active"`
+		Rules  []NetworkRule `json:"rules"`
+	}{active, rules}
+	var settings NetworkSettings
+	return &settings, c.do(ctx, http.MethodPut, "network", nil, body, &settings)
+}
+
+func (c *Client) ResetNetwork(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "network", nil, nil, nil)
+}
+
+func (c *Client) PutNetworkProfile(ctx context.Context, profile NetworkProfile) (*NetworkProfile, error) {
+	var saved NetworkProfile
+	return &saved, c.do(ctx, http.MethodPut, "network/profiles/"+url.PathEscape(profile.Name), nil, profile, &saved)
+}
+
+func (c *Client) GetConcurrency(ctx context.Context) (*ConcurrencySettings, error) {
+	var settings ConcurrencySettings
+	return &settings, c.do(ctx, http.MethodGet, "concurrency", nil, nil, &settings)
+}
+
+// SetConcurrency replaces the per-path concurrency limits.
+func (c *Client) SetConcurrency(ctx context.Context, rules []ConcurrencyRule) (*ConcurrencySettings, error) {
+	if rules == nil {
+		rules = []ConcurrencyRule{}
+	}
+	body := struct {
+		Rules []ConcurrencyRule `json:"rules"`
+	}{rules}
+	var settings ConcurrencySettings
+	return &settings, c.do(ctx, http.MethodPut, "concurrency", nil, body, &settings)
+}
+
+func (c *Client) ResetConcurrency(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "concurrency", nil, nil, nil)
+}
+
+// DebugStats reports the router's goroutines, memory, GC, connection pools
+// and caches.
+func (c *Client) DebugStats(ctx context.Context) (*DebugStats, error) {
+	var stats DebugStats
+	return &stats, c.do(ctx, http.MethodGet, "debug/stats", nil, nil, &stats)
+}
+
+func (p ReportParams) query() url.Values {
+	query := url.Values{"label": p.Labels}
+	if p.Workspace != "" {
+		query.Set("workspace", p.Workspace)
+	}
+	if p.Session != "" {
+		query.Set("session", p.Session)
+	}
+	if !p.Since.IsZero() {
+		query.Set("since", p.Since.Format(time.RFC3339Nano))
+	}
+	if !p.Until.IsZero() {
+		query.Set("until", p.Until.Format(time.RFC3339Nano))
+	}
+	return query
+}
+
+func (c *Client) Report(ctx context.Context, params ReportParams) (*TestReport, error) {
+	var report TestReport
+	return &report, c.do(ctx, http.MethodGet, "report", params.query(), nil, &report)
+}
+
+// ReportHTML returns the report as an HTML page.
+func (c *Client) ReportHTML(ctx context.Context, params ReportParams) ([]byte, error) {
+	query := params.query()
+	query.Set("format", "html")
+	var page json.RawMessage
+	err := c.do(ctx, http.MethodGet, "report", query, nil, &page)
+	return page, err
+}
+
+func (c *Client) ListProfiles(ctx context.Context) (*ProfileList, error) {
+	var list ProfileList
+	return &list, c.do(ctx, http.MethodGet, "profiles", nil, nil, &list)
+}
+
+func (c *Client) PutProfile(ctx context.Context, profile Profile) (*Profile, error) {
+	var saved Profile
+	return &saved, c.do(ctx, http.MethodPut, "profiles/"+url.PathEscape(profile.Name), nil, profile, &saved)
+}
+
+func (c *Client) DeleteProfile(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "profiles/"+url.PathEscape(name), nil, nil, nil)
+}
+
+func (c *Client) ActivateProfile(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPut, "profile", nil, map[string]string{"name": name}, nil)
+}
+
+func (c *Client) DeactivateProfile(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "profile", nil, nil, nil)
+}
+
+func (c *Client) GetClock(ctx context.Context) (*Clock, error) {
+	var clock Clock
+	return &clock, c.do(ctx, http.MethodGet, "clock", nil, nil, &clock)
+}
+
+func (c *Client) SetClock(ctx context.Context, settings ClockSettings) (*Clock, error) {
+	var clock Clock
+	return &clock, c.do(ctx, http.MethodPut, "clock", nil, settings, &clock)
+}
+
+func (c *Client) ResetClock(ctx context.Context) (*Clock, error) {
+	var clock Clock
+	return &clock, c.do(ctx, http.MethodDelete, "clock", nil, nil, &clock)
+}
+
+func (c *Client) SetSeed(ctx context.Context, seed Seed) (*Seed, error) {
+	var saved Seed
+	return &saved, c.do(ctx, http.MethodPut, "seed", nil, seed, &saved)
+}
+
+func (c *Client) ResetSeeds(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "seed", nil, nil, nil)
+}
+
+func (c *Client) ResetRateLimits(ctx context.Context, workspace string) error {
+	return c.do(ctx, http.MethodDelete, "rate-limits", workspaceQuery(workspace), nil, nil)
+}
+
+func (c *Client) ResetStatusSequences(ctx context.Context, workspace string) error {
+	return c.do(ctx, http.MethodDelete, "status-sequences", workspaceQuery(workspace), nil, nil)
+}
+
+func (c *Client) ListCircuitBreakers(ctx context.Context) ([]Circuit, error) {
+	var result struct {
+		Circuits []Circuit `json:"circuits"`
+	}
+	err := c.do(ctx, http.MethodGet, "circuit-breakers", nil, nil, &result)
+	return result.Circuits, err
+}
+
+func (c *Client) TripCircuitBreaker(ctx context.Context, trip CircuitTrip) (*Circuit, error) {
+	var circuit Circuit
+	return &circuit, c.do(ctx, http.MethodPut, "circuit-breakers", nil, trip, &circuit)
+}
+
+func (c *Client) CloseCircuitBreakers(ctx context.Context, workspace, path string) error {
+	query := url.Values{}
+	if workspace != "" {
+		query.Set("workspace", workspace)
+	}
+	if path != "" {
+		query.Set("path", path)
+	}
+	return c.do(ctx, http.MethodDelete, "circuit-breakers", query, nil, nil)
+}
+
+func (c *Client) ListContractViolations(ctx context.Context, kind string) ([]ContractViolation, error) {
+	var query url.Values
+	if kind != "" {
+		query = url.Values{"kind": {kind}}
+	}
+	var result struct {
+		Violations []ContractViolation `json:"violations"`
+	}
+	err := c.do(ctx, http.MethodGet, "contract/violations", query, nil, &result)
+	return result.Violations, err
+}
+
+func (c *Client) ClearContractViolations(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "contract/violations", nil, nil, nil)
+}
+
+// ContractCoverage maps the mocks onto the operations of an OpenAPI
+// document, YAML or JSON, or of the router's OPENAPI_SPEC when doc is nil,
+// counting the requests of the run params select by operation.
+func (c *Client) ContractCoverage(ctx context.Context, doc []byte, params ReportParams) (*ContractCoverage, error) {
+	var coverage ContractCoverage
+	if doc == nil {
+		return &coverage, c.do(ctx, http.MethodGet, "contract/coverage", params.query(), nil, &coverage)
+	}
+	return &coverage, c.do(ctx, http.MethodPost, "contract/coverage", params.query(), document{"application/yaml", doc}, &coverage)
+}
+
+func (c *Client) ListMirrorDiscrepancies(ctx context.Context) ([]MirrorDiscrepancy, error) {
+	var result struct {
+		Discrepancies []MirrorDiscrepancy `json:"discrepancies"`
+	}
+	err := c.do(ctx, http.MethodGet, "mirror/discrepancies", nil, nil, &result)
+	return result.Discrepancies, err
+}
+
+func (c *Client) ClearMirrorDiscrepancies(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "mirror/discrepancies", nil, nil, nil)
+}
+
+func (c *Client) ReplayJournal(ctx context.Context, req ReplayRequest) (*ReplayResult, error) {
+	var result ReplayResult
+	return &result, c.do(ctx, http.MethodPost, "journal/replay", nil, req, &result)
+}
+
+// CreateJournalMocks turns journaled requests and their responses into
+// mocks, or with dryRun only returns them.
+func (c *Client) CreateJournalMocks(ctx context.Context, req JournalMockRequest, dryRun, force bool) (*JournalMockResult, error) {
+	query := forceQuery(force)
+	if dryRun {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("dryRun", "true")
+	}
+	var result JournalMockResult
+	return &result, c.do(ctx, http.MethodPost, "journal/mocks", query, req, &result)
+}
+
+// VerifyRequests checks the number of journaled requests and what each sent;
+// the result's OK is false when they are not as expected.
+func (c *Client) VerifyRequests(ctx context.Context, req VerifyRequest) (*VerifyResult, error) {
+	var result VerifyResult
+	return &result, c.do(ctx, http.MethodPost, "verify", nil, req, &result)
+}
+
+func (c *Client) PruneJournal(ctx context.Context) (*JournalPruneResult, error) {
+	var result JournalPruneResult
+	return &result, c.do(ctx, http.MethodPost, "journal/prune", nil, nil, &result)
+}
+
+func (c *Client) GetGitSyncStatus(ctx context.Context) (*GitSyncStatus, error) {
+	var status GitSyncStatus
+	return &status, c.do(ctx, http.MethodGet, "gitops", nil, nil, &status)
+}
+
+// SyncGit pulls and reconciles immediately. When the sync fails, the returned
+// status is filled in as well as the error.
+func (c *Client) SyncGit(ctx context.Context) (*GitSyncStatus, error) {
+	var status GitSyncStatus
+	return &status, c.do(ctx, http.MethodPost, "gitops/sync", nil, nil, &status)
+}
+
+func (c *Client) GetKubernetesStatus(ctx context.Context) (*KubernetesStatus, error) {
+	var status KubernetesStatus
+	return &status, c.do(ctx, http.MethodGet, "kubernetes", nil, nil, &status)
+}
+
+// StreamRequests calls handle for every request the router handles until ctx
+// is done, handle returns an error or the connection drops.
+func (c *Client) StreamRequests(ctx context.Context, params StreamRequestsParams, handle func(RequestEvent) error) error {
+	query := url.Values{}
+	for name, value := range map[string]string{"workspace": params.Workspace, "method": params.Method, "pathPrefix": params.PathPrefix} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if params.MockID != 0 {
+		query.Set("mockId", strconv.Itoa(params.MockID))
+	}
+	endpoint := c.BaseURL + "/__admin/requests/stream"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return responseError(resp.StatusCode, data)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "request" && data != "" {
+				var e RequestEvent
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					return err
+				}
+				if err := handle(e); err != nil {
+					return err
+				}
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,689 @@
+package adminapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is encoded as a Go duration string and accepts milliseconds when
+// decoding, like the router itself.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(data))
+	if ms, err := strconv.ParseInt(text, 10, 64); err == nil {
+		*d = Duration(time.Duration(ms) * time.Millisecond)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string or milliseconds")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type Mock struct {
+	ID              int               `json:"id,omitempty"`
+	Path            string            `json:"path"`
+	Method          string            `json:"method"`
+	RequestBody     json.RawMessage   `json:"requestBody,omitempty"`
+	ResponseBody    string            `json:"responseBody"`
+	StatusCode      int               `json:"statusCode,omitempty"`
+	Headers         string            `json:"headers,omitempty"`
+	IsTemplate      bool              `json:"isTemplate,omitempty"`
+	Weight          *int              `json:"weight,omitempty"`
+	Host            string            `json:"host,omitempty"`
+	Workspace       string            `json:"workspace,omitempty"`
+	MatchExpression string            `json:"matchExpression,omitempty"`
+	Options         json.RawMessage   `json:"options,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Enabled         *bool             `json:"enabled,omitempty"`
+	CreatedAt       *time.Time        `json:"createdAt,omitempty"`
+	DeletedAt       *time.Time        `json:"deletedAt,omitempty"`
+}
+
+type MockConflict struct {
+	Index  *int   `json:"index,omitempty"`
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	IDs    []int  `json:"ids"`
+}
+
+type MockList struct {
+	Mocks      []Mock `json:"mocks"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// MockResult carries Mock for single-mock operations and Mocks for imports,
+// plus the overlaps that were saved anyway because of force.
+type MockResult struct {
+	Mock      *Mock          `json:"mock,omitempty"`
+	Mocks     []Mock         `json:"mocks,omitempty"`
+	Conflicts []MockConflict `json:"conflicts,omitempty"`
+}
+
+type ListMocksParams struct {
+	Q         string
+	Method    string
+	Status    int
+	Workspace string
+	Labels    []string
+	Enabled   *bool
+	Sort      string
+	Limit     int
+	Cursor    string
+}
+
+type MockStat struct {
+	ID           int        `json:"id"`
+	Method       string     `json:"method"`
+	Path         string     `json:"path"`
+	Workspace    string     `json:"workspace"`
+	Hits         int64      `json:"hits"`
+	LastHit      *time.Time `json:"lastHit,omitempty"`
+	AvgLatencyMs float64    `json:"avgLatencyMs"`
+}
+
+type MockStats struct {
+	Since time.Time  `json:"since"`
+	Mocks []MockStat `json:"mocks"`
+}
+
+type MockStatsParams struct {
+	Sort   string
+	Unused bool
+}
+
+type StaleMocksParams struct {
+	Days           int
+	Workspace      string
+	Labels         []string
+	IncludeManaged bool
+}
+
+type StaleMock struct {
+	ID        int               `json:"id"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Workspace string            `json:"workspace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt *time.Time        `json:"createdAt,omitempty"`
+	LastHit   *time.Time        `json:"lastHit,omitempty"`
+}
+
+type StaleMocks struct {
+	Days  int         `json:"days"`
+	Mocks []StaleMock `json:"mocks"`
+}
+
+// LintIssue is a problem found in a stored mock; Severity is error or
+// warning.
+type LintIssue struct {
+	ID       int    `json:"id,omitempty"`
+	Path     string `json:"path"`
+	Method   string `json:"method"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type LintResult struct {
+	Issues   []LintIssue `json:"issues"`
+	Errors   int         `json:"errors"`
+	Warnings int         `json:"warnings"`
+}
+
+type BulkRequest struct {
+	Action    string   `json:"action"`
+	IDs       []int64  `json:"ids,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+	Workspace string   `json:"workspace,omitempty"`
+}
+
+type Trash struct {
+	Mocks     []Mock    `json:"mocks"`
+	Retention *Duration `json:"retention,omitempty"`
+}
+
+// ImportWSDLParams places the scaffolded mocks: Path replaces the paths of
+// the WSDL's service addresses.
+type ImportWSDLParams struct {
+	Path      string
+	Workspace string
+	DryRun    bool
+	Force     bool
+}
+
+// AsyncEvent is an event imported from an AsyncAPI document, sent as a
+// webhook on its schedule, on demand or by mocks that name it.
+type AsyncEvent struct {
+	Name     string       `json:"name"`
+	Channel  string       `json:"channel"`
+	Summary  string       `json:"summary,omitempty"`
+	Webhook  EventWebhook `json:"webhook"`
+	Interval Duration     `json:"interval,omitempty"`
+}
+
+type EventWebhook struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+type ImportAsyncAPIParams struct {
+	Target   string
+	Interval time.Duration
+}
+
+// ProxyTransform rewrites the upstream responses of requests that no mock
+// matched and that were passed through to the proxy upstream.
+type ProxyTransform struct {
+	Path          string                 `json:"path"`
+	Method        string                 `json:"method,omitempty"`
+	Status        map[string]int         `json:"status,omitempty"`
+	Set           map[string]interface{} `json:"set,omitempty"`
+	Remove        []string               `json:"remove,omitempty"`
+	Headers       map[string]string      `json:"headers,omitempty"`
+	RemoveHeaders []string               `json:"removeHeaders,omitempty"`
+}
+
+type ProxyTransforms struct {
+	Upstream   string           `json:"upstream"`
+	Transforms []ProxyTransform `json:"transforms"`
+}
+
+// RequestRewrite normalizes matching requests before mocks are looked up.
+type RequestRewrite struct {
+	Path          string            `json:"path,omitempty"`
+	Method        string            `json:"method,omitempty"`
+	When          map[string]string `json:"when,omitempty"`
+	StripPrefix   string            `json:"stripPrefix,omitempty"`
+	AddPrefix     string            `json:"addPrefix,omitempty"`
+	SetHeaders    map[string]string `json:"setHeaders,omitempty"`
+	RenameHeaders map[string]string `json:"renameHeaders,omitempty"`
+	RemoveHeaders []string          `json:"removeHeaders,omitempty"`
+	RemoveFields  []string          `json:"removeFields,omitempty"`
+}
+
+// ResponseMutator changes matching mock responses once they are built.
+// Envelope holds the body in place of "$body".
+type ResponseMutator struct {
+	Path         string              `json:"path,omitempty"`
+	Method       string              `json:"method,omitempty"`
+	Status       []string            `json:"status,omitempty"`
+	Headers      map[string]string   `json:"headers,omitempty"`
+	Envelope     interface{}         `json:"envelope,omitempty"`
+	ServerTiming *ServerTimingMetric `json:"serverTiming,omitempty"`
+}
+
+type ServerTimingMetric struct {
+	Name        string   `json:"name"`
+	Duration    Duration `json:"duration,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// GatewayConfig configures the API gateway emulated in front of the mocks.
+type GatewayConfig struct {
+	Paths           []string       `json:"paths,omitempty"`
+	Public          []string       `json:"public,omitempty"`
+	APIKey          *GatewayAPIKey `json:"apiKey,omitempty"`
+	Quota           *GatewayQuota  `json:"quota,omitempty"`
+	RequestIDHeader string         `json:"requestIdHeader,omitempty"`
+	Errors          *GatewayErrors `json:"errors,omitempty"`
+}
+
+type GatewayAPIKey struct {
+	Header string   `json:"header,omitempty"`
+	Query  string   `json:"query,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+}
+
+type GatewayQuota struct {
+	Limit   int      `json:"limit"`
+	Window  Duration `json:"window"`
+	Headers string   `json:"headers,omitempty"`
+}
+
+// GatewayErrors holds the error envelope, with "$status", "$message" and
+// "$body" as placeholders.
+type GatewayErrors struct {
+	Envelope       interface{} `json:"envelope"`
+	WrapMockErrors bool        `json:"wrapMockErrors,omitempty"`
+}
+
+// WorkspaceDefaults are the headers a workspace adds to every response and
+// the template of the router's errors in it.
+type WorkspaceDefaults struct {
+	Workspace        string            `json:"workspace,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	ErrorBody        string            `json:"errorBody,omitempty"`
+	ErrorContentType string            `json:"errorContentType,omitempty"`
+}
+
+type NetworkProfile struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Latency     Duration `json:"latency,omitempty"`
+	Jitter      Duration `json:"jitter,omitempty"`
+	// Bandwidth is in response bytes per second.
+	Bandwidth int64   `json:"bandwidth,omitempty"`
+	DropRate  float64 `json:"dropRate,omitempty"`
+}
+
+type NetworkRule struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+}
+
+type NetworkSettings struct {
+	Active   string           `json:"active"`
+	Rules    []NetworkRule    `json:"rules"`
+	Profiles []NetworkProfile `json:"profiles,omitempty"`
+}
+
+type ConcurrencyRule struct {
+	Path         string   `json:"path"`
+	Limit        int      `json:"limit"`
+	PerPath      bool     `json:"perPath,omitempty"`
+	QueueTimeout Duration `json:"queueTimeout,omitempty"`
+	Status       int      `json:"status,omitempty"`
+	RetryAfter   Duration `json:"retryAfter,omitempty"`
+	Body         string   `json:"body,omitempty"`
+}
+
+type ConcurrencyStatus struct {
+	ConcurrencyRule
+	InFlight int   `json:"inFlight"`
+	Queued   int   `json:"queued"`
+	Rejected int64 `json:"rejected"`
+}
+
+type ConcurrencySettings struct {
+	Rules []ConcurrencyStatus `json:"rules"`
+}
+
+// DebugStats is a snapshot of a router's runtime, connection pools and
+// caches.
+type DebugStats struct {
+	GoVersion  string   `json:"goVersion"`
+	Uptime     Duration `json:"uptime"`
+	CPUs       int      `json:"cpus"`
+	GOMAXPROCS int      `json:"gomaxprocs"`
+	Goroutines int      `json:"goroutines"`
+	Memory     struct {
+		HeapAlloc   uint64 `json:"heapAlloc"`
+		HeapInuse   uint64 `json:"heapInuse"`
+		HeapObjects uint64 `json:"heapObjects"`
+		StackInuse  uint64 `json:"stackInuse"`
+		Sys         uint64 `json:"sys"`
+		TotalAlloc  uint64 `json:"totalAlloc"`
+	} `json:"memory"`
+	GC struct {
+		Cycles      uint32     `json:"cycles"`
+		LastGC      *time.Time `json:"lastGC,omitempty"`
+		PauseTotal  Duration   `json:"pauseTotal"`
+		LastPause   Duration   `json:"lastPause"`
+		CPUFraction float64    `json:"cpuFraction"`
+		NextGC      uint64     `json:"nextGC"`
+	} `json:"gc"`
+	DBPool      map[string]interface{} `json:"dbPool,omitempty"`
+	ReplicaPool map[string]interface{} `json:"replicaPool,omitempty"`
+	Requests    map[string]int64       `json:"requests"`
+	Caches      map[string]int         `json:"caches"`
+	Journal     map[string]int         `json:"journal"`
+}
+
+// ReportParams scope a test report or a contract coverage report; Labels
+// select the mocks expected to be hit, or considered for coverage.
+type ReportParams struct {
+	Workspace string
+	Session   string
+	Since     time.Time
+	Until     time.Time
+	Labels    []string
+}
+
+type ReportedMock struct {
+	ID        int               `json:"id"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Workspace string            `json:"workspace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Hits      int64             `json:"hits"`
+	LastHit   *time.Time        `json:"lastHit,omitempty"`
+	Deleted   bool              `json:"deleted,omitempty"`
+}
+
+type UnmatchedRequest struct {
+	JournalID  int64     `json:"journalId"`
+	RequestID  string    `json:"requestId,omitempty"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	StatusCode int       `json:"statusCode"`
+}
+
+type TestReport struct {
+	GeneratedAt       time.Time          `json:"generatedAt"`
+	Workspace         string             `json:"workspace,omitempty"`
+	Session           string             `json:"session,omitempty"`
+	Since             *time.Time         `json:"since,omitempty"`
+	Until             *time.Time         `json:"until,omitempty"`
+	Labels            []string           `json:"labels,omitempty"`
+	Requests          int64              `json:"requests"`
+	Passed            bool               `json:"passed"`
+	HitMocks          []ReportedMock     `json:"hitMocks"`
+	UnhitMocks        []ReportedMock     `json:"unhitMocks"`
+	UnmatchedTotal    int64              `json:"unmatchedTotal"`
+	UnmatchedRequests []UnmatchedRequest `json:"unmatchedRequests"`
+}
+
+type ResetParams struct {
+	Only      []string
+	Workspace string
+}
+
+type ResetResult struct {
+	Reset          []string `json:"reset"`
+	Workspace      string   `json:"workspace,omitempty"`
+	JournalEntries *int64   `json:"journalEntries,omitempty"`
+	CRUDRecords    *int64   `json:"crudRecords,omitempty"`
+}
+
+// CacheFlushResult counts the entries dropped from each cache.
+type CacheFlushResult struct {
+	Mocks    int `json:"mocks"`
+	Upstream int `json:"upstream"`
+	Renders  int `json:"renders"`
+}
+
+type RestoreResult struct {
+	Mocks       int `json:"mocks"`
+	CRUDRecords int `json:"crudRecords"`
+}
+
+type Faults struct {
+	Latency     Duration `json:"latency,omitempty"`
+	Jitter      Duration `json:"jitter,omitempty"`
+	ErrorRate   float64  `json:"errorRate,omitempty"`
+	ErrorStatus int      `json:"errorStatus,omitempty"`
+	ErrorBody   string   `json:"errorBody,omitempty"`
+}
+
+type Profile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Faults      Faults `json:"faults,omitempty"`
+}
+
+type ProfileList struct {
+	Active   string    `json:"active"`
+	Profiles []Profile `json:"profiles"`
+}
+
+type Clock struct {
+	Now    time.Time `json:"now"`
+	Offset string    `json:"offset"`
+	Frozen bool      `json:"frozen"`
+}
+
+type ClockSettings struct {
+	Time   *time.Time `json:"time,omitempty"`
+	Offset string     `json:"offset,omitempty"`
+	Frozen bool       `json:"frozen"`
+}
+
+type Seed struct {
+	Workspace string `json:"workspace"`
+	Seed      *int64 `json:"seed"`
+}
+
+type Circuit struct {
+	Workspace string    `json:"workspace"`
+	Session   string    `json:"session,omitempty"`
+	Path      string    `json:"path"`
+	Hits      int       `json:"hits"`
+	OpenUntil time.Time `json:"openUntil,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Body      string    `json:"body,omitempty"`
+}
+
+type CircuitTrip struct {
+	Workspace string   `json:"workspace,omitempty"`
+	Session   string   `json:"session,omitempty"`
+	Path      string   `json:"path"`
+	OpenFor   Duration `json:"openFor,omitempty"`
+	Status    int      `json:"status,omitempty"`
+	Body      string   `json:"body,omitempty"`
+}
+
+type ContractViolation struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	MockID  int       `json:"mockId,omitempty"`
+	Message string    `json:"message"`
+}
+
+type CoverageOperation struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationID string `json:"operationId,omitempty"`
+	Mocks       []int  `json:"mocks"`
+	// Requests is only reported with the journal.
+	Requests *int64 `json:"requests,omitempty"`
+}
+
+type CoverageMock struct {
+	ID        int    `json:"id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Workspace string `json:"workspace"`
+}
+
+type CoverageSummary struct {
+	Operations           int      `json:"operations"`
+	Mocked               int      `json:"mocked"`
+	MockedPercent        float64  `json:"mockedPercent"`
+	UnmappedMocks        int      `json:"unmappedMocks"`
+	Exercised            *int     `json:"exercised,omitempty"`
+	ExercisedPercent     *float64 `json:"exercisedPercent,omitempty"`
+	UndocumentedRequests *int64   `json:"undocumentedRequests,omitempty"`
+}
+
+type ContractCoverage struct {
+	Summary       CoverageSummary     `json:"summary"`
+	Operations    []CoverageOperation `json:"operations"`
+	UnmappedMocks []CoverageMock      `json:"unmappedMocks"`
+}
+
+type MirrorDiscrepancy struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	MockID      int       `json:"mockId"`
+	Differences []string  `json:"differences"`
+}
+
+type ReplayFilter struct {
+	IDs        []int64    `json:"ids,omitempty"`
+	Workspace  string     `json:"workspace,omitempty"`
+	Method     string     `json:"method,omitempty"`
+	PathPrefix string     `json:"pathPrefix,omitempty"`
+	MockID     int        `json:"mockId,omitempty"`
+	Session    string     `json:"session,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+	Limit      int        `json:"limit,omitempty"`
+}
+
+type ReplayRequest struct {
+	Target      string       `json:"target"`
+	Concurrency int          `json:"concurrency,omitempty"`
+	Rate        float64      `json:"rate,omitempty"`
+	Timeout     Duration     `json:"timeout,omitempty"`
+	Filter      ReplayFilter `json:"filter"`
+}
+
+// JournalMockRequest selects journal entries to turn into mocks. The lists
+// add to the server's JOURNAL_MOCK_* defaults.
+type JournalMockRequest struct {
+	Filter       ReplayFilter      `json:"filter"`
+	StripHeaders []string          `json:"stripHeaders,omitempty"`
+	IgnoreFields []string          `json:"ignoreFields,omitempty"`
+	IgnoreQuery  []string          `json:"ignoreQuery,omitempty"`
+	Workspace    string            `json:"workspace,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+type SkippedJournalEntry struct {
+	JournalID int64  `json:"journalId"`
+	Reason    string `json:"reason"`
+}
+
+type JournalMockResult struct {
+	Mocks     []Mock                `json:"mocks"`
+	Skipped   []SkippedJournalEntry `json:"skipped"`
+	Conflicts []MockConflict        `json:"conflicts,omitempty"`
+}
+
+// VerifyRequest selects journaled requests and says how many are expected
+// and what each must have sent. Without count, atLeast or atMost, at least
+// one request is expected.
+type VerifyRequest struct {
+	Workspace  string             `json:"workspace,omitempty"`
+	Method     string             `json:"method,omitempty"`
+	Path       string             `json:"path,omitempty"`
+	PathPrefix string             `json:"pathPrefix,omitempty"`
+	MockID     int                `json:"mockId,omitempty"`
+	Since      *time.Time         `json:"since,omitempty"`
+	Count      *int64             `json:"count,omitempty"`
+	AtLeast    *int64             `json:"atLeast,omitempty"`
+	AtMost     *int64             `json:"atMost,omitempty"`
+	Assertions []RequestAssertion `json:"assertions,omitempty"`
+}
+
+// RequestAssertion checks a value sent in the body, at Pointer, or in
+// Header. Equals holds a JSON value.
+type RequestAssertion struct {
+	Pointer string  `json:"pointer,omitempty"`
+	Header  string  `json:"header,omitempty"`
+	Equals  *string `json:"equals,omitempty"`
+	Matches string  `json:"matches,omitempty"`
+	Exists  *bool   `json:"exists,omitempty"`
+}
+
+type VerifyResult struct {
+	OK         bool              `json:"ok"`
+	Count      int64             `json:"count"`
+	Message    string            `json:"message,omitempty"`
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+}
+
+type AssertionResult struct {
+	OK       bool               `json:"ok"`
+	Passed   int64              `json:"passed"`
+	Failed   int64              `json:"failed"`
+	Failures []AssertionFailure `json:"failures,omitempty"`
+}
+
+type AssertionFailure struct {
+	JournalID int64  `json:"journalId"`
+	RequestID string `json:"requestId,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+	Message   string `json:"message"`
+}
+
+type ReplayResult struct {
+	Total            int            `json:"total"`
+	Succeeded        int            `json:"succeeded"`
+	Failed           int            `json:"failed"`
+	StatusMismatches int            `json:"statusMismatches"`
+	StatusCodes      map[string]int `json:"statusCodes"`
+	Errors           []string       `json:"errors,omitempty"`
+	Duration         Duration       `json:"duration"`
+}
+
+type JournalRetention struct {
+	MaxAge   Duration `json:"maxAge,omitempty"`
+	MaxRows  int64    `json:"maxRows,omitempty"`
+	MaxBytes int64    `json:"maxBytes,omitempty"`
+	Interval Duration `json:"interval"`
+}
+
+// JournalPruneResult counts the deleted entries per limit: age, rows and
+// size.
+type JournalPruneResult struct {
+	Retention JournalRetention `json:"retention"`
+	Deleted   map[string]int64 `json:"deleted"`
+}
+
+type StreamRequestsParams struct {
+	Workspace  string
+	Method     string
+	PathPrefix string
+	MockID     int
+}
+
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type RequestEvent struct {
+	RequestID  string              `json:"requestId,omitempty"`
+	ReceivedAt time.Time           `json:"receivedAt"`
+	Workspace  string              `json:"workspace,omitempty"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	MockID     int                 `json:"mockId,omitempty"`
+	StatusCode int                 `json:"statusCode"`
+	DurationMs float64             `json:"durationMs"`
+	Violations []SchemaViolation   `json:"violations,omitempty"`
+}
+
+type SyncResult struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+type GitSyncStatus struct {
+	Repository  string     `json:"repository"`
+	Branch      string     `json:"branch"`
+	Path        string     `json:"path,omitempty"`
+	Interval    string     `json:"interval"`
+	Commit      string     `json:"commit,omitempty"`
+	Files       int        `json:"files"`
+	Mocks       int        `json:"mocks"`
+	LastSync    *time.Time `json:"lastSync,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Result      SyncResult `json:"result"`
+}
+
+type KubernetesStatus struct {
+	Namespace     string     `json:"namespace"`
+	LabelSelector string     `json:"labelSelector"`
+	ConfigMaps    []string   `json:"configMaps"`
+	Mocks         int        `json:"mocks"`
+	LastSync      *time.Time `json:"lastSync,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	Result        SyncResult `json:"result"`
+}
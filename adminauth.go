@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"mock-db-router/adminapi"
+)
+
+// adminToken, when set, is the bearer token the admin API requires, over
+// HTTP and gRPC alike.
+var adminToken = envString("ADMIN_TOKEN", "")
+
+func validAdminToken(authorization string) bool {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(adminToken)) == 1
+}
+
+// requireAdminToken answers admin requests without the token with 401.
+func requireAdminToken(next http.Handler) http.Handler {
+	if adminToken == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validAdminToken(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mock-db-router admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "admin token required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcAdminAuth returns the server options checking the authorization
+// metadata of admin gRPC calls.
+func grpcAdminAuth() []grpc.ServerOption {
+	if adminToken == "" {
+		return nil
+	}
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get("authorization") {
+			if validAdminToken(value) {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "admin token required")
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// newAdminClient returns a client of the admin API at baseURL, sending the
+// token of MOCK_ADMIN_TOKEN.
+func newAdminClient(baseURL string) *adminapi.Client {
+	client := adminapi.New(baseURL)
+	if token := envString("MOCK_ADMIN_TOKEN", ""); token != "" {
+		client.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client
+}
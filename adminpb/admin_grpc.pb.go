@@ -0,0 +1,408 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: admin.proto
+
+// gRPC flavour of the mock-db-router admin API. It shares its behaviour with
+// the REST endpoints under /__admin/ (see adminapi/openapi.yaml).
+
+package adminpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MockAdmin_ListMocks_FullMethodName   = "/mockdbrouter.admin.v1.MockAdmin/ListMocks"
+	MockAdmin_GetMock_FullMethodName     = "/mockdbrouter.admin.v1.MockAdmin/GetMock"
+	MockAdmin_CreateMocks_FullMethodName = "/mockdbrouter.admin.v1.MockAdmin/CreateMocks"
+	MockAdmin_UpdateMock_FullMethodName  = "/mockdbrouter.admin.v1.MockAdmin/UpdateMock"
+	MockAdmin_DeleteMock_FullMethodName  = "/mockdbrouter.admin.v1.MockAdmin/DeleteMock"
+	MockAdmin_Reset_FullMethodName       = "/mockdbrouter.admin.v1.MockAdmin/Reset"
+	MockAdmin_Verify_FullMethodName      = "/mockdbrouter.admin.v1.MockAdmin/Verify"
+	MockAdmin_TailJournal_FullMethodName = "/mockdbrouter.admin.v1.MockAdmin/TailJournal"
+)
+
+// MockAdminClient is the client API for MockAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MockAdminClient interface {
+	ListMocks(ctx context.Context, in *ListMocksRequest, opts ...grpc.CallOption) (*ListMocksResponse, error)
+	GetMock(ctx context.Context, in *GetMockRequest, opts ...grpc.CallOption) (*Mock, error)
+	// CreateMocks imports mocks in one transaction. Without force, mocks that
+	// overlap existing ones fail the call with ALREADY_EXISTS.
+	CreateMocks(ctx context.Context, in *CreateMocksRequest, opts ...grpc.CallOption) (*CreateMocksResponse, error)
+	UpdateMock(ctx context.Context, in *UpdateMockRequest, opts ...grpc.CallOption) (*UpdateMockResponse, error)
+	// DeleteMock moves a mock to the trash.
+	DeleteMock(ctx context.Context, in *DeleteMockRequest, opts ...grpc.CallOption) (*DeleteMockResponse, error)
+	Reset(ctx context.Context, in *ResetRequest, opts ...grpc.CallOption) (*ResetResponse, error)
+	// Verify counts journaled requests and checks the count against the
+	// expectation. It requires JOURNAL_ENABLED.
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	// TailJournal streams requests as they are handled, whether or not the
+	// journal is persisted.
+	TailJournal(ctx context.Context, in *TailJournalRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JournalEntry], error)
+}
+
+type mockAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMockAdminClient(cc grpc.ClientConnInterface) MockAdminClient {
+	return &mockAdminClient{cc}
+}
+
+func (c *mockAdminClient) ListMocks(ctx context.Context, in *ListMocksRequest, opts ...grpc.CallOption) (*ListMocksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMocksResponse)
+	err := c.cc.Invoke(ctx, MockAdmin_ListMocks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mockAdminClient) GetMock(ctx context.Context, in *GetMockRequest, opts ...grpc.CallOption) (*Mock, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Mock)
+	err := c.cc.Invoke(ctx, MockAdmin_GetMock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mockAdminClient) CreateMocks(ctx context.Context, in *CreateMocksRequest, opts ...grpc.CallOption) (*CreateMocksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateMocksResponse)
+	err := c.cc.Invoke(ctx, MockAdmin_CreateMocks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mockAdminClient) UpdateMock(ctx context.Context, in *UpdateMockRequest, opts ...grpc.CallOption) (*UpdateMockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateMockResponse)
+	err := c.cc.Invoke(ctx, MockAdmin_UpdateMock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mockAdminClient) DeleteMock(ctx context.Context, in *DeleteMockRequest, opts ...grpc.CallOption) (*DeleteMockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteMockResponse)
+	err := c.cc.Invoke(ctx, MockAdmin_DeleteMock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mockAdminClient) Reset(ctx context.Context, in *ResetRequest, opts ...grpc.CallOption) (*ResetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResetResponse)
+	err := c.cc.Invoke(ctx, MockAdmin_Reset_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mockAdminClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, MockAdmin_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mockAdminClient) TailJournal(ctx context.Context, in *TailJournalRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JournalEntry], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MockAdmin_ServiceDesc.Streams[0], MockAdmin_TailJournal_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TailJournalRequest, JournalEntry]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MockAdmin_TailJournalClient = grpc.ServerStreamingClient[JournalEntry]
+
+// MockAdminServer is the server API for MockAdmin service.
+// All implementations must embed UnimplementedMockAdminServer
+// for forward compatibility.
+type MockAdminServer interface {
+	ListMocks(context.Context, *ListMocksRequest) (*ListMocksResponse, error)
+	GetMock(context.Context, *GetMockRequest) (*Mock, error)
+	// CreateMocks imports mocks in one transaction. Without force, mocks that
+	// overlap existing ones fail the call with ALREADY_EXISTS.
+	CreateMocks(context.Context, *CreateMocksRequest) (*CreateMocksResponse, error)
+	UpdateMock(context.Context, *UpdateMockRequest) (*UpdateMockResponse, error)
+	// DeleteMock moves a mock to the trash.
+	DeleteMock(context.Context, *DeleteMockRequest) (*DeleteMockResponse, error)
+	Reset(context.Context, *ResetRequest) (*ResetResponse, error)
+	// Verify counts journaled requests and checks the count against the
+	// expectation. It requires JOURNAL_ENABLED.
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	// TailJournal streams requests as they are handled, whether or not the
+	// journal is persisted.
+	TailJournal(*TailJournalRequest, grpc.ServerStreamingServer[JournalEntry]) error
+	mustEmbedUnimplementedMockAdminServer()
+}
+
+// UnimplementedMockAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMockAdminServer struct{}
+
+func (UnimplementedMockAdminServer) ListMocks(context.Context, *ListMocksRequest) (*ListMocksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMocks not implemented")
+}
+func (UnimplementedMockAdminServer) GetMock(context.Context, *GetMockRequest) (*Mock, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMock not implemented")
+}
+func (UnimplementedMockAdminServer) CreateMocks(context.Context, *CreateMocksRequest) (*CreateMocksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateMocks not implemented")
+}
+func (UnimplementedMockAdminServer) UpdateMock(context.Context, *UpdateMockRequest) (*UpdateMockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateMock not implemented")
+}
+func (UnimplementedMockAdminServer) DeleteMock(context.Context, *DeleteMockRequest) (*DeleteMockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMock not implemented")
+}
+func (UnimplementedMockAdminServer) Reset(context.Context, *ResetRequest) (*ResetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reset not implemented")
+}
+func (UnimplementedMockAdminServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedMockAdminServer) TailJournal(*TailJournalRequest, grpc.ServerStreamingServer[JournalEntry]) error {
+	return status.Errorf(codes.Unimplemented, "method TailJournal not implemented")
+}
+func (UnimplementedMockAdminServer) mustEmbedUnimplementedMockAdminServer() {}
+func (UnimplementedMockAdminServer) testEmbeddedByValue()                   {}
+
+// UnsafeMockAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MockAdminServer will
+// result in compilation errors.
+type UnsafeMockAdminServer interface {
+	mustEmbedUnimplementedMockAdminServer()
+}
+
+func RegisterMockAdminServer(s grpc.ServiceRegistrar, srv MockAdminServer) {
+	// If the following call pancis, it indicates UnimplementedMockAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MockAdmin_ServiceDesc, srv)
+}
+
+func _MockAdmin_ListMocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMocksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MockAdminServer).ListMocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MockAdmin_ListMocks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MockAdminServer).ListMocks(ctx, req.(*ListMocksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MockAdmin_GetMock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MockAdminServer).GetMock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MockAdmin_GetMock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MockAdminServer).GetMock(ctx, req.(*GetMockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MockAdmin_CreateMocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateMocksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MockAdminServer).CreateMocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MockAdmin_CreateMocks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MockAdminServer).CreateMocks(ctx, req.(*CreateMocksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MockAdmin_UpdateMock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateMockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MockAdminServer).UpdateMock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MockAdmin_UpdateMock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MockAdminServer).UpdateMock(ctx, req.(*UpdateMockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MockAdmin_DeleteMock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MockAdminServer).DeleteMock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MockAdmin_DeleteMock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MockAdminServer).DeleteMock(ctx, req.(*DeleteMockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MockAdmin_Reset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MockAdminServer).Reset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MockAdmin_Reset_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MockAdminServer).Reset(ctx, req.(*ResetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MockAdmin_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MockAdminServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MockAdmin_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MockAdminServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MockAdmin_TailJournal_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailJournalRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MockAdminServer).TailJournal(m, &grpc.GenericServerStream[TailJournalRequest, JournalEntry]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MockAdmin_TailJournalServer = grpc.ServerStreamingServer[JournalEntry]
+
+// MockAdmin_ServiceDesc is the grpc.ServiceDesc for MockAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MockAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mockdbrouter.admin.v1.MockAdmin",
+	HandlerType: (*MockAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListMocks",
+			Handler:    _MockAdmin_ListMocks_Handler,
+		},
+		{
+			MethodName: "GetMock",
+			Handler:    _MockAdmin_GetMock_Handler,
+		},
+		{
+			MethodName: "CreateMocks",
+			Handler:    _MockAdmin_CreateMocks_Handler,
+		},
+		{
+			MethodName: "UpdateMock",
+			Handler:    _MockAdmin_UpdateMock_Handler,
+		},
+		{
+			MethodName: "DeleteMock",
+			Handler:    _MockAdmin_DeleteMock_Handler,
+		},
+		{
+			MethodName: "Reset",
+			Handler:    _MockAdmin_Reset_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _MockAdmin_Verify_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "TailJournal",
+			Handler:       _MockAdmin_TailJournal_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "admin.proto",
+}
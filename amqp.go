@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type amqpMessageOptions struct {
+	Exchange    string            `json:"exchange"`
+	RoutingKey  string            `json:"routingKey"`
+	Body        string            `json:"body"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Persistent  bool              `json:"persistent,omitempty"`
+}
+
+type amqpPublisher struct {
+	url string
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+var amqpClient *amqpPublisher
+
+func initAMQP(url string) {
+	if url == "" {
+		return
+	}
+	amqpClient = &amqpPublisher{url: url}
+	fmt.Println("AMQP message publishing enabled")
+}
+
+func (p *amqpPublisher) channel() (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+	p.close()
+
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to AMQP broker: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error opening AMQP channel: %v", err)
+	}
+
+	p.conn, p.ch = conn, ch
+	return ch, nil
+}
+
+func (p *amqpPublisher) close() {
+	if p.ch != nil {
+		p.ch.Close()
+		p.ch = nil
+	}
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func (p *amqpPublisher) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+	if err := ch.PublishWithContext(ctx, exchange, routingKey, false, false, msg); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+func publishAMQPMessages(mockResp *MockResponse, data templateData) {
+	messages := mockResp.Options.AMQP
+	if len(messages) == 0 {
+		return
+	}
+	if amqpClient == nil {
+		log.Printf("Mock %d defines AMQP messages but AMQP_URL is not configured", mockResp.ID)
+		return
+	}
+
+	for i, opts := range messages {
+		msg, err := renderAMQPMessage(opts, data)
+		if err != nil {
+			log.Printf("AMQP message %d of mock %d not published: %v", i, mockResp.ID, err)
+			continue
+		}
+
+		go func(opts amqpMessageOptions, msg amqp.Publishing) {
+			ctx, cancel := context.WithTimeout(context.Background(), envDuration("AMQP_TIMEOUT", 10*time.Second))
+			defer cancel()
+
+			if err := amqpClient.publish(ctx, opts.Exchange, opts.RoutingKey, msg); err != nil {
+				log.Printf("Error publishing AMQP message to %q/%q for mock %d: %v", opts.Exchange, opts.RoutingKey, mockResp.ID, err)
+			}
+		}(opts, msg)
+	}
+}
+
+func renderAMQPMessage(opts amqpMessageOptions, data templateData) (amqp.Publishing, error) {
+	body, err := renderString("amqp-body", opts.Body, data)
+	if err != nil {
+		return amqp.Publishing{}, fmt.Errorf("error rendering body: %v", err)
+	}
+
+	msg := amqp.Publishing{
+		ContentType:   opts.ContentType,
+		Body:          []byte(body),
+		Timestamp:     data.Now,
+		MessageId:     newUUID(),
+		CorrelationId: data.RequestID,
+	}
+	if msg.ContentType == "" {
+		msg.ContentType = "application/json"
+	}
+	if opts.Persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+
+	if len(opts.Headers) > 0 {
+		msg.Headers = amqp.Table{}
+		data.Payload = body
+		for name, tmpl := range opts.Headers {
+			rendered, err := renderString("amqp-header", tmpl, data)
+			if err != nil {
+				return amqp.Publishing{}, fmt.Errorf("error rendering header %s: %v", name, err)
+			}
+			msg.Headers[name] = rendered
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+)
+
+// asyncEvent is an event an imported AsyncAPI document says a service sends,
+// simulated as a webhook to the service's subscribers. Events with an
+// interval are sent on that schedule; mocks send events by name.
+type asyncEvent struct {
+	Name     string         `json:"name"`
+	Channel  string         `json:"channel"`
+	Summary  string         `json:"summary,omitempty"`
+	Webhook  webhookOptions `json:"webhook"`
+	Interval jsonDuration   `json:"interval,omitempty"`
+}
+
+type scheduledEvent struct {
+	asyncEvent
+	stop chan struct{}
+}
+
+type asyncEventRegistry struct {
+	mu     sync.Mutex
+	events map[string]*scheduledEvent
+}
+
+var asyncEvents = &asyncEventRegistry{events: make(map[string]*scheduledEvent)}
+
+// define adds an event or replaces the one with the same name, restarting
+// its schedule.
+func (reg *asyncEventRegistry) define(event asyncEvent) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if old, ok := reg.events[event.Name]; ok {
+		close(old.stop)
+	}
+	scheduled := &scheduledEvent{asyncEvent: event, stop: make(chan struct{})}
+	reg.events[event.Name] = scheduled
+	if interval := time.Duration(event.Interval); interval > 0 {
+		go scheduled.run(interval)
+	}
+}
+
+func (reg *asyncEventRegistry) get(name string) (asyncEvent, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	event, ok := reg.events[name]
+	if !ok {
+		return asyncEvent{}, false
+	}
+	return event.asyncEvent, true
+}
+
+func (reg *asyncEventRegistry) list() []asyncEvent {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	events := make([]asyncEvent, 0, len(reg.events))
+	for _, event := range reg.events {
+		events = append(events, event.asyncEvent)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	return events
+}
+
+func (reg *asyncEventRegistry) remove(name string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	event, ok := reg.events[name]
+	if ok {
+		close(event.stop)
+		delete(reg.events, name)
+	}
+	return ok
+}
+
+func (reg *asyncEventRegistry) clear() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	n := len(reg.events)
+	for name, event := range reg.events {
+		close(event.stop)
+		delete(reg.events, name)
+	}
+	return n
+}
+
+func (e *scheduledEvent) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.fire(templateData{Now: clock.now(), rand: unseededRand}, 0)
+		}
+	}
+}
+
+func (e asyncEvent) fire(data templateData, mockID int) error {
+	req, err := renderWebhook(e.Webhook, data)
+	if err != nil {
+		return fmt.Errorf("event %s not sent: %v", e.Name, err)
+	}
+	go req.send(mockID)
+	return nil
+}
+
+// fireAsyncEvents sends the imported events a mock names in options.events.
+func fireAsyncEvents(mockResp *MockResponse, data templateData) {
+	for _, name := range mockResp.Options.Events {
+		event, ok := asyncEvents.get(name)
+		if !ok {
+			log.Printf("Mock %d sends unknown event %q", mockResp.ID, name)
+			continue
+		}
+		if err := event.fire(data, mockResp.ID); err != nil {
+			log.Printf("Mock %d: %v", mockResp.ID, err)
+		}
+	}
+}
+
+// loadAsyncAPIFiles imports the events of AsyncAPI documents at startup.
+func loadAsyncAPIFiles(files []string, target string) error {
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		events, err := parseAsyncAPI(data, target, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, event := range events {
+			asyncEvents.define(event)
+		}
+		fmt.Printf("AsyncAPI events loaded from %s: %d\n", file, len(events))
+	}
+	return nil
+}
+
+// asyncDocument is a decoded AsyncAPI 2.x or 3.x document.
+type asyncDocument map[string]interface{}
+
+// resolve follows local $refs such as "#/components/messages/UserSignedUp".
+func (doc asyncDocument) resolve(value interface{}) interface{} {
+	for depth := 0; depth < 32; depth++ {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		ref, ok := object["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#/") {
+			return value
+		}
+		var target interface{} = map[string]interface{}(doc)
+		for _, token := range strings.Split(ref[2:], "/") {
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			parent, ok := target.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			target = parent[token]
+		}
+		value = target
+	}
+	return value
+}
+
+func (doc asyncDocument) object(value interface{}) map[string]interface{} {
+	object, _ := doc.resolve(value).(map[string]interface{})
+	return object
+}
+
+func stringField(object map[string]interface{}, key string) string {
+	s, _ := object[key].(string)
+	return s
+}
+
+// parseAsyncAPI lists the events sent by the service an AsyncAPI document
+// describes: the subscribe operations of 2.x documents and the send
+// operations of 3.x ones. Channel addresses are resolved against target or,
+// without one, the document's first HTTP server.
+func parseAsyncAPI(data []byte, target string, interval time.Duration) ([]asyncEvent, error) {
+	// Decoded into a plain map: yaml.v3 would give nested mappings the
+	// document's named type.
+	var decoded map[string]interface{}
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid AsyncAPI document: %v", err)
+	}
+	doc := asyncDocument(decoded)
+	version := fmt.Sprint(doc["asyncapi"])
+	if doc["asyncapi"] == nil {
+		return nil, fmt.Errorf("not an AsyncAPI document")
+	}
+	if target == "" {
+		target = doc.httpServer()
+	}
+	if target == "" {
+		return nil, fmt.Errorf("document has no HTTP server; a target URL is required")
+	}
+
+	var events []asyncEvent
+	add := func(name, address string, channel, operation map[string]interface{}, messages []interface{}) {
+		for i, raw := range messages {
+			message := doc.object(raw)
+			if message == nil {
+				continue
+			}
+			eventName := name
+			if len(messages) > 1 {
+				messageName := stringField(message, "name")
+				if messageName == "" {
+					messageName = strconv.Itoa(i + 1)
+				}
+				eventName += "." + messageName
+			}
+			event := asyncEvent{
+				Name:    eventName,
+				Channel: address,
+				Summary: stringField(operation, "summary"),
+				Webhook: doc.webhook(target, address, channel, operation, message),
+			}
+			event.Interval = jsonDuration(interval)
+			schedule := stringField(operation, "x-schedule")
+			if schedule == "" {
+				schedule = stringField(channel, "x-schedule")
+			}
+			if d, err := time.ParseDuration(schedule); err == nil {
+				event.Interval = jsonDuration(d)
+			}
+			events = append(events, event)
+		}
+	}
+
+	channels, _ := doc["channels"].(map[string]interface{})
+	if strings.HasPrefix(version, "2.") {
+		for key, raw := range channels {
+			channel := doc.object(raw)
+			operation := doc.object(channel["subscribe"])
+			if operation == nil {
+				continue
+			}
+			name := stringField(operation, "operationId")
+			if name == "" {
+				name = key
+			}
+			message := doc.object(operation["message"])
+			messages := []interface{}{message}
+			if oneOf, ok := message["oneOf"].([]interface{}); ok {
+				messages = oneOf
+			}
+			add(name, key, channel, operation, messages)
+		}
+	} else if strings.HasPrefix(version, "3.") {
+		operations, _ := doc["operations"].(map[string]interface{})
+		for name, raw := range operations {
+			operation := doc.object(raw)
+			if stringField(operation, "action") != "send" {
+				continue
+			}
+			channel := doc.object(operation["channel"])
+			address := stringField(channel, "address")
+			if address == "" {
+				ref, _ := doc.object(raw)["channel"].(map[string]interface{})
+				address = refName(stringField(ref, "$ref"))
+			}
+			messages, _ := operation["messages"].([]interface{})
+			if len(messages) == 0 {
+				channelMessages, _ := channel["messages"].(map[string]interface{})
+				keys := make([]string, 0, len(channelMessages))
+				for key := range channelMessages {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					messages = append(messages, channelMessages[key])
+				}
+			}
+			add(name, address, channel, operation, messages)
+		}
+	} else {
+		return nil, fmt.Errorf("unsupported AsyncAPI version %s", version)
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("document describes no events sent by the service")
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+	return events, nil
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// httpServer returns the URL of the first server speaking HTTP.
+func (doc asyncDocument) httpServer() string {
+	servers, _ := doc["servers"].(map[string]interface{})
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		server := doc.object(servers[name])
+		protocol := stringField(server, "protocol")
+		if protocol != "http" && protocol != "https" {
+			continue
+		}
+		address := stringField(server, "url")
+		if address == "" {
+			address = stringField(server, "host") + stringField(server, "pathname")
+		}
+		if !strings.Contains(address, "://") {
+			address = protocol + "://" + address
+		}
+		return address
+	}
+	return ""
+}
+
+var channelParameter = regexp.MustCompile(`\{([^{}]+)\}`)
+
+func (doc asyncDocument) webhook(target, address string, channel, operation, message map[string]interface{}) webhookOptions {
+	parameters := doc.object(channel["parameters"])
+	address = channelParameter.ReplaceAllStringFunc(address, func(param string) string {
+		return url.PathEscape(fmt.Sprint(doc.parameterSample(parameters[param[1:len(param)-1]])))
+	})
+
+	hook := webhookOptions{
+		URL:     strings.TrimSuffix(target, "/") + "/" + strings.TrimPrefix(address, "/"),
+		Method:  strings.ToUpper(stringField(doc.object(doc.object(operation["bindings"])["http"]), "method")),
+		Headers: map[string]string{},
+	}
+
+	var payload interface{}
+	var headers map[string]interface{}
+	if examples, ok := message["examples"].([]interface{}); ok && len(examples) > 0 {
+		example := doc.object(examples[0])
+		payload = example["payload"]
+		headers, _ = example["headers"].(map[string]interface{})
+	}
+	if payload == nil {
+		payload = doc.sample(message["payload"], 0)
+	}
+	if headers == nil {
+		headers, _ = doc.sample(message["headers"], 0).(map[string]interface{})
+	}
+	for name, value := range headers {
+		hook.Headers[name] = fmt.Sprint(value)
+	}
+
+	contentType := stringField(message, "contentType")
+	if contentType == "" {
+		contentType = stringField(doc, "defaultContentType")
+	}
+	if contentType != "" {
+		hook.Headers["Content-Type"] = contentType
+	}
+	if s, ok := payload.(string); ok {
+		hook.Body = s
+	} else if payload != nil {
+		body, _ := json.Marshal(payload)
+		hook.Body = string(body)
+	}
+	return hook
+}
+
+func (doc asyncDocument) parameterSample(raw interface{}) interface{} {
+	param := doc.object(raw)
+	if schema := doc.object(param["schema"]); schema != nil {
+		param = schema
+	}
+	if value := schemaExample(param); value != nil {
+		return value
+	}
+	return "1"
+}
+
+// schemaExample returns the value a schema gives as its example, default,
+// constant or first enumerated value.
+func schemaExample(schema map[string]interface{}) interface{} {
+	for _, key := range []string{"example", "const", "default"} {
+		if value, ok := schema[key]; ok {
+			return value
+		}
+	}
+	for _, key := range []string{"examples", "enum"} {
+		if values, ok := schema[key].([]interface{}); ok && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return nil
+}
+
+// sample generates a value for a JSON Schema.
+func (doc asyncDocument) sample(raw interface{}, depth int) interface{} {
+	schema := doc.object(raw)
+	if schema == nil || depth > 8 {
+		return nil
+	}
+	// AsyncAPI 3 wraps payloads of other schema formats in a multi-format schema.
+	if inner, ok := schema["schema"]; ok && schema["schemaFormat"] != nil {
+		return doc.sample(inner, depth)
+	}
+	if value := schemaExample(schema); value != nil {
+		return value
+	}
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		merged := map[string]interface{}{}
+		for _, part := range all {
+			if object, ok := doc.sample(part, depth+1).(map[string]interface{}); ok {
+				for key, value := range object {
+					merged[key] = value
+				}
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alternatives, ok := schema[key].([]interface{}); ok && len(alternatives) > 0 {
+			return doc.sample(alternatives[0], depth+1)
+		}
+	}
+
+	typ := schema["type"]
+	if types, ok := typ.([]interface{}); ok && len(types) > 0 {
+		typ = types[0]
+	}
+	if typ == nil && schema["properties"] != nil {
+		typ = "object"
+	}
+	switch typ {
+	case "object":
+		object := map[string]interface{}{}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range properties {
+			object[name] = doc.sample(property, depth+1)
+		}
+		return object
+	case "array":
+		return []interface{}{doc.sample(schema["items"], depth+1)}
+	case "integer":
+		return 0
+	case "number":
+		return 0.0
+	case "boolean":
+		return true
+	case "string":
+		switch stringField(schema, "format") {
+		case "date-time":
+			return "2024-01-01T00:00:00Z"
+		case "date":
+			return "2024-01-01"
+		case "email":
+			return "user@example.com"
+		case "uuid":
+			return "00000000-0000-4000-8000-000000000000"
+		case "uri", "url":
+			return "https://example.com"
+		}
+		return "string"
+	}
+	return nil
+}
+
+func importAsyncAPIHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "error reading AsyncAPI document"})
+		return
+	}
+	query := r.URL.Query()
+	var interval time.Duration
+	if raw := query.Get("interval"); raw != "" {
+		if interval, err = time.ParseDuration(raw); err != nil || interval < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid interval " + raw})
+			return
+		}
+	}
+	events, err := parseAsyncAPI(data, query.Get("target"), interval)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	for _, event := range events {
+		asyncEvents.define(event)
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"events": events})
+}
+
+func listAsyncEventsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"events": asyncEvents.list()})
+}
+
+// fireAsyncEventHandler sends an event now, with the request's query and
+// JSON body available to its templates.
+func fireAsyncEventHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	event, ok := asyncEvents.get(ps.ByName("name"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "event not found"})
+		return
+	}
+	body, err := readRequestBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := event.fire(newTemplateData(r, body), 0); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, event)
+}
+
+func deleteAsyncEventHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !asyncEvents.remove(ps.ByName("name")) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "event not found"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func clearAsyncEventsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]int{"removed": asyncEvents.clear()})
+}
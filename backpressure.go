@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Without a bound, a burst of mock traffic turns into a queue for the
+// database pool, where every request waits until the lookup times out.
+// MAX_IN_FLIGHT bounds the requests handled at once; up to MAX_QUEUE more
+// wait QUEUE_TIMEOUT for a slot, and the rest are answered 503 at once so
+// clients back off while the requests in flight still finish quickly.
+var (
+	maxInFlight        = envInt("MAX_IN_FLIGHT", 0)
+	maxQueue           = envInt("MAX_QUEUE", 0)
+	queueTimeout       = envDuration("QUEUE_TIMEOUT", time.Second)
+	overloadRetryAfter = envDuration("OVERLOAD_RETRY_AFTER", time.Second)
+
+	inFlightSlots chan struct{}
+	queuedCount   atomic.Int64
+
+	requestsInFlight      = expvar.NewInt("requests_in_flight")
+	requestsQueued        = expvar.NewInt("requests_queued")
+	requestsAdmittedTotal = expvar.NewMap("requests_admitted_total")
+	requestsRejectedTotal = expvar.NewMap("requests_rejected_total")
+	queueWaitSeconds      = expvar.NewFloat("request_queue_wait_seconds_total")
+)
+
+func init() {
+	expvar.Publish("db_pool", expvar.Func(dbPoolStats))
+}
+
+// initBackpressure sizes the pool of request slots; without MAX_IN_FLIGHT
+// requests are not bounded.
+func initBackpressure() {
+	if maxInFlight <= 0 {
+		return
+	}
+	inFlightSlots = make(chan struct{}, maxInFlight)
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	fmt.Printf("Handling at most %d mock requests at once, %d more queued for up to %s\n", maxInFlight, maxQueue, queueTimeout)
+}
+
+// withBackpressure runs mock requests in the bounded pool of slots. Admin
+// requests are not bounded, so an overloaded router can still be inspected.
+func withBackpressure(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if inFlightSlots == nil {
+			next(w, r, ps)
+			return
+		}
+		if !admitRequest(w, r) {
+			return
+		}
+		requestsInFlight.Add(1)
+		defer func() {
+			requestsInFlight.Add(-1)
+			<-inFlightSlots
+		}()
+		next(w, r, ps)
+	}
+}
+
+// admitRequest takes a slot, queueing for one when all are taken, and
+// answers 503 when the queue is full or the wait times out.
+func admitRequest(w http.ResponseWriter, r *http.Request) bool {
+	select {
+	case inFlightSlots <- struct{}{}:
+		requestsAdmittedTotal.Add("immediate", 1)
+		return true
+	default:
+	}
+
+	reason := "queue_full"
+	if queuedCount.Add(1) <= int64(maxQueue) {
+		requestsQueued.Add(1)
+		start := time.Now()
+		timer := time.NewTimer(queueTimeout)
+		var admitted bool
+		select {
+		case inFlightSlots <- struct{}{}:
+			admitted = true
+		case <-timer.C:
+			reason = "timeout"
+		case <-r.Context().Done():
+			reason = "canceled"
+		}
+		timer.Stop()
+		requestsQueued.Add(-1)
+		queuedCount.Add(-1)
+		queueWaitSeconds.Add(time.Since(start).Seconds())
+		if admitted {
+			requestsAdmittedTotal.Add("queued", 1)
+			return true
+		}
+	} else {
+		queuedCount.Add(-1)
+	}
+
+	requestsRejectedTotal.Add(reason, 1)
+	if reason == "canceled" {
+		return false
+	}
+	if overloadRetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((overloadRetryAfter+time.Second-1)/time.Second)))
+	}
+	writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "server overloaded"})
+	return false
+}
+
+// dbPoolStats reports how saturated the primary's connection pool is.
+func dbPoolStats() interface{} {
+	if db == nil {
+		return nil
+	}
+	return sqlPoolStats(db.Stats())
+}
+
+func sqlPoolStats(stats sql.DBStats) map[string]interface{} {
+	return map[string]interface{}{
+		"maxOpen":           stats.MaxOpenConnections,
+		"open":              stats.OpenConnections,
+		"inUse":             stats.InUse,
+		"idle":              stats.Idle,
+		"waitCount":         stats.WaitCount,
+		"waitSeconds":       stats.WaitDuration.Seconds(),
+		"maxIdleClosed":     stats.MaxIdleClosed,
+		"maxLifetimeClosed": stats.MaxLifetimeClosed,
+	}
+}
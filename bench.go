@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mock-db-router/adminapi"
+)
+
+type benchOptions struct {
+	Target      string
+	Rate        float64
+	Duration    time.Duration
+	Warmup      time.Duration
+	Concurrency int
+	Timeout     time.Duration
+}
+
+type benchLatency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+type benchResult struct {
+	Target   string  `json:"target"`
+	Workload int     `json:"workload"`
+	Rate     float64 `json:"targetRps,omitempty"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	// Dropped counts requests not sent on schedule because all workers
+	// were busy: the target rate was not reached.
+	Dropped     int            `json:"dropped"`
+	StatusCodes map[string]int `json:"statusCodes"`
+	Duration    jsonDuration   `json:"duration"`
+	Throughput  float64        `json:"rps"`
+	// LatencyMs holds the latencies of answered requests in milliseconds.
+	LatencyMs benchLatency `json:"latencyMs"`
+	Samples   []string     `json:"errorSamples,omitempty"`
+}
+
+// bench sends the workload round-robin for opts.Duration after a warmup
+// whose requests are not counted. With a rate the requests are scheduled at
+// that rate whatever the latency, otherwise the workers send them back to
+// back.
+func bench(ctx context.Context, opts benchOptions, requests []replayRequest) *benchResult {
+	target := strings.TrimSuffix(opts.Target, "/")
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	client := &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: concurrency,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	result := &benchResult{Target: opts.Target, Workload: len(requests), Rate: opts.Rate, StatusCodes: make(map[string]int)}
+	var mu sync.Mutex
+	var latencies []time.Duration
+	measureFrom := time.Now().Add(opts.Warmup)
+	deadline := measureFrom.Add(opts.Duration)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	record := func(sent time.Time, status int, err error) {
+		if sent.Before(measureFrom) {
+			return
+		}
+		latency := time.Since(sent)
+		if err != nil && ctx.Err() != nil {
+			// Cut off by the end of the run rather than failed.
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		result.Requests++
+		if err != nil {
+			result.Errors++
+			if len(result.Samples) < maxReplayErrors {
+				result.Samples = append(result.Samples, err.Error())
+			}
+			return
+		}
+		result.StatusCodes[strconv.Itoa(status)]++
+		latencies = append(latencies, latency)
+	}
+
+	queue := make(chan replayRequest, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range queue {
+				sent := time.Now()
+				status, err := sendReplayRequest(ctx, client, target, req)
+				record(sent, status, err)
+			}
+		}()
+	}
+
+	var ticker *time.Ticker
+	if interval, _ := rateInterval(opts.Rate); interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+feed:
+	for i := 0; ; i++ {
+		req := requests[i%len(requests)]
+		if ticker == nil {
+			select {
+			case queue <- req:
+			case <-ctx.Done():
+				break feed
+			}
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break feed
+		}
+		select {
+		case queue <- req:
+		default:
+			if !time.Now().Before(measureFrom) {
+				mu.Lock()
+				result.Dropped++
+				mu.Unlock()
+			}
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	elapsed := time.Since(measureFrom)
+	if elapsed > opts.Duration {
+		elapsed = opts.Duration
+	}
+	result.Duration = jsonDuration(elapsed)
+	if elapsed > 0 {
+		result.Throughput = float64(result.Requests) / elapsed.Seconds()
+	}
+	result.LatencyMs = latencySummary(latencies)
+	return result
+}
+
+func latencySummary(latencies []time.Duration) benchLatency {
+	if len(latencies) == 0 {
+		return benchLatency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	percentile := func(p float64) float64 {
+		index := int(float64(len(latencies))*p+0.5) - 1
+		index = max(0, min(index, len(latencies)-1))
+		return ms(latencies[index])
+	}
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	return benchLatency{
+		Min:  ms(latencies[0]),
+		Mean: ms(total / time.Duration(len(latencies))),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P95:  percentile(0.95),
+		P99:  percentile(0.99),
+		P999: percentile(0.999),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+}
+
+// mockWorkload builds one request per enabled mock of a running router, to
+// the mock's path with its request body. ANY mocks get GET.
+func mockWorkload(ctx context.Context, client *adminapi.Client, workspace string) ([]replayRequest, error) {
+	enabled := true
+	params := adminapi.ListMocksParams{Workspace: workspace, Enabled: &enabled, Limit: 500}
+	var requests []replayRequest
+	for {
+		list, err := client.ListMocks(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, mock := range list.Mocks {
+			req := replayRequest{ID: int64(mock.ID), Method: strings.ToUpper(mock.Method), Path: mock.Path, Headers: http.Header{}}
+			if req.Method == anyMethod {
+				req.Method = http.MethodGet
+			}
+			if len(mock.RequestBody) > 0 && string(mock.RequestBody) != "null" {
+				req.Body = string(mock.RequestBody)
+				req.Headers.Set("Content-Type", "application/json")
+			}
+			if mock.Workspace != "" {
+				req.Headers.Set("X-Mock-Workspace", mock.Workspace)
+			}
+			requests = append(requests, req)
+		}
+		if list.NextCursor == "" {
+			return requests, nil
+		}
+		params.Cursor = list.NextCursor
+	}
+}
+
+// runBenchCommand generates load against a router from its mocks or its
+// journal and reports throughput and latency percentiles.
+func runBenchCommand(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	var opts benchOptions
+	var filter replayFilter
+	flags.StringVar(&opts.Target, "target", "", "base URL of the router under load; -admin-url by default")
+	from := flags.String("from", "mocks", "workload: mocks (one request per enabled mock) or journal (recorded requests)")
+	flags.Float64Var(&opts.Rate, "rps", 0, "target requests per second (0 sends as fast as the workers can)")
+	flags.DurationVar(&opts.Duration, "duration", 30*time.Second, "how long to measure")
+	flags.DurationVar(&opts.Warmup, "warmup", 0, "how long to send requests before measuring")
+	flags.IntVar(&opts.Concurrency, "concurrency", 10, "number of requests in flight")
+	flags.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "timeout of each request")
+	flags.StringVar(&filter.Workspace, "workspace", "", "only use mocks or requests of this workspace")
+	flags.StringVar(&filter.Method, "method", "", "only use journal requests with this method")
+	flags.StringVar(&filter.PathPrefix, "path-prefix", "", "only use journal requests whose path starts with this prefix")
+	flags.IntVar(&filter.Limit, "limit", 10000, "maximum number of journal requests to load")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	indexSize := flags.Int("path-index", 0, "measure path pattern matching over this many synthetic patterns instead of a router")
+	lookupSize := flags.Int("lookup", 0, "measure mock lookups over this many synthetic mocks in an embedded Postgres instead of a router")
+	flags.Parse(args)
+
+	if *lookupSize > 0 {
+		result, err := benchMockLookup(*lookupSize, 10000)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			return 1
+		}
+		if *asJSON {
+			printJSON(result)
+			return 0
+		}
+		printMockLookupResult(result)
+		return 0
+	}
+
+	if *indexSize > 0 {
+		result := benchPathIndex(*indexSize, 100000)
+		if *asJSON {
+			printJSON(result)
+			return 0
+		}
+		printPathIndexResult(result)
+		return 0
+	}
+
+	if opts.Target == "" {
+		opts.Target = *adminURL
+	}
+	if opts.Duration <= 0 {
+		fmt.Fprintln(os.Stderr, "bench: -duration must be positive")
+		return 2
+	}
+	if _, err := rateInterval(opts.Rate); err != nil {
+		fmt.Fprintln(os.Stderr, "bench: -rps:", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var requests []replayRequest
+	var err error
+	switch *from {
+	case "mocks":
+		requests, err = mockWorkload(ctx, newAdminClient(*adminURL), filter.Workspace)
+		if err != nil {
+			printAdminError("bench", err)
+			return 1
+		}
+	case "journal":
+		if err := initDB(); err != nil {
+			fmt.Fprintln(os.Stderr, "Database initialization failed:", err)
+			return 1
+		}
+		requests, err = loadReplayRequests(ctx, filter)
+		db.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading journal:", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "bench: unknown workload %q; use mocks or journal\n", *from)
+		return 2
+	}
+	if len(requests) == 0 {
+		fmt.Fprintln(os.Stderr, "bench: the workload is empty")
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Sending %d distinct requests to %s for %s\n", len(requests), opts.Target, opts.Duration)
+	result := bench(ctx, opts, requests)
+	if *asJSON {
+		printJSON(result)
+		return 0
+	}
+	printBenchResult(result)
+	return 0
+}
+
+func printBenchResult(result *benchResult) {
+	fmt.Printf("Requests:   %d in %s (%.1f/s", result.Requests, time.Duration(result.Duration).Round(time.Millisecond), result.Throughput)
+	if result.Rate > 0 {
+		fmt.Printf(", target %.1f/s", result.Rate)
+	}
+	fmt.Println(")")
+	fmt.Printf("Errors:     %d\n", result.Errors)
+	if result.Dropped > 0 {
+		fmt.Printf("Dropped:    %d (all workers busy; raise -concurrency)\n", result.Dropped)
+	}
+	codes := make([]string, 0, len(result.StatusCodes))
+	for code := range result.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("Status %s: %d\n", code, result.StatusCodes[code])
+	}
+	l := result.LatencyMs
+	fmt.Printf("Latency:    min %.2fms  mean %.2fms  max %.2fms\n", l.Min, l.Mean, l.Max)
+	fmt.Printf("            p50 %.2fms  p90 %.2fms  p95 %.2fms  p99 %.2fms  p99.9 %.2fms\n", l.P50, l.P90, l.P95, l.P99, l.P999)
+	for _, sample := range result.Samples {
+		fmt.Println("Error:", sample)
+	}
+}
+
+type pathIndexBenchResult struct {
+	Patterns int          `json:"patterns"`
+	Build    jsonDuration `json:"build"`
+	Lookups  int          `json:"lookups"`
+	// Matched counts lookups that found their pattern.
+	Matched   int          `json:"matched"`
+	LatencyMs benchLatency `json:"latencyMs"`
+}
+
+// syntheticPatterns returns n patterns, mostly with parameters and some
+// wildcards and regexps, spread over 100 services.
+func syntheticPatterns(n int) map[int]pathIndexEntry {
+	entries := make(map[int]pathIndexEntry, n)
+	for i := 0; i < n; i++ {
+		var path string
+		switch {
+		case i%50 == 0:
+			path = fmt.Sprintf("~/svc%d/r%d/v[0-9]+/(?P<id>[0-9]+)", i%100, i)
+		case i%10 == 0:
+			path = fmt.Sprintf("/svc%d/r%d/files/*name", i%100, i)
+		default:
+			path = fmt.Sprintf("/svc%d/r%d/items/:id/parts/:part", i%100, i)
+		}
+		entries[i] = pathIndexEntry{path: path, method: http.MethodGet}
+	}
+	return entries
+}
+
+// syntheticPatternPath returns the i-th request path matching pattern id of
+// syntheticPatterns.
+func syntheticPatternPath(id, i int) string {
+	switch {
+	case id%50 == 0:
+		return fmt.Sprintf("/svc%d/r%d/v2/%d", id%100, id, i)
+	case id%10 == 0:
+		return fmt.Sprintf("/svc%d/r%d/files/reports/%d.csv", id%100, id, i)
+	}
+	return fmt.Sprintf("/svc%d/r%d/items/%d/parts/p%d", id%100, id, i, i%7)
+}
+
+// benchPathIndex builds an index of n synthetic patterns and times lookups
+// of paths each matching a random pattern.
+func benchPathIndex(n, lookups int) *pathIndexBenchResult {
+	entries := syntheticPatterns(n)
+	start := time.Now()
+	index := &pathPatternIndex{}
+	index.set(entries)
+	result := &pathIndexBenchResult{Patterns: n, Build: jsonDuration(time.Since(start)), Lookups: lookups}
+
+	random := rand.New(rand.NewSource(1))
+	latencies := make([]time.Duration, 0, lookups)
+	for i := 0; i < lookups; i++ {
+		id := random.Intn(n)
+		path := syntheticPatternPath(id, i)
+		sent := time.Now()
+		matched := index.match([]string{path}, http.MethodGet)
+		latencies = append(latencies, time.Since(sent))
+		if slices.Contains(matched, entries[id].path) {
+			result.Matched++
+		}
+	}
+	result.LatencyMs = latencySummary(latencies)
+	return result
+}
+
+func printPathIndexResult(result *pathIndexBenchResult) {
+	fmt.Printf("Patterns:   %d indexed in %s\n", result.Patterns, time.Duration(result.Build).Round(time.Millisecond))
+	fmt.Printf("Lookups:    %d, %d matched their pattern\n", result.Lookups, result.Matched)
+	l := result.LatencyMs
+	fmt.Printf("Latency:    min %.3fms  mean %.3fms  max %.3fms\n", l.Min, l.Mean, l.Max)
+	fmt.Printf("            p50 %.3fms  p90 %.3fms  p95 %.3fms  p99 %.3fms  p99.9 %.3fms\n", l.P50, l.P90, l.P95, l.P99, l.P999)
+}
+
+type mockLookupBenchResult struct {
+	Mocks   int          `json:"mocks"`
+	Seed    jsonDuration `json:"seed"`
+	Lookups int          `json:"lookups"`
+	// Matched counts lookups answered by the mock they were sent for.
+	Matched   int          `json:"matched"`
+	LatencyMs benchLatency `json:"latencyMs"`
+}
+
+// benchMockLookup seeds n mocks in a throwaway embedded Postgres and times
+// the whole lookup of requests each meant for a random mock: the path
+// index, the query and the matching. Of every ten mocks, one has a path
+// pattern, one matches query strings through options.query and the others
+// have a literal path.
+func benchMockLookup(n, lookups int) (*mockLookupBenchResult, error) {
+	stop, err := startEmbeddedPostgres()
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+	if err := initDB(); err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if err := createSchema(ctx); err != nil {
+		return nil, err
+	}
+	if err := initBodyHasher(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	_, err = db.ExecContext(ctx, `INSERT INTO return.mock_responses (path, method, response_body, options)
+		SELECT '/svc' || i % 100 || '/r' || i || CASE i % 10
+		           WHEN 0 THEN '/items/:id'
+		           WHEN 5 THEN '/search?page=1'
+		           ELSE '/items' END,
+		       'GET', '{"id": ' || i || '}',
+		       CASE WHEN i % 10 = 5 THEN '{"query": {"ignore": ["ts"]}}'::jsonb END
+		FROM generate_series(0, $1 - 1) AS i`, n)
+	if err != nil {
+		return nil, fmt.Errorf("error seeding mocks: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "ANALYZE return.mock_responses"); err != nil {
+		return nil, err
+	}
+	if err := pathIndex.reload(nil); err != nil {
+		return nil, err
+	}
+	result := &mockLookupBenchResult{Mocks: n, Seed: jsonDuration(time.Since(start)), Lookups: lookups}
+
+	random := rand.New(rand.NewSource(1))
+	latencies := make([]time.Duration, 0, lookups)
+	for i := 0; i < lookups; i++ {
+		id := random.Intn(n)
+		path := fmt.Sprintf("/svc%d/r%d/items", id%100, id)
+		switch id % 10 {
+		case 0:
+			path += "/" + strconv.Itoa(i)
+		case 5:
+			path = fmt.Sprintf("/svc%d/r%d/search?page=1&ts=%d", id%100, id, i)
+		}
+		r, err := http.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		sent := time.Now()
+		mockResp, err := getMockResponse(r, path, "", newTemplateData(r, ""))
+		latencies = append(latencies, time.Since(sent))
+		if err == nil && mockResp.ResponseBody == fmt.Sprintf(`{"id": %d}`, id) {
+			result.Matched++
+		}
+	}
+	result.LatencyMs = latencySummary(latencies)
+	return result, nil
+}
+
+func printMockLookupResult(result *mockLookupBenchResult) {
+	fmt.Printf("Mocks:      %d seeded in %s\n", result.Mocks, time.Duration(result.Seed).Round(time.Millisecond))
+	fmt.Printf("Lookups:    %d, %d answered by their mock\n", result.Lookups, result.Matched)
+	l := result.LatencyMs
+	fmt.Printf("Latency:    min %.3fms  mean %.3fms  max %.3fms\n", l.Min, l.Mean, l.Max)
+	fmt.Printf("            p50 %.3fms  p90 %.3fms  p95 %.3fms  p99 %.3fms  p99.9 %.3fms\n", l.P50, l.P90, l.P95, l.P99, l.P999)
+}
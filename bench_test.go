@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// BenchmarkPathIndexMatch times a lookup among 100,000 synthetic patterns,
+// as bench -path-index does, so regressions show in go test -bench.
+func BenchmarkPathIndexMatch(b *testing.B) {
+	const n = 100000
+	index := &pathPatternIndex{}
+	index.set(syntheticPatterns(n))
+	paths := make([]string, 1024)
+	for i := range paths {
+		paths[i] = syntheticPatternPath(i*97%n, i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(index.match(paths[i%len(paths):i%len(paths)+1], http.MethodGet)) == 0 {
+			b.Fatalf("%s matched no pattern", paths[i%len(paths)])
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// bodyCodec converts between a binary body format and the JSON the matcher
+// pipeline and mock definitions work with.
+type bodyCodec struct {
+	name   string
+	decode func([]byte) (interface{}, error)
+	encode func(interface{}) ([]byte, error)
+}
+
+var (
+	cborEncoder, _ = cbor.CanonicalEncOptions().EncMode()
+
+	msgpackCodec = &bodyCodec{
+		name: "application/msgpack",
+		decode: func(data []byte) (interface{}, error) {
+			var value interface{}
+			err := msgpack.Unmarshal(data, &value)
+			return value, err
+		},
+		encode: func(value interface{}) ([]byte, error) {
+			var buf bytes.Buffer
+			enc := msgpack.NewEncoder(&buf)
+			enc.SetSortMapKeys(true)
+			enc.UseCompactInts(true)
+			err := enc.Encode(value)
+			return buf.Bytes(), err
+		},
+	}
+	cborCodec = &bodyCodec{
+		name: "application/cbor",
+		decode: func(data []byte) (interface{}, error) {
+			var value interface{}
+			err := cbor.Unmarshal(data, &value)
+			return value, err
+		},
+		encode: func(value interface{}) ([]byte, error) {
+			return cborEncoder.Marshal(value)
+		},
+	}
+)
+
+func codecForMediaType(mediaType string) *bodyCodec {
+	switch {
+	case mediaType == "application/msgpack", mediaType == "application/x-msgpack", mediaType == "application/vnd.msgpack":
+		return msgpackCodec
+	case mediaType == "application/cbor", strings.HasSuffix(mediaType, "+cbor"):
+		return cborCodec
+	}
+	return nil
+}
+
+func codecForContentType(contentType string) *bodyCodec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	return codecForMediaType(mediaType)
+}
+
+// acceptedCodec picks the binary format a client prefers in its Accept
+// header. It returns nil when JSON is preferred or no binary format is named.
+func acceptedCodec(accept string) *bodyCodec {
+	var best *bodyCodec
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		if codec := codecForMediaType(mediaType); codec != nil {
+			best, bestQ = codec, q
+		} else if mediaType == "application/json" {
+			best, bestQ = nil, q
+		}
+	}
+	return best
+}
+
+// jsonCompatible rewrites decoded values json.Marshal cannot handle, such as
+// maps with non-string keys and CBOR tags.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonCompatible(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonCompatible(item)
+		}
+		return v
+	case cbor.Tag:
+		return jsonCompatible(v.Content)
+	}
+	return value
+}
+
+// binaryValue reads a JSON document with its numbers as integers where they
+// are whole, so they are encoded compactly rather than as floats.
+func binaryValue(body string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var convert func(interface{}) interface{}
+	convert = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				return n
+			}
+			f, _ := v.Float64()
+			return f
+		case map[string]interface{}:
+			for key, item := range v {
+				v[key] = convert(item)
+			}
+		case []interface{}:
+			for i, item := range v {
+				v[i] = convert(item)
+			}
+		}
+		return value
+	}
+	return convert(value), nil
+}
+
+// decodeRequestBody returns the JSON form of a binary request body
+// (protobuf, MessagePack or CBOR) so it goes through the same matching as a
+// JSON body. ok is false for bodies in any other format.
+func decodeRequestBody(header http.Header, body string) (string, bool, error) {
+	if decoded, ok, err := decodeProtobufRequest(header, body); ok || err != nil {
+		return decoded, ok, err
+	}
+	codec := codecForContentType(header.Get("Content-Type"))
+	if codec == nil || body == "" {
+		return "", false, nil
+	}
+	value, err := codec.decode([]byte(body))
+	if err != nil {
+		return "", false, fmt.Errorf("invalid %s body: %v", codec.name, err)
+	}
+	data, err := json.Marshal(jsonCompatible(value))
+	if err != nil {
+		return "", false, fmt.Errorf("%s body has no JSON form: %v", codec.name, err)
+	}
+	return string(data), true, nil
+}
+
+// encodeBinaryResponse encodes a JSON response body as MessagePack or CBOR
+// when the mock's Content-Type names that format or, for mocks without a
+// Content-Type, when the client asks for it in Accept.
+func encodeBinaryResponse(mockResp *MockResponse, r *http.Request) error {
+	var contentType string
+	for name, value := range parseHeaders(mockResp.Headers) {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+	}
+
+	codec := codecForContentType(contentType)
+	if codec == nil {
+		if contentType != "" || mockResp.contentType != "" {
+			return nil
+		}
+		codec = acceptedCodec(r.Header.Get("Accept"))
+		if codec == nil || !json.Valid([]byte(mockResp.ResponseBody)) {
+			return nil
+		}
+		mockResp.contentType = codec.name
+	}
+
+	value, err := binaryValue(mockResp.ResponseBody)
+	if err != nil {
+		return fmt.Errorf("response body is not JSON: %v", err)
+	}
+	data, err := codec.encode(value)
+	if err != nil {
+		return err
+	}
+	mockResp.ResponseBody = string(data)
+	return nil
+}
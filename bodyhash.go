@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+type bodyHasher struct {
+	prefix string
+	sum    func(data []byte) []byte
+}
+
+var hasher *bodyHasher
+
+// newBodyHasher builds the fingerprint function for mock request bodies.
+// Hashes are stored with a prefix naming the algorithm and salt, so rows
+// hashed under a different configuration are recognized as stale.
+func newBodyHasher(algorithm, salt string) (*bodyHasher, error) {
+	prefix := algorithm
+	if salt != "" {
+		saltID := sha256.Sum256([]byte(salt))
+		prefix += "+" + hex.EncodeToString(saltID[:4])
+	}
+
+	switch algorithm {
+	case "sha256":
+		return &bodyHasher{prefix: prefix + ":", sum: func(data []byte) []byte {
+			if salt == "" {
+				sum := sha256.Sum256(data)
+				return sum[:]
+			}
+			mac := hmac.New(sha256.New, []byte(salt))
+			mac.Write(data)
+			return mac.Sum(nil)
+		}}, nil
+	case "xxhash":
+		return &bodyHasher{prefix: prefix + ":", sum: func(data []byte) []byte {
+			digest := xxhash.New()
+			digest.WriteString(salt)
+			digest.Write(data)
+			return binary.BigEndian.AppendUint64(nil, digest.Sum64())
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported body hash algorithm %q (use sha256 or xxhash)", algorithm)
+	}
+}
+
+func initBodyHasher() error {
+	var err error
+	hasher, err = newBodyHasher(envString("BODY_HASH_ALGORITHM", "sha256"), envString("BODY_HASH_SALT", ""))
+	return err
+}
+
+// canonicalJSON re-encodes a JSON document with sorted object keys and no
+// insignificant whitespace, so equivalent bodies compare equal byte for byte.
+func canonicalJSON(body string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(body)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func bodyHash(body string) (string, error) {
+	canonical, err := canonicalJSON(body)
+	if err != nil {
+		return "", err
+	}
+	return hasher.prefix + hex.EncodeToString(hasher.sum(canonical)), nil
+}
+
+func storeBodyHash(id int, hash string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE return.mock_responses SET request_body_hash = $2
+		WHERE id = $1 AND (request_body_hash IS NULL OR NOT starts_with(request_body_hash, $3))
+	`, id, hash, hasher.prefix)
+	if err != nil {
+		log.Printf("Error storing body hash of mock %d: %v", id, err)
+	}
+}
+
+// backfillBodyHashes hashes the request bodies of mocks inserted without a
+// hash or hashed under another configuration. Rows added later are hashed
+// lazily the first time they are looked up.
+func backfillBodyHashes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, request_body::text FROM return.mock_responses
+		WHERE request_body IS NOT NULL
+		  AND (request_body_hash IS NULL OR NOT starts_with(request_body_hash, $1))
+	`, hasher.prefix)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	hashes := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var body string
+		if err := rows.Scan(&id, &body); err != nil {
+			return err
+		}
+		hash, err := bodyHash(body)
+		if err != nil {
+			log.Printf("Mock %d has an unparseable request body: %v", id, err)
+			continue
+		}
+		hashes[id] = hash
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for id, hash := range hashes {
+		storeBodyHash(id, hash)
+	}
+	if len(hashes) > 0 {
+		log.Printf("Hashed request bodies of %d mocks", len(hashes))
+	}
+	return nil
+}
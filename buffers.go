@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// maxPooledBuffer keeps the buffers of exceptionally large bodies out of
+// the pool, so one upload does not pin megabytes for the process lifetime.
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// readBody reads a body into a pooled buffer sized from the announced
+// length, and copies it once into the returned string.
+func readBody(body io.Reader, contentLength int64) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if contentLength > 0 && contentLength <= maxPooledBuffer {
+		buf.Grow(int(contentLength) + bytes.MinRead)
+	}
+	if _, err := buf.ReadFrom(body); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// bytesOf returns the bytes of s without copying them, for functions such
+// as json.Unmarshal and json.Valid that only read their input. The slice
+// must not be modified.
+func bytesOf(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// setContentLength announces the length of a body about to be written in
+// one go, so net/http sends it without chunked encoding. Headers set by the
+// mock win.
+func setContentLength(header http.Header, status int, body string) {
+	if body == "" || !bodyAllowedForStatus(status) {
+		return
+	}
+	if header.Get("Content-Length") != "" || header.Get("Transfer-Encoding") != "" {
+		return
+	}
+	header["Content-Length"] = []string{strconv.Itoa(len(body))}
+}
+
+func bodyAllowedForStatus(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// newBodyReader lets handlers after the router's own read the body again,
+// sharing the bytes of the string read.
+func newBodyReader(body string) io.ReadCloser {
+	if body == "" {
+		return http.NoBody
+	}
+	return io.NopCloser(strings.NewReader(body))
+}
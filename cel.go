@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+)
+
+var (
+	celEnv      *cel.Env
+	celEnvErr   error
+	celEnvOnce  sync.Once
+	celPrograms sync.Map
+)
+
+func matchEnv() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		celEnv, celEnvErr = cel.NewEnv(
+			cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("body", cel.DynType),
+			cel.CrossTypeNumericComparisons(true),
+		)
+	})
+	return celEnv, celEnvErr
+}
+
+func compileMatchExpression(expression string) (cel.Program, error) {
+	if cached, ok := celPrograms.Load(expression); ok {
+		return cached.(cel.Program), nil
+	}
+
+	env, err := matchEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	celPrograms.Store(expression, program)
+	return program, nil
+}
+
+func celActivation(data templateData) map[string]interface{} {
+	header := make(map[string]string, len(data.Header))
+	for key, values := range data.Header {
+		if len(values) > 0 {
+			header[strings.ToLower(key)] = values[0]
+		}
+	}
+	return map[string]interface{}{
+		"request": map[string]interface{}{
+			"host":      data.Host,
+			"clientIp":  data.ClientIP,
+			"country":   data.Country,
+			"method":    data.Method,
+			"path":      data.Path,
+			"query":     flattenValues(data.Query),
+			"header":    header,
+			"body":      data.Body,
+			"workspace": data.Workspace,
+			"session":   data.Session,
+			"profile":   data.Profile,
+		},
+		"body": data.JSON,
+	}
+}
+
+func matchesExpression(mockID int, expression string, activation map[string]interface{}) bool {
+	program, err := compileMatchExpression(expression)
+	if err != nil {
+		log.Printf("Invalid match expression in mock %d: %v", mockID, err)
+		return false
+	}
+	result, _, err := program.Eval(activation)
+	if err != nil {
+		return false
+	}
+	matched, ok := result.Value().(bool)
+	return ok && matched
+}
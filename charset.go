@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// Content encodings a mock can compress its body with.
+var responseContentEncodings = map[string]bool{"gzip": true, "deflate": true}
+
+// responseCharset looks up a charset by its IANA name or alias, such as
+// ISO-8859-9 or latin5, and returns its preferred MIME name.
+func responseCharset(name string) (encoding.Encoding, string, error) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown charset %q", name)
+	}
+	if enc == nil {
+		// Known to IANA but without an encoder here.
+		return nil, "", fmt.Errorf("charset %q is not supported", name)
+	}
+	mimeName, err := ianaindex.MIME.Name(enc)
+	if err != nil {
+		mimeName = strings.ToUpper(name)
+	}
+	return enc, mimeName, nil
+}
+
+// encodeResponseBody applies the charset, contentEncoding and base64Body
+// options to the final response body. The body is transcoded from UTF-8 and
+// compressed unless it is stored as base64, in which case it is sent as
+// stored and the options only label it.
+func encodeResponseBody(mockResp *MockResponse) error {
+	opts := mockResp.Options
+	body := []byte(mockResp.ResponseBody)
+	if opts.Base64Body {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(mockResp.ResponseBody))
+		if err != nil {
+			return fmt.Errorf("response body is not base64: %v", err)
+		}
+		body = decoded
+	}
+
+	if opts.Charset != "" {
+		enc, name, err := responseCharset(opts.Charset)
+		if err != nil {
+			return err
+		}
+		if !opts.Base64Body {
+			body, err = enc.NewEncoder().Bytes(body)
+			if err != nil {
+				return fmt.Errorf("response body cannot be encoded as %s: %v", name, err)
+			}
+		}
+		mockResp.charset = name
+	}
+
+	if encName := strings.ToLower(opts.ContentEncoding); encName != "" && encName != "identity" {
+		if !responseContentEncodings[encName] {
+			return fmt.Errorf("unsupported content encoding %q", opts.ContentEncoding)
+		}
+		if !opts.Base64Body {
+			var err error
+			body, err = compressBody(encName, body)
+			if err != nil {
+				return err
+			}
+		}
+		mockResp.contentEncoding = encName
+	}
+
+	mockResp.ResponseBody = string(body)
+	return nil
+}
+
+func compressBody(encName string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encName == "gzip" {
+		w = gzip.NewWriter(&buf)
+	} else {
+		// HTTP's deflate is the zlib format.
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, fmt.Errorf("%s compression failed: %v", encName, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("%s compression failed: %v", encName, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// setEncodingHeaders labels the response with the charset and content
+// encoding encodeResponseBody applied, replacing a charset the Content-Type
+// names already.
+func setEncodingHeaders(header http.Header, mockResp *MockResponse) {
+	if mockResp.charset != "" {
+		contentType := header.Get("Content-Type")
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType, params = strings.TrimSpace(strings.Split(contentType, ";")[0]), nil
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params["charset"] = mockResp.charset
+		if formatted := mime.FormatMediaType(mediaType, params); formatted != "" {
+			header.Set("Content-Type", formatted)
+		}
+	}
+	if mockResp.contentEncoding != "" {
+		header.Set("Content-Encoding", mockResp.contentEncoding)
+		header.Del("Content-Length")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const defaultCircuitOpenFor = 30 * time.Second
+
+type circuitBreakerOptions struct {
+	TripAfter int          `json:"tripAfter"`
+	OpenFor   jsonDuration `json:"openFor,omitempty"`
+	Status    int          `json:"status,omitempty"`
+	Body      string       `json:"body,omitempty"`
+}
+
+type circuitState struct {
+	Workspace string    `json:"workspace"`
+	Session   string    `json:"session,omitempty"`
+	Path      string    `json:"path"`
+	Hits      int       `json:"hits"`
+	OpenUntil time.Time `json:"openUntil,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Body      string    `json:"body,omitempty"`
+}
+
+type circuitBreakers struct {
+	mu       sync.Mutex
+	circuits map[string]*circuitState
+}
+
+var breakers = &circuitBreakers{circuits: make(map[string]*circuitState)}
+
+func circuitKey(workspace, session, path string) string {
+	return workspace + "|" + session + "|" + path
+}
+
+func (b *circuitBreakers) state(workspace, session, path string) *circuitState {
+	key := circuitKey(workspace, session, path)
+	state, ok := b.circuits[key]
+	if !ok {
+		state = &circuitState{Workspace: workspace, Session: session, Path: path}
+		b.circuits[key] = state
+	}
+	return state
+}
+
+func (b *circuitBreakers) open(workspace, session, path string, until time.Time, status int, body string) *circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state(workspace, session, path)
+	state.Hits = 0
+	state.OpenUntil = until
+	state.Status = status
+	state.Body = body
+	copied := *state
+	return &copied
+}
+
+func (b *circuitBreakers) openState(workspace, session, path string, now time.Time) (circuitState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.circuits[circuitKey(workspace, session, path)]
+	if !ok || !now.Before(state.OpenUntil) {
+		return circuitState{}, false
+	}
+	return *state, true
+}
+
+func (b *circuitBreakers) hit(workspace, session, path string, opts *circuitBreakerOptions, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state(workspace, session, path)
+	state.Hits++
+	if state.Hits < opts.TripAfter {
+		return
+	}
+	openFor := time.Duration(opts.OpenFor)
+	if openFor <= 0 {
+		openFor = defaultCircuitOpenFor
+	}
+	state.Hits = 0
+	state.OpenUntil = now.Add(openFor)
+	state.Status = opts.Status
+	state.Body = opts.Body
+}
+
+func (b *circuitBreakers) close(workspace, path string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, state := range b.circuits {
+		if (workspace == "" || state.Workspace == workspace) && (path == "" || state.Path == path) {
+			delete(b.circuits, key)
+		}
+	}
+}
+
+func (b *circuitBreakers) closeSession(workspace, session string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, state := range b.circuits {
+		if state.Workspace == workspace && state.Session == session {
+			delete(b.circuits, key)
+		}
+	}
+}
+
+func enforceCircuit(w http.ResponseWriter, data templateData) bool {
+	state, open := breakers.openState(data.Workspace, data.Session, data.Path, data.Now)
+	if !open {
+		return true
+	}
+
+	status := state.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	retryAfter := int(math.Ceil(state.OpenUntil.Sub(data.Now).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	if state.Body != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(state.Body))
+		return false
+	}
+	writeJSON(w, status, map[string]string{"error": "circuit open"})
+	return false
+}
+
+func recordCircuitHit(mockResp *MockResponse, data templateData) {
+	if opts := mockResp.Options.CircuitBreaker; opts != nil && opts.TripAfter > 0 {
+		breakers.hit(data.Workspace, data.Session, data.Path, opts, data.Now)
+	}
+}
+
+type circuitTrip struct {
+	Workspace string       `json:"workspace"`
+	Session   string       `json:"session,omitempty"`
+	Path      string       `json:"path"`
+	OpenFor   jsonDuration `json:"openFor"`
+	Status    int          `json:"status"`
+	Body      string       `json:"body"`
+}
+
+func listCircuitsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	breakers.mu.Lock()
+	circuits := make([]circuitState, 0, len(breakers.circuits))
+	for _, state := range breakers.circuits {
+		circuits = append(circuits, *state)
+	}
+	breakers.mu.Unlock()
+
+	sort.Slice(circuits, func(i, j int) bool {
+		return circuitKey(circuits[i].Workspace, circuits[i].Session, circuits[i].Path) < circuitKey(circuits[j].Workspace, circuits[j].Session, circuits[j].Path)
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"circuits": circuits})
+}
+
+func tripCircuitHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var trip circuitTrip
+	if err := json.NewDecoder(r.Body).Decode(&trip); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+		return
+	}
+	if !strings.HasPrefix(trip.Path, "/") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "path must start with /"})
+		return
+	}
+	if trip.Workspace == "" {
+		trip.Workspace = "default"
+	}
+	openFor := time.Duration(trip.OpenFor)
+	if openFor <= 0 {
+		openFor = defaultCircuitOpenFor
+	}
+
+	state := breakers.open(trip.Workspace, trip.Session, trip.Path, clock.now().Add(openFor), trip.Status, trip.Body)
+	writeJSON(w, http.StatusOK, state)
+}
+
+func closeCircuitsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	breakers.close(query.Get("workspace"), query.Get("path"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *circuitBreakers) snapshot() []circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuits := make([]circuitState, 0, len(b.circuits))
+	for _, state := range b.circuits {
+		circuits = append(circuits, *state)
+	}
+	return circuits
+}
+
+func (b *circuitBreakers) restore(circuits []circuitState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.circuits = make(map[string]*circuitState, len(circuits))
+	for _, state := range circuits {
+		copied := state
+		b.circuits[circuitKey(state.Workspace, state.Session, state.Path)] = &copied
+	}
+}
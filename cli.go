@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"mock-db-router/adminapi"
+)
+
+func adminClientFlag(flags *flag.FlagSet) *string {
+	return flags.String("admin-url", envString("MOCK_ADMIN_URL", "http://localhost:8080"), "base URL of a running router")
+}
+
+func printJSON(v interface{}) {
+	output, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(output))
+}
+
+func printAdminError(command string, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+	var apiErr *adminapi.Error
+	if errors.As(err, &apiErr) {
+		for _, conflict := range apiErr.Conflicts {
+			fmt.Fprintf(os.Stderr, "  %s %s overlaps mocks %v\n", conflict.Method, conflict.Path, conflict.IDs)
+		}
+		for _, violation := range apiErr.Violations {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", violation.Path, violation.Message)
+		}
+	}
+}
+
+// runImportCommand creates the mocks of one or more definition files on a
+// running router through the admin API, in a single transaction.
+func runImportCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	force := flags.Bool("force", false, "create mocks even when they overlap existing ones")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: mock-db-router import [flags] file...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var mocks []adminapi.Mock
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "import:", err)
+			return 1
+		}
+		// Unknown fields would be lost decoding into adminapi.Mock, so the
+		// file is checked against the schema here rather than by the router.
+		violations, err := validateBody(string(adminapi.MockSchema), string(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import: %s: %v\n", path, err)
+			return 1
+		}
+		if len(violations) > 0 {
+			fmt.Fprintf(os.Stderr, "import: %s does not match the mock schema\n", path)
+			for _, violation := range violations {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", violation.Path, violation.Message)
+			}
+			return 1
+		}
+		var defs []adminapi.Mock
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+			err = json.Unmarshal(data, &defs)
+		} else {
+			defs = make([]adminapi.Mock, 1)
+			err = json.Unmarshal(data, &defs[0])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import: %s: %v\n", path, err)
+			return 1
+		}
+		mocks = append(mocks, defs...)
+	}
+
+	result, err := newAdminClient(*adminURL).ImportMocks(context.Background(), mocks, *force)
+	if err != nil {
+		printAdminError("import", err)
+		return 1
+	}
+	fmt.Printf("Imported %d mocks\n", len(result.Mocks))
+	for _, conflict := range result.Conflicts {
+		fmt.Printf("  %s %s overlaps mocks %v\n", conflict.Method, conflict.Path, conflict.IDs)
+	}
+	return 0
+}
+
+func runResetCommand(args []string) int {
+	flags := flag.NewFlagSet("reset", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	var params adminapi.ResetParams
+	var only string
+	flags.StringVar(&only, "only", "", "comma-separated subset of counters, state, journal and crud")
+	flags.StringVar(&params.Workspace, "workspace", "", "only reset this workspace")
+	flags.Parse(args)
+	if only != "" {
+		params.Only = strings.Split(only, ",")
+	}
+
+	result, err := newAdminClient(*adminURL).Reset(context.Background(), params)
+	if err != nil {
+		printAdminError("reset", err)
+		return 1
+	}
+	printJSON(result)
+	return 0
+}
+
+// runTailCommand prints requests as a running router handles them.
+func runTailCommand(args []string) int {
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	var params adminapi.StreamRequestsParams
+	flags.StringVar(&params.Workspace, "workspace", "", "only show requests of this workspace")
+	flags.StringVar(&params.Method, "method", "", "only show requests with this method")
+	flags.StringVar(&params.PathPrefix, "path-prefix", "", "only show requests whose path starts with this prefix")
+	flags.IntVar(&params.MockID, "mock-id", 0, "only show requests matched by this mock")
+	asJSON := flags.Bool("json", false, "print every request as a JSON line")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := newAdminClient(*adminURL).StreamRequests(ctx, params, func(e adminapi.RequestEvent) error {
+		if *asJSON {
+			line, _ := json.Marshal(e)
+			fmt.Println(string(line))
+			return nil
+		}
+		mock := "unmatched"
+		if e.MockID != 0 {
+			mock = fmt.Sprintf("mock %d", e.MockID)
+		}
+		fmt.Printf("%s %s %s -> %d (%s, %.1fms)\n", e.ReceivedAt.Format("15:04:05.000"), e.Method, e.Path, e.StatusCode, mock, e.DurationMs)
+		return nil
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		printAdminError("tail", err)
+		return 1
+	}
+	return 0
+}
+
+// runStaleCommand reports mocks not hit for a number of days and, with
+// -delete, moves them to the trash after asking for confirmation.
+func runStaleCommand(args []string) int {
+	flags := flag.NewFlagSet("stale", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	params := adminapi.StaleMocksParams{}
+	var labels string
+	flags.IntVar(&params.Days, "days", 90, "report mocks not hit for this many days")
+	flags.StringVar(&params.Workspace, "workspace", "", "only consider this workspace")
+	flags.StringVar(&labels, "label", "", "comma-separated label selectors the mocks must match")
+	flags.BoolVar(&params.IncludeManaged, "include-managed", false, "include mocks managed by GitOps or Kubernetes sync")
+	remove := flags.Bool("delete", false, "move the reported mocks to the trash")
+	yes := flags.Bool("yes", false, "delete without asking for confirmation")
+	flags.Parse(args)
+	if labels != "" {
+		params.Labels = strings.Split(labels, ",")
+	}
+
+	client := newAdminClient(*adminURL)
+	ctx := context.Background()
+	stale, err := client.ListStaleMocks(ctx, params)
+	if err != nil {
+		printAdminError("stale", err)
+		return 1
+	}
+	for _, mock := range stale.Mocks {
+		lastHit := "never"
+		if mock.LastHit != nil {
+			lastHit = mock.LastHit.Format("2006-01-02")
+		}
+		fmt.Printf("%6d  %-7s %-40s %-12s last hit %s\n", mock.ID, mock.Method, mock.Path, mock.Workspace, lastHit)
+	}
+	fmt.Printf("%d mocks not hit in %d days\n", len(stale.Mocks), stale.Days)
+	if !*remove || len(stale.Mocks) == 0 {
+		return 0
+	}
+
+	if !*yes {
+		fmt.Printf("Move %d mocks to the trash? [y/N] ", len(stale.Mocks))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Nothing deleted")
+			return 0
+		}
+	}
+	deleted, err := client.DeleteStaleMocks(ctx, params)
+	if err != nil {
+		printAdminError("stale", err)
+		return 1
+	}
+	fmt.Printf("Moved %d mocks to the trash\n", len(deleted.Mocks))
+	return 0
+}
+
+// runReportCommand prints the report of a test run or saves it, as JSON or
+// HTML, for CI artifacts. With -strict it fails when an expected mock was
+// never hit or a request matched no mock.
+func runReportCommand(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	var params adminapi.ReportParams
+	var labels, since, until string
+	flags.StringVar(&params.Workspace, "workspace", "", "only consider this workspace")
+	flags.StringVar(&params.Session, "session", "", "only consider requests sent with this session header")
+	flags.StringVar(&since, "since", "", "only consider requests received at or after this RFC3339 time")
+	flags.StringVar(&until, "until", "", "only consider requests received before this RFC3339 time")
+	flags.StringVar(&labels, "label", "", "comma-separated label selectors of the mocks expected to be hit")
+	format := flags.String("format", "text", "output format: text, json or html")
+	output := flags.String("o", "", "write the report to this file instead of standard output")
+	strict := flags.Bool("strict", false, "exit with status 1 unless every expected mock was hit and every request matched")
+	flags.Parse(args)
+	if labels != "" {
+		params.Labels = strings.Split(labels, ",")
+	}
+	for _, bound := range []struct {
+		value string
+		dest  *time.Time
+	}{{since, &params.Since}, {until, &params.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "report: invalid time %q: %v\n", bound.value, err)
+			return 2
+		}
+		*bound.dest = parsed
+	}
+	if *format != "text" && *format != "json" && *format != "html" {
+		fmt.Fprintf(os.Stderr, "report: unknown format %q\n", *format)
+		return 2
+	}
+
+	client := newAdminClient(*adminURL)
+	ctx := context.Background()
+	report, err := client.Report(ctx, params)
+	if err != nil {
+		printAdminError("report", err)
+		return 1
+	}
+	var out bytes.Buffer
+	switch *format {
+	case "json":
+		data, _ := json.MarshalIndent(report, "", "  ")
+		out.Write(append(data, '\n'))
+	case "html":
+		// A second request, so the page and the exit status may disagree
+		// if requests arrive in between.
+		page, err := client.ReportHTML(ctx, params)
+		if err != nil {
+			printAdminError("report", err)
+			return 1
+		}
+		out.Write(page)
+	default:
+		writeReportText(&out, report)
+	}
+	if *output == "" {
+		os.Stdout.Write(out.Bytes())
+	} else if err := os.WriteFile(*output, out.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "report:", err)
+		return 1
+	}
+
+	if *strict && !report.Passed {
+		return 1
+	}
+	return 0
+}
+
+func writeReportText(w io.Writer, report *adminapi.TestReport) {
+	fmt.Fprintf(w, "%d requests, %d mocks hit\n", report.Requests, len(report.HitMocks))
+	if len(report.UnhitMocks) > 0 {
+		fmt.Fprintf(w, "\n%d expected mocks never hit:\n", len(report.UnhitMocks))
+		for _, mock := range report.UnhitMocks {
+			fmt.Fprintf(w, "%6d  %-7s %-40s %s\n", mock.ID, mock.Method, mock.Path, mock.Workspace)
+		}
+	}
+	if report.UnmatchedTotal > 0 {
+		fmt.Fprintf(w, "\n%d unmatched requests:\n", report.UnmatchedTotal)
+		for _, request := range report.UnmatchedRequests {
+			fmt.Fprintf(w, "%s %s %s -> %d\n", request.ReceivedAt.Format("15:04:05.000"), request.Method, request.Path, request.StatusCode)
+		}
+	}
+	if report.Passed {
+		fmt.Fprintln(w, "\nPASSED")
+	} else {
+		fmt.Fprintln(w, "\nFAILED")
+	}
+}
+
+// runCoverageCommand prints which operations of an OpenAPI document have
+// mocks and were exercised, failing below the -min-mocked or -min-exercised
+// percentages.
+func runCoverageCommand(args []string) int {
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	var params adminapi.ReportParams
+	var labels, since string
+	spec := flags.String("spec", "", "OpenAPI document to check, instead of the router's OPENAPI_SPEC")
+	flags.StringVar(&params.Workspace, "workspace", "", "only consider this workspace")
+	flags.StringVar(&params.Session, "session", "", "only count requests sent with this session header")
+	flags.StringVar(&since, "since", "", "only count requests received at or after this RFC3339 time")
+	flags.StringVar(&labels, "label", "", "comma-separated label selectors the mocks must match")
+	minMocked := flags.Float64("min-mocked", 0, "fail when fewer operations than this percentage have mocks")
+	minExercised := flags.Float64("min-exercised", 0, "fail when fewer operations than this percentage were exercised")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+	if labels != "" {
+		params.Labels = strings.Split(labels, ",")
+	}
+	if since != "" {
+		parsed, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "coverage: invalid time %q: %v\n", since, err)
+			return 2
+		}
+		params.Since = parsed
+	}
+	var doc []byte
+	if *spec != "" {
+		var err error
+		if doc, err = os.ReadFile(*spec); err != nil {
+			fmt.Fprintln(os.Stderr, "coverage:", err)
+			return 1
+		}
+	}
+
+	coverage, err := newAdminClient(*adminURL).ContractCoverage(context.Background(), doc, params)
+	if err != nil {
+		printAdminError("coverage", err)
+		return 1
+	}
+	summary := coverage.Summary
+	if *asJSON {
+		printJSON(coverage)
+	} else {
+		for _, op := range coverage.Operations {
+			mocks := "no mocks"
+			if len(op.Mocks) > 0 {
+				mocks = fmt.Sprintf("mocks %v", op.Mocks)
+			}
+			requests := ""
+			if op.Requests != nil {
+				requests = fmt.Sprintf(", %d requests", *op.Requests)
+			}
+			fmt.Printf("%-7s %-40s %s%s\n", op.Method, op.Path, mocks, requests)
+		}
+		for _, mock := range coverage.UnmappedMocks {
+			fmt.Printf("mock %d (%s %s) matches no operation\n", mock.ID, mock.Method, mock.Path)
+		}
+		fmt.Printf("%d of %d operations mocked (%.1f%%)", summary.Mocked, summary.Operations, summary.MockedPercent)
+		if summary.Exercised != nil {
+			fmt.Printf(", %d exercised (%.1f%%), %d undocumented requests", *summary.Exercised, *summary.ExercisedPercent, *summary.UndocumentedRequests)
+		}
+		fmt.Println()
+	}
+
+	if summary.MockedPercent < *minMocked {
+		fmt.Fprintf(os.Stderr, "coverage: %.1f%% of operations mocked, below %.1f%%\n", summary.MockedPercent, *minMocked)
+		return 1
+	}
+	if *minExercised > 0 {
+		if summary.ExercisedPercent == nil {
+			fmt.Fprintln(os.Stderr, "coverage: -min-exercised needs JOURNAL_ENABLED on the router")
+			return 1
+		}
+		if *summary.ExercisedPercent < *minExercised {
+			fmt.Fprintf(os.Stderr, "coverage: %.1f%% of operations exercised, below %.1f%%\n", *summary.ExercisedPercent, *minExercised)
+			return 1
+		}
+	}
+	return 0
+}
+
+// runLintCommand checks mock definition files, or the mocks of a running
+// router when no file is given, and fails when any has errors.
+func runLintCommand(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	adminURL := adminClientFlag(flags)
+	strict := flags.Bool("strict", false, "fail on warnings as well")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: mock-db-router lint [flags] [file...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var issues []adminapi.LintIssue
+	var errorCount, warningCount int
+	if flags.NArg() > 0 {
+		if err := initBodyHasher(); err != nil {
+			fmt.Fprintln(os.Stderr, "lint:", err)
+			return 1
+		}
+		result, names, err := lintFiles(flags.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "lint:", err)
+			return 1
+		}
+		for _, issue := range result.Issues {
+			fmt.Printf("%-7s %s %s %s: %s\n", issue.Severity, names[issue.index], issue.Method, issue.Path, issue.Message)
+		}
+		errorCount, warningCount = result.Errors, result.Warnings
+	} else {
+		result, err := newAdminClient(*adminURL).Lint(context.Background())
+		if err != nil {
+			printAdminError("lint", err)
+			return 1
+		}
+		issues, errorCount, warningCount = result.Issues, result.Errors, result.Warnings
+	}
+	for _, issue := range issues {
+		fmt.Printf("%-7s mock %d %s %s: %s\n", issue.Severity, issue.ID, issue.Method, issue.Path, issue.Message)
+	}
+
+	fmt.Printf("%d errors, %d warnings\n", errorCount, warningCount)
+	if errorCount > 0 || (*strict && warningCount > 0) {
+		return 1
+	}
+	return 0
+}
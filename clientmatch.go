@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type requestMatchOptions struct {
+	ClientIP  []string               `json:"clientIp,omitempty"`
+	Country   []string               `json:"country,omitempty"`
+	UserAgent string                 `json:"userAgent,omitempty"`
+	Path      string                 `json:"path,omitempty"`
+	Headers   map[string]string      `json:"headers,omitempty"`
+	Body      map[string]interface{} `json:"body,omitempty"`
+
+	// Body metadata, independent of the body's content.
+	ContentType []string   `json:"contentType,omitempty"`
+	BodySize    *sizeRange `json:"bodySize,omitempty"`
+	EmptyBody   *bool      `json:"emptyBody,omitempty"`
+
+	// Time lists the windows the request's time must fall in one of.
+	Time []timeWindow `json:"time,omitempty"`
+
+	// Not lists exclusions: the request is rejected when every condition of
+	// any one entry holds.
+	Not []requestMatchOptions `json:"not,omitempty"`
+}
+
+type sizeRange struct {
+	Min byteSize `json:"min,omitempty"`
+	Max byteSize `json:"max,omitempty"`
+}
+
+var (
+	trustForwardedFor = envBool("TRUST_FORWARDED_FOR", false)
+
+	compiledPatterns sync.Map
+)
+
+func clientIP(r *http.Request) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := compiledPatterns.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+func matchesClientIP(ranges []string, ip string) (bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, nil
+	}
+	addr = addr.Unmap()
+	for _, value := range ranges {
+		if !strings.Contains(value, "/") {
+			other, err := netip.ParseAddr(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid IP %q", value)
+			}
+			if other.Unmap() == addr {
+				return true, nil
+			}
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid CIDR %q", value)
+		}
+		if prefix.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchesContentType reports whether the media type of header, without its
+// parameters, matches one of the patterns, such as "application/json",
+// "text/*" or "*/*+xml".
+func matchesContentType(patterns []string, header string) (bool, error) {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false, nil
+	}
+	for _, pattern := range patterns {
+		matched, err := path.Match(strings.ToLower(strings.TrimSpace(pattern)), mediaType)
+		if err != nil {
+			return false, fmt.Errorf("invalid content type pattern %q", pattern)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *requestMatchOptions) matches(data templateData) (bool, error) {
+	if len(m.ClientIP) > 0 {
+		matched, err := matchesClientIP(m.ClientIP, data.ClientIP)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	if len(m.Country) > 0 && !matchesCountry(m.Country, data.Country) {
+		return false, nil
+	}
+	if m.UserAgent != "" {
+		pattern, err := compilePattern(m.UserAgent)
+		if err != nil {
+			return false, fmt.Errorf("invalid userAgent pattern: %v", err)
+		}
+		if !pattern.MatchString(data.Header.Get("User-Agent")) {
+			return false, nil
+		}
+	}
+	if m.Path != "" {
+		pattern, err := compilePattern(m.Path)
+		if err != nil {
+			return false, fmt.Errorf("invalid path pattern: %v", err)
+		}
+		if !pattern.MatchString(data.Path) {
+			return false, nil
+		}
+	}
+	for name, expression := range m.Headers {
+		values, present := data.Header[http.CanonicalHeaderKey(name)]
+		if !present {
+			return false, nil
+		}
+		pattern, err := compilePattern(expression)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern for header %s: %v", name, err)
+		}
+		if !pattern.MatchString(strings.Join(values, ", ")) {
+			return false, nil
+		}
+	}
+	if len(m.ContentType) > 0 {
+		matched, err := matchesContentType(m.ContentType, data.Header.Get("Content-Type"))
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	if m.BodySize != nil {
+		size := byteSize(len(data.Body))
+		if size < m.BodySize.Min || (m.BodySize.Max > 0 && size > m.BodySize.Max) {
+			return false, nil
+		}
+	}
+	if m.EmptyBody != nil && (data.Body == "") != *m.EmptyBody {
+		return false, nil
+	}
+	if len(m.Time) > 0 {
+		matched, err := matchesTimeWindows(m.Time, data.Now)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	for pointer, expected := range m.Body {
+		actual, found := lookupJSONPointer(data.JSON, pointer)
+		if !found || !reflect.DeepEqual(actual, expected) {
+			return false, nil
+		}
+	}
+	for _, exclusion := range m.Not {
+		excluded, err := exclusion.matches(data)
+		if err != nil || excluded {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// lookupJSONPointer resolves an RFC 6901 pointer such as "/items/0/id".
+func lookupJSONPointer(document interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return document, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	current := document
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = unescape.Replace(token)
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type mockClock struct {
+	mu     sync.RWMutex
+	offset time.Duration
+	frozen time.Time
+}
+
+var clock = &mockClock{}
+
+func (c *mockClock) now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.frozen.IsZero() {
+		return c.frozen
+	}
+	return time.Now().Add(c.offset)
+}
+
+func (c *mockClock) set(offset time.Duration, frozen time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset = offset
+	c.frozen = frozen
+}
+
+func requestNow(r *http.Request) time.Time {
+	header := strings.TrimSpace(r.Header.Get("X-Mock-Time"))
+	if header == "" {
+		return clock.now()
+	}
+
+	t, err := parseMockTime(header, clock.now())
+	if err != nil {
+		log.Printf("Ignoring invalid X-Mock-Time header %q: %v", header, err)
+		return clock.now()
+	}
+	return t
+}
+
+func parseMockTime(value string, base time.Time) (time.Time, error) {
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		offset, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return base.Add(offset), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("expected RFC3339 time, unix seconds or a +/- duration")
+}
+
+type clockSettings struct {
+	Time   string `json:"time,omitempty"`
+	Offset string `json:"offset,omitempty"`
+	Frozen bool   `json:"frozen"`
+}
+
+func getClockHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	clock.mu.RLock()
+	offset, frozen := clock.offset, clock.frozen
+	clock.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"now":    clock.now().Format(time.RFC3339Nano),
+		"offset": offset.String(),
+		"frozen": !frozen.IsZero(),
+	})
+}
+
+func setClockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var settings clockSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+		return
+	}
+
+	target := time.Now()
+	if settings.Time != "" {
+		t, err := time.Parse(time.RFC3339, settings.Time)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "time must be RFC3339"})
+			return
+		}
+		target = t
+	}
+	if settings.Offset != "" {
+		d, err := time.ParseDuration(settings.Offset)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "offset must be a duration"})
+			return
+		}
+		target = target.Add(d)
+	}
+
+	var frozen time.Time
+	if settings.Frozen {
+		frozen = target
+	}
+	clock.set(time.Until(target), frozen)
+	getClockHandler(w, r, ps)
+}
+
+func resetClockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	clock.set(0, time.Time{})
+	getClockHandler(w, r, ps)
+}
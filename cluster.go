@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// clusterEvent tells the other instances sharing a database about a change
+// they keep no track of themselves: mocks changed through this instance, or
+// in-memory state that was reset.
+type clusterEvent struct {
+	Origin string `json:"origin"`
+	Type   string `json:"type"`
+	// MockIDs are the changed mocks of a mocks event; none means any mock
+	// may have changed.
+	MockIDs   []int    `json:"mockIds,omitempty"`
+	Targets   []string `json:"targets,omitempty"`
+	Workspace string   `json:"workspace,omitempty"`
+}
+
+const (
+	clusterMocksEvent = "mocks"
+	clusterResetEvent = "reset"
+	clusterFlushEvent = "flush"
+
+	clusterPublishTimeout = 2 * time.Second
+)
+
+var (
+	clusterInstance = newUUID()
+	clusterRedis    *redis.Client
+	clusterChannel  string
+
+	clusterEventsTotal = expvar.NewMap("cluster_events_total")
+
+	mockChangeListenersMu sync.Mutex
+	mockChangeListeners   []func(ids []int)
+)
+
+// onMockChange registers a function called with the IDs of mocks changed
+// through this or, with Redis configured, any other instance.
+func onMockChange(listener func(ids []int)) {
+	mockChangeListenersMu.Lock()
+	defer mockChangeListenersMu.Unlock()
+	mockChangeListeners = append(mockChangeListeners, listener)
+}
+
+func notifyMockChange(ids []int) {
+	mockChangeListenersMu.Lock()
+	listeners := append([]func(ids []int){}, mockChangeListeners...)
+	mockChangeListenersMu.Unlock()
+	for _, listener := range listeners {
+		listener(ids)
+	}
+}
+
+// mocksChanged is called after mocks were written; with no IDs any mock
+// may have changed.
+func mocksChanged(ids ...int) {
+	notifyMockChange(ids)
+	publishClusterEvent(clusterEvent{Type: clusterMocksEvent, MockIDs: ids})
+}
+
+// initCluster connects to the Redis server of REDIS_URL and subscribes to
+// the events of the other instances.
+func initCluster(url, channel string) error {
+	if url == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("error connecting to Redis: %v", err)
+	}
+	// Subscribe before publishing anything, so no event is missed once
+	// startup is done.
+	pubsub := client.Subscribe(context.Background(), channel)
+	if _, err := pubsub.Receive(context.Background()); err != nil {
+		client.Close()
+		return fmt.Errorf("error subscribing to Redis channel %s: %v", channel, err)
+	}
+	clusterRedis = client
+	clusterChannel = channel
+	go receiveClusterEvents(pubsub)
+	fmt.Printf("Sharing mock changes and resets on Redis channel %s\n", channel)
+	return nil
+}
+
+func publishClusterEvent(event clusterEvent) {
+	if clusterRedis == nil {
+		return
+	}
+	event.Origin = clusterInstance
+	payload, _ := json.Marshal(event)
+	ctx, cancel := context.WithTimeout(context.Background(), clusterPublishTimeout)
+	defer cancel()
+	if err := clusterRedis.Publish(ctx, clusterChannel, payload).Err(); err != nil {
+		clusterEventsTotal.Add("errors", 1)
+		log.Printf("Error publishing %s event to Redis: %v", event.Type, err)
+		return
+	}
+	clusterEventsTotal.Add("published", 1)
+}
+
+// receiveClusterEvents applies the events of other instances. The client
+// resubscribes by itself after losing the connection; events sent in the
+// meantime are lost, as Redis pub/sub does not keep them.
+func receiveClusterEvents(pubsub *redis.PubSub) {
+	for msg := range pubsub.Channel() {
+		var event clusterEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			clusterEventsTotal.Add("errors", 1)
+			log.Printf("Ignoring invalid Redis event: %v", err)
+			continue
+		}
+		if event.Origin == clusterInstance {
+			continue
+		}
+		clusterEventsTotal.Add("received", 1)
+		switch event.Type {
+		case clusterMocksEvent:
+			notifyMockChange(event.MockIDs)
+		case clusterResetEvent:
+			resetMemoryState(selectedResetTargets(event.Targets), event.Workspace)
+			log.Printf("Reset %v from another instance", event.Targets)
+		case clusterFlushEvent:
+			flushCaches()
+			log.Printf("Flushed caches from another instance")
+		default:
+			log.Printf("Ignoring Redis event of unknown type %q", event.Type)
+		}
+	}
+}
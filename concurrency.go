@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// concurrencyRule caps the requests in flight for the paths matching a
+// glob, like the thread pool of the service being mocked. Excess requests
+// wait up to QueueTimeout for a slot, then get Status.
+type concurrencyRule struct {
+	Path  string `json:"path"`
+	Limit int    `json:"limit"`
+	// PerPath gives every path matching the glob its own limit instead of
+	// sharing one.
+	PerPath bool `json:"perPath,omitempty"`
+	// QueueTimeout lets excess requests wait for a slot; without it they
+	// are rejected at once.
+	QueueTimeout jsonDuration `json:"queueTimeout,omitempty"`
+	// Status answers rejected requests; 503 by default.
+	Status     int          `json:"status,omitempty"`
+	RetryAfter jsonDuration `json:"retryAfter,omitempty"`
+	Body       string       `json:"body,omitempty"`
+}
+
+type concurrencyPool struct {
+	rule     concurrencyRule
+	slots    map[string]*concurrencySlots
+	queued   int
+	rejected int64
+}
+
+// concurrencySlots holds the requests in flight for a key of a pool: the
+// path with PerPath, "" otherwise.
+type concurrencySlots struct {
+	key      string
+	inFlight chan struct{}
+	// users counts the requests in flight or waiting for a slot, so that
+	// the entry is dropped once the last one leaves and paths seen once
+	// do not pile up.
+	users int
+}
+
+// concurrencyStatus is a rule with its pool's current load.
+type concurrencyStatus struct {
+	concurrencyRule
+	InFlight int   `json:"inFlight"`
+	Queued   int   `json:"queued"`
+	Rejected int64 `json:"rejected"`
+}
+
+type concurrencyLimiter struct {
+	mu    sync.Mutex
+	pools []*concurrencyPool
+}
+
+var (
+	concurrency = &concurrencyLimiter{}
+
+	concurrencyRejectedTotal = expvar.NewMap("concurrency_rejected_total")
+)
+
+// configure replaces the rules. Requests in flight keep their slots in the
+// old pools.
+func (l *concurrencyLimiter) configure(rules []concurrencyRule) error {
+	pools := make([]*concurrencyPool, 0, len(rules))
+	for i, rule := range rules {
+		if _, err := path.Match(rule.Path, "/"); err != nil || rule.Path == "" {
+			return fmt.Errorf("rule %d: invalid path pattern %q", i, rule.Path)
+		}
+		if rule.Limit < 1 {
+			return fmt.Errorf("rule %d: limit must be at least 1", i)
+		}
+		if rule.QueueTimeout < 0 || rule.RetryAfter < 0 {
+			return fmt.Errorf("rule %d: queueTimeout and retryAfter must not be negative", i)
+		}
+		if rule.Status != 0 && (rule.Status < 100 || rule.Status > 599) {
+			return fmt.Errorf("rule %d: invalid status %d", i, rule.Status)
+		}
+		pools = append(pools, &concurrencyPool{rule: rule, slots: make(map[string]*concurrencySlots)})
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pools = pools
+	return nil
+}
+
+// slot returns the pool of the first rule matching the path and the slots
+// of the path in it, which the caller must leave when done.
+func (l *concurrencyLimiter) slot(urlPath string) (*concurrencyPool, *concurrencySlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, pool := range l.pools {
+		if matched, _ := path.Match(pool.rule.Path, urlPath); !matched {
+			continue
+		}
+		key := ""
+		if pool.rule.PerPath {
+			key = urlPath
+		}
+		slots, ok := pool.slots[key]
+		if !ok {
+			slots = &concurrencySlots{key: key, inFlight: make(chan struct{}, pool.rule.Limit)}
+			pool.slots[key] = slots
+		}
+		slots.users++
+		return pool, slots
+	}
+	return nil, nil
+}
+
+func (l *concurrencyLimiter) leave(pool *concurrencyPool, slots *concurrencySlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if slots.users--; slots.users == 0 {
+		delete(pool.slots, slots.key)
+	}
+}
+
+func (l *concurrencyLimiter) snapshot() []concurrencyStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]concurrencyStatus, 0, len(l.pools))
+	for _, pool := range l.pools {
+		status := concurrencyStatus{concurrencyRule: pool.rule, Queued: pool.queued, Rejected: pool.rejected}
+		for _, slots := range pool.slots {
+			status.InFlight += len(slots.inFlight)
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+// limitConcurrency takes a slot for the request when a rule covers its
+// path, waiting for one if the rule queues. It returns the function giving
+// the slot back, or false after answering a request that got none.
+func limitConcurrency(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	pool, slots := concurrency.slot(r.URL.Path)
+	if pool == nil {
+		return func() {}, true
+	}
+	release := func() {
+		<-slots.inFlight
+		concurrency.leave(pool, slots)
+	}
+	select {
+	case slots.inFlight <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	if wait := time.Duration(pool.rule.QueueTimeout); wait > 0 {
+		concurrency.mu.Lock()
+		pool.queued++
+		concurrency.mu.Unlock()
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		var acquired bool
+		select {
+		case slots.inFlight <- struct{}{}:
+			acquired = true
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		concurrency.mu.Lock()
+		pool.queued--
+		concurrency.mu.Unlock()
+		if acquired {
+			return release, true
+		}
+		if r.Context().Err() != nil {
+			concurrency.leave(pool, slots)
+			return nil, false
+		}
+	}
+
+	concurrency.leave(pool, slots)
+	concurrency.mu.Lock()
+	pool.rejected++
+	concurrency.mu.Unlock()
+	concurrencyRejectedTotal.Add(pool.rule.Path, 1)
+	requestLogf(r, "Rejecting %s %s: %d requests in flight for %s", r.Method, r.URL.Path, pool.rule.Limit, pool.rule.Path)
+
+	status := pool.rule.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if retryAfter := time.Duration(pool.rule.RetryAfter); retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	}
+	if pool.rule.Body != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(pool.rule.Body))
+	} else {
+		writeJSON(w, status, map[string]string{"error": "too many concurrent requests"})
+	}
+	return nil, false
+}
+
+// loadConcurrencyLimits configures the rules of a JSON file holding an
+// array of them.
+func loadConcurrencyLimits(file string) error {
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading concurrency limits: %v", err)
+	}
+	var rules []concurrencyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("invalid concurrency limits: %v", err)
+	}
+	return concurrency.configure(rules)
+}
+
+func getConcurrencyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rules": concurrency.snapshot()})
+}
+
+// setConcurrencyHandler replaces the concurrency rules.
+func setConcurrencyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body struct {
+		Rules []concurrencyRule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid concurrency settings: " + err.Error()})
+		return
+	}
+	if err := concurrency.configure(body.Rules); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Concurrency limits set: %d rules", len(body.Rules))
+	getConcurrencyHandler(w, r, nil)
+}
+
+func resetConcurrencyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	concurrency.configure(nil)
+	w.WriteHeader(http.StatusNoContent)
+}
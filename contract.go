@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxContractViolations = 1000
+
+type contractViolation struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	MockID  int       `json:"mockId,omitempty"`
+	Message string    `json:"message"`
+}
+
+type contractValidator struct {
+	doc     *openapi3.T
+	router  routers.Router
+	enforce bool
+
+	mu         sync.Mutex
+	violations []contractViolation
+}
+
+var contract *contractValidator
+
+func initContractValidation(specPath string) error {
+	if specPath == "" {
+		return nil
+	}
+
+	openapi3.SchemaErrorDetailsDisabled = true
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromFile(specPath)
+	if err != nil {
+		return fmt.Errorf("error loading OpenAPI document: %v", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+
+	for _, server := range doc.Servers {
+		server.URL = serverBasePath(server.URL)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return fmt.Errorf("error building OpenAPI router: %v", err)
+	}
+
+	contract = &contractValidator{
+		doc:     doc,
+		router:  router,
+		enforce: envBool("OPENAPI_ENFORCE", false),
+	}
+	fmt.Println("OpenAPI contract validation enabled for", specPath)
+	return nil
+}
+
+func serverBasePath(serverURL string) string {
+	if idx := strings.Index(serverURL, "://"); idx >= 0 {
+		serverURL = serverURL[idx+3:]
+		if slash := strings.Index(serverURL, "/"); slash >= 0 {
+			serverURL = serverURL[slash:]
+		} else {
+			serverURL = ""
+		}
+	}
+	if serverURL == "" {
+		return "/"
+	}
+	return serverURL
+}
+
+func (c *contractValidator) validateRequest(r *http.Request, requestBody string) (*openapi3filter.RequestValidationInput, []string) {
+	route, pathParams, err := c.router.FindRoute(r)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError:         true,
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	err = openapi3filter.ValidateRequest(context.Background(), input)
+	r.Body = io.NopCloser(strings.NewReader(requestBody))
+	return input, flattenContractErrors(err)
+}
+
+func (c *contractValidator) validateResponse(input *openapi3filter.RequestValidationInput, mockResp *MockResponse) []string {
+	header := http.Header{}
+	for key, value := range parseHeaders(mockResp.Headers) {
+		header.Set(key, value)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	status := mockResp.ResponseStatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	respInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 status,
+		Header:                 header,
+		Options: &openapi3filter.Options{
+			MultiError:            true,
+			IncludeResponseStatus: true,
+		},
+	}
+	respInput.SetBodyBytes([]byte(mockResp.ResponseBody))
+	return flattenContractErrors(openapi3filter.ValidateResponse(context.Background(), respInput))
+}
+
+func flattenContractErrors(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		var messages []string
+		for _, e := range multi {
+			messages = append(messages, flattenContractErrors(e)...)
+		}
+		return messages
+	}
+	return []string{strings.TrimSpace(err.Error())}
+}
+
+func (c *contractValidator) record(kind string, r *http.Request, mockID int, messages []string) {
+	if len(messages) == 0 {
+		return
+	}
+	contractViolationsTotal.Add(kind, int64(len(messages)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, message := range messages {
+		c.violations = append(c.violations, contractViolation{
+			Time:    time.Now(),
+			Kind:    kind,
+			Method:  r.Method,
+			Path:    buildFullPath(r),
+			MockID:  mockID,
+			Message: message,
+		})
+		log.Printf("OpenAPI %s violation for %s %s: %s", kind, r.Method, r.URL.Path, message)
+	}
+	if overflow := len(c.violations) - maxContractViolations; overflow > 0 {
+		c.violations = append([]contractViolation(nil), c.violations[overflow:]...)
+	}
+}
+
+func contractViolationsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if contract == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "contract validation is not enabled"})
+		return
+	}
+
+	contract.mu.Lock()
+	violations := append([]contractViolation{}, contract.violations...)
+	contract.mu.Unlock()
+
+	kind := r.URL.Query().Get("kind")
+	filtered := violations[:0]
+	for _, v := range violations {
+		if kind == "" || v.Kind == kind {
+			filtered = append(filtered, v)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"violations": filtered})
+}
+
+func clearContractViolationsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if contract != nil {
+		contract.mu.Lock()
+		contract.violations = nil
+		contract.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
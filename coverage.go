@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/julienschmidt/httprouter"
+)
+
+// coverageOperation is an operation of an OpenAPI document with the mocks
+// answering it and, with the journal, the requests that reached it.
+type coverageOperation struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationID string `json:"operationId,omitempty"`
+	Mocks       []int  `json:"mocks"`
+	Requests    *int64 `json:"requests,omitempty"`
+
+	// routes are the operation's path below each server base path, split
+	// into segments.
+	routes [][]string
+	// params counts the templated segments; the fewer, the more specific.
+	params int
+}
+
+// coverageMock is a mock that corresponds to no operation.
+type coverageMock struct {
+	ID        int    `json:"id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Workspace string `json:"workspace"`
+}
+
+type coverageSummary struct {
+	Operations    int     `json:"operations"`
+	Mocked        int     `json:"mocked"`
+	MockedPercent float64 `json:"mockedPercent"`
+	UnmappedMocks int     `json:"unmappedMocks"`
+	// Exercised and UndocumentedRequests need the journal.
+	Exercised            *int     `json:"exercised,omitempty"`
+	ExercisedPercent     *float64 `json:"exercisedPercent,omitempty"`
+	UndocumentedRequests *int64   `json:"undocumentedRequests,omitempty"`
+}
+
+type contractCoverage struct {
+	Summary       coverageSummary      `json:"summary"`
+	Operations    []*coverageOperation `json:"operations"`
+	UnmappedMocks []coverageMock       `json:"unmappedMocks"`
+}
+
+var templateSegment = regexp.MustCompile(`\{[^}]*\}`)
+
+// coverageOperations lists the operations of a document by path and method.
+func coverageOperations(doc *openapi3.T) []*coverageOperation {
+	bases := []string{""}
+	if len(doc.Servers) > 0 {
+		bases = nil
+		for _, server := range doc.Servers {
+			base := strings.TrimRight(serverBasePath(server.URL), "/")
+			if !strings.Contains(base, "{") && !slices.Contains(bases, base) {
+				bases = append(bases, base)
+			}
+		}
+		if len(bases) == 0 {
+			bases = []string{""}
+		}
+	}
+
+	operations := []*coverageOperation{}
+	if doc.Paths == nil {
+		return operations
+	}
+	for path, item := range doc.Paths.Map() {
+		for method, op := range item.Operations() {
+			operation := &coverageOperation{Method: method, Path: path, OperationID: op.OperationID, Mocks: []int{}}
+			for _, base := range bases {
+				operation.routes = append(operation.routes, strings.Split(normalizePath(base+path), "/"))
+			}
+			for _, segment := range strings.Split(path, "/") {
+				if strings.Contains(segment, "{") {
+					operation.params++
+				}
+			}
+			operations = append(operations, operation)
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].Path != operations[j].Path {
+			return operations[i].Path < operations[j].Path
+		}
+		return operations[i].Method < operations[j].Method
+	})
+	return operations
+}
+
+// matches reports whether a mock or a request path, without its query, can
+// reach the operation. Templated segments of the operation match any
+// segment, and so do the ":name" segments of a pattern; a "*" segment
+// matches the rest. "~" patterns are tried on the operation's path with its
+// parameters set to 1.
+func (o *coverageOperation) matches(method, path string) bool {
+	if method != o.Method && method != "ANY" {
+		return false
+	}
+	kind := pathKind(path)
+	if kind == regexpPath {
+		re, err := compilePathRegexp(path)
+		if err != nil {
+			return false
+		}
+		for _, route := range o.routes {
+			if re.MatchString(templateSegment.ReplaceAllString(strings.Join(route, "/"), "1")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	segments := strings.Split(normalizePath(path), "/")
+	for _, route := range o.routes {
+		if routeMatches(segments, route, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func routeMatches(segments, route []string, kind int) bool {
+	for i, segment := range segments {
+		if kind == wildcardPath && i == len(segments)-1 && strings.HasPrefix(segment, "*") {
+			return len(route) > i
+		}
+		if i >= len(route) {
+			return false
+		}
+		switch {
+		case strings.Contains(route[i], "{"):
+		case kind == paramPath && len(segment) > 1 && segment[0] == ':':
+		case segment != route[i]:
+			return false
+		}
+	}
+	return len(segments) == len(route)
+}
+
+// bestOperation is the most specific operation a literal path reaches, the
+// one the API would route it to, or nil.
+func bestOperation(operations []*coverageOperation, method, path string) *coverageOperation {
+	var best *coverageOperation
+	for _, operation := range operations {
+		if operation.matches(method, path) && (best == nil || operation.params < best.params) {
+			best = operation
+		}
+	}
+	return best
+}
+
+// buildContractCoverage maps the mocks selected by mocks onto the operations
+// of a document and, with the journal, counts the requests of a run by
+// operation.
+func buildContractCoverage(ctx context.Context, doc *openapi3.T, journal replayFilter, mocks mockFilter) (*contractCoverage, error) {
+	coverage := &contractCoverage{Operations: coverageOperations(doc), UnmappedMocks: []coverageMock{}}
+
+	rows, err := db.QueryContext(ctx, "SELECT id, method, path, COALESCE(workspace, ''), labels::text FROM return.mock_responses"+
+		mocks.where()+" ORDER BY id", mocks.args...)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := scanReportedMocks(rows, false)
+	if err != nil {
+		return nil, err
+	}
+	var methods []string
+	for _, operation := range coverage.Operations {
+		if !slices.Contains(methods, operation.Method) {
+			methods = append(methods, operation.Method)
+		}
+	}
+	for _, mock := range stored {
+		path, _, _ := strings.Cut(mock.Path, "?")
+		var matched []*coverageOperation
+		if pathKind(path) == literalPath {
+			// A literal path is routed like a request, by each method for
+			// ANY.
+			mockMethods := []string{mock.Method}
+			if mock.Method == "ANY" {
+				mockMethods = methods
+			}
+			for _, method := range mockMethods {
+				if operation := bestOperation(coverage.Operations, method, path); operation != nil {
+					matched = append(matched, operation)
+				}
+			}
+		} else {
+			for _, operation := range coverage.Operations {
+				if operation.matches(mock.Method, path) {
+					matched = append(matched, operation)
+				}
+			}
+		}
+		if len(matched) == 0 {
+			coverage.UnmappedMocks = append(coverage.UnmappedMocks, coverageMock{ID: mock.ID, Method: mock.Method, Path: mock.Path, Workspace: mock.Workspace})
+		}
+		for _, operation := range matched {
+			operation.Mocks = append(operation.Mocks, mock.ID)
+		}
+	}
+
+	summary := &coverage.Summary
+	summary.Operations = len(coverage.Operations)
+	summary.UnmappedMocks = len(coverage.UnmappedMocks)
+	for _, operation := range coverage.Operations {
+		if len(operation.Mocks) > 0 {
+			summary.Mocked++
+		}
+	}
+	summary.MockedPercent = percentOf(summary.Mocked, summary.Operations)
+
+	if !journalEnabled {
+		return coverage, nil
+	}
+	if err := flushJournal(ctx); err != nil {
+		return nil, err
+	}
+	where, args := journalConditions(journal)
+	rows, err = db.QueryContext(ctx, "SELECT method, split_part(path, '?', 1), count(*) FROM return.request_journal"+where+
+		" GROUP BY 1, 2", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for _, operation := range coverage.Operations {
+		operation.Requests = new(int64)
+	}
+	var undocumented int64
+	for rows.Next() {
+		var method, path string
+		var count int64
+		if err := rows.Scan(&method, &path, &count); err != nil {
+			return nil, err
+		}
+		if operation := bestOperation(coverage.Operations, method, path); operation != nil {
+			*operation.Requests += count
+		} else {
+			undocumented += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	exercised := 0
+	for _, operation := range coverage.Operations {
+		if *operation.Requests > 0 {
+			exercised++
+		}
+	}
+	exercisedPercent := percentOf(exercised, summary.Operations)
+	summary.Exercised, summary.ExercisedPercent, summary.UndocumentedRequests = &exercised, &exercisedPercent, &undocumented
+	return coverage, nil
+}
+
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)*1000/float64(total)) / 10
+}
+
+// contractCoverageHandler reports the coverage of the OPENAPI_SPEC document
+// on GET, or of the document posted, in YAML or JSON.
+func contractCoverageHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var doc *openapi3.T
+	if r.Method == http.MethodPost {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "error reading OpenAPI document"})
+			return
+		}
+		loader := openapi3.NewLoader()
+		if doc, err = loader.LoadFromData(data); err == nil {
+			err = doc.Validate(loader.Context)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid OpenAPI document: %v", err)})
+			return
+		}
+	} else if contract == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "contract validation is not enabled; set OPENAPI_SPEC or post a document"})
+		return
+	} else {
+		doc = contract.doc
+	}
+
+	journal, mocks, err := reportScope(r.URL.Query())
+	if err != nil {
+		writeAdminError(w, err, "error computing contract coverage")
+		return
+	}
+	coverage, err := buildContractCoverage(r.Context(), doc, journal, mocks)
+	if err != nil {
+		writeAdminError(w, err, "error computing contract coverage")
+		return
+	}
+	writeJSON(w, http.StatusOK, coverage)
+}
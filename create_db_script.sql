@@ -1,13 +1,122 @@
 CREATE TABLE IF NOT EXISTS public.mock_responses (
     id SERIAL PRIMARY KEY,
     path VARCHAR(500) NOT NULL,
-    method VARCHAR(10) NOT NULL,
+    method VARCHAR(20) NOT NULL,
     request_body JSONB,
-    response_body JSONB NOT NULL,
+    request_body_hash VARCHAR(128),
+    response_body TEXT NOT NULL,
     response_status_code INTEGER DEFAULT 200,
     headers TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    is_template BOOLEAN NOT NULL DEFAULT false,
+    weight INTEGER NOT NULL DEFAULT 1,
+    host VARCHAR(255),
+    workspace VARCHAR(100),
+    match_expression TEXT,
+    options JSONB,
+    labels JSONB,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    last_hit_at TIMESTAMP
 );
 
-CREATE INDEX IF NOT EXISTS idx_mock_responses_lookup
-ON public.mock_responses (path, method, (md5(request_body::jsonb::text)));
+CREATE TABLE IF NOT EXISTS public.crud_records (
+    id BIGSERIAL PRIMARY KEY,
+    workspace VARCHAR(100) NOT NULL,
+    session VARCHAR(200) NOT NULL DEFAULT '',
+    collection VARCHAR(500) NOT NULL,
+    record_id VARCHAR(200) NOT NULL,
+    body JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (workspace, session, collection, record_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.request_schemas (
+    id SERIAL PRIMARY KEY,
+    path VARCHAR(500) NOT NULL,
+    method VARCHAR(10),
+    schema JSONB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.request_journal (
+    id BIGSERIAL PRIMARY KEY,
+    received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    request_id VARCHAR(200),
+    workspace VARCHAR(100) NOT NULL,
+    method VARCHAR(20) NOT NULL,
+    path VARCHAR(2000) NOT NULL,
+    headers JSONB,
+    body TEXT,
+    mock_id INTEGER,
+    status_code INTEGER,
+    duration_ms DOUBLE PRECISION,
+    violations JSONB,
+    response_headers JSONB,
+    response_body TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_request_journal_received_at
+ON public.request_journal (received_at);
+
+-- Upgrading an existing table: templated and non-JSON bodies need a TEXT column.
+ALTER TABLE public.mock_responses ALTER COLUMN response_body TYPE TEXT;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS is_template BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS weight INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS options JSONB;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS match_expression TEXT;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS host VARCHAR(255);
+ALTER TABLE public.request_journal ADD COLUMN IF NOT EXISTS request_id VARCHAR(200);
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS workspace VARCHAR(100);
+ALTER TABLE public.mock_responses ALTER COLUMN method TYPE VARCHAR(20);
+ALTER TABLE public.request_journal ALTER COLUMN method TYPE VARCHAR(20);
+ALTER TABLE public.request_journal ADD COLUMN IF NOT EXISTS response_headers JSONB;
+ALTER TABLE public.request_journal ADD COLUMN IF NOT EXISTS response_body TEXT;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS request_body_hash VARCHAR(128);
+DROP INDEX IF EXISTS public.idx_mock_responses_lookup;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS labels JSONB;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE public.mock_responses ADD COLUMN IF NOT EXISTS last_hit_at TIMESTAMP;
+ALTER TABLE public.crud_records ADD COLUMN IF NOT EXISTS session VARCHAR(200) NOT NULL DEFAULT '';
+ALTER TABLE public.crud_records DROP CONSTRAINT IF EXISTS crud_records_workspace_collection_record_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS crud_records_workspace_session_collection_record_id_key
+ON public.crud_records (workspace, session, collection, record_id);
+
+CREATE INDEX IF NOT EXISTS idx_mock_responses_body_hash
+ON public.mock_responses (path, method, request_body_hash);
+
+-- Mocks whose options.query match query strings are looked up by their
+-- path without the query string.
+CREATE INDEX IF NOT EXISTS idx_mock_responses_base_path
+ON public.mock_responses (split_part(path, '?', 1), method, request_body_hash)
+WHERE options ? 'query';
+
+-- The same lookups with PATH_CASE_INSENSITIVE, which compares lower(path).
+CREATE INDEX IF NOT EXISTS idx_mock_responses_lower_path
+ON public.mock_responses (lower(path), method, request_body_hash);
+
+CREATE INDEX IF NOT EXISTS idx_mock_responses_lower_base_path
+ON public.mock_responses (split_part(lower(path), '?', 1), method, request_body_hash)
+WHERE options ? 'query';
+
+CREATE INDEX IF NOT EXISTS idx_mock_responses_labels
+ON public.mock_responses USING GIN (labels);
+
+-- The router hashes canonicalized request bodies itself; a body changed
+-- without a new hash invalidates the stored one so it is recomputed on the
+-- next lookup.
+CREATE OR REPLACE FUNCTION public.reset_request_body_hash() RETURNS trigger AS $$
+BEGIN
+    IF NEW.request_body IS DISTINCT FROM OLD.request_body
+       AND NEW.request_body_hash IS NOT DISTINCT FROM OLD.request_body_hash THEN
+        NEW.request_body_hash := NULL;
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS mock_responses_reset_body_hash ON public.mock_responses;
+CREATE TRIGGER mock_responses_reset_body_hash
+BEFORE UPDATE OF request_body ON public.mock_responses
+FOR EACH ROW EXECUTE FUNCTION public.reset_request_body_hash();
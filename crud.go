@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+var crudCollections []string
+
+func loadCRUDCollections(value string) {
+	for _, collection := range strings.Split(value, ",") {
+		collection = strings.TrimSuffix(strings.TrimSpace(collection), "/")
+		if collection != "" {
+			crudCollections = append(crudCollections, collection)
+		}
+	}
+}
+
+func matchCRUDPath(path string) (collection string, id string, ok bool) {
+	path = strings.TrimSuffix(path, "/")
+	for _, c := range crudCollections {
+		if len(c) <= len(collection) {
+			continue
+		}
+		if path == c {
+			collection, id, ok = c, "", true
+		} else if strings.HasPrefix(path, c+"/") && !strings.Contains(path[len(c)+1:], "/") {
+			collection, id, ok = c, path[len(c)+1:], true
+		}
+	}
+	return collection, id, ok
+}
+
+func crudHandler(w http.ResponseWriter, r *http.Request, collection, id, requestBodyJSON string) {
+	workspace := requestWorkspace(r)
+	session := requestSession(r)
+	idField := envString("CRUD_ID_FIELD", "id")
+
+	ctx, cancel := requestQueryContext(r)
+	defer cancel()
+
+	var err error
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		err = crudList(ctx, w, workspace, session, collection)
+	case id == "" && r.Method == http.MethodPost:
+		err = crudCreate(ctx, w, workspace, session, collection, idField, requestBodyJSON)
+	case id != "" && r.Method == http.MethodGet:
+		err = crudGet(ctx, w, workspace, session, collection, id)
+	case id != "" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		err = crudUpdate(ctx, w, workspace, session, collection, id, idField, requestBodyJSON, r.Method == http.MethodPatch)
+	case id != "" && r.Method == http.MethodDelete:
+		err = crudDelete(ctx, w, workspace, session, collection, id)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if err != nil {
+		writeQueryError(w, r, "CRUD storage", queryError(ctx, "crud", err))
+	}
+}
+
+func crudList(ctx context.Context, w http.ResponseWriter, workspace, session, collection string) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT body
+		FROM return.crud_records
+		WHERE workspace = $1
+		  AND session = $2
+		  AND collection = $3
+		ORDER BY id
+	`, workspace, session, collection)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	items := []json.RawMessage{}
+	for rows.Next() {
+		var body string
+		if err := rows.Scan(&body); err != nil {
+			return err
+		}
+		items = append(items, json.RawMessage(body))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, items)
+	return nil
+}
+
+func crudCreate(ctx context.Context, w http.ResponseWriter, workspace, session, collection, idField, requestBodyJSON string) error {
+	record, ok := decodeCRUDRecord(w, requestBodyJSON)
+	if !ok {
+		return nil
+	}
+
+	var id sql.NullString
+	if value, exists := record[idField]; exists {
+		id = sql.NullString{String: crudIDString(value), Valid: true}
+	}
+
+	var recordID string
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO return.crud_records (workspace, session, collection, record_id, body)
+		VALUES ($1, $2, $3, COALESCE($4, nextval(pg_get_serial_sequence('return.crud_records', 'id'))::text), $5)
+		ON CONFLICT (workspace, session, collection, record_id) DO NOTHING
+		RETURNING record_id
+	`, workspace, session, collection, id, requestBodyJSON).Scan(&recordID)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "record already exists"})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !id.Valid {
+		record[idField] = crudIDValue(recordID)
+		body, _ := json.Marshal(record)
+		if _, err := db.ExecContext(ctx, `
+			UPDATE return.crud_records
+			SET body = $5
+			WHERE workspace = $1 AND session = $2 AND collection = $3 AND record_id = $4
+		`, workspace, session, collection, recordID, string(body)); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Location", collection+"/"+recordID)
+	writeJSON(w, http.StatusCreated, record)
+	return nil
+}
+
+func crudGet(ctx context.Context, w http.ResponseWriter, workspace, session, collection, id string) error {
+	var body string
+	err := db.QueryRowContext(ctx, `
+		SELECT body
+		FROM return.crud_records
+		WHERE workspace = $1
+		  AND session = $2
+		  AND collection = $3
+		  AND record_id = $4
+	`, workspace, session, collection, id).Scan(&body)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, json.RawMessage(body))
+	return nil
+}
+
+func crudUpdate(ctx context.Context, w http.ResponseWriter, workspace, session, collection, id, idField, requestBodyJSON string, merge bool) error {
+	record, ok := decodeCRUDRecord(w, requestBodyJSON)
+	if !ok {
+		return nil
+	}
+	record[idField] = crudIDValue(id)
+	body, _ := json.Marshal(record)
+
+	// The id keeps the type it was created with, so a string id such as
+	// "123" does not turn into a number; the path's form is only a fallback.
+	newBody := `jsonb_set($5::jsonb, ARRAY[$6::text], COALESCE(body -> $6::text, $5::jsonb -> $6::text))`
+	query := `
+		UPDATE return.crud_records
+		SET body = ` + newBody + `, updated_at = CURRENT_TIMESTAMP
+		WHERE workspace = $1 AND session = $2 AND collection = $3 AND record_id = $4
+		RETURNING body
+	`
+	if merge {
+		query = `
+			UPDATE return.crud_records
+			SET body = body || ` + newBody + `, updated_at = CURRENT_TIMESTAMP
+			WHERE workspace = $1 AND session = $2 AND collection = $3 AND record_id = $4
+			RETURNING body
+		`
+	}
+
+	var updated string
+	err := db.QueryRowContext(ctx, query, workspace, session, collection, id, string(body), idField).Scan(&updated)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, json.RawMessage(updated))
+	return nil
+}
+
+func crudDelete(ctx context.Context, w http.ResponseWriter, workspace, session, collection, id string) error {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM return.crud_records
+		WHERE workspace = $1 AND session = $2 AND collection = $3 AND record_id = $4
+	`, workspace, session, collection, id)
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return nil
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func decodeCRUDRecord(w http.ResponseWriter, requestBodyJSON string) (map[string]interface{}, bool) {
+	var record map[string]interface{}
+	if requestBodyJSON == "" || json.Unmarshal([]byte(requestBodyJSON), &record) != nil || record == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "request body must be a JSON object"})
+		return nil, false
+	}
+	return record, true
+}
+
+func crudIDString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func crudIDValue(id string) interface{} {
+	var number json.Number
+	if err := json.Unmarshal([]byte(id), &number); err == nil {
+		return number
+	}
+	return id
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// datasetRecord is a record of a dataset kept as sent, so serving it does
+// not reorder its fields, with its decoded form for filtering and sorting.
+type datasetRecord struct {
+	raw   json.RawMessage
+	doc   interface{}
+	score float64
+	keys  []interface{}
+}
+
+// sortField is a field records are sorted by, or their search score.
+type sortField struct {
+	pointer string
+	score   bool
+	desc    bool
+}
+
+func (o *paginationOptions) datasetParams() (sortParam, searchParam string) {
+	sortParam, searchParam = o.SortParam, o.SearchParam
+	if sortParam == "" {
+		sortParam = "sort"
+	}
+	if searchParam == "" {
+		searchParam = "q"
+	}
+	return sortParam, searchParam
+}
+
+// fieldPointer turns a field name such as "author.name" into a JSON pointer;
+// pointers are kept.
+func fieldPointer(field string) string {
+	if strings.HasPrefix(field, "/") {
+		return field
+	}
+	return "/" + strings.ReplaceAll(field, ".", "/")
+}
+
+// sortFields reads the sort parameter, "-createdAt,name" for instance, or
+// falls back to SortBy.
+func (o *paginationOptions) sortFields(query url.Values) ([]sortField, error) {
+	sortParam, _ := o.datasetParams()
+	value := query.Get(sortParam)
+	if value == "" {
+		if o.SortBy == "" {
+			return nil, nil
+		}
+		return []sortField{{pointer: fieldPointer(o.SortBy), desc: o.Order == "desc"}}, nil
+	}
+	var fields []sortField
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimLeft(name, "+-")
+		if name == "" {
+			continue
+		}
+		if len(o.Sortable) > 0 && !slices.Contains(o.Sortable, name) {
+			return nil, pageRequestError{fmt.Sprintf("cannot sort by %q; use %s", name, strings.Join(o.Sortable, ", "))}
+		}
+		fields = append(fields, sortField{pointer: fieldPointer(name), desc: desc})
+	}
+	return fields, nil
+}
+
+// filtered reports whether a record passes the filters of a request.
+// Filters take several values as repeated parameters or separated by
+// commas, and match records holding any of them.
+func (o *paginationOptions) filtered(record interface{}, query url.Values) bool {
+	for _, field := range o.Filters {
+		values, ok := query[field]
+		if !ok {
+			continue
+		}
+		var wanted []string
+		for _, value := range values {
+			wanted = append(wanted, strings.Split(value, ",")...)
+		}
+		value, _ := lookupJSONPointer(record, fieldPointer(field))
+		if !slices.ContainsFunc(recordTexts(value), func(text string) bool { return slices.Contains(wanted, text) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchScore rates how well a record matches a search: 1 or 0 when
+// looking for the text as is, by fuzzyScore with Fuzzy.
+func (o *paginationOptions) searchScore(record interface{}, search string) float64 {
+	var texts []string
+	if len(o.Search) == 0 {
+		texts = recordTexts(record)
+	}
+	for _, field := range o.Search {
+		value, _ := lookupJSONPointer(record, fieldPointer(field))
+		texts = append(texts, recordTexts(value)...)
+	}
+	if o.Fuzzy {
+		return fuzzyScore(texts, search)
+	}
+	if slices.ContainsFunc(texts, func(text string) bool { return strings.Contains(strings.ToLower(text), search) }) {
+		return 1
+	}
+	return 0
+}
+
+// recordTexts lists the scalar values in a JSON value as text.
+func recordTexts(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case []interface{}:
+		var texts []string
+		for _, item := range v {
+			texts = append(texts, recordTexts(item)...)
+		}
+		return texts
+	case map[string]interface{}:
+		var texts []string
+		for _, item := range v {
+			texts = append(texts, recordTexts(item)...)
+		}
+		return texts
+	}
+	return nil
+}
+
+// compareSortValues orders numbers numerically and anything else by its
+// text, records without the field first.
+func compareSortValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	x, xNumber := a.(float64)
+	y, yNumber := b.(float64)
+	if xNumber && yNumber {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareSortKeys(a, b []interface{}, fields []sortField) int {
+	for i, field := range fields {
+		order := compareSortValues(a[i], b[i])
+		if field.desc {
+			order = -order
+		}
+		if order != 0 {
+			return order
+		}
+	}
+	return 0
+}
+
+// paginateDataset replaces a dataset body with the records a request
+// selects, in the order it asks for, and of those the page it asks for.
+// Mistakes in the request's parameters are answered with 400 Bad Request.
+func paginateDataset(w http.ResponseWriter, r *http.Request, mockResp *MockResponse) (bool, error) {
+	opts := mockResp.Options.Pagination
+	if opts == nil || !opts.Dataset {
+		return true, nil
+	}
+	page, items, err := opts.datasetPage(mockResp.ResponseBody, r.URL.Query())
+	if _, ok := err.(pageRequestError); ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	opts.setHeaders(w, r, page)
+
+	var body []byte
+	if opts.ItemsField != "" {
+		envelope := map[string]interface{}{opts.ItemsField: items, "total": page.Total}
+		if opts.Style == "cursor" {
+			var next interface{}
+			if page.NextCursor != "" {
+				next = page.NextCursor
+			}
+			envelope["nextCursor"] = next
+		}
+		body, err = json.Marshal(envelope)
+	} else {
+		body, err = json.Marshal(items)
+	}
+	if err != nil {
+		return false, err
+	}
+	mockResp.ResponseBody = string(body)
+	return true, nil
+}
+
+// datasetPage filters, sorts and pages the records of a dataset body.
+func (o *paginationOptions) datasetPage(body string, query url.Values) (*pageInfo, []json.RawMessage, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(bytesOf(body), &raw); err != nil {
+		return nil, nil, fmt.Errorf("pagination dataset is not a JSON array: %v", err)
+	}
+	fields, err := o.sortFields(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	sortParam, searchParam := o.datasetParams()
+	search := strings.ToLower(strings.TrimSpace(query.Get(searchParam)))
+	filtering := slices.ContainsFunc(o.Filters, query.Has)
+	if o.Fuzzy && search != "" && query.Get(sortParam) == "" {
+		// The best matches first, in the usual order among equals.
+		fields = append([]sortField{{score: true, desc: true}}, fields...)
+	}
+
+	records := make([]datasetRecord, 0, len(raw))
+	for _, item := range raw {
+		record := datasetRecord{raw: item}
+		if filtering || search != "" || len(fields) > 0 {
+			json.Unmarshal(item, &record.doc)
+		}
+		if filtering && !o.filtered(record.doc, query) {
+			continue
+		}
+		if search != "" {
+			if record.score = o.searchScore(record.doc, search); record.score == 0 {
+				continue
+			}
+		}
+		if len(fields) > 0 {
+			record.keys = make([]interface{}, len(fields))
+			for i, field := range fields {
+				if field.score {
+					record.keys[i] = record.score
+				} else {
+					record.keys[i], _ = lookupJSONPointer(record.doc, field.pointer)
+				}
+			}
+		}
+		records = append(records, record)
+	}
+	if len(fields) > 0 {
+		sort.SliceStable(records, func(a, b int) bool {
+			return compareSortKeys(records[a].keys, records[b].keys, fields) < 0
+		})
+	}
+
+	page, err := o.requestedPage(query, len(records), func(c pageCursor) int {
+		if len(fields) == 0 || len(c.Values) != len(fields) {
+			return c.Offset
+		}
+		// The page starts after the cursor's record, wherever it is now.
+		return sort.Search(len(records), func(i int) bool {
+			order := compareSortKeys(records[i].keys, c.Values, fields)
+			return order > 0 || order == 0 && i >= c.Offset
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	items := make([]json.RawMessage, page.Items)
+	for i := range items {
+		items[i] = records[page.Offset+i].raw
+	}
+	if page.NextCursor != "" && len(fields) > 0 && page.Items > 0 {
+		last := page.Offset + page.Items - 1
+		page.NextCursor = pageCursor{Offset: last + 1, Values: records[last].keys}.encode()
+	}
+	return page, items, nil
+}
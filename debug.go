@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	// debugPprof serves the net/http/pprof profiles under
+	// /__admin/debug/pprof/. Profiles expose memory contents and cost CPU
+	// while recorded, so they are off unless asked for, and only served
+	// behind ADMIN_TOKEN.
+	debugPprof = envBool("DEBUG_PPROF", false)
+
+	startedAt = time.Now()
+)
+
+func registerDebugHandlers(router *httprouter.Router) {
+	router.GET(adminPathPrefix+"debug/stats", debugStatsHandler)
+	if debugPprof {
+		router.GET(adminPathPrefix+"debug/pprof/*name", pprofHandler)
+		router.POST(adminPathPrefix+"debug/pprof/*name", pprofHandler)
+	}
+}
+
+// pprofHandler dispatches to the pprof handlers, which expect to be served
+// at /debug/pprof/.
+func pprofHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch name := strings.TrimPrefix(ps.ByName("name"), "/"); name {
+	case "":
+		pprof.Index(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
+
+type debugStats struct {
+	GoVersion   string                 `json:"goVersion"`
+	Uptime      jsonDuration           `json:"uptime"`
+	CPUs        int                    `json:"cpus"`
+	GOMAXPROCS  int                    `json:"gomaxprocs"`
+	Goroutines  int                    `json:"goroutines"`
+	Memory      debugMemoryStats       `json:"memory"`
+	GC          debugGCStats           `json:"gc"`
+	DBPool      map[string]interface{} `json:"dbPool,omitempty"`
+	ReplicaPool map[string]interface{} `json:"replicaPool,omitempty"`
+	Requests    map[string]int64       `json:"requests"`
+	Caches      map[string]int         `json:"caches"`
+	Journal     map[string]int         `json:"journal"`
+}
+
+type debugMemoryStats struct {
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapInuse   uint64 `json:"heapInuse"`
+	HeapObjects uint64 `json:"heapObjects"`
+	StackInuse  uint64 `json:"stackInuse"`
+	Sys         uint64 `json:"sys"`
+	TotalAlloc  uint64 `json:"totalAlloc"`
+}
+
+type debugGCStats struct {
+	Cycles      uint32       `json:"cycles"`
+	LastGC      *time.Time   `json:"lastGC,omitempty"`
+	PauseTotal  jsonDuration `json:"pauseTotal"`
+	LastPause   jsonDuration `json:"lastPause"`
+	CPUFraction float64      `json:"cpuFraction"`
+	NextGC      uint64       `json:"nextGC"`
+}
+
+// debugStatsHandler reports the runtime, pool and cache figures useful for
+// diagnosing a slow or memory-hungry instance in place.
+func debugStatsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := debugStats{
+		GoVersion:  runtime.Version(),
+		Uptime:     jsonDuration(time.Since(startedAt).Round(time.Second)),
+		CPUs:       runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: debugMemoryStats{
+			HeapAlloc:   mem.HeapAlloc,
+			HeapInuse:   mem.HeapInuse,
+			HeapObjects: mem.HeapObjects,
+			StackInuse:  mem.StackInuse,
+			Sys:         mem.Sys,
+			TotalAlloc:  mem.TotalAlloc,
+		},
+		GC: debugGCStats{
+			Cycles:      mem.NumGC,
+			PauseTotal:  jsonDuration(mem.PauseTotalNs),
+			CPUFraction: mem.GCCPUFraction,
+			NextGC:      mem.NextGC,
+		},
+		Requests: map[string]int64{
+			"inFlight": requestsInFlight.Value(),
+			"queued":   requestsQueued.Value(),
+		},
+		Caches:  map[string]int{},
+		Journal: map[string]int{},
+	}
+	if mem.NumGC > 0 {
+		last := time.Unix(0, int64(mem.LastGC))
+		stats.GC.LastGC = &last
+		stats.GC.LastPause = jsonDuration(mem.PauseNs[(mem.NumGC+255)%256])
+	}
+	if pool, ok := dbPoolStats().(map[string]interface{}); ok {
+		stats.DBPool = pool
+	}
+	if replica != nil {
+		stats.ReplicaPool = sqlPoolStats(replica.db.Stats())
+	}
+
+	mockCacheMu.Lock()
+	stats.Caches["mockLookups"] = len(mockCache)
+	mockCacheMu.Unlock()
+	upstreamCacheMu.Lock()
+	stats.Caches["upstreamResponses"] = len(upstreamCache)
+	upstreamCacheMu.Unlock()
+	compiledTemplatesMu.Lock()
+	stats.Caches["templates"] = len(compiledTemplates)
+	compiledTemplatesMu.Unlock()
+	renderCacheMu.Lock()
+	stats.Caches["renders"] = len(renderCache)
+	renderCacheMu.Unlock()
+	idempotencyKeys.mu.Lock()
+	stats.Caches["idempotencyKeys"] = len(idempotencyKeys.responses)
+	idempotencyKeys.mu.Unlock()
+	if journalQueue != nil {
+		stats.Journal["queued"] = len(journalQueue.queue)
+		stats.Journal["capacity"] = cap(journalQueue.queue)
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// downloadOptions serve a mock's body as a file: with a Content-Disposition,
+// byte ranges for clients resuming a download and, to exercise that,
+// connections cut short.
+type downloadOptions struct {
+	// Filename names the file in Content-Disposition.
+	Filename string `json:"filename,omitempty"`
+	// Inline lets the client display the file instead of saving it.
+	Inline bool `json:"inline,omitempty"`
+	// NoRanges answers every request with the whole file and
+	// Accept-Ranges: none.
+	NoRanges bool `json:"noRanges,omitempty"`
+	// LastModified is sent as Last-Modified and checked by If-Range and
+	// If-Modified-Since, next to the ETag derived from the body.
+	LastModified time.Time `json:"lastModified,omitempty"`
+	// InterruptAfter drops the connection after sending this many bytes of
+	// a response.
+	InterruptAfter byteSize `json:"interruptAfter,omitempty"`
+}
+
+// setDownloadHeader offers the response as a file, for download mocks and
+// tables with a filename.
+func setDownloadHeader(header http.Header, mockResp *MockResponse) {
+	opts := mockResp.Options.Download
+	filename := mockResp.filename
+	if opts == nil && filename == "" {
+		return
+	}
+	disposition := "attachment"
+	if opts != nil {
+		if opts.Filename != "" {
+			filename = opts.Filename
+		}
+		if opts.Inline {
+			disposition = "inline"
+		}
+	}
+	params := map[string]string{}
+	if filename != "" {
+		params["filename"] = filename
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, params))
+}
+
+// serveDownload answers for mocks with download options and returns false
+// for others. Successful responses honour Range, If-Range and conditional
+// requests like a file server; others are written as they are.
+func serveDownload(w http.ResponseWriter, r *http.Request, mockResp *MockResponse) bool {
+	opts := mockResp.Options.Download
+	if opts == nil {
+		return false
+	}
+	if mockResp.contentType == "" {
+		// Without a Content-Type header the file's type follows its name.
+		mockResp.contentType = "application/octet-stream"
+		if ext := path.Ext(opts.Filename); ext != "" && mime.TypeByExtension(ext) != "" {
+			mockResp.contentType = mime.TypeByExtension(ext)
+		}
+	}
+	if opts.InterruptAfter > 0 {
+		w = &interruptingWriter{ResponseWriter: w, r: r, remaining: int(opts.InterruptAfter)}
+	}
+	status := mockResp.ResponseStatusCode
+	if opts.NoRanges || (status != 0 && status != http.StatusOK) {
+		if opts.NoRanges {
+			w.Header().Set("Accept-Ranges", "none")
+		}
+		writeResponse(w, mockResp)
+		return true
+	}
+
+	writeResponseHeaders(w, mockResp)
+	if w.Header().Get("ETag") == "" {
+		sum := sha256.Sum256([]byte(mockResp.ResponseBody))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	}
+	http.ServeContent(w, r, "", opts.LastModified, strings.NewReader(mockResp.ResponseBody))
+	return true
+}
+
+// interruptingWriter drops the connection once it has sent its share of the
+// response, leaving the client with a partial download.
+type interruptingWriter struct {
+	http.ResponseWriter
+	r         *http.Request
+	remaining int
+}
+
+// WriteHeader leaves error responses, such as 416 for an unsatisfiable
+// range, whole.
+func (i *interruptingWriter) WriteHeader(code int) {
+	if code != http.StatusOK && code != http.StatusPartialContent {
+		i.remaining = math.MaxInt
+	}
+	i.ResponseWriter.WriteHeader(code)
+}
+
+func (i *interruptingWriter) Write(b []byte) (int, error) {
+	if len(b) <= i.remaining {
+		i.remaining -= len(b)
+		return i.ResponseWriter.Write(b)
+	}
+	i.ResponseWriter.Write(b[:i.remaining])
+	http.NewResponseController(i.ResponseWriter).Flush()
+	requestLogf(i.r, "Interrupting download of %s %s", i.r.Method, i.r.URL.Path)
+	panic(http.ErrAbortHandler)
+}
+
+func (i *interruptingWriter) Unwrap() http.ResponseWriter {
+	return i.ResponseWriter
+}
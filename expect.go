@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Go's server sends 100 Continue to a client waiting for it once the handler
+// starts reading the body, and answers other expectations with 417 itself.
+// Responding without reading the body rejects the upload instead: the
+// client never sends it.
+
+// expectsContinue reports whether the client waits for 100 Continue before
+// sending the body.
+func expectsContinue(r *http.Request) bool {
+	return r.ProtoAtLeast(1, 1) && r.ContentLength != 0 &&
+		strings.EqualFold(strings.TrimSpace(r.Header.Get("Expect")), "100-continue")
+}
+
+// rejectBeforeBody answers a request waiting for 100 Continue with the mock
+// it matches without its body, when that mock has rejectBeforeBody or a
+// maxBodySize below the announced Content-Length, and returns false then.
+// Only conditions on the request line and headers can hold at this point;
+// conditions on the body see an empty one.
+func rejectBeforeBody(w http.ResponseWriter, r *http.Request, urlPath string, entry *journalEntry) bool {
+	if !expectsContinue(r) {
+		return true
+	}
+	data := newTemplateData(r, "")
+	mockResp, err := getMockResponse(r, urlPath, "", data)
+	if err != nil {
+		return true
+	}
+	if limit := int64(mockResp.Options.MaxBodySize); limit > 0 && r.ContentLength > limit {
+		entry.MockID = mockResp.ID
+		closeAfterResponse(w, r)
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if !mockResp.Options.RejectBeforeBody {
+		return true
+	}
+	entry.MockID = mockResp.ID
+	if mockResp.IsTemplate {
+		if err := renderMockResponse(mockResp, data); err != nil {
+			http.Error(w, "Template rendering failed", http.StatusInternalServerError)
+			requestLogf(r, "Template error: %v", err)
+			return false
+		}
+	}
+	if err := encodeResponseBody(mockResp); err != nil {
+		http.Error(w, "Response encoding failed", http.StatusInternalServerError)
+		requestLogf(r, "Encoding the response of mock %d failed: %v", mockResp.ID, err)
+		return false
+	}
+	closeAfterResponse(w, r)
+	writeResponse(w, mockResp)
+	return false
+}
+
+// closeAfterResponse tells an HTTP/1 client not to reuse the connection,
+// which the server closes after a response leaving the body unread.
+func closeAfterResponse(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "close")
+	}
+}
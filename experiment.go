@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// experimentOptions serve each client one arm of an A/B experiment,
+// chosen by a hash of its identifier so the same client always gets the
+// same arm.
+type experimentOptions struct {
+	// Name salts the hash, so experiments split clients independently; the
+	// mock ID by default.
+	Name string `json:"name,omitempty"`
+	// Header and Cookie name where the client identifier is read from, the
+	// header first. A client without one is given a new identifier in the
+	// cookie; without either the client IP is used.
+	Header   string              `json:"header,omitempty"`
+	Cookie   string              `json:"cookie,omitempty"`
+	Variants []experimentVariant `json:"variants"`
+}
+
+// experimentVariant is an arm of an experiment. Unset fields keep the
+// mock's own response.
+type experimentVariant struct {
+	Name string `json:"name"`
+	// Weight is the arm's share of clients; 1 by default.
+	Weight       *int              `json:"weight,omitempty"`
+	ResponseBody *string           `json:"responseBody,omitempty"`
+	StatusCode   int               `json:"statusCode,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+}
+
+// experimentVariantHeader forces an arm on requests and names the arm served
+// on responses.
+var experimentVariantHeader = envString("EXPERIMENT_VARIANT_HEADER", "X-Mock-Variant")
+
+func (v *experimentVariant) weight() int {
+	if v.Weight == nil {
+		return 1
+	}
+	return *v.Weight
+}
+
+func (e *experimentOptions) validate() error {
+	if len(e.Variants) == 0 {
+		return fmt.Errorf("an experiment needs variants")
+	}
+	names := make(map[string]bool)
+	total := 0
+	for i, v := range e.Variants {
+		if v.Name == "" || names[v.Name] {
+			return fmt.Errorf("variant %d needs a unique name", i)
+		}
+		names[v.Name] = true
+		if v.weight() < 0 {
+			return fmt.Errorf("variant %s has a negative weight", v.Name)
+		}
+		total += v.weight()
+	}
+	if total == 0 {
+		return fmt.Errorf("the variants' weights add up to 0")
+	}
+	return nil
+}
+
+// clientIdentifier returns what the experiment assigns the client by,
+// setting a new identifier cookie when the client has none.
+func (e *experimentOptions) clientIdentifier(w http.ResponseWriter, r *http.Request) string {
+	if e.Header != "" {
+		if id := strings.TrimSpace(r.Header.Get(e.Header)); id != "" {
+			return id
+		}
+	}
+	if e.Cookie == "" {
+		return clientIP(r)
+	}
+	if cookie, err := r.Cookie(e.Cookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	id := newUUID()
+	http.SetCookie(w, &http.Cookie{Name: e.Cookie, Value: id, Path: "/", HttpOnly: true})
+	return id
+}
+
+// assign picks the arm of a client identifier.
+func (e *experimentOptions) assign(salt, id string) *experimentVariant {
+	total := 0
+	for i := range e.Variants {
+		total += max(e.Variants[i].weight(), 0)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(salt + "\x00" + id))
+	n := int(h.Sum64() % uint64(total))
+	for i := range e.Variants {
+		weight := max(e.Variants[i].weight(), 0)
+		if n < weight {
+			return &e.Variants[i]
+		}
+		n -= weight
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
+
+// applyExperiment serves the client's arm of the mock's experiment, or the
+// one the variant header asks for.
+func applyExperiment(w http.ResponseWriter, r *http.Request, mockResp *MockResponse, data *templateData) {
+	e := mockResp.Options.Experiment
+	if e == nil || e.validate() != nil {
+		return
+	}
+	var variant *experimentVariant
+	if forced := strings.TrimSpace(r.Header.Get(experimentVariantHeader)); forced != "" {
+		for i := range e.Variants {
+			if e.Variants[i].Name == forced {
+				variant = &e.Variants[i]
+			}
+		}
+	}
+	if variant == nil {
+		salt := e.Name
+		if salt == "" {
+			salt = strconv.Itoa(mockResp.ID)
+		}
+		variant = e.assign(salt, e.clientIdentifier(w, r))
+	}
+
+	data.Variant = variant.Name
+	w.Header().Set(experimentVariantHeader, variant.Name)
+	if variant.ResponseBody != nil {
+		mockResp.ResponseBody = *variant.ResponseBody
+	}
+	if variant.StatusCode != 0 {
+		mockResp.ResponseStatusCode = variant.StatusCode
+	}
+	if len(variant.Headers) > 0 {
+		headers := parseHeaders(mockResp.Headers)
+		for name, value := range variant.Headers {
+			for existing := range headers {
+				if strings.EqualFold(existing, name) {
+					delete(headers, existing)
+				}
+			}
+			headers[name] = value
+		}
+		pairs := make([]string, 0, len(headers))
+		for name, value := range headers {
+			pairs = append(pairs, name+"="+value)
+		}
+		slices.Sort(pairs)
+		mockResp.Headers = sql.NullString{String: strings.Join(pairs, "; "), Valid: true}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// fuzzyMinSimilarity is how close a misspelt word must be to a word of the
+// text, as the share of its letters that need no edit, to count as a match.
+const fuzzyMinSimilarity = 0.6
+
+// fuzzyMinLength keeps shorter words from matching about anything.
+const fuzzyMinLength = 3
+
+// fuzzyScore rates how well texts match a search, between 0 for no match
+// and 1 for an exact one. Every word of the search must match some word of
+// the texts, scoring by how: the whole text, a prefix, a word, a word
+// prefix, a substring, a close spelling and, least, the letters in order.
+// The score is the average over the search's words.
+func fuzzyScore(texts []string, search string) float64 {
+	terms := searchWords(search)
+	if len(terms) == 0 {
+		return 0
+	}
+	lowered := make([]string, len(texts))
+	words := make([][]string, len(texts))
+	for i, text := range texts {
+		lowered[i] = strings.ToLower(text)
+		words[i] = searchWords(text)
+	}
+	phrase := strings.Join(terms, " ")
+	for i := range texts {
+		if strings.Join(words[i], " ") == phrase {
+			return 1
+		}
+	}
+
+	total := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for i, text := range lowered {
+			best = max(best, termScore(term, text, words[i]))
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total / float64(len(terms))
+}
+
+func termScore(term, text string, words []string) float64 {
+	switch {
+	case text == term:
+		return 1
+	case strings.HasPrefix(text, term):
+		return 0.9
+	}
+	best := 0.0
+	for _, word := range words {
+		switch {
+		case word == term:
+			best = max(best, 0.85)
+		case strings.HasPrefix(word, term):
+			best = max(best, 0.75)
+		case strings.Contains(word, term):
+			best = max(best, 0.6)
+		case len([]rune(term)) < fuzzyMinLength:
+		default:
+			if similarity := wordSimilarity(term, word); similarity >= fuzzyMinSimilarity {
+				best = max(best, 0.7*similarity)
+			} else if isSubsequence(term, word) {
+				best = max(best, 0.2)
+			}
+		}
+	}
+	return best
+}
+
+// searchWords splits text into lowercase words of letters and digits.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// wordSimilarity is 1 minus the Levenshtein distance of two words relative
+// to the longer one.
+func wordSimilarity(a, b string) float64 {
+	x, y := []rune(a), []rune(b)
+	longest := max(len(x), len(y))
+	if longest == 0 {
+		return 1
+	}
+	previous := make([]int, len(y)+1)
+	current := make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		current[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(y)])/float64(longest)
+}
+
+// isSubsequence reports whether the letters of term appear in word in
+// order, as in "jsmth" and "johnsmith".
+func isSubsequence(term, word string) bool {
+	remaining := []rune(term)
+	for _, r := range word {
+		if len(remaining) > 0 && r == remaining[0] {
+			remaining = remaining[1:]
+		}
+	}
+	return len(remaining) == 0
+}
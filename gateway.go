@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// gatewayConfig emulates the API gateway the services sit behind in
+// production: API keys, quotas, a request ID header and a standard error
+// envelope, applied in front of the mocks.
+type gatewayConfig struct {
+	// Paths are the path globs behind the gateway; all by default.
+	Paths []string `json:"paths,omitempty"`
+	// Public are path globs served without an API key.
+	Public          []string       `json:"public,omitempty"`
+	APIKey          *gatewayAPIKey `json:"apiKey,omitempty"`
+	Quota           *gatewayQuota  `json:"quota,omitempty"`
+	RequestIDHeader string         `json:"requestIdHeader,omitempty"`
+	Errors          *gatewayErrors `json:"errors,omitempty"`
+}
+
+type gatewayAPIKey struct {
+	Header string `json:"header,omitempty"`
+	// Query is a query parameter the key may be sent in instead.
+	Query string `json:"query,omitempty"`
+	// Keys are the valid keys; without them any key is accepted.
+	Keys []string `json:"keys,omitempty"`
+}
+
+// gatewayQuota allows each API key, or client IP without API keys, Limit
+// requests per Window.
+type gatewayQuota struct {
+	Limit   int          `json:"limit"`
+	Window  jsonDuration `json:"window"`
+	Headers string       `json:"headers,omitempty"`
+}
+
+// gatewayErrors shapes the gateway's own errors and, with WrapMockErrors,
+// the JSON error responses of mocks. "$status", "$message" and "$body" in
+// the envelope are replaced by the status, its message and the mock's body.
+type gatewayErrors struct {
+	Envelope       interface{} `json:"envelope"`
+	WrapMockErrors bool        `json:"wrapMockErrors,omitempty"`
+}
+
+const (
+	envelopeStatus  = "$status"
+	envelopeMessage = "$message"
+)
+
+// defaultGateway is the preset GATEWAY_EMULATION turns on.
+func defaultGateway() *gatewayConfig {
+	return &gatewayConfig{
+		APIKey:          &gatewayAPIKey{Header: "X-Api-Key"},
+		Quota:           &gatewayQuota{Limit: 100, Window: jsonDuration(time.Minute)},
+		RequestIDHeader: "X-Gateway-Request-Id",
+		Errors: &gatewayErrors{
+			Envelope: map[string]interface{}{
+				"error": map[string]interface{}{"status": envelopeStatus, "message": envelopeMessage, "details": envelopeBody, "requestId": "{{.RequestID}}"},
+			},
+			WrapMockErrors: true,
+		},
+	}
+}
+
+func (g *gatewayConfig) validate() error {
+	for _, pattern := range append(append([]string{}, g.Paths...), g.Public...) {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %v", pattern, err)
+		}
+	}
+	if g.APIKey != nil && g.APIKey.Header == "" && g.APIKey.Query == "" {
+		g.APIKey.Header = "X-Api-Key"
+	}
+	if q := g.Quota; q != nil {
+		if q.Limit <= 0 || q.Window <= 0 {
+			return fmt.Errorf("quota needs a positive limit and window")
+		}
+		if q.Headers != "" && !slices.Contains(rateLimitHeaderStyles, q.Headers) {
+			return fmt.Errorf("quota headers must be one of %s", strings.Join(rateLimitHeaderStyles, ", "))
+		}
+	}
+	if g.Errors != nil && g.Errors.Envelope == nil {
+		return fmt.Errorf("errors needs an envelope")
+	}
+	return nil
+}
+
+func (g *gatewayConfig) fronts(urlPath string) bool {
+	return len(g.Paths) == 0 || matchesAnyGlob(g.Paths, urlPath)
+}
+
+func matchesAnyGlob(patterns []string, urlPath string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, urlPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	gatewayMu sync.RWMutex
+	gateway   *gatewayConfig
+)
+
+func currentGateway() *gatewayConfig {
+	gatewayMu.RLock()
+	defer gatewayMu.RUnlock()
+	return gateway
+}
+
+func setGateway(config *gatewayConfig) error {
+	if config != nil {
+		if err := config.validate(); err != nil {
+			return err
+		}
+	}
+	gatewayMu.Lock()
+	gateway = config
+	gatewayMu.Unlock()
+	return nil
+}
+
+// initGateway turns on the preset with enabled, or the configuration of a
+// JSON file.
+func initGateway(file string, enabled bool) error {
+	var config *gatewayConfig
+	if enabled {
+		config = defaultGateway()
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading gateway config: %v", err)
+		}
+		config = &gatewayConfig{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return fmt.Errorf("invalid gateway config: %v", err)
+		}
+	}
+	if config == nil {
+		return nil
+	}
+	if err := setGateway(config); err != nil {
+		return fmt.Errorf("invalid gateway config: %v", err)
+	}
+	fmt.Println("Gateway emulation enabled")
+	return nil
+}
+
+// admitThroughGateway checks a request the way the gateway would before it
+// reaches a mock. It returns false when it answered the request itself.
+func admitThroughGateway(w http.ResponseWriter, r *http.Request) bool {
+	g := currentGateway()
+	if g == nil || !g.fronts(r.URL.Path) {
+		return true
+	}
+	if g.RequestIDHeader != "" {
+		id := requestID(r)
+		r.Header.Set(g.RequestIDHeader, id)
+		w.Header().Set(g.RequestIDHeader, id)
+	}
+
+	client := clientIP(r)
+	if key := g.APIKey; key != nil && !matchesAnyGlob(g.Public, r.URL.Path) {
+		value := ""
+		if key.Header != "" {
+			value = strings.TrimSpace(r.Header.Get(key.Header))
+		}
+		if value == "" && key.Query != "" {
+			value = r.URL.Query().Get(key.Query)
+		}
+		if value == "" {
+			writeGatewayError(w, r, g, http.StatusUnauthorized, "missing API key")
+			return false
+		}
+		if len(key.Keys) > 0 && !slices.Contains(key.Keys, value) {
+			writeGatewayError(w, r, g, http.StatusForbidden, "invalid API key")
+			return false
+		}
+		client = "key:" + value
+	}
+
+	if q := g.Quota; q != nil {
+		now := requestNow(r)
+		opts := &rateLimitOptions{Limit: q.Limit, Window: q.Window, Headers: q.Headers}
+		key := requestWorkspace(r) + "|" + requestSession(r) + "|gateway:" + client
+		remaining, reset, allowed := rateLimits.hit(key, q.Limit, time.Duration(q.Window), now)
+		retryAfter := max(int(math.Ceil(reset.Sub(now).Seconds())), 1)
+		setRateLimitHeaders(w.Header(), opts, remaining, reset, retryAfter)
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeGatewayError(w, r, g, http.StatusTooManyRequests,
+				fmt.Sprintf("quota of %d requests per %v exceeded", q.Limit, time.Duration(q.Window)))
+			return false
+		}
+	}
+	return true
+}
+
+// writeGatewayError answers with an error of the gateway, in its envelope.
+func writeGatewayError(w http.ResponseWriter, r *http.Request, g *gatewayConfig, status int, message string) {
+	if g.Errors == nil {
+		writeJSON(w, status, map[string]string{"error": message})
+		return
+	}
+	body, err := buildEnvelope(g.Errors.Envelope, map[string]interface{}{
+		envelopeStatus: status, envelopeMessage: message, envelopeBody: nil,
+	}, newTemplateData(r, ""))
+	if err != nil {
+		requestLogf(r, "Gateway error envelope failed: %v", err)
+		body = map[string]string{"error": message}
+	}
+	writeJSON(w, status, body)
+}
+
+// gatewayNotFound answers requests no mock matched in the gateway's error
+// envelope. It returns false when the gateway leaves them alone.
+func gatewayNotFound(w http.ResponseWriter, r *http.Request) bool {
+	g := currentGateway()
+	if g == nil || g.Errors == nil || !g.fronts(r.URL.Path) {
+		return false
+	}
+	writeGatewayError(w, r, g, http.StatusNotFound, "no route for "+r.Method+" "+r.URL.Path)
+	return true
+}
+
+// wrapMockError puts the JSON error responses of mocks in the gateway's
+// error envelope.
+func wrapMockError(r *http.Request, mockResp *MockResponse, data templateData) error {
+	g := currentGateway()
+	status := mockResp.ResponseStatusCode
+	if g == nil || g.Errors == nil || !g.Errors.WrapMockErrors || status < 400 || !g.fronts(r.URL.Path) || !isJSONResponse(mockResp) {
+		return nil
+	}
+	var body interface{}
+	if strings.TrimSpace(mockResp.ResponseBody) != "" {
+		if err := json.Unmarshal([]byte(mockResp.ResponseBody), &body); err != nil {
+			return nil
+		}
+	}
+	wrapped, err := buildEnvelope(g.Errors.Envelope, map[string]interface{}{
+		envelopeStatus: status, envelopeMessage: http.StatusText(status), envelopeBody: body,
+	}, data)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(wrapped)
+	if err != nil {
+		return err
+	}
+	mockResp.ResponseBody = string(encoded)
+	return nil
+}
+
+func getGatewayHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	g := currentGateway()
+	if g == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "gateway emulation is not enabled; set GATEWAY_EMULATION or PUT a configuration"})
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+// putGatewayHandler replaces the configuration; ?preset=true starts from
+// the preset, which the body then overrides.
+func putGatewayHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	config := &gatewayConfig{}
+	preset, _ := strconv.ParseBool(r.URL.Query().Get("preset"))
+	if preset {
+		config = defaultGateway()
+	}
+	if err := json.NewDecoder(r.Body).Decode(config); err != nil && !(preset && errors.Is(err, io.EOF)) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid gateway config: " + err.Error()})
+		return
+	}
+	if err := setGateway(config); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Gateway emulation configured")
+	writeJSON(w, http.StatusOK, config)
+}
+
+func deleteGatewayHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	setGateway(nil)
+	log.Printf("Gateway emulation disabled")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+var (
+	// geoHeaders are the headers a request's country is read from, the
+	// first one present winning.
+	geoHeaders        = envList("GEO_HEADERS", []string{"CF-IPCountry", "X-Country"})
+	geoDefaultCountry = strings.ToUpper(envString("GEO_DEFAULT_COUNTRY", ""))
+)
+
+// requestCountry returns the upper-cased country code of a request, or
+// GEO_DEFAULT_COUNTRY when no geo header names one. "XX", which Cloudflare
+// sends for unknown locations, counts as none.
+func requestCountry(r *http.Request) string {
+	for _, name := range geoHeaders {
+		country := strings.ToUpper(strings.TrimSpace(r.Header.Get(name)))
+		if country != "" && country != "XX" {
+			return country
+		}
+	}
+	return geoDefaultCountry
+}
+
+// matchesCountry reports whether country is one of the codes, compared
+// case-insensitively.
+func matchesCountry(codes []string, country string) bool {
+	for _, code := range codes {
+		if country != "" && strings.EqualFold(code, country) {
+			return true
+		}
+	}
+	return false
+}
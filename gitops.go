@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const gitopsSource = "gitops"
+
+type gitSyncStatus struct {
+	Repository  string     `json:"repository"`
+	Branch      string     `json:"branch"`
+	Path        string     `json:"path,omitempty"`
+	Interval    string     `json:"interval"`
+	Commit      string     `json:"commit,omitempty"`
+	Files       int        `json:"files"`
+	Mocks       int        `json:"mocks"`
+	LastSync    *time.Time `json:"lastSync,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Result      syncResult `json:"result"`
+}
+
+type gitSync struct {
+	repository string
+	branch     string
+	path       string
+	dir        string
+	interval   time.Duration
+
+	running sync.Mutex
+	mu      sync.RWMutex
+	status  gitSyncStatus
+}
+
+var gitops *gitSync
+
+// startGitSync clones GITOPS_REPOSITORY and reconciles the mocks defined in
+// its JSON files, then pulls and reconciles again every GITOPS_INTERVAL.
+func startGitSync() {
+	repository := envString("GITOPS_REPOSITORY", "")
+	if repository == "" {
+		return
+	}
+	gitops = &gitSync{
+		repository: repository,
+		branch:     envString("GITOPS_BRANCH", "main"),
+		path:       strings.Trim(envString("GITOPS_PATH", ""), "/"),
+		dir:        envString("GITOPS_DIR", filepath.Join(os.TempDir(), "mock-db-router-gitops")),
+		interval:   envDuration("GITOPS_INTERVAL", time.Minute),
+	}
+	gitops.status = gitSyncStatus{
+		Repository: redactURL(repository),
+		Branch:     gitops.branch,
+		Path:       gitops.path,
+		Interval:   gitops.interval.String(),
+	}
+
+	go func() {
+		for {
+			if err := gitops.sync(context.Background()); err != nil {
+				log.Printf("Error syncing mocks from %s: %v", gitops.status.Repository, err)
+			}
+			if gitops.interval <= 0 {
+				return
+			}
+			time.Sleep(gitops.interval)
+		}
+	}()
+}
+
+// redactURL hides a password embedded in a repository URL.
+func redactURL(repository string) string {
+	if u, err := url.Parse(repository); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return repository
+}
+
+func (g *gitSync) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		message = strings.ReplaceAll(message, g.repository, redactURL(g.repository))
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, message)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (g *gitSync) pull(ctx context.Context) (string, error) {
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); err != nil {
+		if err := os.RemoveAll(g.dir); err != nil {
+			return "", err
+		}
+		if _, err := g.git(ctx, "clone", "--quiet", "--depth", "1", "--branch", g.branch, g.repository, g.dir); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := g.git(ctx, "-C", g.dir, "fetch", "--quiet", "--depth", "1", "origin", g.branch); err != nil {
+			return "", err
+		}
+		if _, err := g.git(ctx, "-C", g.dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+	return g.git(ctx, "-C", g.dir, "rev-parse", "HEAD")
+}
+
+// loadDefinitions reads every .json file below the configured path and keys
+// each mock by its file and position within it.
+func (g *gitSync) loadDefinitions() (map[string]mockDefinition, int, error) {
+	root := filepath.Join(g.dir, filepath.FromSlash(g.path))
+	desired := make(map[string]mockDefinition)
+	files := 0
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(path), ".json") {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		defs, _, err := parseMockDefinitions(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", rel, err)
+		}
+		for i, def := range defs {
+			desired[fmt.Sprintf("%s#%d", rel, i)] = def
+		}
+		files++
+		return nil
+	})
+	return desired, files, err
+}
+
+func (g *gitSync) sync(ctx context.Context) error {
+	g.running.Lock()
+	defer g.running.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	commit, err := g.pull(ctx)
+	var desired map[string]mockDefinition
+	var files int
+	if err == nil {
+		desired, files, err = g.loadDefinitions()
+	}
+	var result syncResult
+	if err == nil {
+		result, err = reconcileMocks(ctx, gitopsSource, desired)
+	}
+
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status.LastSync = &now
+	if err != nil {
+		g.status.Error = err.Error()
+		return err
+	}
+	g.status.Error = ""
+	g.status.LastSuccess = &now
+	g.status.Commit = commit
+	g.status.Files = files
+	g.status.Mocks = len(desired)
+	g.status.Result = result
+	if result.Created+result.Updated+result.Deleted > 0 {
+		log.Printf("Synced mocks from %s at %.12s: %d created, %d updated, %d deleted",
+			g.status.Repository, commit, result.Created, result.Updated, result.Deleted)
+	}
+	return nil
+}
+
+func (g *gitSync) currentStatus() gitSyncStatus {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.status
+}
+
+func gitSyncStatusHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if gitops == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "GitOps sync is not enabled; set GITOPS_REPOSITORY"})
+		return
+	}
+	writeJSON(w, http.StatusOK, gitops.currentStatus())
+}
+
+func gitSyncHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if gitops == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "GitOps sync is not enabled; set GITOPS_REPOSITORY"})
+		return
+	}
+	if err := gitops.sync(r.Context()); err != nil {
+		writeJSON(w, http.StatusBadGateway, gitops.currentStatus())
+		return
+	}
+	writeJSON(w, http.StatusOK, gitops.currentStatus())
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// graphqlOptions answer GraphQL requests with data generated from the
+// registered schema. Resolvers override generated values per "Type.field".
+type graphqlOptions struct {
+	Resolvers map[string]interface{} `json:"resolvers,omitempty"`
+	// ListLength is the number of items generated for list fields (default 2).
+	ListLength int `json:"listLength,omitempty"`
+}
+
+// graphqlSchema is the schema loaded from GRAPHQL_SCHEMA_FILES; nil when none is.
+var graphqlSchema *ast.Schema
+
+func loadGraphQLSchema(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	var sources []*ast.Source
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, &ast.Source{Name: file, Input: string(data)})
+	}
+	schema, err := gqlparser.LoadSchema(sources...)
+	if err != nil {
+		return fmt.Errorf("invalid GraphQL schema: %v", err)
+	}
+	graphqlSchema = schema
+	fmt.Printf("GraphQL schema loaded: %d types\n", len(schema.Types))
+	return nil
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// readGraphQLRequest reads the operation from a JSON body or, for GET, from
+// the query string.
+func readGraphQLRequest(data templateData) (graphqlRequest, error) {
+	var req graphqlRequest
+	if data.Method == "GET" {
+		req.Query = data.Query.Get("query")
+		req.OperationName = data.Query.Get("operationName")
+		if variables := data.Query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return req, fmt.Errorf("invalid variables: %v", err)
+			}
+		}
+	} else {
+		body, err := json.Marshal(data.JSON)
+		if err != nil || data.JSON == nil {
+			return req, fmt.Errorf("request body is not a GraphQL JSON request")
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, fmt.Errorf("request body is not a GraphQL JSON request: %v", err)
+		}
+	}
+	if req.Query == "" {
+		return req, fmt.Errorf("no query in request")
+	}
+	return req, nil
+}
+
+// graphqlObject keeps the fields of a result in selection order.
+type graphqlObject []graphqlResult
+
+type graphqlResult struct {
+	key   string
+	value interface{}
+}
+
+func (o graphqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type graphqlResolver struct {
+	schema    *ast.Schema
+	opts      *graphqlOptions
+	variables map[string]interface{}
+	data      templateData
+}
+
+// renderGraphQLResponse replaces the mock's response body with the result of
+// the request's operation. Invalid operations get a GraphQL errors document.
+func renderGraphQLResponse(mockResp *MockResponse, data templateData) error {
+	if graphqlSchema == nil {
+		return fmt.Errorf("no GraphQL schema is registered")
+	}
+	var errs gqlerror.List
+	var result graphqlObject
+	req, err := readGraphQLRequest(data)
+	if err != nil {
+		errs = gqlerror.List{gqlerror.Errorf("%v", err)}
+	} else {
+		result, errs = executeGraphQL(mockResp.Options.GraphQL, req, data)
+	}
+
+	response := map[string]interface{}{}
+	if len(errs) > 0 {
+		response["errors"] = errs
+	}
+	if result != nil {
+		response["data"] = result
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	mockResp.ResponseBody = string(body)
+	mockResp.contentType = "application/json"
+	return nil
+}
+
+func executeGraphQL(opts *graphqlOptions, req graphqlRequest, data templateData) (graphqlObject, gqlerror.List) {
+	doc, errs := gqlparser.LoadQueryWithRules(graphqlSchema, req.Query, nil)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	op := doc.Operations.ForName(req.OperationName)
+	if op == nil {
+		if req.OperationName == "" {
+			return nil, gqlerror.List{gqlerror.Errorf("operationName is required for documents with several operations")}
+		}
+		return nil, gqlerror.List{gqlerror.Errorf("no operation named %q", req.OperationName)}
+	}
+	variables, err := validator.VariableValues(graphqlSchema, op, req.Variables)
+	if err != nil {
+		if gqlErr, ok := err.(*gqlerror.Error); ok {
+			return nil, gqlerror.List{gqlErr}
+		}
+		return nil, gqlerror.List{gqlerror.Errorf("%v", err)}
+	}
+
+	var root *ast.Definition
+	switch op.Operation {
+	case ast.Query:
+		root = graphqlSchema.Query
+	case ast.Mutation:
+		root = graphqlSchema.Mutation
+	default:
+		return nil, gqlerror.List{gqlerror.Errorf("%s operations are not supported", op.Operation)}
+	}
+	if root == nil {
+		return nil, gqlerror.List{gqlerror.Errorf("schema has no %s type", op.Operation)}
+	}
+
+	resolver := &graphqlResolver{schema: graphqlSchema, opts: opts, variables: variables, data: data}
+	result, err := resolver.object(op.SelectionSet, root, nil, 1)
+	if err != nil {
+		return nil, gqlerror.List{gqlerror.Errorf("%v", err)}
+	}
+	return result, nil
+}
+
+// collectFields flattens fragments into the fields selected on typeName,
+// merging the sub-selections of fields with the same response key.
+func (g *graphqlResolver) collectFields(set ast.SelectionSet, typeName string, fields []*ast.Field) []*ast.Field {
+	for _, selection := range set {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if !g.included(s.Directives) {
+				continue
+			}
+			merged := false
+			for i, field := range fields {
+				if field.Alias == s.Alias {
+					copied := *field
+					copied.SelectionSet = append(append(ast.SelectionSet{}, field.SelectionSet...), s.SelectionSet...)
+					fields[i] = &copied
+					merged = true
+				}
+			}
+			if !merged {
+				fields = append(fields, s)
+			}
+		case *ast.InlineFragment:
+			if g.included(s.Directives) && g.applies(s.TypeCondition, typeName) {
+				fields = g.collectFields(s.SelectionSet, typeName, fields)
+			}
+		case *ast.FragmentSpread:
+			if g.included(s.Directives) && s.Definition != nil && g.applies(s.Definition.TypeCondition, typeName) {
+				fields = g.collectFields(s.Definition.SelectionSet, typeName, fields)
+			}
+		}
+	}
+	return fields
+}
+
+func (g *graphqlResolver) included(directives ast.DirectiveList) bool {
+	if d := directives.ForName("skip"); d != nil && d.ArgumentMap(g.variables)["if"] == true {
+		return false
+	}
+	if d := directives.ForName("include"); d != nil && d.ArgumentMap(g.variables)["if"] == false {
+		return false
+	}
+	return true
+}
+
+func (g *graphqlResolver) applies(condition, typeName string) bool {
+	if condition == "" || condition == typeName {
+		return true
+	}
+	def := g.schema.Types[condition]
+	if def == nil {
+		return false
+	}
+	for _, possible := range g.schema.GetPossibleTypes(def) {
+		if possible.Name == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// object resolves the selection on an object. override holds values given
+// by a resolver for the object itself; n numbers generated values.
+func (g *graphqlResolver) object(set ast.SelectionSet, def *ast.Definition, override map[string]interface{}, n int) (graphqlObject, error) {
+	var result graphqlObject
+	for _, field := range g.collectFields(set, def.Name, nil) {
+		if field.Name == "__typename" {
+			result = append(result, graphqlResult{field.Alias, def.Name})
+			continue
+		}
+		if strings.HasPrefix(field.Name, "__") {
+			return nil, fmt.Errorf("introspection is not supported by the mock")
+		}
+		if field.Definition == nil {
+			continue
+		}
+
+		value, ok := override[field.Alias]
+		if !ok {
+			value, ok = override[field.Name]
+		}
+		if resolver, found := g.opts.Resolvers[def.Name+"."+field.Name]; found {
+			rendered, err := g.render(resolver, field)
+			if err != nil {
+				return nil, fmt.Errorf("resolver %s.%s: %v", def.Name, field.Name, err)
+			}
+			value, ok = rendered, true
+		}
+		resolved, err := g.complete(field, field.Definition.Type, value, ok, n)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, graphqlResult{field.Alias, resolved})
+	}
+	if result == nil {
+		result = graphqlObject{}
+	}
+	return result, nil
+}
+
+// render executes the templates in the strings of a resolver value, with
+// the field's arguments as .Args.
+func (g *graphqlResolver) render(value interface{}, field *ast.Field) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		data := g.data
+		data.Args = field.ArgumentMap(g.variables)
+		return renderString(field.Name, v, data)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := g.render(item, field)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := g.render(item, field)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+func (g *graphqlResolver) complete(field *ast.Field, typ *ast.Type, value interface{}, given bool, n int) (interface{}, error) {
+	if given && value == nil {
+		if typ.NonNull {
+			return nil, fmt.Errorf("field %s is non-null but resolves to null", field.Alias)
+		}
+		return nil, nil
+	}
+
+	if typ.Elem != nil {
+		var items []interface{}
+		if given {
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("field %s is a list but resolves to %T", field.Alias, value)
+			}
+			items = list
+		} else {
+			length := g.opts.ListLength
+			if length <= 0 {
+				length = 2
+			}
+			items = make([]interface{}, length)
+		}
+		result := make([]interface{}, len(items))
+		for i, item := range items {
+			completed, err := g.complete(field, typ.Elem, item, given, i+1)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = completed
+		}
+		return result, nil
+	}
+
+	def := g.schema.Types[typ.NamedType]
+	switch def.Kind {
+	case ast.Scalar:
+		if given {
+			return coerceScalar(def.Name, value), nil
+		}
+		return g.sample(def.Name, field.Name, n), nil
+	case ast.Enum:
+		if given {
+			return value, nil
+		}
+		if len(def.EnumValues) == 0 {
+			return nil, nil
+		}
+		return def.EnumValues[(n-1)%len(def.EnumValues)].Name, nil
+	}
+
+	var override map[string]interface{}
+	if given {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s is an object but resolves to %T", field.Alias, value)
+		}
+		override = object
+	}
+	if def.IsAbstractType() {
+		possible := g.schema.GetPossibleTypes(def)
+		if len(possible) == 0 {
+			return nil, fmt.Errorf("field %s: %s has no possible types", field.Alias, typ.NamedType)
+		}
+		typeName, _ := override["__typename"].(string)
+		if typeName == "" {
+			typeName = possible[(n-1)%len(possible)].Name
+		}
+		def = nil
+		for _, candidate := range possible {
+			if candidate.Name == typeName {
+				def = candidate
+			}
+		}
+		if def == nil {
+			return nil, fmt.Errorf("field %s: %q is not a possible type of %s", field.Alias, typeName, typ.NamedType)
+		}
+	}
+	return g.object(field.SelectionSet, def, override, n)
+}
+
+// coerceScalar converts strings rendered by templates to the scalar's type.
+func coerceScalar(typeName string, value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	switch typeName {
+	case "Int":
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+	case "Float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "Boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// sample generates a value for a scalar field, varied by n so the items of
+// a list differ.
+func (g *graphqlResolver) sample(typeName, fieldName string, n int) interface{} {
+	switch typeName {
+	case "ID":
+		return strconv.Itoa(n)
+	case "Int":
+		return n
+	case "Float":
+		return float64(n) + 0.5
+	case "Boolean":
+		return n%2 == 1
+	case "DateTime", "Time", "Timestamp":
+		return g.data.Now.UTC().Format("2006-01-02T15:04:05Z")
+	case "Date":
+		return g.data.Now.UTC().Format("2006-01-02")
+	case "JSON":
+		return map[string]interface{}{}
+	}
+	lower := strings.ToLower(fieldName)
+	switch {
+	case strings.Contains(lower, "email"):
+		return fmt.Sprintf("user%d@example.com", n)
+	case strings.HasSuffix(lower, "url"), strings.HasSuffix(lower, "uri"):
+		return fmt.Sprintf("https://example.com/%s/%d", fieldName, n)
+	}
+	return fmt.Sprintf("%s %d", fieldName, n)
+}
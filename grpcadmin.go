@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"mock-db-router/adminpb"
+)
+
+// grpcAdminServer serves the admin API over gRPC. It shares the
+// implementation of the REST handlers; only the encoding differs.
+type grpcAdminServer struct {
+	adminpb.UnimplementedMockAdminServer
+}
+
+func startAdminGRPC(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(grpcAdminAuth()...)
+	adminpb.RegisterMockAdminServer(server, &grpcAdminServer{})
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			log.Printf("Admin gRPC server stopped: %v", err)
+		}
+	}()
+	log.Printf("Admin gRPC API listening on %s", ln.Addr())
+	return nil
+}
+
+func grpcError(err error, message string) error {
+	var requestErr adminRequestError
+	var conflictErr *mockConflictError
+	switch {
+	case errors.As(err, &requestErr):
+		return status.Error(codes.InvalidArgument, requestErr.Error())
+	case errors.As(err, &conflictErr):
+		var overlaps []string
+		for _, conflict := range conflictErr.conflicts {
+			overlaps = append(overlaps, fmt.Sprintf("%s %s overlaps %v", conflict.Method, conflict.Path, conflict.IDs))
+		}
+		return status.Error(codes.AlreadyExists, conflictErr.message+": "+strings.Join(overlaps, "; "))
+	case errors.Is(err, errMockNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		log.Printf("Error %s: %v", strings.TrimPrefix(message, "error "), err)
+		return status.Error(codes.Internal, message)
+	}
+}
+
+func toProtoMock(def mockDefinition) *adminpb.Mock {
+	m := &adminpb.Mock{
+		Id:              int32(def.ID),
+		Path:            def.Path,
+		Method:          def.Method,
+		RequestBody:     string(def.RequestBody),
+		ResponseBody:    def.ResponseBody,
+		StatusCode:      int32(def.StatusCode),
+		Headers:         def.Headers,
+		IsTemplate:      def.IsTemplate,
+		Host:            def.Host,
+		Workspace:       def.Workspace,
+		MatchExpression: def.MatchExpression,
+		Options:         string(def.Options),
+		Labels:          def.Labels,
+		Enabled:         def.Enabled,
+	}
+	if def.Weight != nil {
+		weight := int32(*def.Weight)
+		m.Weight = &weight
+	}
+	if def.CreatedAt != nil {
+		m.CreatedAt = timestamppb.New(*def.CreatedAt)
+	}
+	return m
+}
+
+func fromProtoMock(m *adminpb.Mock) mockDefinition {
+	def := mockDefinition{
+		ID:              int(m.GetId()),
+		Path:            m.GetPath(),
+		Method:          m.GetMethod(),
+		ResponseBody:    m.GetResponseBody(),
+		StatusCode:      int(m.GetStatusCode()),
+		Headers:         m.GetHeaders(),
+		IsTemplate:      m.GetIsTemplate(),
+		Host:            m.GetHost(),
+		Workspace:       m.GetWorkspace(),
+		MatchExpression: m.GetMatchExpression(),
+		Labels:          m.GetLabels(),
+		Enabled:         m.Enabled,
+	}
+	if m.RequestBody != "" {
+		def.RequestBody = json.RawMessage(m.RequestBody)
+	}
+	if m.Options != "" {
+		def.Options = json.RawMessage(m.Options)
+	}
+	if m.Weight != nil {
+		weight := int(*m.Weight)
+		def.Weight = &weight
+	}
+	return def
+}
+
+func toProtoConflicts(conflicts []mockConflict) []*adminpb.MockConflict {
+	var result []*adminpb.MockConflict
+	for _, conflict := range conflicts {
+		c := &adminpb.MockConflict{Path: conflict.Path, Method: conflict.Method}
+		if conflict.Index != nil {
+			index := int32(*conflict.Index)
+			c.Index = &index
+		}
+		for _, id := range conflict.IDs {
+			c.Ids = append(c.Ids, int32(id))
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func (s *grpcAdminServer) ListMocks(ctx context.Context, req *adminpb.ListMocksRequest) (*adminpb.ListMocksResponse, error) {
+	query := url.Values{"label": req.GetLabels()}
+	for name, value := range map[string]string{
+		"q": req.GetQ(), "method": req.GetMethod(), "workspace": req.GetWorkspace(), "sort": req.GetSort(), "cursor": req.GetCursor(),
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if req.Status != 0 {
+		query.Set("status", strconv.Itoa(int(req.Status)))
+	}
+	if req.Limit != 0 {
+		query.Set("limit", strconv.Itoa(int(req.Limit)))
+	}
+	if req.Enabled != nil {
+		query.Set("enabled", strconv.FormatBool(*req.Enabled))
+	}
+
+	mocks, nextCursor, err := listMocks(ctx, query)
+	if err != nil {
+		return nil, grpcError(err, "error loading mocks")
+	}
+	resp := &adminpb.ListMocksResponse{NextCursor: nextCursor}
+	for _, def := range mocks {
+		resp.Mocks = append(resp.Mocks, toProtoMock(def))
+	}
+	return resp, nil
+}
+
+func (s *grpcAdminServer) GetMock(ctx context.Context, req *adminpb.GetMockRequest) (*adminpb.Mock, error) {
+	def, err := getMock(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, grpcError(err, "error loading mock")
+	}
+	return toProtoMock(def), nil
+}
+
+func (s *grpcAdminServer) CreateMocks(ctx context.Context, req *adminpb.CreateMocksRequest) (*adminpb.CreateMocksResponse, error) {
+	defs := make([]mockDefinition, 0, len(req.GetMocks()))
+	for _, m := range req.GetMocks() {
+		defs = append(defs, fromProtoMock(m))
+	}
+	conflicts, err := createMocks(ctx, defs, req.GetForce())
+	if err != nil {
+		return nil, grpcError(err, "error saving mocks")
+	}
+	resp := &adminpb.CreateMocksResponse{Conflicts: toProtoConflicts(conflicts)}
+	for _, def := range defs {
+		resp.Mocks = append(resp.Mocks, toProtoMock(def))
+	}
+	return resp, nil
+}
+
+func (s *grpcAdminServer) UpdateMock(ctx context.Context, req *adminpb.UpdateMockRequest) (*adminpb.UpdateMockResponse, error) {
+	if req.GetMock().GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "mock id is required")
+	}
+	def := fromProtoMock(req.GetMock())
+	conflicts, err := saveMock(ctx, &def, req.GetForce())
+	if err != nil {
+		return nil, grpcError(err, "error saving mock")
+	}
+	return &adminpb.UpdateMockResponse{Mock: toProtoMock(def), Conflicts: toProtoConflicts(conflicts)}, nil
+}
+
+func (s *grpcAdminServer) DeleteMock(ctx context.Context, req *adminpb.DeleteMockRequest) (*adminpb.DeleteMockResponse, error) {
+	if err := trashMock(ctx, int(req.GetId())); err != nil {
+		return nil, grpcError(err, "error deleting mock")
+	}
+	return &adminpb.DeleteMockResponse{}, nil
+}
+
+func (s *grpcAdminServer) Reset(ctx context.Context, req *adminpb.ResetRequest) (*adminpb.ResetResponse, error) {
+	result, err := resetState(ctx, req.GetOnly(), req.GetWorkspace())
+	if err != nil {
+		return nil, grpcError(err, "error resetting state")
+	}
+	resp := &adminpb.ResetResponse{}
+	resp.Reset_, _ = result["reset"].([]string)
+	resp.JournalEntries, _ = result["journalEntries"].(int64)
+	resp.CrudRecords, _ = result["crudRecords"].(int64)
+	return resp, nil
+}
+
+func (s *grpcAdminServer) Verify(ctx context.Context, req *adminpb.VerifyRequest) (*adminpb.VerifyResponse, error) {
+	if !journalEnabled {
+		return nil, status.Error(codes.FailedPrecondition, "verification needs the request journal; set JOURNAL_ENABLED=true")
+	}
+	verify := verifyRequest{
+		Workspace:          req.GetWorkspace(),
+		Method:             req.GetMethod(),
+		Path:               req.GetPath(),
+		PathPrefix:         req.GetPathPrefix(),
+		MockID:             int(req.GetMockId()),
+		requestExpectation: requestExpectation{Count: req.Count, AtLeast: req.AtLeast, AtMost: req.AtMost},
+	}
+	if req.Since != nil {
+		verify.Since = req.Since.AsTime()
+	}
+	for _, a := range req.GetAssertions() {
+		verify.Assertions = append(verify.Assertions, &requestAssertion{Pointer: a.GetPointer(), Header: a.GetHeader(), Equals: a.Equals, Matches: a.GetMatches(), Exists: a.Exists})
+	}
+	result, err := verifyJournal(ctx, verify)
+	if err != nil {
+		return nil, grpcError(err, "error verifying requests")
+	}
+	resp := &adminpb.VerifyResponse{Ok: result.OK, Count: result.Count, Message: result.Message}
+	for _, assertion := range result.Assertions {
+		pbResult := &adminpb.AssertionResult{Ok: assertion.OK, Passed: assertion.Passed, Failed: assertion.Failed}
+		for _, failure := range assertion.Failures {
+			pbResult.Failures = append(pbResult.Failures, &adminpb.AssertionFailure{
+				JournalId: failure.JournalID,
+				RequestId: failure.RequestID,
+				Actual:    failure.Actual,
+				Message:   failure.Message,
+			})
+		}
+		resp.Assertions = append(resp.Assertions, pbResult)
+	}
+	return resp, nil
+}
+
+func (s *grpcAdminServer) TailJournal(req *adminpb.TailJournalRequest, stream adminpb.MockAdmin_TailJournalServer) error {
+	entries, unsubscribe := journalTail.subscribe()
+	defer unsubscribe()
+
+	filter := journalTailFilter{
+		Workspace:  req.GetWorkspace(),
+		Method:     req.GetMethod(),
+		PathPrefix: req.GetPathPrefix(),
+		MockID:     int(req.GetMockId()),
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case entry := <-entries:
+			if !filter.matches(entry) {
+				continue
+			}
+			headers := make(map[string]string, len(entry.Headers))
+			for name, values := range entry.Headers {
+				headers[name] = strings.Join(values, ", ")
+			}
+			err := stream.Send(&adminpb.JournalEntry{
+				RequestId:  entry.RequestID,
+				ReceivedAt: timestamppb.New(entry.ReceivedAt),
+				Workspace:  entry.Workspace,
+				Method:     entry.Method,
+				Path:       entry.Path,
+				Headers:    headers,
+				Body:       entry.Body,
+				MockId:     int32(entry.MockID),
+				StatusCode: int32(entry.StatusCode),
+				DurationMs: float64(entry.Duration.Microseconds()) / 1000,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
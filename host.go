@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+func matchesHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(host)
+	if pattern == host {
+		return true
+	}
+	if strings.Contains(pattern, ":") {
+		return false
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return pattern == host
+}
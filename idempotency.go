@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotencyOptions make a mock honour idempotency keys: the first request
+// with a key gets the mock's response, and repeating the key within the
+// window replays that response instead of running the mock again, or gets
+// 409 Conflict.
+type idempotencyOptions struct {
+	// Header carries the key; Idempotency-Key by default.
+	Header string `json:"header,omitempty"`
+	// Window keeps a key's response; 24h by default.
+	Window jsonDuration `json:"window,omitempty"`
+	// Replay is "response" (the default) to replay the first response, or
+	// "conflict" to answer 409.
+	Replay string `json:"replay,omitempty"`
+	// Required answers 400 to requests without a key.
+	Required bool `json:"required,omitempty"`
+}
+
+const (
+	idempotencyReplayResponse = "response"
+	idempotencyReplayConflict = "conflict"
+
+	defaultIdempotencyHeader = "Idempotency-Key"
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
+type idempotentResponse struct {
+	// bodyHash fingerprints the request, so a key reused for another
+	// request is told apart from a replay.
+	bodyHash [32]byte
+	expires  time.Time
+	// done is false while the first request is being handled.
+	done   bool
+	status int
+	header http.Header
+	body   string
+}
+
+type idempotencyStore struct {
+	mu        sync.Mutex
+	swept     time.Time
+	responses map[string]*idempotentResponse
+}
+
+var idempotencyKeys = &idempotencyStore{responses: make(map[string]*idempotentResponse)}
+
+// idempotentRequest is a first request with a key, whose response is kept
+// by finish.
+type idempotentRequest struct {
+	key      string
+	response *idempotentResponse
+}
+
+// checkIdempotency answers requests repeating an idempotency key of the
+// mock and returns false for them. Other requests with a key get an
+// idempotentRequest to finish once the response is written.
+func checkIdempotency(w http.ResponseWriter, mockResp *MockResponse, data templateData) (*idempotentRequest, bool) {
+	opts := mockResp.Options.Idempotency
+	if opts == nil {
+		return nil, true
+	}
+	header := opts.Header
+	if header == "" {
+		header = defaultIdempotencyHeader
+	}
+	value := data.Header.Get(header)
+	if value == "" {
+		if opts.Required {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing " + header + " header"})
+			return nil, false
+		}
+		return nil, true
+	}
+	window := time.Duration(opts.Window)
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+
+	key := data.Workspace + "|" + data.Session + "|" + strconv.Itoa(mockResp.ID) + "|" + value
+	bodyHash := sha256.Sum256([]byte(data.Method + " " + data.Path + "\n" + data.Body))
+	s := idempotencyKeys
+	s.mu.Lock()
+	now := data.Now
+	if now.Sub(s.swept) > time.Minute || now.Before(s.swept) {
+		for k, response := range s.responses {
+			if response.done && !now.Before(response.expires) {
+				delete(s.responses, k)
+			}
+		}
+		s.swept = now
+	}
+	previous, found := s.responses[key]
+	if found && previous.done && !now.Before(previous.expires) {
+		found = false
+	}
+	if !found {
+		response := &idempotentResponse{bodyHash: bodyHash, expires: now.Add(window)}
+		s.responses[key] = response
+		s.mu.Unlock()
+		return &idempotentRequest{key: key, response: response}, true
+	}
+	replay := *previous
+	s.mu.Unlock()
+
+	switch {
+	case replay.bodyHash != bodyHash:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{
+			"error": header + " was already used for a different request",
+		})
+	case !replay.done:
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error": "a request with this " + header + " is still being processed",
+		})
+	case opts.Replay == idempotencyReplayConflict:
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error": "a request with this " + header + " was already processed",
+		})
+	default:
+		// Headers this request got already, such as its request ID or rate
+		// limit, are its own.
+		for name, values := range replay.header {
+			if _, set := w.Header()[name]; !set {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(replay.status)
+		w.Write([]byte(replay.body))
+	}
+	return nil, false
+}
+
+// finish keeps the written response for replays. Without one, because the
+// request failed before, the key is released for a retry.
+func (i *idempotentRequest) finish(header http.Header, mockResp *MockResponse) {
+	if i == nil {
+		return
+	}
+	s := idempotencyKeys
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mockResp == nil {
+		if s.responses[i.key] == i.response {
+			delete(s.responses, i.key)
+		}
+		return
+	}
+	i.response.done = true
+	i.response.status = mockResp.ResponseStatusCode
+	if i.response.status == 0 {
+		i.response.status = http.StatusOK
+	}
+	i.response.header = header.Clone()
+	i.response.body = mockResp.ResponseBody
+}
+
+func (s *idempotencyStore) reset(workspace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.responses {
+		if workspace == "" || strings.HasPrefix(key, workspace+"|") {
+			delete(s.responses, key)
+		}
+	}
+}
+
+func (s *idempotencyStore) resetSession(workspace, session string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.responses {
+		if strings.HasPrefix(key, workspace+"|"+session+"|") {
+			delete(s.responses, key)
+		}
+	}
+}
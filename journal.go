@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type journalEntry struct {
+	ReceivedAt time.Time
+	RequestID  string
+	Workspace  string
+	Method     string
+	Path       string
+	Headers    http.Header
+	Body       string
+	MockID     int
+	StatusCode int
+	Duration   time.Duration
+	Violations []schemaViolation
+
+	// ResponseHeaders and ResponseBody are kept with JOURNAL_RESPONSES; the
+	// body only when it was text and whole.
+	ResponseHeaders  http.Header
+	ResponseBody     string
+	ResponseRecorded bool
+}
+
+var (
+	journalEnabled = envBool("JOURNAL_ENABLED", false)
+	// journalResponses keeps responses too, so entries can become mocks.
+	journalResponses       = journalEnabled && envBool("JOURNAL_RESPONSES", false)
+	journalResponseMaxBody = envSize("JOURNAL_RESPONSE_MAX_BODY", 1<<20)
+)
+
+func newJournalEntry(r *http.Request) *journalEntry {
+	return &journalEntry{
+		ReceivedAt: time.Now(),
+		RequestID:  requestID(r),
+		Workspace:  requestWorkspace(r),
+		Method:     r.Method,
+		Path:       buildFullPath(r),
+		Headers:    r.Header.Clone(),
+	}
+}
+
+// responseCapture is how many bytes of a response body to keep: what the
+// request log shows at level, or what the journal keeps when more.
+func responseCapture(level logLevel) int {
+	capture := bodyCapture(level)
+	if journalResponses {
+		capture = max(capture, int(journalResponseMaxBody))
+	}
+	return capture
+}
+
+// recordResponse keeps the response of a request for the journal. Bodies
+// cut short or not valid UTF-8, such as compressed ones, are left out.
+func (e *journalEntry) recordResponse(rec *statusRecorder) {
+	if !journalResponses {
+		return
+	}
+	e.ResponseHeaders = rec.Header().Clone()
+	if rec.written <= len(rec.captured) && utf8.Valid(rec.captured) {
+		e.ResponseBody, e.ResponseRecorded = string(rec.captured), true
+	}
+}
+
+type journalHub struct {
+	mu          sync.Mutex
+	subscribers map[chan *journalEntry]struct{}
+}
+
+var journalTail = &journalHub{subscribers: make(map[chan *journalEntry]struct{})}
+
+func (h *journalHub) subscribe() (<-chan *journalEntry, func()) {
+	ch := make(chan *journalEntry, 64)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers, ch)
+		h.mu.Unlock()
+	}
+}
+
+// publish hands the entry to every live tail. Subscribers that fall behind
+// miss entries instead of slowing down request handling.
+func (h *journalHub) publish(entry *journalEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+func recordJournal(entry *journalEntry) {
+	journalTail.publish(entry)
+	if !journalEnabled {
+		return
+	}
+	if journalQueue != nil {
+		journalQueue.enqueue(entry)
+		return
+	}
+	if err := insertJournalEntries([]*journalEntry{entry}); err != nil {
+		log.Printf("Error writing journal entry: %v", err)
+	}
+}
+
+const journalColumns = 13
+
+// insertJournalEntries writes entries with a single multi-row INSERT.
+func insertJournalEntries(entries []*journalEntry) error {
+	values := make([]string, 0, len(entries))
+	args := make([]interface{}, 0, len(entries)*journalColumns)
+	for _, entry := range entries {
+		headers, _ := json.Marshal(entry.Headers)
+		var violations sql.NullString
+		if len(entry.Violations) > 0 {
+			data, _ := json.Marshal(entry.Violations)
+			violations = sql.NullString{String: string(data), Valid: true}
+		}
+		var mockID sql.NullInt64
+		if entry.MockID != 0 {
+			mockID = sql.NullInt64{Int64: int64(entry.MockID), Valid: true}
+		}
+		var responseHeaders, responseBody sql.NullString
+		if entry.ResponseHeaders != nil {
+			data, _ := json.Marshal(entry.ResponseHeaders)
+			responseHeaders = sql.NullString{String: string(data), Valid: true}
+		}
+		if entry.ResponseRecorded {
+			responseBody = sql.NullString{String: entry.ResponseBody, Valid: true}
+		}
+
+		placeholders := make([]string, journalColumns)
+		for i := range placeholders {
+			placeholders[i] = "$" + strconv.Itoa(len(args)+i+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, entry.ReceivedAt, entry.RequestID, entry.Workspace, entry.Method, entry.Path, string(headers), entry.Body,
+			mockID, entry.StatusCode, float64(entry.Duration.Microseconds())/1000, violations, responseHeaders, responseBody)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO return.request_journal
+			(received_at, request_id, workspace, method, path, headers, body, mock_id, status_code, duration_ms, violations,
+			 response_headers, response_body)
+		VALUES `+strings.Join(values, ", "), args...)
+	return err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	// capture is how many bytes of the response body to keep in captured,
+	// for verbose request logs.
+	capture  int
+	captured []byte
+	written  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if keep := min(len(b), s.capture-len(s.captured)); keep > 0 {
+		s.captured = append(s.captured, b[:keep]...)
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.written += n
+	return n, err
+}
+
+// WriteString writes s without the copy of a []byte conversion when the
+// underlying writer supports it, as the net/http response does.
+func (s *statusRecorder) WriteString(str string) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if keep := min(len(str), s.capture-len(s.captured)); keep > 0 {
+		s.captured = append(s.captured, str[:keep]...)
+	}
+	n, err := io.WriteString(s.ResponseWriter, str)
+	s.written += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// streaming handlers need for flushing.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
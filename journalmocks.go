@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// journalMockRules select journal entries and shape the mocks made from
+// them. The lists add to the JOURNAL_MOCK_* defaults.
+type journalMockRules struct {
+	Filter replayFilter `json:"filter"`
+	// StripHeaders are response headers left out of the mocks.
+	StripHeaders []string `json:"stripHeaders,omitempty"`
+	// IgnoreFields are JSON pointers of request body fields that change
+	// from call to call, such as timestamps; the mocks match the rest.
+	IgnoreFields []string `json:"ignoreFields,omitempty"`
+	// IgnoreQuery are query parameters the mocks accept any value of.
+	IgnoreQuery []string          `json:"ignoreQuery,omitempty"`
+	Workspace   string            `json:"workspace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// skippedEntry is a journal entry that could not become a mock.
+type skippedEntry struct {
+	JournalID int64  `json:"journalId"`
+	Reason    string `json:"reason"`
+}
+
+// maxConvertedEntries bounds the entries converted at once.
+const maxConvertedEntries = 1000
+
+var (
+	journalMockStripHeaders = envList("JOURNAL_MOCK_STRIP_HEADERS", []string{"Date", "Set-Cookie"})
+	journalMockIgnoreFields = envList("JOURNAL_MOCK_IGNORE_FIELDS", nil)
+	journalMockIgnoreQuery  = envList("JOURNAL_MOCK_IGNORE_QUERY", nil)
+)
+
+type journalResponse struct {
+	id        int64
+	workspace string
+	method    string
+	path      string
+	headers   http.Header
+	body      string
+	status    int
+
+	responseHeaders http.Header
+	responseBody    *string
+}
+
+func loadJournalResponses(ctx context.Context, filter replayFilter) ([]journalResponse, error) {
+	if err := flushJournal(ctx); err != nil {
+		return nil, err
+	}
+	limit := maxConvertedEntries + 1
+	if filter.Limit > 0 && filter.Limit < limit {
+		limit = filter.Limit
+	}
+	where, args := journalConditions(filter)
+	rows, err := db.QueryContext(ctx, "SELECT id, workspace, method, path, headers, body, COALESCE(status_code, 0), response_headers, response_body"+
+		" FROM return.request_journal"+where+" ORDER BY received_at, id LIMIT "+strconv.Itoa(limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []journalResponse
+	for rows.Next() {
+		var entry journalResponse
+		var headers, body, responseHeaders, responseBody []byte
+		if err := rows.Scan(&entry.id, &entry.workspace, &entry.method, &entry.path, &headers, &body, &entry.status, &responseHeaders, &responseBody); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(headers, &entry.headers)
+		entry.body = string(body)
+		if responseHeaders != nil {
+			json.Unmarshal(responseHeaders, &entry.responseHeaders)
+			if entry.responseHeaders == nil {
+				entry.responseHeaders = http.Header{}
+			}
+		}
+		if responseBody != nil {
+			text := string(responseBody)
+			entry.responseBody = &text
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) > maxConvertedEntries {
+		return nil, adminRequestError(fmt.Sprintf("the filter selects more than %d entries; narrow it down", maxConvertedEntries))
+	}
+	return entries, nil
+}
+
+// journalMocks turns journal entries into mock definitions answering the
+// same requests with the journaled responses. Of entries for the same
+// request the latest wins.
+func journalMocks(entries []journalResponse, rules journalMockRules) ([]mockDefinition, []skippedEntry) {
+	strip := make(map[string]bool)
+	for _, list := range [][]string{hopByHopHeaders, {"Content-Length", requestIDHeader}, journalMockStripHeaders, rules.StripHeaders} {
+		for _, name := range list {
+			strip[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	ignoreFields := append(append([]string{}, journalMockIgnoreFields...), rules.IgnoreFields...)
+	ignoreQuery := append(append([]string{}, journalMockIgnoreQuery...), rules.IgnoreQuery...)
+
+	defs := []mockDefinition{}
+	skipped := []skippedEntry{}
+	index := make(map[string]int)
+	for _, entry := range entries {
+		if entry.responseHeaders == nil {
+			skipped = append(skipped, skippedEntry{entry.id, "the response was not journaled; set JOURNAL_RESPONSES=true"})
+			continue
+		}
+		if entry.responseBody == nil {
+			skipped = append(skipped, skippedEntry{entry.id, "the response body was not text or larger than JOURNAL_RESPONSE_MAX_BODY"})
+			continue
+		}
+		if encoding := entry.responseHeaders.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+			skipped = append(skipped, skippedEntry{entry.id, "the response body is " + encoding + " encoded"})
+			continue
+		}
+
+		def := mockDefinition{
+			Path:         entry.path,
+			Method:       entry.method,
+			ResponseBody: *entry.responseBody,
+			StatusCode:   entry.status,
+			Headers:      mockHeaderString(entry.responseHeaders, strip),
+			Workspace:    rules.Workspace,
+			Labels:       map[string]string{"journal-entry": strconv.FormatInt(entry.id, 10)},
+		}
+		if def.Workspace == "" && entry.workspace != "default" {
+			def.Workspace = entry.workspace
+		}
+		for name, value := range rules.Labels {
+			def.Labels[name] = value
+		}
+
+		options := map[string]interface{}{}
+		if len(ignoreQuery) > 0 {
+			def.Path = withoutQueryParams(def.Path, ignoreQuery)
+			options["query"] = queryMatchOptions{Ignore: ignoreQuery}
+		}
+		key := def.Workspace + "\x00" + def.Method + "\x00" + def.Path + "\x00"
+		var body interface{}
+		if strings.TrimSpace(entry.body) != "" && json.Unmarshal([]byte(entry.body), &body) == nil {
+			if len(ignoreFields) == 0 {
+				def.RequestBody = json.RawMessage(entry.body)
+				key += entry.body
+			} else {
+				conditions := make(map[string]interface{})
+				bodyConditions(body, "", ignoreFields, conditions)
+				options["match"] = map[string]interface{}{"body": conditions}
+				match, _ := json.Marshal(conditions)
+				key += string(match)
+			}
+		}
+		if len(options) > 0 {
+			def.Options, _ = json.Marshal(options)
+		}
+
+		if i, ok := index[key]; ok {
+			defs[i] = def
+			continue
+		}
+		index[key] = len(defs)
+		defs = append(defs, def)
+	}
+	return defs, skipped
+}
+
+// mockHeaderString formats response headers for the headers column, which
+// separates headers with ";". Content-Type keeps its media type only, and
+// other values holding a ";" are left out.
+func mockHeaderString(header http.Header, strip map[string]bool) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if !strip[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if http.CanonicalHeaderKey(name) == "Content-Type" {
+			value, _, _ = strings.Cut(value, ";")
+		} else if strings.Contains(value, ";") {
+			continue
+		}
+		pairs = append(pairs, name+"="+strings.TrimSpace(value))
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func withoutQueryParams(path string, names []string) string {
+	base, rawQuery, hasQuery := strings.Cut(path, "?")
+	if !hasQuery {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return path
+	}
+	removed := false
+	for name := range query {
+		if matchesParamName(names, name) {
+			query.Del(name)
+			removed = true
+		}
+	}
+	if !removed {
+		return path
+	}
+	if len(query) == 0 {
+		return base
+	}
+	return base + "?" + query.Encode()
+}
+
+// bodyConditions lists the scalar fields of a JSON document by pointer,
+// leaving out the ignored fields and everything below them.
+func bodyConditions(doc interface{}, pointer string, ignored []string, conditions map[string]interface{}) {
+	for _, field := range ignored {
+		if pointer == field || strings.HasPrefix(pointer, field+"/") {
+			return
+		}
+	}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		escape := strings.NewReplacer("~", "~0", "/", "~1")
+		for name, child := range v {
+			bodyConditions(child, pointer+"/"+escape.Replace(name), ignored, conditions)
+		}
+	case []interface{}:
+		for i, child := range v {
+			bodyConditions(child, pointer+"/"+strconv.Itoa(i), ignored, conditions)
+		}
+	default:
+		conditions[pointer] = v
+	}
+}
+
+// journalMocksHandler creates mocks from the journal entries a filter
+// selects, or with dryRun=true only returns them.
+func journalMocksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !journalEnabled {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "the request journal is not enabled; set JOURNAL_ENABLED=true"})
+		return
+	}
+	var rules journalMockRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	entries, err := loadJournalResponses(r.Context(), rules.Filter)
+	if err != nil {
+		writeAdminError(w, err, "error loading journal entries")
+		return
+	}
+	defs, skipped := journalMocks(entries, rules)
+
+	query := r.URL.Query()
+	if dryRun, _ := strconv.ParseBool(query.Get("dryRun")); dryRun || len(defs) == 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"mocks": defs, "skipped": skipped})
+		return
+	}
+	force, _ := strconv.ParseBool(query.Get("force"))
+	conflicts, err := createMocks(r.Context(), defs, force)
+	if err != nil {
+		writeAdminError(w, err, "error saving mocks")
+		return
+	}
+	response := map[string]interface{}{"mocks": defs, "skipped": skipped}
+	if len(conflicts) > 0 {
+		response["conflicts"] = conflicts
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// journalRetention bounds the journal table. Rows beyond any of the limits
+// are deleted oldest first.
+type journalRetention struct {
+	MaxAge  jsonDuration `json:"maxAge,omitempty"`
+	MaxRows int64        `json:"maxRows,omitempty"`
+	// MaxBytes is compared with the size of the stored rows, estimated from
+	// the most recent ones.
+	MaxBytes byteSize     `json:"maxBytes,omitempty"`
+	Interval jsonDuration `json:"interval"`
+}
+
+const journalPruneBatch = 10000
+
+var (
+	journalLimits = journalRetention{
+		MaxAge:   jsonDuration(envDuration("JOURNAL_MAX_AGE", 0)),
+		MaxRows:  int64(envInt("JOURNAL_MAX_ROWS", 0)),
+		MaxBytes: byteSize(envSize("JOURNAL_MAX_SIZE", 0)),
+		Interval: jsonDuration(envDuration("JOURNAL_PRUNE_INTERVAL", 5*time.Minute)),
+	}
+
+	journalPrunedTotal = expvar.NewMap("journal_pruned_total")
+	journalRows        = expvar.NewInt("journal_rows")
+	journalBytes       = expvar.NewInt("journal_bytes")
+
+	// journalPruneMu keeps the worker and manual runs from pruning at once.
+	journalPruneMu sync.Mutex
+)
+
+func (l journalRetention) enabled() bool {
+	return l.MaxAge > 0 || l.MaxRows > 0 || l.MaxBytes > 0
+}
+
+// pruneJournal applies the limits and returns the rows deleted per limit.
+func pruneJournal(ctx context.Context, limits journalRetention) (map[string]int64, error) {
+	journalPruneMu.Lock()
+	defer journalPruneMu.Unlock()
+
+	deleted := map[string]int64{}
+	if limits.MaxAge > 0 {
+		n, err := deleteJournalWhere(ctx, "received_at < $1", time.Now().Add(-time.Duration(limits.MaxAge)))
+		if err != nil {
+			return deleted, err
+		}
+		deleted["age"] = n
+	}
+	if limits.MaxRows > 0 {
+		n, err := deleteJournalBeyond(ctx, limits.MaxRows)
+		if err != nil {
+			return deleted, err
+		}
+		deleted["rows"] = n
+	}
+	if limits.MaxBytes > 0 {
+		var rowSize float64
+		err := db.QueryRowContext(ctx, `
+			SELECT coalesce(avg(pg_column_size(recent.*)), 0)
+			FROM (SELECT * FROM return.request_journal ORDER BY id DESC LIMIT 1000) recent
+		`).Scan(&rowSize)
+		if err != nil {
+			return deleted, fmt.Errorf("error estimating journal row size: %v", err)
+		}
+		if rowSize > 0 {
+			n, err := deleteJournalBeyond(ctx, max(int64(float64(limits.MaxBytes)/rowSize), 1))
+			if err != nil {
+				return deleted, err
+			}
+			deleted["size"] = n
+		}
+	}
+	for limit, n := range deleted {
+		journalPrunedTotal.Add(limit, n)
+	}
+	updateJournalSize(ctx)
+	return deleted, nil
+}
+
+// deleteJournalBeyond deletes all but the newest keep rows.
+func deleteJournalBeyond(ctx context.Context, keep int64) (int64, error) {
+	var cutoff int64
+	err := db.QueryRowContext(ctx, `
+		SELECT coalesce((SELECT id FROM return.request_journal ORDER BY id DESC OFFSET $1 LIMIT 1), 0)
+	`, keep).Scan(&cutoff)
+	if err != nil || cutoff == 0 {
+		return 0, err
+	}
+	return deleteJournalWhere(ctx, "id <= $1", cutoff)
+}
+
+// deleteJournalWhere deletes in batches, so pruning a large backlog does not
+// hold locks against journal writes for long.
+func deleteJournalWhere(ctx context.Context, condition string, arg interface{}) (int64, error) {
+	var total int64
+	for {
+		result, err := db.ExecContext(ctx, `
+			DELETE FROM return.request_journal
+			WHERE id IN (SELECT id FROM return.request_journal WHERE `+condition+` LIMIT $2)
+		`, arg, journalPruneBatch)
+		if err != nil {
+			return total, fmt.Errorf("error pruning journal: %v", err)
+		}
+		n, _ := result.RowsAffected()
+		total += n
+		if n < journalPruneBatch {
+			return total, nil
+		}
+	}
+}
+
+// updateJournalSize refreshes the journal_rows and journal_bytes gauges from
+// the planner's statistics, which avoids counting a large table.
+func updateJournalSize(ctx context.Context) {
+	var rows, size int64
+	err := db.QueryRowContext(ctx, `
+		SELECT greatest(reltuples, 0)::bigint, pg_total_relation_size(oid)
+		FROM pg_class WHERE oid = 'return.request_journal'::regclass
+	`).Scan(&rows, &size)
+	if err != nil {
+		log.Printf("Error reading journal size: %v", err)
+		return
+	}
+	journalRows.Set(rows)
+	journalBytes.Set(size)
+}
+
+// startJournalPruner applies the journal limits at startup and then at
+// every interval.
+func startJournalPruner(limits journalRetention) {
+	if !journalEnabled || !limits.enabled() || limits.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(limits.Interval))
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			deleted, err := pruneJournal(ctx, limits)
+			cancel()
+			if err != nil {
+				log.Printf("Error applying journal retention: %v", err)
+			} else if n := deleted["age"] + deleted["rows"] + deleted["size"]; n > 0 {
+				log.Printf("Pruned %d journal entries", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// pruneJournalHandler applies the limits now, rather than at the next
+// interval, and reports what was deleted.
+func pruneJournalHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	deleted, err := pruneJournal(r.Context(), journalLimits)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error pruning journal"})
+		log.Printf("Error pruning journal: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"retention": journalLimits, "deleted": deleted})
+}
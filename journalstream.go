@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const journalStreamHeartbeat = 15 * time.Second
+
+// journalTailFilter selects the live journal entries a tailing client sees.
+type journalTailFilter struct {
+	Workspace  string
+	Method     string
+	PathPrefix string
+	MockID     int
+}
+
+func (f journalTailFilter) matches(entry *journalEntry) bool {
+	return (f.Workspace == "" || entry.Workspace == f.Workspace) &&
+		(f.Method == "" || strings.EqualFold(entry.Method, f.Method)) &&
+		(f.PathPrefix == "" || strings.HasPrefix(entry.Path, f.PathPrefix)) &&
+		(f.MockID == 0 || entry.MockID == f.MockID)
+}
+
+type journalStreamEvent struct {
+	RequestID  string            `json:"requestId,omitempty"`
+	ReceivedAt time.Time         `json:"receivedAt"`
+	Workspace  string            `json:"workspace,omitempty"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Headers    http.Header       `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	MockID     int               `json:"mockId,omitempty"`
+	StatusCode int               `json:"statusCode"`
+	DurationMs float64           `json:"durationMs"`
+	Violations []schemaViolation `json:"violations,omitempty"`
+}
+
+// journalStreamHandler pushes requests to the client as Server-Sent Events
+// while they are handled. The connection stays open until the client leaves.
+func journalStreamHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	filter := journalTailFilter{
+		Workspace:  query.Get("workspace"),
+		Method:     query.Get("method"),
+		PathPrefix: query.Get("pathPrefix"),
+	}
+	if raw := query.Get("mockId"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid mockId"})
+			return
+		}
+		filter.MockID = id
+	}
+
+	rc := http.NewResponseController(w)
+	// The server write timeout would otherwise cut the stream off.
+	rc.SetWriteDeadline(time.Time{})
+
+	entries, unsubscribe := journalTail.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(journalStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case entry := <-entries:
+			if !filter.matches(entry) {
+				continue
+			}
+			data, _ := json.Marshal(journalStreamEvent{
+				RequestID:  entry.RequestID,
+				ReceivedAt: entry.ReceivedAt,
+				Workspace:  entry.Workspace,
+				Method:     entry.Method,
+				Path:       entry.Path,
+				Headers:    entry.Headers,
+				Body:       entry.Body,
+				MockID:     entry.MockID,
+				StatusCode: entry.StatusCode,
+				DurationMs: float64(entry.Duration.Microseconds()) / 1000,
+				Violations: entry.Violations,
+			})
+			fmt.Fprintf(w, "event: request\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
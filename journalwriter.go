@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// journalWriter takes journal entries off the request path: entries wait in
+// a bounded queue and workers insert them in batches. When the queue is
+// full the oldest entry is dropped, so a slow database never slows requests.
+type journalWriter struct {
+	queue         chan *journalEntry
+	batchSize     int
+	flushInterval time.Duration
+	// pending counts the entries queued or being inserted.
+	pending atomic.Int64
+}
+
+var (
+	journalQueue *journalWriter
+
+	journalDroppedTotal     = expvar.NewInt("journal_dropped_total")
+	journalWriteErrorsTotal = expvar.NewInt("journal_write_errors_total")
+)
+
+func init() {
+	expvar.Publish("journal_queue_length", expvar.Func(func() interface{} {
+		if journalQueue == nil {
+			return 0
+		}
+		return len(journalQueue.queue)
+	}))
+}
+
+// startJournalWriter starts the workers unless the journal is off or
+// JOURNAL_ASYNC is false, in which case requests write their entry
+// themselves.
+func startJournalWriter() {
+	if !journalEnabled || !envBool("JOURNAL_ASYNC", true) {
+		return
+	}
+	w := &journalWriter{
+		queue:         make(chan *journalEntry, max(envInt("JOURNAL_QUEUE_SIZE", 10000), 1)),
+		batchSize:     max(envInt("JOURNAL_BATCH_SIZE", 100), 1),
+		flushInterval: envDuration("JOURNAL_FLUSH_INTERVAL", 200*time.Millisecond),
+	}
+	for i := 0; i < max(envInt("JOURNAL_WORKERS", 2), 1); i++ {
+		go w.run()
+	}
+	journalQueue = w
+}
+
+// enqueue never blocks: with the queue full, the oldest entry makes room.
+func (w *journalWriter) enqueue(entry *journalEntry) {
+	w.pending.Add(1)
+	for {
+		select {
+		case w.queue <- entry:
+			return
+		default:
+		}
+		select {
+		case <-w.queue:
+			w.pending.Add(-1)
+			journalDroppedTotal.Add(1)
+		default:
+		}
+	}
+}
+
+func (w *journalWriter) run() {
+	timer := time.NewTimer(w.flushInterval)
+	timer.Stop()
+	for entry := range w.queue {
+		batch := []*journalEntry{entry}
+		timer.Reset(w.flushInterval)
+	collect:
+		for len(batch) < w.batchSize {
+			select {
+			case entry := <-w.queue:
+				batch = append(batch, entry)
+			case <-timer.C:
+				break collect
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		if err := insertJournalEntries(batch); err != nil {
+			journalWriteErrorsTotal.Add(1)
+			log.Printf("Error writing %d journal entries: %v", len(batch), err)
+		}
+		w.pending.Add(-int64(len(batch)))
+	}
+}
+
+// flushJournal waits until the entries recorded so far are written, so
+// verifications and replays see the requests that preceded them.
+func flushJournal(ctx context.Context) error {
+	if journalQueue == nil {
+		return nil
+	}
+	for journalQueue.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	return nil
+}
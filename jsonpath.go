@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is one member (key), index or wildcard (all members or
+// items) of a JSONPath. A descendant step selects its member at any depth.
+type jsonPathStep struct {
+	key        string
+	index      int
+	isIndex    bool
+	wildcard   bool
+	descendant bool
+}
+
+// parseJSONPath reads the subset of JSONPath used to address fields:
+// $.a.b, $['a b'], $.items[0], wildcards as in $.items[*].price and
+// descendants as in $..password.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %q must start with $", path)
+	}
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			rest = rest[2:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("JSONPath %q has an empty member name", path)
+			}
+			steps = append(steps, jsonPathStep{key: name, wildcard: name == "*", descendant: true})
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("JSONPath %q has an empty member name", path)
+			}
+			if name == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{key: name})
+			}
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("JSONPath %q has an unclosed [", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if inner == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			} else if index, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			} else {
+				return nil, fmt.Errorf("JSONPath %q has an invalid selector [%s]", path, inner)
+			}
+		default:
+			return nil, fmt.Errorf("JSONPath %q is invalid at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+// jsonPathUpdate calls update with the parent and member of each value the
+// path selects. The last member of objects is selected even when missing,
+// so fields can be added. It returns the number of selected values.
+func jsonPathUpdate(doc interface{}, steps []jsonPathStep, update func(parent interface{}, step jsonPathStep)) int {
+	if len(steps) == 0 {
+		return 0
+	}
+	step, last := steps[0], len(steps) == 1
+	count := 0
+	if step.descendant {
+		return jsonPathDescend(doc, steps, update)
+	}
+	visit := func(child interface{}, childStep jsonPathStep) {
+		if last {
+			update(doc, childStep)
+			count++
+		} else {
+			count += jsonPathUpdate(child, steps[1:], update)
+		}
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if step.wildcard {
+			for key, child := range v {
+				visit(child, jsonPathStep{key: key})
+			}
+		} else if !step.isIndex {
+			if child, ok := v[step.key]; ok || last {
+				visit(child, step)
+			}
+		}
+	case []interface{}:
+		if step.wildcard {
+			for i, child := range v {
+				visit(child, jsonPathStep{index: i, isIndex: true})
+			}
+		} else if step.isIndex {
+			index := step.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index >= 0 && index < len(v) {
+				visit(v[index], jsonPathStep{index: index, isIndex: true})
+			}
+		}
+	}
+	return count
+}
+
+// jsonPathDescend applies a path starting with a descendant step to doc and
+// to every value nested in it. Only members that exist are selected.
+func jsonPathDescend(doc interface{}, steps []jsonPathStep, update func(parent interface{}, step jsonPathStep)) int {
+	plain := steps[0]
+	plain.descendant = false
+	count := 0
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if _, ok := v[plain.key]; ok || plain.wildcard {
+			count += jsonPathUpdate(doc, append([]jsonPathStep{plain}, steps[1:]...), update)
+		}
+		for _, child := range v {
+			count += jsonPathDescend(child, steps, update)
+		}
+	case []interface{}:
+		if plain.wildcard {
+			count += jsonPathUpdate(doc, append([]jsonPathStep{plain}, steps[1:]...), update)
+		}
+		for _, child := range v {
+			count += jsonPathDescend(child, steps, update)
+		}
+	}
+	return count
+}
+
+// jsonPathSet replaces or adds the values the path selects.
+func jsonPathSet(doc interface{}, steps []jsonPathStep, value interface{}) int {
+	return jsonPathUpdate(doc, steps, func(parent interface{}, step jsonPathStep) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[step.key] = cloneJSON(value)
+		case []interface{}:
+			p[step.index] = cloneJSON(value)
+		}
+	})
+}
+
+// jsonPathRemove deletes the object members the path selects; selected
+// array items are set to null.
+func jsonPathRemove(doc interface{}, steps []jsonPathStep) int {
+	return jsonPathUpdate(doc, steps, func(parent interface{}, step jsonPathStep) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			delete(p, step.key)
+		case []interface{}:
+			p[step.index] = nil
+		}
+	})
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+type jwtKey struct {
+	ID        string
+	Algorithm string
+	private   crypto.Signer
+	secret    []byte
+}
+
+func newJWTKey(id, alg, keyFile, secret string) (*jwtKey, error) {
+	hash, err := jwtHash(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &jwtKey{ID: id, Algorithm: alg}
+	switch alg[:2] {
+	case "HS":
+		if secret == "" {
+			return nil, fmt.Errorf("algorithm %s requires a secret", alg)
+		}
+		key.secret = []byte(secret)
+	case "RS", "ES":
+		if keyFile != "" {
+			key.private, err = loadPrivateKey(keyFile)
+		} else if secret != "" {
+			key.private, err = parsePrivateKey([]byte(secret), "the secret")
+		} else {
+			key.private, err = generatePrivateKey(alg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKeyType(alg, key.private); err != nil {
+			return nil, err
+		}
+	}
+
+	if key.ID == "" {
+		key.ID = defaultKeyID(key, hash)
+	}
+	return key, nil
+}
+
+func jwtHash(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 {
+		return 0, fmt.Errorf("unsupported JWT algorithm: %s", alg)
+	}
+	switch alg[:2] {
+	case "HS", "RS", "ES":
+	default:
+		return 0, fmt.Errorf("unsupported JWT algorithm: %s", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported JWT algorithm: %s", alg)
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+	return parsePrivateKey(data, path)
+}
+
+// parsePrivateKey parses a PEM private key; source names where it came from
+// in errors.
+func parsePrivateKey(data []byte, source string) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", source)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type in %s", source)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %q in %s", block.Type, source)
+}
+
+func generatePrivateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	}
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+func checkKeyType(alg string, signer crypto.Signer) error {
+	switch priv := signer.(type) {
+	case *rsa.PrivateKey:
+		if alg[:2] == "RS" {
+			return nil
+		}
+	case *ecdsa.PrivateKey:
+		if alg[:2] == "ES" && ecdsaCurveName(priv.Curve) == ecdsaCurveForAlg(alg) {
+			return nil
+		}
+	}
+	return fmt.Errorf("key type %T cannot be used with %s", signer, alg)
+}
+
+func ecdsaCurveForAlg(alg string) string {
+	switch alg {
+	case "ES256":
+		return "P-256"
+	case "ES384":
+		return "P-384"
+	}
+	return "P-521"
+}
+
+func ecdsaCurveName(curve elliptic.Curve) string {
+	return curve.Params().Name
+}
+
+func defaultKeyID(key *jwtKey, hash crypto.Hash) string {
+	if key.private == nil {
+		return strings.ToLower(key.Algorithm)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.private.Public())
+	if err != nil {
+		return strings.ToLower(key.Algorithm)
+	}
+	h := hash.New()
+	h.Write(der)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func (k *jwtKey) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]interface{}{"alg": k.Algorithm, "typ": "JWT", "kid": k.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("error encoding claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := k.signature([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (k *jwtKey) signature(input []byte) ([]byte, error) {
+	hash, err := jwtHash(k.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.secret != nil {
+		mac := hmac.New(hash.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	}
+
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch priv := k.private.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, priv, hash, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported signing key type %T", k.private)
+}
+
+func (k *jwtKey) verify(token string) (map[string]interface{}, error) {
+	header, claims, err := decodeJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if header["alg"] != k.Algorithm {
+		return nil, fmt.Errorf("unexpected token algorithm: %v", header["alg"])
+	}
+
+	idx := strings.LastIndex(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(token[idx+1:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	if err := k.verifySignature([]byte(token[:idx]), signature); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	if exp, ok := claims["exp"].(float64); ok && now >= int64(exp) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return nil, errors.New("token is not valid yet")
+	}
+	return claims, nil
+}
+
+func (k *jwtKey) verifySignature(input, signature []byte) error {
+	hash, err := jwtHash(k.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if k.secret != nil {
+		mac := hmac.New(hash.New, k.secret)
+		mac.Write(input)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch priv := k.private.(type) {
+	case *rsa.PrivateKey:
+		if err := rsa.VerifyPKCS1v15(&priv.PublicKey, hash, digest, signature); err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PrivateKey:
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(&priv.PublicKey, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signing key type %T", k.private)
+}
+
+func (k *jwtKey) jwk() map[string]interface{} {
+	switch priv := k.private.(type) {
+	case *rsa.PrivateKey:
+		return map[string]interface{}{
+			"kty": "RSA",
+			"kid": k.ID,
+			"use": "sig",
+			"alg": k.Algorithm,
+			"n":   base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()),
+		}
+	case *ecdsa.PrivateKey:
+		pub, err := priv.PublicKey.ECDH()
+		if err != nil {
+			return nil
+		}
+		point := pub.Bytes()[1:]
+		size := len(point) / 2
+		return map[string]interface{}{
+			"kty": "EC",
+			"kid": k.ID,
+			"use": "sig",
+			"alg": k.Algorithm,
+			"crv": ecdsaCurveName(priv.Curve),
+			"x":   base64.RawURLEncoding.EncodeToString(point[:size]),
+			"y":   base64.RawURLEncoding.EncodeToString(point[size:]),
+		}
+	}
+	return nil
+}
+
+func decodeJWT(token string) (map[string]interface{}, map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("malformed token")
+	}
+
+	var header, claims map[string]interface{}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	return header, claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+var templateJWTKeys = make(map[string]*jwtKey)
+
+func loadTemplateJWTKeys(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			return fmt.Errorf("invalid JWT key definition %q, expected name:alg[:keyfile|secret]", entry)
+		}
+		name, alg := parts[0], parts[1]
+		var source string
+		if len(parts) == 3 {
+			source = parts[2]
+		}
+
+		var key *jwtKey
+		var err error
+		if secretName, ok := strings.CutPrefix(source, "secret:"); ok {
+			// The key, a PEM one for RS* and ES*, is a named secret.
+			var value string
+			if value, err = lookupSecret(secretName); err != nil {
+				return fmt.Errorf("error loading JWT key %q: %v", name, err)
+			}
+			key, err = newJWTKey(name, alg, "", value)
+		} else if strings.HasPrefix(alg, "HS") {
+			key, err = newJWTKey(name, alg, "", source)
+		} else {
+			key, err = newJWTKey(name, alg, source, "")
+		}
+		if err != nil {
+			return fmt.Errorf("error loading JWT key %q: %v", name, err)
+		}
+		templateJWTKeys[name] = key
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaEventOptions struct {
+	Topic   string            `json:"topic"`
+	Key     string            `json:"key,omitempty"`
+	Value   string            `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+var kafkaWriter *kafka.Writer
+
+func initKafka(brokers string) {
+	if brokers == "" {
+		return
+	}
+
+	var addrs []string
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			addrs = append(addrs, broker)
+		}
+	}
+
+	kafkaWriter = &kafka.Writer{
+		Addr:                   kafka.TCP(addrs...),
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireOne,
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: envBool("KAFKA_AUTO_CREATE_TOPICS", false),
+	}
+	fmt.Println("Kafka event publishing enabled for", strings.Join(addrs, ","))
+}
+
+func publishKafkaEvents(mockResp *MockResponse, data templateData) {
+	events := mockResp.Options.Kafka
+	if len(events) == 0 {
+		return
+	}
+	if kafkaWriter == nil {
+		log.Printf("Mock %d defines Kafka events but KAFKA_BROKERS is not configured", mockResp.ID)
+		return
+	}
+
+	messages := make([]kafka.Message, 0, len(events))
+	for i, event := range events {
+		msg, err := renderKafkaMessage(event, data)
+		if err != nil {
+			log.Printf("Kafka event %d of mock %d not published: %v", i, mockResp.ID, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), envDuration("KAFKA_TIMEOUT", 10*time.Second))
+		defer cancel()
+
+		if err := kafkaWriter.WriteMessages(ctx, messages...); err != nil {
+			log.Printf("Error publishing Kafka events for mock %d: %v", mockResp.ID, err)
+		}
+	}()
+}
+
+func renderKafkaMessage(event kafkaEventOptions, data templateData) (kafka.Message, error) {
+	if event.Topic == "" {
+		return kafka.Message{}, fmt.Errorf("topic is required")
+	}
+
+	key, err := renderString("kafka-key", event.Key, data)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("error rendering key: %v", err)
+	}
+	value, err := renderString("kafka-value", event.Value, data)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("error rendering value: %v", err)
+	}
+
+	msg := kafka.Message{Topic: event.Topic, Value: []byte(value)}
+	if key != "" {
+		msg.Key = []byte(key)
+	}
+	data.Payload = value
+	for name, tmpl := range event.Headers {
+		rendered, err := renderString("kafka-header", tmpl, data)
+		if err != nil {
+			return kafka.Message{}, fmt.Errorf("error rendering header %s: %v", name, err)
+		}
+		msg.Headers = append(msg.Headers, kafka.Header{Key: name, Value: []byte(rendered)})
+	}
+	if _, ok := event.Headers[requestIDHeader]; !ok && data.RequestID != "" {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: requestIDHeader, Value: []byte(data.RequestID)})
+	}
+	return msg, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	kubernetesSource         = "kubernetes"
+	serviceAccountDir        = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultKubernetesLabel   = "mock-db-router/mocks=true"
+	kubernetesRelistInterval = 5 * time.Second
+)
+
+type configMap struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+type configMapList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []configMap `json:"items"`
+}
+
+type configMapEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type kubernetesStatus struct {
+	Namespace     string     `json:"namespace"`
+	LabelSelector string     `json:"labelSelector"`
+	ConfigMaps    []string   `json:"configMaps"`
+	Mocks         int        `json:"mocks"`
+	LastSync      *time.Time `json:"lastSync,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	Result        syncResult `json:"result"`
+}
+
+// kubernetesWatcher loads mock definitions from the ConfigMaps matching a
+// label selector and keeps them reconciled as the ConfigMaps change. It talks
+// to the API server directly with the pod's service account.
+type kubernetesWatcher struct {
+	server    string
+	namespace string
+	selector  string
+	client    *http.Client
+
+	configMaps map[string]configMap
+
+	mu     sync.RWMutex
+	status kubernetesStatus
+}
+
+var kubeWatcher *kubernetesWatcher
+
+func startKubernetesWatcher() error {
+	if !envBool("K8S_WATCH_CONFIGMAPS", false) {
+		return nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("K8S_WATCH_CONFIGMAPS requires running inside a Kubernetes cluster")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return fmt.Errorf("error reading service account CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("invalid service account CA")
+	}
+	namespace := envString("K8S_NAMESPACE", "")
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return fmt.Errorf("error reading service account namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	kubeWatcher = &kubernetesWatcher{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		selector:  envString("K8S_LABEL_SELECTOR", defaultKubernetesLabel),
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+		configMaps: make(map[string]configMap),
+	}
+	kubeWatcher.status = kubernetesStatus{Namespace: namespace, LabelSelector: kubeWatcher.selector, ConfigMaps: []string{}}
+	go kubeWatcher.run()
+	log.Printf("Watching ConfigMaps labeled %s in namespace %s for mocks", kubeWatcher.selector, namespace)
+	return nil
+}
+
+// request calls the API server, reading the token on every call because
+// projected service account tokens are rotated.
+func (k *kubernetesWatcher) request(ctx context.Context, query url.Values) (*http.Response, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	query.Set("labelSelector", k.selector)
+	endpoint := k.server + "/api/v1/namespaces/" + url.PathEscape(k.namespace) + "/configmaps?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("listing ConfigMaps: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func (k *kubernetesWatcher) run() {
+	for {
+		if err := k.listAndWatch(context.Background()); err != nil {
+			log.Printf("Error watching ConfigMaps: %v", err)
+			k.setError(err)
+		}
+		time.Sleep(kubernetesRelistInterval)
+	}
+}
+
+// listAndWatch lists the ConfigMaps, reconciles them and then follows the
+// watch stream until it ends, reconciling after every change.
+func (k *kubernetesWatcher) listAndWatch(ctx context.Context) error {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	resp, err := k.request(listCtx, url.Values{})
+	if err != nil {
+		cancel()
+		return err
+	}
+	var list configMapList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	k.configMaps = make(map[string]configMap, len(list.Items))
+	for _, item := range list.Items {
+		k.configMaps[item.Metadata.Name] = item
+	}
+	if err := k.reconcile(ctx); err != nil {
+		return err
+	}
+
+	resp, err = k.request(ctx, url.Values{
+		"watch":               {"true"},
+		"resourceVersion":     {list.Metadata.ResourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {"600"},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event configMapEvent
+		if err := decoder.Decode(&event); err != nil {
+			// The API server closes watches after a while; list again.
+			return nil
+		}
+		var item configMap
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			if err := json.Unmarshal(event.Object, &item); err != nil {
+				return err
+			}
+		case "ERROR":
+			// Usually 410 Gone: the resource version is too old to resume from.
+			return nil
+		default:
+			continue
+		}
+		if event.Type == "DELETED" {
+			delete(k.configMaps, item.Metadata.Name)
+		} else {
+			k.configMaps[item.Metadata.Name] = item
+		}
+		if err := k.reconcile(ctx); err != nil {
+			log.Printf("Error loading mocks from ConfigMaps: %v", err)
+			k.setError(err)
+		}
+	}
+}
+
+// reconcile loads every data key ending in .json of every ConfigMap, keying
+// each mock by ConfigMap, data key and position.
+func (k *kubernetesWatcher) reconcile(ctx context.Context) error {
+	desired := make(map[string]mockDefinition)
+	names := make([]string, 0, len(k.configMaps))
+	for name, item := range k.configMaps {
+		names = append(names, name)
+		for key, value := range item.Data {
+			if !strings.HasSuffix(strings.ToLower(key), ".json") {
+				continue
+			}
+			defs, _, err := parseMockDefinitions([]byte(value))
+			if err != nil {
+				return fmt.Errorf("ConfigMap %s, key %s: %v", name, key, err)
+			}
+			for i, def := range defs {
+				desired[fmt.Sprintf("%s/%s#%d", name, key, i)] = def
+			}
+		}
+	}
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	result, err := reconcileMocks(ctx, kubernetesSource, desired)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	k.mu.Lock()
+	k.status.ConfigMaps = names
+	k.status.Mocks = len(desired)
+	k.status.LastSync = &now
+	k.status.Error = ""
+	k.status.Result = result
+	k.mu.Unlock()
+	if result.Created+result.Updated+result.Deleted > 0 {
+		log.Printf("Synced mocks from ConfigMaps: %d created, %d updated, %d deleted", result.Created, result.Updated, result.Deleted)
+	}
+	return nil
+}
+
+func (k *kubernetesWatcher) setError(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.status.Error = err.Error()
+}
+
+func kubernetesStatusHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if kubeWatcher == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Kubernetes watcher is not enabled; set K8S_WATCH_CONFIGMAPS=true"})
+		return
+	}
+	kubeWatcher.mu.RLock()
+	defer kubeWatcher.mu.RUnlock()
+	writeJSON(w, http.StatusOK, kubeWatcher.status)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/julienschmidt/httprouter"
+
+	"mock-db-router/adminapi"
+)
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+// lintIssue is a problem found in a mock definition that would otherwise
+// only show when a request reaches the mock.
+type lintIssue struct {
+	ID       int    `json:"id,omitempty"`
+	Path     string `json:"path"`
+	Method   string `json:"method"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// index is the position of the definition among those linted.
+	index int
+}
+
+type lintResult struct {
+	Issues   []lintIssue `json:"issues"`
+	Errors   int         `json:"errors"`
+	Warnings int         `json:"warnings"`
+}
+
+// lintMocks checks the definitions one by one and then against each other.
+// The definitions are normalized in place. Issues name the other mocks
+// involved by names, or by ID without them.
+func lintMocks(defs []mockDefinition, names []string) lintResult {
+	result := lintResult{Issues: []lintIssue{}}
+	report := func(i int, severity, format string, args ...interface{}) {
+		result.Issues = append(result.Issues, lintIssue{
+			ID: defs[i].ID, Path: defs[i].Path, Method: defs[i].Method,
+			Severity: severity, Message: fmt.Sprintf(format, args...), index: i,
+		})
+		if severity == lintError {
+			result.Errors++
+		} else {
+			result.Warnings++
+		}
+	}
+
+	valid := make([]bool, len(defs))
+	for i := range defs {
+		def := &defs[i]
+		raw := def.raw
+		if raw == nil {
+			raw, _ = json.Marshal(def)
+		}
+		violations, err := validateBody(string(adminapi.MockSchema), string(raw))
+		if err != nil {
+			report(i, lintError, "%v", err)
+			continue
+		}
+		for _, v := range violations {
+			if field := strings.ReplaceAll(strings.TrimPrefix(v.Path, "/"), "/", "."); field != "" {
+				report(i, lintError, "%s: %s", field, v.Message)
+			} else {
+				report(i, lintError, "%s", v.Message)
+			}
+		}
+		if len(violations) > 0 {
+			continue
+		}
+		if err := def.normalize(); err != nil {
+			report(i, lintError, "%v", err)
+			continue
+		}
+		valid[i] = true
+		for _, problem := range lintMock(def) {
+			report(i, lintError, "%s", problem)
+		}
+	}
+
+	// Mocks answering exactly the same requests are picked by weight, so one
+	// with weight 0 is never served next to one with a positive weight. When
+	// all weigh 0, the oldest one always wins.
+	type group struct{ members []int }
+	groups := make(map[string]*group)
+	var order []string
+	for i := range defs {
+		if !valid[i] || !*defs[i].Enabled {
+			continue
+		}
+		key, err := defs[i].conditionKey()
+		if err != nil {
+			continue
+		}
+		key = defs[i].Method + " " + defs[i].Path + " " + key
+		if groups[key] == nil {
+			groups[key] = &group{}
+			order = append(order, key)
+		}
+		groups[key].members = append(groups[key].members, i)
+	}
+	for _, key := range order {
+		members := groups[key].members
+		if len(members) < 2 {
+			continue
+		}
+		winner := -1
+		for _, i := range members {
+			if *defs[i].Weight > 0 {
+				winner = i
+				break
+			}
+		}
+		if winner < 0 {
+			winner = members[0]
+		}
+		for _, i := range members {
+			if i != winner && *defs[i].Weight <= 0 {
+				report(i, lintWarning, "unreachable: %s answers the same requests and wins, as this mock has weight 0; give it a positive weight or delete it",
+					lintMockName(defs, names, winner))
+			}
+		}
+	}
+	return result
+}
+
+func lintMockName(defs []mockDefinition, names []string, i int) string {
+	if names != nil {
+		return names[i]
+	}
+	return fmt.Sprintf("mock %d", defs[i].ID)
+}
+
+// lintMock returns the problems of a normalized definition.
+func lintMock(def *mockDefinition) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, problem := range lintPathPattern(def.Path) {
+		add("path: %s", problem)
+	}
+	templatedHeaders := def.IsTemplate && strings.Contains(def.Headers, "{{")
+	if !templatedHeaders {
+		for _, problem := range lintHeaders(def.Headers) {
+			add("headers: %s", problem)
+		}
+	}
+	if def.IsTemplate {
+		if err := lintTemplate(def.ResponseBody); err != nil {
+			add("responseBody: invalid template: %v", err)
+		}
+		if err := lintTemplate(def.Headers); err != nil {
+			add("headers: invalid template: %v", err)
+		}
+	}
+
+	opts, err := parseMockOptions(nullIfEmpty(string(def.Options)))
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	templates := map[string]string{}
+	for i, hook := range opts.Webhooks {
+		templates[fmt.Sprintf("webhooks[%d].url", i)] = hook.URL
+		templates[fmt.Sprintf("webhooks[%d].body", i)] = hook.Body
+	}
+	for i, event := range opts.Kafka {
+		templates[fmt.Sprintf("kafka[%d].key", i)] = event.Key
+		templates[fmt.Sprintf("kafka[%d].value", i)] = event.Value
+		for name, value := range event.Headers {
+			templates[fmt.Sprintf("kafka[%d].headers.%s", i, name)] = value
+		}
+	}
+	for i, message := range opts.AMQP {
+		templates[fmt.Sprintf("amqp[%d].body", i)] = message.Body
+		for name, value := range message.Headers {
+			templates[fmt.Sprintf("amqp[%d].headers.%s", i, name)] = value
+		}
+	}
+	if opts.Upstream != nil {
+		templates["upstream.url"] = opts.Upstream.URL
+		templates["upstream.body"] = opts.Upstream.Body
+		for name, value := range opts.Upstream.Headers {
+			templates["upstream.headers."+name] = value
+		}
+	}
+	fields := make([]string, 0, len(templates))
+	for field := range templates {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	for _, field := range fields {
+		if err := lintTemplate(templates[field]); err != nil {
+			add("options.%s: invalid template: %v", field, err)
+		}
+	}
+
+	if opts.Match != nil {
+		for _, problem := range lintMatchOptions(opts.Match, "options.match") {
+			add("%s", problem)
+		}
+	}
+	if opts.Experiment != nil {
+		if err := opts.Experiment.validate(); err != nil {
+			add("options.experiment: %v", err)
+		}
+	}
+	if opts.Log != nil {
+		for _, pattern := range opts.Log.Redact {
+			if _, err := regexp.Compile(pattern); err != nil {
+				add("options.log.redact: invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	if opts.Script != "" {
+		if _, err := compileScript(opts.Script); err != nil {
+			add("options.script: %v", err)
+		}
+	}
+	if len(opts.RequestSchema) > 0 {
+		if _, err := compileSchema(string(opts.RequestSchema)); err != nil {
+			add("options.requestSchema: %v", err)
+		}
+	}
+	if tableFormat(&MockResponse{Headers: nullIfEmpty(def.Headers), Options: opts}) != "" && !def.IsTemplate {
+		if _, err := tableRows(def.ResponseBody, &tableOptions{}); err != nil {
+			add("responseBody: %v", err)
+		}
+	}
+	if _, _, err := responseCharset(opts.Charset); opts.Charset != "" && err != nil {
+		add("options.charset: %v", err)
+	} else if !def.IsTemplate {
+		// A body the charset cannot encode, or invalid base64, fails every
+		// request.
+		if err := encodeResponseBody(&MockResponse{ResponseBody: def.ResponseBody, Options: opts}); err != nil {
+			add("responseBody: %v", err)
+		}
+	}
+	if opts.RenderCache != nil {
+		if !def.IsTemplate {
+			add("options.renderCache: the mock is not a template")
+		}
+		if opts.RenderCache.TTL < 0 {
+			add("options.renderCache.ttl: must not be negative")
+		}
+	}
+	if p := opts.Pagination; p != nil {
+		if p.Total < 0 {
+			add("options.pagination.total: must not be negative")
+		}
+		if p.Style != "" && !slices.Contains(paginationStyles, p.Style) {
+			add("options.pagination.style: unknown style %q; use %s", p.Style, strings.Join(paginationStyles, ", "))
+		}
+		if p.Order != "" && !slices.Contains(paginationOrders, p.Order) {
+			add("options.pagination.order: unknown order %q; use asc or desc", p.Order)
+		}
+		if p.Dataset && !def.IsTemplate && json.Unmarshal([]byte(def.ResponseBody), new([]json.RawMessage)) != nil {
+			add("options.pagination.dataset: responseBody is not a JSON array")
+		}
+		if !p.Dataset && (p.SortBy != "" || p.ItemsField != "" || len(p.Filters) > 0 || len(p.Search) > 0 || len(p.Sortable) > 0 || p.Fuzzy) {
+			add("options.pagination: sortBy, sortable, filters, search, fuzzy and itemsField only apply to a dataset")
+		}
+		if p.MaxPageSize > 0 && p.PageSize > p.MaxPageSize {
+			add("options.pagination.pageSize: %d exceeds maxPageSize %d", p.PageSize, p.MaxPageSize)
+		}
+	}
+	if rl := opts.RateLimit; rl != nil && rl.Headers != "" && !slices.Contains(rateLimitHeaderStyles, rl.Headers) {
+		add("options.rateLimit.headers: unknown style %q; use %s", rl.Headers, strings.Join(rateLimitHeaderStyles, ", "))
+	}
+	return problems
+}
+
+// lintHeaders checks the Name=value;Name=value format of a headers column.
+func lintHeaders(headers string) []string {
+	var problems []string
+	for _, pair := range strings.Split(headers, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, _, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		switch {
+		case !found:
+			problems = append(problems, fmt.Sprintf("%q is not a Name=value pair; separate headers with \";\", as in \"Content-Type=application/json;X-Trace=1\"", pair))
+		case !validHeaderName(name):
+			problems = append(problems, fmt.Sprintf("%q is not a valid header name", name))
+		}
+	}
+	return problems
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// lintTemplate parses text with the functions available when rendering it.
+func lintTemplate(text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+	_, err := template.New("lint").
+		Funcs(templateFuncs).
+		Funcs(requestTemplateFuncs(templateData{})).
+		Parse(text)
+	return err
+}
+
+func lintMatchOptions(m *requestMatchOptions, field string) []string {
+	var problems []string
+	checkPattern := func(name, pattern string) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.%s: invalid pattern %q: %v", field, name, pattern, err))
+		}
+	}
+	if m.UserAgent != "" {
+		checkPattern("userAgent", m.UserAgent)
+	}
+	if m.Path != "" {
+		checkPattern("path", m.Path)
+	}
+	for name, pattern := range m.Headers {
+		checkPattern("headers."+name, pattern)
+	}
+	for _, value := range m.ClientIP {
+		var err error
+		if strings.Contains(value, "/") {
+			_, err = netip.ParsePrefix(value)
+		} else {
+			_, err = netip.ParseAddr(value)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.clientIp: %q is not an IP or CIDR range", field, value))
+		}
+	}
+	for _, pattern := range m.ContentType {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.contentType: invalid pattern %q", field, pattern))
+		}
+	}
+	for i := range m.Time {
+		if err := m.Time[i].validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.time[%d]: %v", field, i, err))
+		}
+	}
+	for i := range m.Not {
+		problems = append(problems, lintMatchOptions(&m.Not[i], fmt.Sprintf("%s.not[%d]", field, i))...)
+	}
+	return problems
+}
+
+// lintStoredMocks lints the mocks in the database, trashed ones aside.
+func lintStoredMocks(ctx context.Context) (lintResult, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+mockDefinitionColumns+`
+		FROM return.mock_responses
+		WHERE deleted_at IS NULL
+		ORDER BY id
+	`)
+	if err != nil {
+		return lintResult{}, err
+	}
+	defer rows.Close()
+	var defs []mockDefinition
+	for rows.Next() {
+		def, err := scanMockDefinition(rows)
+		if err != nil {
+			return lintResult{}, err
+		}
+		defs = append(defs, def)
+	}
+	if err := rows.Err(); err != nil {
+		return lintResult{}, err
+	}
+	return lintMocks(defs, nil), nil
+}
+
+// lintOnStartup lints the stored mocks as MOCK_LINT says: fail stops the
+// router when any mock has errors, warn only logs them, off skips linting.
+func lintOnStartup() error {
+	mode := strings.ToLower(envString("MOCK_LINT", "fail"))
+	switch mode {
+	case "off":
+		return nil
+	case "fail", "warn":
+	default:
+		return fmt.Errorf("invalid MOCK_LINT %q; use fail, warn or off", mode)
+	}
+	result, err := lintStoredMocks(context.Background())
+	if err != nil {
+		return fmt.Errorf("error reading mocks: %v", err)
+	}
+	for _, issue := range result.Issues {
+		log.Printf("Lint %s: mock %d %s %s: %s", issue.Severity, issue.ID, issue.Method, issue.Path, issue.Message)
+	}
+	if result.Errors > 0 && mode == "fail" {
+		return fmt.Errorf("%d errors in mock definitions; fix the mocks, or set MOCK_LINT=warn to start anyway", result.Errors)
+	}
+	if len(result.Issues) > 0 {
+		fmt.Printf("Mock lint: %d errors, %d warnings\n", result.Errors, result.Warnings)
+	}
+	return nil
+}
+
+func lintHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	result, err := lintStoredMocks(r.Context())
+	if err != nil {
+		log.Printf("Error linting mocks: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error linting mocks"})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// lintFiles lints the definitions of mock files, as imported by the import
+// command, together. The definitions are named file#index.
+func lintFiles(paths []string) (lintResult, []string, error) {
+	var defs []mockDefinition
+	var names []string
+	for _, file := range paths {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return lintResult{}, nil, err
+		}
+		// Decode each definition on its own, so lintMocks can check it
+		// against the schema as written and go on with the others.
+		items := []json.RawMessage{data}
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+			if err := json.Unmarshal(data, &items); err != nil {
+				return lintResult{}, nil, fmt.Errorf("%s: %v", file, err)
+			}
+		}
+		for i, item := range items {
+			def := mockDefinition{raw: item}
+			if err := json.Unmarshal(item, &def); err != nil && !json.Valid(item) {
+				return lintResult{}, nil, fmt.Errorf("%s: %v", file, err)
+			}
+			names = append(names, fmt.Sprintf("%s#%d", file, i))
+			defs = append(defs, def)
+		}
+	}
+	return lintMocks(defs, names), names, nil
+}
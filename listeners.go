@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type workspaceKey struct{}
+
+type listener struct {
+	addr      string
+	workspace string
+}
+
+func parseListeners(value string) ([]listener, error) {
+	var listeners []listener
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addr, workspace, ok := strings.Cut(entry, "=")
+		addr, workspace = strings.TrimSpace(addr), strings.TrimSpace(workspace)
+		if !ok || addr == "" || workspace == "" {
+			return nil, fmt.Errorf("invalid listener %q, expected addr=workspace", entry)
+		}
+		if !strings.Contains(addr, ":") {
+			addr = ":" + addr
+		}
+		listeners = append(listeners, listener{addr: addr, workspace: workspace})
+	}
+	return listeners, nil
+}
+
+func withWorkspace(workspace string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), workspaceKey{}, workspace)))
+	})
+}
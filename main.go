@@ -1,44 +1,74 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/julienschmidt/httprouter"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const connStr = "host=host port=5432 user=pg_user password=pg_password dbname=db_name sslmode=disable"
 
 var (
-	db   *sql.DB
-	once sync.Once
+	db        *sql.DB
+	dbConnStr = connStr
+	once      sync.Once
+
+	maxRequestBodySize = envSize("MAX_REQUEST_BODY_SIZE", 10<<20)
 )
 
+const anyMethod = "ANY"
+
 type MockResponse struct {
+	ID                 int
+	Path               string
+	Method             string
 	ResponseBody       string
 	ResponseStatusCode int
 	Headers            sql.NullString
+	IsTemplate         bool
+	Weight             int
+	Host               sql.NullString
+	Workspace          sql.NullString
+	Profile            sql.NullString
+	MatchExpression    sql.NullString
+	Options            mockOptions
+	rawOptions         sql.NullString
+	exactPath          bool
+	// requestJSON is the request body decoded for this mock, e.g. from
+	// protobuf, when the request itself carried no JSON.
+	requestJSON interface{}
+	// contentType is sent when the mock's headers set none.
+	contentType string
+	// charset and contentEncoding label a body encodeResponseBody encoded.
+	charset         string
+	contentEncoding string
+	// filename offers the response as a download.
+	filename string
 }
 
 func readRequestBody(r *http.Request) (string, error) {
 	var requestBody string
-	if r.Body != nil {
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			return "", fmt.Errorf("error reading request body: %v", err)
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		if requestBody, err = readBody(r.Body, r.ContentLength); err != nil {
+			return "", fmt.Errorf("error reading request body: %w", err)
 		}
-		requestBody = string(bodyBytes)
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		r.Body = newBodyReader(requestBody)
 	}
 	return requestBody, nil
 }
@@ -48,8 +78,7 @@ func validateAndReturnJSON(requestBody string) (string, error) {
 		return "", nil
 	}
 
-	var temp interface{}
-	if err := json.Unmarshal([]byte(requestBody), &temp); err != nil {
+	if !json.Valid(bytesOf(requestBody)) {
 		return "", nil
 	}
 
@@ -57,27 +86,39 @@ func validateAndReturnJSON(requestBody string) (string, error) {
 }
 
 func writeResponse(w http.ResponseWriter, mockResp *MockResponse) {
+	writeResponseHeaders(w, mockResp)
+	statusCode := mockResp.ResponseStatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	setContentLength(w.Header(), statusCode, mockResp.ResponseBody)
+	w.WriteHeader(statusCode)
+	io.WriteString(w, mockResp.ResponseBody)
+}
+
+// writeResponseHeaders sets the mock's headers, before the status is
+// written.
+func writeResponseHeaders(w http.ResponseWriter, mockResp *MockResponse) {
 	headers := parseHeaders(mockResp.Headers)
 	for key, value := range headers {
 		w.Header().Set(key, value)
 	}
 
 	if w.Header().Get("Content-Type") == "" {
-		w.Header().Set("Content-Type", "application/json")
-	}
-
-	statusCode := mockResp.ResponseStatusCode
-	if statusCode == 0 {
-		statusCode = http.StatusOK
+		contentType := mockResp.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		w.Header().Set("Content-Type", contentType)
 	}
-	w.WriteHeader(statusCode)
-	w.Write([]byte(mockResp.ResponseBody))
+	setEncodingHeaders(w.Header(), mockResp)
+	setDownloadHeader(w.Header(), mockResp)
 }
 
 func initDB() error {
 	var err error
 	once.Do(func() {
-		db, err = sql.Open("postgres", connStr)
+		db, err = sql.Open("postgres", dbConnStr)
 		if err != nil {
 			return
 		}
@@ -87,53 +128,217 @@ func initDB() error {
 			return
 		}
 
-		db.SetMaxOpenConns(10)
-		db.SetMaxIdleConns(5)
-		db.SetConnMaxLifetime(15 * time.Minute)
-		db.SetConnMaxIdleTime(3 * time.Minute)
+		var mode string
+		if mode, err = resolvePoolMode(context.Background(), db); err != nil {
+			db.Close()
+			return
+		}
+		dbPoolMode = mode
+		if mode == poolModeTransaction {
+			db.Close()
+			if db, err = sql.Open("postgres", poolerConnStr(dbConnStr)); err != nil {
+				return
+			}
+			if err = db.Ping(); err != nil {
+				db.Close()
+				return
+			}
+			fmt.Println("Database is behind a transaction pooler such as PgBouncer; queries are sent in a single round trip")
+			if envString("REDIS_URL", "") == "" {
+				fmt.Println("Set REDIS_URL to share resets and mock changes between instances")
+			}
+		}
+
+		configurePool(db)
 
 		fmt.Println("Database connection pool initialized")
 	})
 	return err
 }
 
-func getMockResponse(path string, method string, requestBodyJSON string) (*MockResponse, error) {
-	var mockResp MockResponse
-
+func getMockResponse(r *http.Request, path string, requestBodyJSON string, data templateData) (*MockResponse, error) {
+	method := r.Method
+	paths := lookupPaths(path)
+	basePath, requestQuery, hasQuery := strings.Cut(paths[0], "?")
+	basePaths := lookupPaths(basePath)
+	// Patterns match the path alone, like literal paths of mocks whose
+	// options.query match query strings.
+	if patterns := pathIndex.match(basePaths, method); len(patterns) > 0 {
+		if hasQuery {
+			basePaths = append(basePaths, patterns...)
+		} else {
+			paths = append(paths, patterns...)
+		}
+	}
 	var query string
 	var args []interface{}
+	var requestHash string
 
 	if requestBodyJSON == "" {
-		query = `
-			SELECT response_body, headers, response_status_code 
-			FROM return.mock_responses 
-			WHERE path = $1 
-			  AND method = $2 
-			  AND request_body IS NULL
-		`
-		args = []interface{}{path, method}
+		query = mockLookupQuery("NULL::text", "request_body IS NULL", "$3")
+		args = []interface{}{pq.Array(paths), method, pq.Array(basePaths)}
 	} else {
-		query = `
-			SELECT response_body, headers, response_status_code 
-			FROM return.mock_responses 
-			WHERE path = $1 
-			  AND method = $2 
-			  AND md5(request_body::jsonb::text) = md5($3::jsonb::text)
-		`
-		args = []interface{}{path, method, requestBodyJSON}
+		var err error
+		if requestHash, err = bodyHash(requestBodyJSON); err != nil {
+			return nil, err
+		}
+		query = mockLookupQuery(
+			"CASE WHEN request_body_hash IS NULL OR NOT starts_with(request_body_hash, $5) THEN request_body::text END",
+			`(request_body_hash = $3
+			       OR (request_body IS NOT NULL AND (request_body_hash IS NULL OR NOT starts_with(request_body_hash, $5)))
+			       OR (request_body IS NULL AND (match_expression IS NOT NULL OR options ?| array['match', 'graphql'])))`,
+			"$4")
+		args = []interface{}{pq.Array(paths), method, requestHash, pq.Array(basePaths), hasher.prefix}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := requestQueryContext(r)
 	defer cancel()
 
-	row := db.QueryRowContext(ctx, query, args...)
-	err := row.Scan(&mockResp.ResponseBody, &mockResp.Headers, &mockResp.ResponseStatusCode)
-
+	cacheKey := strings.Join(paths, "\x00") + "\x01" + method + "\x01" + requestHash
+	rows, err := lookupMockRows(ctx, cacheKey, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, queryError(ctx, "lookup", err)
 	}
 
-	return &mockResp, nil
+	var candidates []*MockResponse
+	var activation map[string]interface{}
+	for _, row := range rows {
+		mockResp := row.mock
+		var err error
+		if row.unhashedBody.Valid {
+			hash, err := bodyHash(row.unhashedBody.String)
+			if err != nil {
+				requestLogf(r, "Mock %d has an unparseable request body: %v", mockResp.ID, err)
+				continue
+			}
+			go storeBodyHash(mockResp.ID, hash)
+			if hash != requestHash {
+				continue
+			}
+		}
+		if mockResp.Host.Valid && mockResp.Host.String != "" && !matchesHost(mockResp.Host.String, r.Host) {
+			continue
+		}
+		if mockResp.Workspace.Valid && mockResp.Workspace.String != "" && mockResp.Workspace.String != data.Workspace {
+			continue
+		}
+		if mockResp.Profile.Valid && mockResp.Profile.String != "" && mockResp.Profile.String != data.Profile {
+			continue
+		}
+		if mockResp.Options, err = parseMockOptions(mockResp.rawOptions); err != nil {
+			return nil, fmt.Errorf("mock %d: %v", mockResp.ID, err)
+		}
+		mockData, decoded := decodeMockProtobuf(&mockResp, data)
+		if !decoded {
+			continue
+		}
+		if mockResp.MatchExpression.Valid && mockResp.MatchExpression.String != "" {
+			mockActivation := activation
+			if mockResp.requestJSON != nil {
+				mockActivation = celActivation(mockData)
+			} else if activation == nil {
+				activation = celActivation(data)
+				mockActivation = activation
+			}
+			if !matchesExpression(mockResp.ID, mockResp.MatchExpression.String, mockActivation) {
+				continue
+			}
+		}
+		storedPath := mockResp.Path
+		if pathCaseInsensitive {
+			storedPath = strings.ToLower(storedPath)
+		}
+		mockResp.exactPath = slices.Contains(paths, storedPath)
+		if !mockResp.exactPath {
+			_, storedQuery, _ := strings.Cut(storedPath, "?")
+			rules := mockResp.Options.Query
+			if mockResp.Options.Pagination != nil {
+				rules = mockResp.Options.Pagination.queryRules(rules)
+			}
+			if rules == nil || !rules.matches(storedQuery, requestQuery) {
+				continue
+			}
+		}
+		if mockResp.Options.Match != nil {
+			matched, err := mockResp.Options.Match.matches(mockData)
+			if err != nil {
+				requestLogf(r, "Invalid match options in mock %d: %v", mockResp.ID, err)
+			}
+			if !matched {
+				continue
+			}
+		}
+		if mockResp.Options.SOAP != nil && !mockResp.Options.SOAP.matches(mockData) {
+			continue
+		}
+		if mockResp.Options.Matcher != nil {
+			matched, err := runMatcher(mockResp.Options.Matcher, r, data.Body)
+			if err != nil {
+				requestLogf(r, "Matcher %q of mock %d failed: %v", mockResp.Options.Matcher.Name, mockResp.ID, err)
+			}
+			if !matched {
+				continue
+			}
+		}
+		candidates = append(candidates, &mockResp)
+	}
+
+	candidates = preferScoped(candidates, func(m *MockResponse) bool { return m.Profile.Valid && m.Profile.String != "" })
+	candidates = preferScoped(candidates, func(m *MockResponse) bool { return m.Workspace.Valid && m.Workspace.String != "" })
+	candidates = preferScoped(candidates, func(m *MockResponse) bool { return m.Host.Valid && m.Host.String != "" })
+	candidates = preferScoped(candidates, (*MockResponse).hasConditions)
+	candidates = preferScoped(candidates, func(m *MockResponse) bool { return m.exactPath })
+	candidates = preferSpecificPaths(candidates)
+	candidates = preferScoped(candidates, func(m *MockResponse) bool { return m.Method != anyMethod })
+	if len(candidates) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return pickWeighted(candidates, data.rand), nil
+}
+
+// mockLookupQuery selects the mocks of the paths in $1 and method $2, and
+// those of mocks whose options.query match query strings by the paths
+// without query string in basePaths. Each arm of the UNION has an index of
+// its own; an OR of both could use neither.
+func mockLookupQuery(bodyColumn, bodyCondition, basePaths string) string {
+	columns := `id, path, method, response_body, headers, response_status_code, is_template, weight, host, workspace, match_expression, options, labels->>'profile',
+			       ` + bodyColumn
+	conditions := `method IN ($2, 'ANY')
+			  AND enabled
+			  AND deleted_at IS NULL
+			  AND ` + bodyCondition
+	return `
+			SELECT ` + columns + `
+			FROM return.mock_responses
+			WHERE ` + pathColumn() + ` = ANY($1)
+			  AND ` + conditions + `
+			UNION ALL
+			SELECT ` + columns + `
+			FROM return.mock_responses
+			WHERE options ? 'query'
+			  AND split_part(` + pathColumn() + `, '?', 1) = ANY(` + basePaths + `)
+			  AND ` + pathColumn() + ` <> ALL($1)
+			  AND ` + conditions + `
+			ORDER BY id
+		`
+}
+
+func preferScoped(candidates []*MockResponse, scoped func(*MockResponse) bool) []*MockResponse {
+	var preferred []*MockResponse
+	for _, candidate := range candidates {
+		if scoped(candidate) {
+			preferred = append(preferred, candidate)
+		}
+	}
+	if len(preferred) > 0 {
+		return preferred
+	}
+	return candidates
+}
+
+func (m *MockResponse) hasConditions() bool {
+	return m.Options.Match != nil || m.Options.Matcher != nil || (m.Options.SOAP != nil && m.Options.SOAP.Operation != "") ||
+		(m.MatchExpression.Valid && m.MatchExpression.String != "")
 }
 
 func parseHeaders(headerStr sql.NullString) map[string]string {
@@ -168,36 +373,305 @@ func buildFullPath(r *http.Request) string {
 	return urlPath
 }
 
+func requestWorkspace(r *http.Request) string {
+	if workspace, ok := r.Context().Value(workspaceKey{}).(string); ok {
+		return workspace
+	}
+	if workspace := strings.TrimSpace(r.Header.Get("X-Mock-Workspace")); workspace != "" {
+		return workspace
+	}
+	return "default"
+}
+
 func proxyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	urlPath := buildFullPath(r)
 	method := r.Method
 
+	entry := newJournalEntry(r)
+	rec := &statusRecorder{ResponseWriter: w, capture: responseCapture(requestLogLevel(r, nil))}
+	w = rec
+	var mockLog *logOptions
+	defer func() {
+		entry.StatusCode = rec.status
+		entry.Duration = time.Since(entry.ReceivedAt)
+		entry.recordResponse(rec)
+		if entry.MockID != 0 {
+			mockStats.record(entry.MockID, entry.ReceivedAt, entry.Duration)
+		}
+		redactEntry(entry)
+		recordJournal(entry)
+		logRequest(r, entry, rec, mockLog)
+	}()
+
+	rewrites := rewriteRequest(r)
+	if len(rewrites) > 0 {
+		urlPath = buildFullPath(r)
+	}
+	applyWorkspaceHeaders(w, r)
+	if !runMiddlewares(middlewares.preMatch, w, r) {
+		return
+	}
+	if !admitThroughGateway(w, r) {
+		return
+	}
+	release, ok := limitConcurrency(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if maxRequestBodySize > 0 {
+		if r.ContentLength > maxRequestBodySize {
+			writeRouterError(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	}
+	if !rejectBeforeBody(w, r, urlPath, entry) {
+		return
+	}
 	requestBody, err := readRequestBody(r)
 	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		log.Printf("Error reading request body: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeRouterError(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeRouterError(w, r, "Error reading request body", http.StatusBadRequest)
+		requestLogf(r, "Error reading request body: %v", err)
 		return
 	}
+	entry.Body = requestBody
+	if rewritten := rewriteBody(r, requestBody, rewrites); rewritten != requestBody {
+		requestBody = rewritten
+		r.Body = newBodyReader(requestBody)
+	}
 
-	validatedJSON, err := validateAndReturnJSON(requestBody)
+	matchBody := requestBody
+	if decoded, ok, err := decodeRequestBody(r.Header, requestBody); err != nil {
+		writeRouterError(w, r, "Invalid request body", http.StatusBadRequest)
+		requestLogf(r, "Invalid request body: %v", err)
+		return
+	} else if ok {
+		matchBody = decoded
+	}
+
+	validatedJSON, err := validateAndReturnJSON(matchBody)
 	if err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		log.Printf("Invalid JSON: %v", err)
+		writeRouterError(w, r, "Invalid JSON", http.StatusBadRequest)
+		requestLogf(r, "Invalid JSON: %v", err)
 		return
 	}
 
-	mockResp, err := getMockResponse(urlPath, method, validatedJSON)
+	var contractInput *openapi3filter.RequestValidationInput
+	if contract != nil {
+		var messages []string
+		contractInput, messages = contract.validateRequest(r, requestBody)
+		contract.record("request", r, 0, messages)
+		if len(messages) > 0 && contract.enforce {
+			writeValidationError(w, r, "request does not match the OpenAPI contract", messages)
+			return
+		}
+	}
+
+	if pathSchemasEnabled {
+		ctx, cancel := requestQueryContext(r)
+		schema, err := getPathSchema(ctx, r.URL.Path, method)
+		if err != nil {
+			writeQueryError(w, r, "Schema lookup", queryError(ctx, "schema", err))
+			cancel()
+			return
+		}
+		cancel()
+		if schema != "" && !enforceSchema(w, r, entry, schema, requestBody) {
+			return
+		}
+	}
+
+	data := newTemplateData(r, requestBody)
+	if matchBody != requestBody {
+		json.Unmarshal(bytesOf(matchBody), &data.JSON)
+	}
+	if data.Session != "" {
+		sessionStore.touch(data.Workspace, data.Session)
+	}
+	shaped, ok := shapeTraffic(w, r, data)
+	if !ok {
+		return
+	}
+	w = shaped
+	if !injectFaults(w, r, data) {
+		return
+	}
+	if !enforceCircuit(w, data) {
+		return
+	}
+	mockResp, err := getMockResponse(r, urlPath, validatedJSON, data)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			http.NotFound(w, r)
+			if collection, id, ok := matchCRUDPath(r.URL.Path); ok {
+				crudHandler(w, r, collection, id, validatedJSON)
+				return
+			}
+			if proxyUpstream != nil {
+				proxyRequest(w, r, requestBody, data)
+				return
+			}
+			if !gatewayNotFound(w, r) {
+				writeRouterError(w, r, "404 page not found", http.StatusNotFound)
+			}
+			return
+		}
+		writeQueryError(w, r, "Mock lookup", err)
+		return
+	}
+	entry.MockID = mockResp.ID
+	if pathKind(mockResp.Path) != literalPath {
+		data.PathParams = pathParams(mockResp.Path, r.URL.Path)
+	}
+	if mockLog = mockResp.Options.Log; mockLog != nil {
+		rec.capture = responseCapture(requestLogLevel(r, mockLog))
+	}
+	if mockResp.requestJSON != nil {
+		data.JSON = mockResp.requestJSON
+	}
+	if limit := int(mockResp.Options.MaxBodySize); limit > 0 && len(requestBody) > limit {
+		writeRouterError(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !runMiddlewares(middlewares.postMatch, w, r) {
+		return
+	}
+	if !enforceRateLimit(w, mockResp, data) {
+		return
+	}
+
+	if schema := mockResp.Options.RequestSchema; len(schema) > 0 && !enforceSchema(w, r, entry, string(schema), requestBody) {
+		return
+	}
+	idempotent, ok := checkIdempotency(w, mockResp, data)
+	if !ok {
+		return
+	}
+	var written *MockResponse
+	defer func() { idempotent.finish(w.Header(), written) }()
+
+	applyExperiment(w, r, mockResp, &data)
+	applyStatusSequence(w, mockResp, data)
+	if data.Page, ok = applyPagination(w, r, mockResp.Options.Pagination, data); !ok {
+		return
+	}
+	if upstream := mockResp.Options.Upstream; upstream != nil && upstream.URL != "" {
+		fetched, err := fetchUpstream(upstream, data, r)
+		if err != nil {
+			requestLogf(r, "Upstream fetch of mock %d failed: %v", mockResp.ID, err)
+			if !upstream.IgnoreErrors {
+				writeRouterError(w, r, "Upstream fetch failed", http.StatusBadGateway)
+				return
+			}
+		}
+		data.Upstream = fetched
+	}
+	if mockResp.IsTemplate {
+		if err := renderMockResponse(mockResp, data); err != nil {
+			writeRouterError(w, r, "Template rendering failed", http.StatusInternalServerError)
+			requestLogf(r, "Template error: %v", err)
+			return
+		}
+	}
+	if err := applyUpstream(mockResp, data.Upstream); err != nil {
+		writeRouterError(w, r, "Upstream merge failed", http.StatusBadGateway)
+		requestLogf(r, "Upstream merge of mock %d failed: %v", mockResp.ID, err)
+		return
+	}
+	if ok, err := paginateDataset(w, r, mockResp); !ok {
+		if err != nil {
+			writeRouterError(w, r, "Pagination failed", http.StatusInternalServerError)
+			requestLogf(r, "Pagination of mock %d failed: %v", mockResp.ID, err)
+		}
+		return
+	}
+	if mockResp.Options.GraphQL != nil {
+		if err := renderGraphQLResponse(mockResp, data); err != nil {
+			writeRouterError(w, r, "GraphQL resolution failed", http.StatusInternalServerError)
+			requestLogf(r, "GraphQL error in mock %d: %v", mockResp.ID, err)
+			return
+		}
+	}
+	if mockResp.Options.Script != "" {
+		if err := runMockScript(mockResp, data); err != nil {
+			writeRouterError(w, r, "Script execution failed", http.StatusInternalServerError)
+			requestLogf(r, "Script error in mock %d: %v", mockResp.ID, err)
+			return
+		}
+	}
+	if mockResp.Options.Responder != nil {
+		if err := runResponder(mockResp.Options.Responder, mockResp, r, requestBody); err != nil {
+			writeRouterError(w, r, "Responder failed", http.StatusInternalServerError)
+			requestLogf(r, "Responder %q of mock %d failed: %v", mockResp.Options.Responder.Name, mockResp.ID, err)
+			return
+		}
+	}
+
+	if soap := mockResp.Options.SOAP; soap != nil && soap.Fault != nil {
+		if err := renderSOAPFault(mockResp, data); err != nil {
+			writeRouterError(w, r, "SOAP fault rendering failed", http.StatusInternalServerError)
+			requestLogf(r, "SOAP fault of mock %d failed: %v", mockResp.ID, err)
 			return
 		}
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
+	}
+
+	if err := mutateResponse(w, r, mockResp, data, entry.ReceivedAt); err != nil {
+		writeRouterError(w, r, "Response mutation failed", http.StatusInternalServerError)
+		requestLogf(r, "Mutating the response of mock %d failed: %v", mockResp.ID, err)
+		return
+	}
+	if err := wrapMockError(r, mockResp, data); err != nil {
+		writeRouterError(w, r, "Response mutation failed", http.StatusInternalServerError)
+		requestLogf(r, "Wrapping the error of mock %d failed: %v", mockResp.ID, err)
 		return
 	}
 
-	writeResponse(w, mockResp)
+	if contractInput != nil {
+		contract.record("response", r, mockResp.ID, contract.validateResponse(contractInput, mockResp))
+	}
+	if err := encodeProtobufResponse(mockResp); err != nil {
+		writeRouterError(w, r, "Protobuf encoding failed", http.StatusInternalServerError)
+		requestLogf(r, "Protobuf encoding of mock %d failed: %v", mockResp.ID, err)
+		return
+	}
+	if err := encodeTableResponse(mockResp); err != nil {
+		writeRouterError(w, r, "Response encoding failed", http.StatusInternalServerError)
+		requestLogf(r, "Encoding the response of mock %d failed: %v", mockResp.ID, err)
+		return
+	}
+	if err := encodeBinaryResponse(mockResp, r); err != nil {
+		writeRouterError(w, r, "Response encoding failed", http.StatusInternalServerError)
+		requestLogf(r, "Encoding the response of mock %d failed: %v", mockResp.ID, err)
+		return
+	}
+	// The mirror compares the body as text, before its charset and content
+	// encoding are applied.
+	mirrorBody := mockResp.ResponseBody
+	if err := encodeResponseBody(mockResp); err != nil {
+		writeRouterError(w, r, "Response encoding failed", http.StatusInternalServerError)
+		requestLogf(r, "Encoding the response of mock %d failed: %v", mockResp.ID, err)
+		return
+	}
+
+	if !runMiddlewares(middlewares.preResponse, w, r) {
+		return
+	}
+	if !serveDownload(w, r, mockResp) {
+		writeResponse(w, mockResp)
+	}
+	written = mockResp
+	recordCircuitHit(mockResp, data)
+	mirrorTraffic(mirrorRequestCopy(r, requestBody), mockResp, mirrorBody)
+	fireWebhooks(mockResp, data)
+	fireAsyncEvents(mockResp, data)
+	publishKafkaEvents(mockResp, data)
+	publishAMQPMessages(mockResp, data)
 }
 
 func registerHandlers(router *httprouter.Router, path string, handler httprouter.Handle) {
@@ -208,17 +682,233 @@ func registerHandlers(router *httprouter.Router, path string, handler httprouter
 	router.PATCH(path, handler)
 	router.OPTIONS(path, handler)
 	router.HEAD(path, handler)
+
+	// Methods without a route of their own (PROPFIND, REPORT, ...) reach the
+	// handler through NotFound instead of being answered with 405.
+	router.HandleMethodNotAllowed = false
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, nil)
+	})
+}
+
+type mount struct {
+	prefix  string
+	handler http.Handler
+}
+
+func rootHandler(mounts []mount, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range mounts {
+			if strings.HasPrefix(r.URL.Path, m.prefix) {
+				m.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	})
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplayCommand(os.Args[2:]))
+		case "import":
+			os.Exit(runImportCommand(os.Args[2:]))
+		case "reset":
+			os.Exit(runResetCommand(os.Args[2:]))
+		case "tail":
+			os.Exit(runTailCommand(os.Args[2:]))
+		case "stale":
+			os.Exit(runStaleCommand(os.Args[2:]))
+		case "report":
+			os.Exit(runReportCommand(os.Args[2:]))
+		case "coverage":
+			os.Exit(runCoverageCommand(os.Args[2:]))
+		case "lint":
+			os.Exit(runLintCommand(os.Args[2:]))
+		case "bench":
+			os.Exit(runBenchCommand(os.Args[2:]))
+		case "secrets":
+			os.Exit(runSecretsCommand(os.Args[2:]))
+		}
+	}
+
+	if debugPprof && adminToken == "" {
+		log.Fatal("Debug initialization failed: DEBUG_PPROF requires ADMIN_TOKEN")
+	}
+
+	storage := storageMode(os.Args[1:])
+	switch storage {
+	case storagePostgres:
+	case storageEmbeddedPostgres:
+		stop, err := startEmbeddedPostgres()
+		if err != nil {
+			log.Fatal("Embedded Postgres initialization failed:", err)
+		}
+		defer stop()
+	default:
+		log.Fatalf("Unknown storage %q; use %s or %s", storage, storagePostgres, storageEmbeddedPostgres)
+	}
+
 	if err := initDB(); err != nil {
 		log.Fatal("Database initialization failed:", err)
 	}
 	defer db.Close()
+	if storage == storageEmbeddedPostgres {
+		if err := createSchema(context.Background()); err != nil {
+			log.Fatal("Database initialization failed:", err)
+		}
+	}
+	if err := initBodyHasher(); err != nil {
+		log.Fatal("Body hash initialization failed:", err)
+	}
+	if err := backfillBodyHashes(); err != nil {
+		log.Printf("Error hashing mock request bodies: %v", err)
+	}
+	if err := lintOnStartup(); err != nil {
+		log.Fatal("Mock lint failed:", err)
+	}
+	startTrashPurger(trashRetention)
+	if err := initReplica(envString("DB_READ_DSN", ""), envDuration("DB_REPLICA_MAX_LAG", 5*time.Second),
+		envDuration("DB_REPLICA_CHECK_INTERVAL", 5*time.Second)); err != nil {
+		log.Fatal("Read replica initialization failed:", err)
+	}
+	if err := initCluster(envString("REDIS_URL", ""), envString("REDIS_CHANNEL", "mock-db-router")); err != nil {
+		log.Fatal("Redis initialization failed:", err)
+	}
+	if err := initSharedState(); err != nil {
+		log.Fatal("Shared state initialization failed:", err)
+	}
+	handleFlushSignal()
+	startJournalWriter()
+	startJournalPruner(journalLimits)
+	startSessionSweeper()
+	startMockStatsFlusher(envDuration("MOCK_STATS_FLUSH_INTERVAL", time.Minute))
+	startGitSync()
+	if err := startKubernetesWatcher(); err != nil {
+		log.Fatal("Kubernetes watcher initialization failed:", err)
+	}
+	if err := startAdminGRPC(envString("ADMIN_GRPC_ADDR", "")); err != nil {
+		log.Fatal("Admin gRPC initialization failed:", err)
+	}
 
 	router := httprouter.New()
-	registerHandlers(router, "/*path", proxyHandler)
+	registerHandlers(router, "/*path", withBackpressure(proxyHandler))
 
-	fmt.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	if err := loadSecrets(envString("SECRETS_FILE", "")); err != nil {
+		log.Fatal("Secrets initialization failed:", err)
+	}
+	if err := loadTemplateJWTKeys(envString("TEMPLATE_JWT_KEYS", "")); err != nil {
+		log.Fatal("JWT key initialization failed:", err)
+	}
+	if err := pathIndex.reload(nil); err != nil {
+		log.Fatal("Path index initialization failed:", err)
+	}
+	if compiled := precompileTemplates(nil); compiled > 0 {
+		fmt.Printf("Precompiled the templates of %d mocks\n", compiled)
+	}
+	loadCRUDCollections(envString("CRUD_COLLECTIONS", ""))
+	initKafka(envString("KAFKA_BROKERS", ""))
+	initAMQP(envString("AMQP_URL", ""))
+	if err := loadPlugins(envList("PLUGINS", nil)); err != nil {
+		log.Fatal("Plugin initialization failed:", err)
+	}
+	if err := loadProfiles(envString("PROFILES_CONFIG", ""), envString("MOCK_PROFILE", "")); err != nil {
+		log.Fatal("Profile initialization failed:", err)
+	}
+	if err := loadRedaction(envString("REDACTION_CONFIG", "")); err != nil {
+		log.Fatal("Redaction initialization failed:", err)
+	}
+	if err := loadRequestLogging(envString("LOG_CONFIG", ""), envString("LOG_REQUESTS", "")); err != nil {
+		log.Fatal("Request logging initialization failed:", err)
+	}
+	if err := loadNetworkProfiles(envString("NETWORK_PROFILES", ""), envString("NETWORK_PROFILE", "")); err != nil {
+		log.Fatal("Network profile initialization failed:", err)
+	}
+	initBackpressure()
+	if err := loadConcurrencyLimits(envString("CONCURRENCY_LIMITS", "")); err != nil {
+		log.Fatal("Concurrency limit initialization failed:", err)
+	}
+	if err := loadMiddlewares(envString("MIDDLEWARE_CONFIG", "")); err != nil {
+		log.Fatal("Middleware initialization failed:", err)
+	}
+	if err := initContractValidation(envString("OPENAPI_SPEC", "")); err != nil {
+		log.Fatal("OpenAPI contract initialization failed:", err)
+	}
+	if err := loadProtoDescriptors(envList("PROTO_FILES", nil), envList("PROTO_IMPORT_PATHS", nil), envList("PROTO_DESCRIPTOR_SETS", nil)); err != nil {
+		log.Fatal("Protobuf initialization failed:", err)
+	}
+	if err := loadRequestRewrites(envString("REQUEST_REWRITES", "")); err != nil {
+		log.Fatal("Request rewrite initialization failed:", err)
+	}
+	if err := loadWorkspaceDefaults(envString("WORKSPACE_DEFAULTS", "")); err != nil {
+		log.Fatal("Workspace defaults initialization failed:", err)
+	}
+	if err := initGateway(envString("GATEWAY_CONFIG", ""), envBool("GATEWAY_EMULATION", false)); err != nil {
+		log.Fatal("Gateway emulation initialization failed:", err)
+	}
+	if err := loadResponseMutators(envString("RESPONSE_MUTATORS", "")); err != nil {
+		log.Fatal("Response mutator initialization failed:", err)
+	}
+	if err := initProxy(envString("PROXY_UPSTREAM", ""), envString("PROXY_TRANSFORMS", "")); err != nil {
+		log.Fatal("Proxy initialization failed:", err)
+	}
+	if err := loadAsyncAPIFiles(envList("ASYNCAPI_FILES", nil), envString("ASYNCAPI_TARGET", "")); err != nil {
+		log.Fatal("AsyncAPI initialization failed:", err)
+	}
+	if err := loadGraphQLSchema(envList("GRAPHQL_SCHEMA_FILES", nil)); err != nil {
+		log.Fatal("GraphQL initialization failed:", err)
+	}
+
+	mounts := []mount{{adminPathPrefix, requireAdminToken(newAdminRouter())}}
+	if envBool("OAUTH_ENABLED", false) {
+		issuer, err := newOAuthIssuer()
+		if err != nil {
+			log.Fatal("OAuth issuer initialization failed:", err)
+		}
+		mounts = append(mounts, mount{oauthPathPrefix, issuer.router()})
+		if _, exists := templateJWTKeys["oauth"]; !exists {
+			templateJWTKeys["oauth"] = issuer.key
+		}
+		fmt.Println("OAuth issuer enabled at", issuer.issuer)
+	}
+
+	handler := withRequestID(recoverPanics(rootHandler(mounts, router)))
+	listeners, err := parseListeners(envString("LISTENERS", ""))
+	if err != nil {
+		log.Fatal("Listener configuration failed:", err)
+	}
+	for _, l := range listeners {
+		ln, err := listen(l.addr)
+		if err != nil {
+			log.Fatal("Listener initialization failed:", err)
+		}
+		fmt.Printf("Server starting on %s for workspace %s\n", l.addr, l.workspace)
+		go func(ln net.Listener, workspace string) {
+			log.Fatal(serve(ln, withWorkspace(workspace, handler)))
+		}(ln, l.workspace)
+	}
+
+	activated, err := systemdListeners()
+	if err != nil {
+		log.Fatal("Listener initialization failed:", err)
+	}
+	if len(activated) == 0 {
+		addr := envString("LISTEN_ADDR", ":8080")
+		ln, err := listen(addr)
+		if err != nil {
+			log.Fatal("Listener initialization failed:", err)
+		}
+		activated = append(activated, ln)
+		fmt.Println("Server starting on", addr)
+	} else {
+		fmt.Printf("Server starting on %d systemd-activated sockets\n", len(activated))
+	}
+	for _, ln := range activated[1:] {
+		go func(ln net.Listener) {
+			log.Fatal(serve(ln, handler))
+		}(ln)
+	}
+	log.Fatal(serve(activated[0], handler))
 }
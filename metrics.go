@@ -0,0 +1,18 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	contractViolationsTotal  = expvar.NewMap("contract_violations_total")
+	mirrorDiscrepanciesTotal = expvar.NewInt("mirror_discrepancies_total")
+	panicsTotal              = expvar.NewInt("panics_total")
+)
+
+func metricsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	expvar.Handler().ServeHTTP(w, r)
+}
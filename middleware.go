@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A middleware returns false when it has written a response itself and the
+// request must not be processed any further.
+type middleware = func(w http.ResponseWriter, r *http.Request) bool
+type middlewareFactory = func(config json.RawMessage) (middleware, error)
+
+type middlewareConfig struct {
+	PreMatch    []pluginRef `json:"preMatch"`
+	PostMatch   []pluginRef `json:"postMatch"`
+	PreResponse []pluginRef `json:"preResponse"`
+}
+
+type middlewareChain struct {
+	preMatch    []middleware
+	postMatch   []middleware
+	preResponse []middleware
+}
+
+var (
+	middlewaresMu       sync.Mutex
+	middlewareFactories = map[string]middlewareFactory{
+		"requireHeader":        requireHeaderMiddleware,
+		"setRequestHeaders":    setRequestHeadersMiddleware,
+		"removeRequestHeaders": removeRequestHeadersMiddleware,
+		"setResponseHeaders":   setResponseHeadersMiddleware,
+		"log":                  logMiddleware,
+	}
+
+	middlewares middlewareChain
+)
+
+func registerMiddleware(name string, factory middlewareFactory) error {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	if _, exists := middlewareFactories[name]; exists {
+		return fmt.Errorf("middleware %q is already registered", name)
+	}
+	middlewareFactories[name] = factory
+	return nil
+}
+
+func loadMiddlewares(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading middleware config: %v", err)
+	}
+	var config middlewareConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("invalid middleware config: %v", err)
+	}
+
+	stages := []struct {
+		name  string
+		refs  []pluginRef
+		chain *[]middleware
+	}{
+		{"preMatch", config.PreMatch, &middlewares.preMatch},
+		{"postMatch", config.PostMatch, &middlewares.postMatch},
+		{"preResponse", config.PreResponse, &middlewares.preResponse},
+	}
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	for _, stage := range stages {
+		for i, ref := range stage.refs {
+			factory, ok := middlewareFactories[ref.Name]
+			if !ok {
+				return fmt.Errorf("%s[%d]: unknown middleware %q", stage.name, i, ref.Name)
+			}
+			mw, err := factory(ref.Config)
+			if err != nil {
+				return fmt.Errorf("%s[%d] (%s): %v", stage.name, i, ref.Name, err)
+			}
+			*stage.chain = append(*stage.chain, mw)
+		}
+	}
+	fmt.Println("Middleware chain loaded from", path)
+	return nil
+}
+
+func runMiddlewares(chain []middleware, w http.ResponseWriter, r *http.Request) bool {
+	for _, mw := range chain {
+		if !mw(w, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeMiddlewareConfig(config json.RawMessage, v interface{}) error {
+	if len(config) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(config, v); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	return nil
+}
+
+func requireHeaderMiddleware(raw json.RawMessage) (middleware, error) {
+	var config struct {
+		Header  string   `json:"header"`
+		Values  []string `json:"values"`
+		Status  int      `json:"status"`
+		Message string   `json:"message"`
+	}
+	if err := decodeMiddlewareConfig(raw, &config); err != nil {
+		return nil, err
+	}
+	if config.Header == "" {
+		return nil, fmt.Errorf("header is required")
+	}
+	if config.Status == 0 {
+		config.Status = http.StatusUnauthorized
+	}
+	if config.Message == "" {
+		config.Message = "missing or invalid " + config.Header + " header"
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		value := r.Header.Get(config.Header)
+		if value != "" && len(config.Values) == 0 {
+			return true
+		}
+		for _, allowed := range config.Values {
+			if value == allowed {
+				return true
+			}
+		}
+		writeJSON(w, config.Status, map[string]string{"error": config.Message})
+		return false
+	}, nil
+}
+
+func setRequestHeadersMiddleware(raw json.RawMessage) (middleware, error) {
+	var headers map[string]string
+	if err := decodeMiddlewareConfig(raw, &headers); err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		return true
+	}, nil
+}
+
+func removeRequestHeadersMiddleware(raw json.RawMessage) (middleware, error) {
+	var headers []string
+	if err := decodeMiddlewareConfig(raw, &headers); err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, key := range headers {
+			r.Header.Del(key)
+		}
+		return true
+	}, nil
+}
+
+func setResponseHeadersMiddleware(raw json.RawMessage) (middleware, error) {
+	var headers map[string]string
+	if err := decodeMiddlewareConfig(raw, &headers); err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for key, value := range headers {
+			w.Header().Set(key, value)
+		}
+		return true
+	}, nil
+}
+
+func logMiddleware(raw json.RawMessage) (middleware, error) {
+	var config struct {
+		Headers []string `json:"headers"`
+	}
+	if err := decodeMiddlewareConfig(raw, &config); err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		line := r.Method + " " + buildFullPath(r)
+		for _, name := range config.Headers {
+			line += fmt.Sprintf(" %s=%q", name, r.Header.Get(name))
+		}
+		log.Print(strings.TrimSpace(line))
+		return true
+	}, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxMirrorDiscrepancies = 1000
+
+type mirrorOptions struct {
+	Disabled      bool     `json:"disabled,omitempty"`
+	IgnoreHeaders []string `json:"ignoreHeaders,omitempty"`
+	IgnoreFields  []string `json:"ignoreFields,omitempty"`
+}
+
+type mirrorDiscrepancy struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	MockID      int       `json:"mockId"`
+	Differences []string  `json:"differences"`
+}
+
+type mirrorRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+var (
+	mirrorUpstream      = strings.TrimSuffix(envString("MIRROR_UPSTREAM", ""), "/")
+	mirrorIgnoreHeaders = envList("MIRROR_IGNORE_HEADERS", nil)
+	mirrorIgnoreFields  = envList("MIRROR_IGNORE_FIELDS", nil)
+	mirrorClient        = &http.Client{
+		Timeout: envDuration("MIRROR_TIMEOUT", 10*time.Second),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	mirrorMu            sync.Mutex
+	mirrorDiscrepancies []mirrorDiscrepancy
+)
+
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Accept-Encoding"}
+
+func mirrorRequestCopy(r *http.Request, requestBody string) *mirrorRequest {
+	if mirrorUpstream == "" {
+		return nil
+	}
+	header := r.Header.Clone()
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+	return &mirrorRequest{method: r.Method, path: buildFullPath(r), header: header, body: requestBody}
+}
+
+// mirrorTraffic compares the upstream's response with the mock's in the
+// background; body is the mock's before encodeResponseBody.
+func mirrorTraffic(req *mirrorRequest, mockResp *MockResponse, body string) {
+	if req == nil || mockResp.Options.Mirror.Disabled {
+		return
+	}
+	expected := *mockResp
+	expected.ResponseBody = body
+	if expected.Options.Base64Body {
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(body)); err == nil {
+			expected.ResponseBody = string(decoded)
+		}
+	}
+	go func() {
+		differences, err := compareWithUpstream(req, &expected)
+		if err != nil {
+			log.Printf("Mirror request for mock %d failed: %v", expected.ID, err)
+			return
+		}
+		if len(differences) > 0 {
+			recordMirrorDiscrepancy(req, expected.ID, differences)
+		}
+	}()
+}
+
+func compareWithUpstream(req *mirrorRequest, mockResp *MockResponse) ([]string, error) {
+	upstreamReq, err := http.NewRequest(req.method, mirrorUpstream+req.path, strings.NewReader(req.body))
+	if err != nil {
+		return nil, err
+	}
+	upstreamReq.Header = req.header
+
+	resp, err := mirrorClient.Do(upstreamReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upstream body: %v", err)
+	}
+	if mockResp.charset != "" && !mockResp.Options.Base64Body {
+		// Compare the upstream's text with the mock's before it was encoded.
+		if enc, _, err := responseCharset(mockResp.charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(body); err == nil {
+				body = decoded
+			}
+		}
+	}
+
+	var differences []string
+	status := mockResp.ResponseStatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status != resp.StatusCode {
+		differences = append(differences, fmt.Sprintf("status: mock %d, upstream %d", status, resp.StatusCode))
+	}
+
+	ignoredHeaders := make(map[string]bool)
+	for _, list := range [][]string{mirrorIgnoreHeaders, mockResp.Options.Mirror.IgnoreHeaders} {
+		for _, name := range list {
+			ignoredHeaders[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	mockHeaders := parseHeaders(mockResp.Headers)
+	var names []string
+	for name := range mockHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ignoredHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		if actual := resp.Header.Get(name); actual != mockHeaders[name] {
+			differences = append(differences, fmt.Sprintf("header %s: mock %q, upstream %q", name, mockHeaders[name], actual))
+		}
+	}
+
+	ignoredFields := make(map[string]bool)
+	for _, list := range [][]string{mirrorIgnoreFields, mockResp.Options.Mirror.IgnoreFields} {
+		for _, field := range list {
+			ignoredFields[field] = true
+		}
+	}
+	return append(differences, diffBodies(mockResp.ResponseBody, string(body), ignoredFields)...), nil
+}
+
+func diffBodies(expected, actual string, ignored map[string]bool) []string {
+	var expectedJSON, actualJSON interface{}
+	if json.Unmarshal([]byte(expected), &expectedJSON) != nil || json.Unmarshal([]byte(actual), &actualJSON) != nil {
+		if expected != actual {
+			return []string{"body: mock and upstream bodies differ"}
+		}
+		return nil
+	}
+	return diffJSON("", expectedJSON, actualJSON, ignored, nil)
+}
+
+func diffJSON(pointer string, expected, actual interface{}, ignored map[string]bool, differences []string) []string {
+	if ignored[pointer] {
+		return differences
+	}
+	location := pointer
+	if location == "" {
+		location = "/"
+	}
+
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for key := range e {
+			keys[key] = true
+		}
+		for key := range a {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			child := pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+			ev, inExpected := e[key]
+			av, inActual := a[key]
+			switch {
+			case ignored[child]:
+			case !inActual:
+				differences = append(differences, fmt.Sprintf("body %s: missing in upstream", child))
+			case !inExpected:
+				differences = append(differences, fmt.Sprintf("body %s: missing in mock", child))
+			default:
+				differences = diffJSON(child, ev, av, ignored, differences)
+			}
+		}
+		return differences
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		if len(e) != len(a) {
+			return append(differences, fmt.Sprintf("body %s: mock has %d items, upstream %d", location, len(e), len(a)))
+		}
+		for i := range e {
+			differences = diffJSON(fmt.Sprintf("%s/%d", pointer, i), e[i], a[i], ignored, differences)
+		}
+		return differences
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		differences = append(differences, fmt.Sprintf("body %s: mock %s, upstream %s", location, compactJSON(expected), compactJSON(actual)))
+	}
+	return differences
+}
+
+func compactJSON(v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(encoded)
+}
+
+func recordMirrorDiscrepancy(req *mirrorRequest, mockID int, differences []string) {
+	mirrorDiscrepanciesTotal.Add(1)
+	log.Printf("Mock %d differs from upstream for %s %s: %s", mockID, req.method, req.path, strings.Join(differences, "; "))
+
+	mirrorMu.Lock()
+	defer mirrorMu.Unlock()
+
+	mirrorDiscrepancies = append(mirrorDiscrepancies, mirrorDiscrepancy{
+		Time:        time.Now(),
+		Method:      req.method,
+		Path:        req.path,
+		MockID:      mockID,
+		Differences: differences,
+	})
+	if overflow := len(mirrorDiscrepancies) - maxMirrorDiscrepancies; overflow > 0 {
+		mirrorDiscrepancies = append([]mirrorDiscrepancy(nil), mirrorDiscrepancies[overflow:]...)
+	}
+}
+
+func mirrorDiscrepanciesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mirrorMu.Lock()
+	discrepancies := append([]mirrorDiscrepancy{}, mirrorDiscrepancies...)
+	mirrorMu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"discrepancies": discrepancies})
+}
+
+func clearMirrorDiscrepanciesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mirrorMu.Lock()
+	mirrorDiscrepancies = nil
+	mirrorMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// mockRow is a mock as read by a lookup, before it is matched.
+type mockRow struct {
+	mock MockResponse
+	// unhashedBody is the request body of a mock whose hash is missing or
+	// stale.
+	unhashedBody sql.NullString
+}
+
+type mockCacheEntry struct {
+	rows    []mockRow
+	expires time.Time
+}
+
+const maxMockCacheEntries = 10000
+
+var (
+	// mockCacheTTL keeps the mocks read by a lookup for the next lookups of
+	// the same path, method and body. Mock changes through the admin API
+	// clear the cache; changes made directly in the database show once the
+	// entries expire or the cache is flushed.
+	mockCacheTTL = envDuration("MOCK_CACHE_TTL", 0)
+
+	mockCacheMu sync.Mutex
+	mockCache   = make(map[string]mockCacheEntry)
+
+	mockCacheTotal = expvar.NewMap("mock_cache_total")
+)
+
+func init() {
+	onMockChange(func([]int) { flushMockCache() })
+}
+
+// lookupMockRows reads the mocks of a lookup, from the cache when key was
+// looked up within MOCK_CACHE_TTL.
+func lookupMockRows(ctx context.Context, key, query string, args ...interface{}) ([]mockRow, error) {
+	if mockCacheTTL > 0 {
+		mockCacheMu.Lock()
+		entry, ok := mockCache[key]
+		mockCacheMu.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			mockCacheTotal.Add("hits", 1)
+			return entry.rows, nil
+		}
+		mockCacheTotal.Add("misses", 1)
+	}
+
+	rows, err := queryRead(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []mockRow
+	for rows.Next() {
+		var row mockRow
+		m := &row.mock
+		err := rows.Scan(&m.ID, &m.Path, &m.Method, &m.ResponseBody, &m.Headers, &m.ResponseStatusCode, &m.IsTemplate, &m.Weight, &m.Host, &m.Workspace, &m.MatchExpression, &m.rawOptions, &m.Profile, &row.unhashedBody)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if mockCacheTTL > 0 {
+		mockCacheMu.Lock()
+		if len(mockCache) >= maxMockCacheEntries {
+			clear(mockCache)
+		}
+		mockCache[key] = mockCacheEntry{rows: result, expires: time.Now().Add(mockCacheTTL)}
+		mockCacheMu.Unlock()
+	}
+	return result, nil
+}
+
+// flushMockCache drops the cached lookups and returns how many there were.
+func flushMockCache() int {
+	mockCacheMu.Lock()
+	defer mockCacheMu.Unlock()
+	flushed := len(mockCache)
+	clear(mockCache)
+	return flushed
+}
+
+func flushUpstreamCache() int {
+	upstreamCacheMu.Lock()
+	defer upstreamCacheMu.Unlock()
+	flushed := len(upstreamCache)
+	clear(upstreamCache)
+	return flushed
+}
+
+// cacheFlushResult counts the entries dropped from each cache.
+type cacheFlushResult struct {
+	Mocks    int `json:"mocks"`
+	Upstream int `json:"upstream"`
+	Renders  int `json:"renders"`
+}
+
+// flushCaches drops the cached mock lookups, upstream responses and
+// renderings, so rows changed directly in the database are used by the next
+// request.
+func flushCaches() cacheFlushResult {
+	return cacheFlushResult{Mocks: flushMockCache(), Upstream: flushUpstreamCache(), Renders: flushRenderCache()}
+}
+
+func flushCacheHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	result := flushCaches()
+	publishClusterEvent(clusterEvent{Type: clusterFlushEvent})
+	log.Printf("Flushed %d cached mock lookups, %d upstream responses and %d renderings", result.Mocks, result.Upstream, result.Renders)
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleFlushSignal flushes the caches on SIGUSR1, e.g. after
+// `kill -USR1 <pid>`.
+func handleFlushSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			result := flushCaches()
+			publishClusterEvent(clusterEvent{Type: clusterFlushEvent})
+			log.Printf("SIGUSR1: flushed %d cached mock lookups, %d upstream responses and %d renderings", result.Mocks, result.Upstream, result.Renders)
+		}
+	}()
+}
@@ -0,0 +1,5 @@
+package main
+
+// handleFlushSignal does nothing: Windows has no SIGUSR1, use
+// POST /__admin/cache/flush instead.
+func handleFlushSignal() {}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+
+	"mock-db-router/adminapi"
+)
+
+type mockDefinition struct {
+	ID              int               `json:"id,omitempty"`
+	Path            string            `json:"path"`
+	Method          string            `json:"method"`
+	RequestBody     json.RawMessage   `json:"requestBody,omitempty"`
+	ResponseBody    string            `json:"responseBody"`
+	StatusCode      int               `json:"statusCode,omitempty"`
+	Headers         string            `json:"headers,omitempty"`
+	IsTemplate      bool              `json:"isTemplate,omitempty"`
+	Weight          *int              `json:"weight,omitempty"`
+	Host            string            `json:"host,omitempty"`
+	Workspace       string            `json:"workspace,omitempty"`
+	MatchExpression string            `json:"matchExpression,omitempty"`
+	Options         json.RawMessage   `json:"options,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Enabled         *bool             `json:"enabled,omitempty"`
+	CreatedAt       *time.Time        `json:"createdAt,omitempty"`
+	DeletedAt       *time.Time        `json:"deletedAt,omitempty"`
+	// raw is the definition as read from a file, with any unknown fields.
+	raw json.RawMessage
+}
+
+type mockConflict struct {
+	Index  *int   `json:"index,omitempty"`
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	IDs    []int  `json:"ids"`
+}
+
+type dbQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const mockDefinitionColumns = `id, path, method, request_body::text, response_body, response_status_code,
+	headers, is_template, weight, host, workspace, match_expression, options::text, labels::text, enabled, created_at, deleted_at`
+
+func scanMockDefinition(rows *sql.Rows) (mockDefinition, error) {
+	var def mockDefinition
+	var requestBody, headers, host, workspace, expression, options, labels sql.NullString
+	var status, weight sql.NullInt64
+	var enabled bool
+	var createdAt, deletedAt sql.NullTime
+	err := rows.Scan(&def.ID, &def.Path, &def.Method, &requestBody, &def.ResponseBody, &status,
+		&headers, &def.IsTemplate, &weight, &host, &workspace, &expression, &options, &labels, &enabled, &createdAt, &deletedAt)
+	if err != nil {
+		return def, err
+	}
+	if labels.Valid {
+		if err := json.Unmarshal([]byte(labels.String), &def.Labels); err != nil {
+			return def, fmt.Errorf("mock %d: invalid labels: %v", def.ID, err)
+		}
+	}
+	def.Enabled = &enabled
+	if requestBody.Valid {
+		def.RequestBody = json.RawMessage(requestBody.String)
+	}
+	if options.Valid {
+		def.Options = json.RawMessage(options.String)
+	}
+	if createdAt.Valid {
+		def.CreatedAt = &createdAt.Time
+	}
+	if deletedAt.Valid {
+		def.DeletedAt = &deletedAt.Time
+	}
+	w := int(weight.Int64)
+	def.Weight = &w
+	def.StatusCode = int(status.Int64)
+	if !status.Valid {
+		def.StatusCode = http.StatusOK
+	}
+	def.Headers = headers.String
+	def.Host = host.String
+	def.Workspace = workspace.String
+	def.MatchExpression = expression.String
+	return def, nil
+}
+
+func (d *mockDefinition) normalize() error {
+	if pathKind(d.Path) == regexpPath {
+		if _, err := compilePathRegexp(d.Path); err != nil {
+			return fmt.Errorf("invalid path regexp: %v", err)
+		}
+	} else if !strings.HasPrefix(d.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	d.Method = strings.ToUpper(strings.TrimSpace(d.Method))
+	if d.Method == "" {
+		return fmt.Errorf("method is required")
+	}
+	if d.StatusCode == 0 {
+		d.StatusCode = http.StatusOK
+	}
+	if d.Weight == nil {
+		weight := 1
+		d.Weight = &weight
+	} else if *d.Weight < 0 {
+		return fmt.Errorf("weight must not be negative")
+	}
+	if len(d.RequestBody) > 0 && string(d.RequestBody) != "null" && !json.Valid(d.RequestBody) {
+		return fmt.Errorf("requestBody is not valid JSON")
+	}
+	if string(d.RequestBody) == "null" {
+		d.RequestBody = nil
+	}
+	if string(d.Options) == "null" {
+		d.Options = nil
+	}
+	if d.Enabled == nil {
+		enabled := true
+		d.Enabled = &enabled
+	}
+	for name := range d.Labels {
+		if strings.TrimSpace(name) == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	if _, err := parseMockOptions(nullIfEmpty(string(d.Options))); err != nil {
+		return err
+	}
+	if d.MatchExpression != "" {
+		if _, err := compileMatchExpression(d.MatchExpression); err != nil {
+			return fmt.Errorf("invalid matchExpression: %v", err)
+		}
+	}
+	return nil
+}
+
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func labelsColumn(labels map[string]string) sql.NullString {
+	if len(labels) == 0 {
+		return sql.NullString{}
+	}
+	data, _ := json.Marshal(labels)
+	return nullIfEmpty(string(data))
+}
+
+// conditionKey summarizes everything besides path, method and body that
+// decides which requests a mock answers. Two mocks with equal keys compete
+// for exactly the same requests.
+func (d *mockDefinition) conditionKey() (string, error) {
+	var bodyHashValue string
+	if len(d.RequestBody) > 0 {
+		var err error
+		if bodyHashValue, err = bodyHash(string(d.RequestBody)); err != nil {
+			return "", err
+		}
+	}
+	opts, err := parseMockOptions(nullIfEmpty(string(d.Options)))
+	if err != nil {
+		return "", err
+	}
+	key, err := json.Marshal([]interface{}{
+		bodyHashValue,
+		strings.ToLower(d.Host),
+		d.Workspace,
+		// Lookups only see the mocks of the active profile.
+		d.Labels["profile"],
+		strings.TrimSpace(d.MatchExpression),
+		opts.Query,
+		opts.Match,
+		opts.Matcher,
+		soapOperationKey(opts.SOAP),
+	})
+	return string(key), err
+}
+
+// findMockConflicts returns the IDs of mocks that would answer exactly the
+// same requests as def, making the choice between them random.
+func findMockConflicts(ctx context.Context, q dbQueryer, def mockDefinition) ([]int, error) {
+	key, err := def.conditionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+mockDefinitionColumns+`
+		FROM return.mock_responses
+		WHERE path = $1 AND method = $2 AND id <> $3 AND enabled AND deleted_at IS NULL
+		ORDER BY id
+	`, def.Path, def.Method, def.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conflicts []int
+	for rows.Next() {
+		existing, err := scanMockDefinition(rows)
+		if err != nil {
+			return nil, err
+		}
+		existingKey, err := existing.conditionKey()
+		if err != nil {
+			continue
+		}
+		if existingKey == key {
+			conflicts = append(conflicts, existing.ID)
+		}
+	}
+	return conflicts, rows.Err()
+}
+
+func insertMock(ctx context.Context, tx *sql.Tx, def *mockDefinition) error {
+	var requestBody, requestBodyHash sql.NullString
+	if len(def.RequestBody) > 0 {
+		hash, err := bodyHash(string(def.RequestBody))
+		if err != nil {
+			return err
+		}
+		requestBody = nullIfEmpty(string(def.RequestBody))
+		requestBodyHash = nullIfEmpty(hash)
+	}
+
+	var createdAt time.Time
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO return.mock_responses
+			(path, method, request_body, request_body_hash, response_body, response_status_code, headers,
+			 is_template, weight, host, workspace, match_expression, options, labels, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, created_at
+	`, def.Path, def.Method, requestBody, requestBodyHash, def.ResponseBody, def.StatusCode, nullIfEmpty(def.Headers),
+		def.IsTemplate, *def.Weight, nullIfEmpty(def.Host), nullIfEmpty(def.Workspace), nullIfEmpty(def.MatchExpression),
+		nullIfEmpty(string(def.Options)), labelsColumn(def.Labels), *def.Enabled).Scan(&def.ID, &createdAt)
+	if err != nil {
+		return err
+	}
+	def.CreatedAt = &createdAt
+	return nil
+}
+
+func updateMock(ctx context.Context, tx *sql.Tx, def *mockDefinition) (bool, error) {
+	var requestBody, requestBodyHash sql.NullString
+	if len(def.RequestBody) > 0 {
+		hash, err := bodyHash(string(def.RequestBody))
+		if err != nil {
+			return false, err
+		}
+		requestBody = nullIfEmpty(string(def.RequestBody))
+		requestBodyHash = nullIfEmpty(hash)
+	}
+
+	var createdAt time.Time
+	err := tx.QueryRowContext(ctx, `
+		UPDATE return.mock_responses SET
+			path = $2, method = $3, request_body = $4, request_body_hash = $5, response_body = $6,
+			response_status_code = $7, headers = $8, is_template = $9, weight = $10, host = $11,
+			workspace = $12, match_expression = $13, options = $14, labels = $15, enabled = $16
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING created_at
+	`, def.ID, def.Path, def.Method, requestBody, requestBodyHash, def.ResponseBody, def.StatusCode, nullIfEmpty(def.Headers),
+		def.IsTemplate, *def.Weight, nullIfEmpty(def.Host), nullIfEmpty(def.Workspace), nullIfEmpty(def.MatchExpression),
+		nullIfEmpty(string(def.Options)), labelsColumn(def.Labels), *def.Enabled).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	def.CreatedAt = &createdAt
+	return true, nil
+}
+
+type mockFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (f *mockFilter) add(condition string, values ...interface{}) {
+	for _, value := range values {
+		f.args = append(f.args, value)
+		condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(f.args)), 1)
+	}
+	f.conditions = append(f.conditions, condition)
+}
+
+func (f *mockFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// addLabels restricts the filter to mocks carrying all given labels, each
+// written as "name=value" or just "name" to require the label with any value.
+func (f *mockFilter) addLabels(selectors []string) error {
+	equal := make(map[string]string)
+	for _, selector := range selectors {
+		name, value, hasValue := strings.Cut(selector, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return fmt.Errorf("invalid label selector %q", selector)
+		}
+		if hasValue {
+			equal[name] = strings.TrimSpace(value)
+		} else {
+			f.add("labels->>? IS NOT NULL", name)
+		}
+	}
+	if len(equal) > 0 {
+		data, _ := json.Marshal(equal)
+		f.add("labels @> ?::jsonb", string(data))
+	}
+	return nil
+}
+
+type bulkMockRequest struct {
+	Action    string   `json:"action"`
+	IDs       []int64  `json:"ids,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+	Workspace string   `json:"workspace,omitempty"`
+}
+
+func mockIDParam(w http.ResponseWriter, ps httprouter.Params) (int, bool) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid mock id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func decodeMockDefinitions(r *http.Request) ([]mockDefinition, bool, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, false, err
+	}
+	return parseMockDefinitions(raw)
+}
+
+// mockSchemaError lists where mock definitions depart from the published
+// schema, such as misspelled option names that would otherwise be ignored.
+type mockSchemaError struct {
+	violations []schemaViolation
+}
+
+func (e *mockSchemaError) Error() string {
+	messages := make([]string, len(e.violations))
+	for i, v := range e.violations {
+		messages[i] = v.Message
+		if v.Path != "" {
+			messages[i] = v.Path + ": " + v.Message
+		}
+	}
+	return "does not match the mock schema: " + strings.Join(messages, "; ")
+}
+
+func mockSchemaHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(adminapi.MockSchema)
+}
+
+// parseMockDefinitions accepts a single mock definition or an array of them
+// and reports which form was used. Definitions must match the mock schema.
+func parseMockDefinitions(raw []byte) ([]mockDefinition, bool, error) {
+	violations, err := validateBody(string(adminapi.MockSchema), string(raw))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(violations) > 0 {
+		return nil, false, &mockSchemaError{violations: violations}
+	}
+	trimmed := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(trimmed, "[") {
+		var defs []mockDefinition
+		err := json.Unmarshal(raw, &defs)
+		return defs, true, err
+	}
+	var def mockDefinition
+	err = json.Unmarshal(raw, &def)
+	return []mockDefinition{def}, false, err
+}
+
+const (
+	defaultMockPageSize = 100
+	maxMockPageSize     = 1000
+)
+
+type mockSortKey struct {
+	expression string
+	cast       string
+	value      func(def *mockDefinition) string
+}
+
+var mockSortKeys = map[string]mockSortKey{
+	"id":     {"id", "integer", func(def *mockDefinition) string { return strconv.Itoa(def.ID) }},
+	"path":   {"path", "text", func(def *mockDefinition) string { return def.Path }},
+	"method": {"method", "text", func(def *mockDefinition) string { return def.Method }},
+	"status": {"COALESCE(response_status_code, 200)", "integer", func(def *mockDefinition) string { return strconv.Itoa(def.StatusCode) }},
+	"createdAt": {"COALESCE(created_at, 'epoch'::timestamp)", "timestamp", func(def *mockDefinition) string {
+		if def.CreatedAt == nil {
+			return "epoch"
+		}
+		return def.CreatedAt.Format(time.RFC3339Nano)
+	}},
+}
+
+// mockCursor marks the last mock of a page: its sort value and ID.
+type mockCursor struct {
+	Sort  string `json:"sort"`
+	Value string `json:"value"`
+	ID    int    `json:"id"`
+}
+
+func (c mockCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMockCursor(value string) (mockCursor, error) {
+	var cursor mockCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return cursor, fmt.Errorf("invalid cursor")
+	}
+	return cursor, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// adminRequestError is a problem with the caller's input rather than with
+// storage.
+type adminRequestError string
+
+func (e adminRequestError) Error() string { return string(e) }
+
+type mockConflictError struct {
+	message   string
+	conflicts []mockConflict
+}
+
+func (e *mockConflictError) Error() string { return e.message }
+
+var errMockNotFound = errors.New("mock not found")
+
+// writeAdminError reports err with the matching status; anything that is not
+// the caller's fault is logged and hidden behind message.
+func writeAdminError(w http.ResponseWriter, err error, message string) {
+	var requestErr adminRequestError
+	var conflictErr *mockConflictError
+	switch {
+	case errors.As(err, &requestErr):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": requestErr.Error()})
+	case errors.As(err, &conflictErr):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"error": conflictErr.message, "conflicts": conflictErr.conflicts})
+	case errors.Is(err, errMockNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": message})
+		log.Printf("Error %s: %v", strings.TrimPrefix(message, "error "), err)
+	}
+}
+
+// listMocks returns one page of mocks. Filters combine with AND, q searches
+// path, request body and response body, sort names a field with an optional
+// "-" prefix for descending order, and cursor continues after the previous
+// page's nextCursor.
+func listMocks(ctx context.Context, query url.Values) ([]mockDefinition, string, error) {
+	filter := mockFilter{conditions: []string{"deleted_at IS NULL"}}
+	if err := filter.addLabels(query["label"]); err != nil {
+		return nil, "", adminRequestError(err.Error())
+	}
+	if value := query.Get("enabled"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, "", adminRequestError("enabled must be true or false")
+		}
+		filter.add("enabled = ?", enabled)
+	}
+	if method := query.Get("method"); method != "" {
+		filter.add("method = ?", strings.ToUpper(method))
+	}
+	if value := query.Get("status"); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, "", adminRequestError("status must be a number")
+		}
+		filter.add("COALESCE(response_status_code, 200) = ?", status)
+	}
+	if workspace := query.Get("workspace"); workspace != "" {
+		filter.add("workspace = ?", workspace)
+	}
+	if search := query.Get("q"); search != "" {
+		pattern := "%" + escapeLike(search) + "%"
+		filter.add("(path ILIKE ? OR request_body::text ILIKE ? OR response_body ILIKE ?)", pattern, pattern, pattern)
+	}
+
+	sortName := query.Get("sort")
+	descending := strings.HasPrefix(sortName, "-")
+	sortName = strings.TrimPrefix(sortName, "-")
+	if sortName == "" {
+		sortName = "id"
+	}
+	sortKey, ok := mockSortKeys[sortName]
+	if !ok {
+		return nil, "", adminRequestError("sort must be one of id, path, method, status and createdAt")
+	}
+	direction, comparison := "ASC", ">"
+	if descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	limit := defaultMockPageSize
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return nil, "", adminRequestError("limit must be a positive number")
+		}
+		if limit > maxMockPageSize {
+			limit = maxMockPageSize
+		}
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeMockCursor(value)
+		if err == nil && cursor.Sort != query.Get("sort") {
+			err = fmt.Errorf("cursor belongs to a different sort order")
+		}
+		if err != nil {
+			return nil, "", adminRequestError(err.Error())
+		}
+		filter.add(fmt.Sprintf("(%s, id) %s (?::%s, ?)", sortKey.expression, comparison, sortKey.cast), cursor.Value, cursor.ID)
+	}
+
+	statement := "SELECT " + mockDefinitionColumns + " FROM return.mock_responses" + filter.where() +
+		fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %d", sortKey.expression, direction, direction, limit+1)
+	rows, err := db.QueryContext(ctx, statement, filter.args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	mocks := []mockDefinition{}
+	for rows.Next() {
+		def, err := scanMockDefinition(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		mocks = append(mocks, def)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(mocks) > limit {
+		mocks = mocks[:limit]
+		last := &mocks[limit-1]
+		nextCursor = mockCursor{Sort: query.Get("sort"), Value: sortKey.value(last), ID: last.ID}.encode()
+	}
+	return mocks, nextCursor, nil
+}
+
+func listMocksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mocks, nextCursor, err := listMocks(r.Context(), r.URL.Query())
+	if err != nil {
+		writeAdminError(w, err, "error loading mocks")
+		return
+	}
+	response := map[string]interface{}{"mocks": mocks}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func getMock(ctx context.Context, id int) (mockDefinition, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+mockDefinitionColumns+" FROM return.mock_responses WHERE id = $1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return mockDefinition{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return mockDefinition{}, err
+		}
+		return mockDefinition{}, errMockNotFound
+	}
+	return scanMockDefinition(rows)
+}
+
+func getMockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, ok := mockIDParam(w, ps)
+	if !ok {
+		return
+	}
+	def, err := getMock(r.Context(), id)
+	if err != nil {
+		writeAdminError(w, err, "error loading mock")
+		return
+	}
+	writeJSON(w, http.StatusOK, def)
+}
+
+// createMocks inserts mocks in one transaction. Mocks that would compete with
+// existing ones for the same requests fail the whole batch unless force is
+// set; the overlaps are returned either way.
+func createMocks(ctx context.Context, defs []mockDefinition, force bool) ([]mockConflict, error) {
+	for i := range defs {
+		defs[i].ID = 0
+		if err := defs[i].normalize(); err != nil {
+			return nil, adminRequestError(fmt.Sprintf("mock %d: %v", i, err))
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var conflicts []mockConflict
+	for i := range defs {
+		ids, err := findMockConflicts(ctx, tx, defs[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > 0 {
+			index := i
+			conflicts = append(conflicts, mockConflict{Index: &index, Path: defs[i].Path, Method: defs[i].Method, IDs: ids})
+		}
+		if err := insertMock(ctx, tx, &defs[i]); err != nil {
+			return nil, err
+		}
+	}
+	if len(conflicts) > 0 && !force {
+		return nil, &mockConflictError{
+			message:   "mocks would overlap existing mocks for the same requests; retry with force=true to create them anyway",
+			conflicts: conflicts,
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(defs))
+	for i := range defs {
+		ids[i] = defs[i].ID
+	}
+	mocksChanged(ids...)
+	return conflicts, nil
+}
+
+// createMocksHandler inserts one mock, or a whole array of them as an import.
+func createMocksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defs, isImport, err := decodeMockDefinitions(r)
+	var schemaErr *mockSchemaError
+	if errors.As(err, &schemaErr) {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":      "mock definition does not match the mock schema",
+			"violations": schemaErr.violations,
+		})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid mock definition: " + err.Error()})
+		return
+	}
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+	conflicts, err := createMocks(r.Context(), defs, force)
+	if err != nil {
+		writeAdminError(w, err, "error saving mocks")
+		return
+	}
+
+	response := map[string]interface{}{}
+	if isImport {
+		response["mocks"] = defs
+	} else {
+		response["mock"] = defs[0]
+	}
+	if len(conflicts) > 0 {
+		response["conflicts"] = conflicts
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// saveMock replaces the mock with def's ID, refusing overlaps with other
+// mocks unless force is set.
+func saveMock(ctx context.Context, def *mockDefinition, force bool) ([]mockConflict, error) {
+	if err := def.normalize(); err != nil {
+		return nil, adminRequestError(err.Error())
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids, err := findMockConflicts(ctx, tx, *def)
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []mockConflict
+	if len(ids) > 0 {
+		conflicts = append(conflicts, mockConflict{Path: def.Path, Method: def.Method, IDs: ids})
+		if !force {
+			return nil, &mockConflictError{
+				message:   "mock would overlap existing mocks for the same requests; retry with force=true to save it anyway",
+				conflicts: conflicts,
+			}
+		}
+	}
+
+	found, err := updateMock(ctx, tx, def)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errMockNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	mocksChanged(def.ID)
+	return conflicts, nil
+}
+
+func updateMockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, ok := mockIDParam(w, ps)
+	if !ok {
+		return
+	}
+	var def mockDefinition
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid mock definition: " + err.Error()})
+		return
+	}
+	def.ID = id
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+	conflicts, err := saveMock(r.Context(), &def, force)
+	if err != nil {
+		writeAdminError(w, err, "error saving mock")
+		return
+	}
+
+	response := map[string]interface{}{"mock": def}
+	if len(conflicts) > 0 {
+		response["conflicts"] = conflicts
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func trashMock(ctx context.Context, id int) error {
+	result, err := db.ExecContext(ctx, "UPDATE return.mock_responses SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errMockNotFound
+	}
+	mocksChanged(id)
+	return nil
+}
+
+func deleteMockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, ok := mockIDParam(w, ps)
+	if !ok {
+		return
+	}
+	if err := trashMock(r.Context(), id); err != nil {
+		writeAdminError(w, err, "error deleting mock")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bulkMocksHandler enables, disables or deletes every mock matching the
+// given IDs, labels and workspace.
+func bulkMocksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req bulkMockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid bulk request: " + err.Error()})
+		return
+	}
+	if len(req.IDs) == 0 && len(req.Labels) == 0 && req.Workspace == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "at least one of ids, labels and workspace is required"})
+		return
+	}
+
+	filter := mockFilter{conditions: []string{"deleted_at IS NULL"}}
+	if len(req.IDs) > 0 {
+		filter.add("id = ANY(?)", pq.Array(req.IDs))
+	}
+	if err := filter.addLabels(req.Labels); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.Workspace != "" {
+		filter.add("workspace = ?", req.Workspace)
+	}
+
+	var statement string
+	switch req.Action {
+	case "enable":
+		statement = "UPDATE return.mock_responses SET enabled = true"
+	case "disable":
+		statement = "UPDATE return.mock_responses SET enabled = false"
+	case "delete":
+		statement = "UPDATE return.mock_responses SET deleted_at = now()"
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "action must be enable, disable or delete"})
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), statement+filter.where()+" RETURNING id", filter.args...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error updating mocks"})
+		log.Printf("Error running bulk %s on mocks: %v", req.Action, err)
+		return
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error updating mocks"})
+			log.Printf("Error running bulk %s on mocks: %v", req.Action, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error updating mocks"})
+		log.Printf("Error running bulk %s on mocks: %v", req.Action, err)
+		return
+	}
+	if len(ids) > 0 {
+		mocksChanged(ids...)
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"affected": int64(len(ids))})
+}
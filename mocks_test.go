@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestConditionKeyProfiles(t *testing.T) {
+	mock := func(profile string) mockDefinition {
+		def := mockDefinition{Path: "/api/orders", Method: "GET"}
+		if profile != "" {
+			def.Labels = map[string]string{"profile": profile, "team": profile + "-team"}
+		}
+		return def
+	}
+	key := func(def mockDefinition) string {
+		k, err := def.conditionKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	happy, outage := key(mock("happy-path")), key(mock("provider-outage"))
+	if happy == outage {
+		t.Error("mocks of different profiles conflict")
+	}
+	if happy == key(mock("")) {
+		t.Error("a profile mock conflicts with the mock outside profiles")
+	}
+	duplicate := mock("happy-path")
+	duplicate.Labels["team"] = "other"
+	if key(duplicate) != happy {
+		t.Error("mocks of the same profile do not conflict")
+	}
+}
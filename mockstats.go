@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+var mockHitsTotal = expvar.NewMap("mock_hits_total")
+
+type mockHits struct {
+	hits      int64
+	lastHit   time.Time
+	totalTime time.Duration
+}
+
+// mockStatsStore counts the requests served by each mock since startup or
+// the last reset. The counters live in memory only.
+type mockStatsStore struct {
+	mu    sync.Mutex
+	since time.Time
+	mocks map[int]*mockHits
+	// dirty holds the mocks hit since their last_hit_at was last written.
+	dirty map[int]struct{}
+}
+
+var mockStats = &mockStatsStore{since: time.Now(), mocks: make(map[int]*mockHits), dirty: make(map[int]struct{})}
+
+func (s *mockStatsStore) record(id int, at time.Time, duration time.Duration) {
+	s.mu.Lock()
+	hits := s.mocks[id]
+	if hits == nil {
+		hits = &mockHits{}
+		s.mocks[id] = hits
+	}
+	hits.hits++
+	hits.totalTime += duration
+	if at.After(hits.lastHit) {
+		hits.lastHit = at
+	}
+	s.dirty[id] = struct{}{}
+	s.mu.Unlock()
+	mockHitsTotal.Add(strconv.Itoa(id), 1)
+}
+
+func (s *mockStatsStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.since = time.Now()
+	s.mocks = make(map[int]*mockHits)
+	mockHitsTotal.Init()
+}
+
+// flush stores the time of the latest hit of every mock hit since the last
+// flush in last_hit_at, which outlives restarts. The time written is the
+// flush time, so it is accurate to the flush interval.
+func (s *mockStatsStore) flush(ctx context.Context) error {
+	s.mu.Lock()
+	ids := make([]int64, 0, len(s.dirty))
+	for id := range s.dirty {
+		ids = append(ids, int64(id))
+	}
+	s.dirty = make(map[int]struct{})
+	s.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := db.ExecContext(ctx, "UPDATE return.mock_responses SET last_hit_at = now() WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		s.mu.Lock()
+		for _, id := range ids {
+			s.dirty[int(id)] = struct{}{}
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func startMockStatsFlusher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := mockStats.flush(ctx); err != nil {
+				log.Printf("Error writing mock hit times: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+type mockStat struct {
+	ID           int        `json:"id"`
+	Method       string     `json:"method"`
+	Path         string     `json:"path"`
+	Workspace    string     `json:"workspace"`
+	Hits         int64      `json:"hits"`
+	LastHit      *time.Time `json:"lastHit,omitempty"`
+	AvgLatencyMs float64    `json:"avgLatencyMs"`
+}
+
+func mockStatsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	unused := query.Get("unused") == "true"
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "hits"
+	}
+	if sortBy != "hits" && sortBy != "lastHit" && sortBy != "latency" && sortBy != "id" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "sort must be hits, lastHit, latency or id"})
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT id, method, path, COALESCE(workspace, ''), last_hit_at FROM return.mock_responses WHERE deleted_at IS NULL
+	`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error loading mock stats"})
+		log.Printf("Error loading mock stats: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	mockStats.mu.Lock()
+	since := mockStats.since
+	stats := []mockStat{}
+	for rows.Next() {
+		var stat mockStat
+		var lastHit sql.NullTime
+		if err := rows.Scan(&stat.ID, &stat.Method, &stat.Path, &stat.Workspace, &lastHit); err != nil {
+			mockStats.mu.Unlock()
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error loading mock stats"})
+			log.Printf("Error loading mock stats: %v", err)
+			return
+		}
+		if hits := mockStats.mocks[stat.ID]; hits != nil {
+			if unused {
+				continue
+			}
+			lastHit := hits.lastHit
+			stat.Hits = hits.hits
+			stat.LastHit = &lastHit
+			stat.AvgLatencyMs = float64((hits.totalTime / time.Duration(hits.hits)).Microseconds()) / 1000
+		} else if lastHit.Valid {
+			// Hit before the counters started, by this or another instance.
+			stat.LastHit = &lastHit.Time
+		}
+		stats = append(stats, stat)
+	}
+	mockStats.mu.Unlock()
+	if err := rows.Err(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error loading mock stats"})
+		log.Printf("Error loading mock stats: %v", err)
+		return
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		switch sortBy {
+		case "lastHit":
+			if (a.LastHit == nil) != (b.LastHit == nil) {
+				return a.LastHit != nil
+			}
+			if a.LastHit != nil && !a.LastHit.Equal(*b.LastHit) {
+				return a.LastHit.After(*b.LastHit)
+			}
+		case "latency":
+			if a.AvgLatencyMs != b.AvgLatencyMs {
+				return a.AvgLatencyMs > b.AvgLatencyMs
+			}
+		case "hits":
+			if a.Hits != b.Hits {
+				return a.Hits > b.Hits
+			}
+		}
+		return a.ID < b.ID
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"since": since, "mocks": stats})
+}
+
+func resetMockStatsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mockStats.reset()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// envelopeBody is the value in an envelope replaced by the mock's body.
+const envelopeBody = "$body"
+
+// responseMutator changes the responses of matching mocks once they are
+// built, the way a gateway in front of the real service would.
+type responseMutator struct {
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+	// Status lists the codes, or classes such as "2xx", the mutator applies
+	// to; all by default.
+	Status []string `json:"status,omitempty"`
+
+	// Headers are set unless the mock sets them itself; values are
+	// templates.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Envelope wraps JSON bodies: a JSON value with the body in place of
+	// "$body", where other strings are templates.
+	Envelope     interface{}         `json:"envelope,omitempty"`
+	ServerTiming *serverTimingMetric `json:"serverTiming,omitempty"`
+}
+
+// serverTimingMetric is added to the Server-Timing header. Without a
+// Duration the time spent on the request so far is reported.
+type serverTimingMetric struct {
+	Name        string       `json:"name"`
+	Duration    jsonDuration `json:"duration,omitempty"`
+	Description string       `json:"description,omitempty"`
+}
+
+func (m *responseMutator) validate() error {
+	if m.Path != "" {
+		if _, err := path.Match(m.Path, "/"); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %v", m.Path, err)
+		}
+	}
+	for _, code := range m.Status {
+		if !statusPattern(code, 0) {
+			if _, err := strconv.Atoi(code); err != nil {
+				return fmt.Errorf("invalid status %q; use a code or a class like 5xx", code)
+			}
+		}
+	}
+	if m.Envelope != nil && !containsPlaceholder(m.Envelope, envelopeBody) {
+		return fmt.Errorf("envelope must contain %q", envelopeBody)
+	}
+	if m.ServerTiming != nil && (m.ServerTiming.Name == "" || strings.ContainsAny(m.ServerTiming.Name, " ,;=\"")) {
+		return fmt.Errorf("serverTiming needs a name without spaces or separators")
+	}
+	if len(m.Headers) == 0 && m.Envelope == nil && m.ServerTiming == nil {
+		return fmt.Errorf("the mutator changes nothing")
+	}
+	return nil
+}
+
+func containsPlaceholder(v interface{}, placeholder string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == placeholder
+	case map[string]interface{}:
+		for _, child := range v {
+			if containsPlaceholder(child, placeholder) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if containsPlaceholder(child, placeholder) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *responseMutator) matches(r *http.Request, status int) bool {
+	if m.Method != "" && !strings.EqualFold(m.Method, r.Method) {
+		return false
+	}
+	if m.Path != "" {
+		if matched, _ := path.Match(m.Path, r.URL.Path); !matched {
+			return false
+		}
+	}
+	if len(m.Status) == 0 {
+		return true
+	}
+	for _, code := range m.Status {
+		if code == strconv.Itoa(status) || statusPattern(code, status) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	responseMutatorsMu sync.RWMutex
+	responseMutators   []responseMutator
+)
+
+// loadResponseMutators reads the initial mutators from a JSON file.
+func loadResponseMutators(file string) error {
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading response mutators: %v", err)
+	}
+	var mutators []responseMutator
+	if err := json.Unmarshal(data, &mutators); err != nil {
+		return fmt.Errorf("invalid response mutators: %v", err)
+	}
+	if err := setResponseMutators(mutators); err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d response mutators from %s\n", len(mutators), file)
+	return nil
+}
+
+func setResponseMutators(mutators []responseMutator) error {
+	for i := range mutators {
+		if err := mutators[i].validate(); err != nil {
+			return fmt.Errorf("mutator %d: %v", i, err)
+		}
+	}
+	responseMutatorsMu.Lock()
+	responseMutators = mutators
+	responseMutatorsMu.Unlock()
+	return nil
+}
+
+// mutateResponse applies the matching mutators, in order, to a built mock
+// response: headers go to w, to be overridden by the mock's own, and
+// envelopes wrap the body. started is when the request came in.
+func mutateResponse(w http.ResponseWriter, r *http.Request, mockResp *MockResponse, data templateData, started time.Time) error {
+	status := mockResp.ResponseStatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	responseMutatorsMu.RLock()
+	var matched []responseMutator
+	for _, m := range responseMutators {
+		if m.matches(r, status) {
+			matched = append(matched, m)
+		}
+	}
+	responseMutatorsMu.RUnlock()
+
+	for _, m := range matched {
+		for name, value := range m.Headers {
+			rendered, err := renderString("mutator-header", value, data)
+			if err != nil {
+				return fmt.Errorf("error rendering header %s: %v", name, err)
+			}
+			w.Header().Set(name, rendered)
+		}
+		if m.ServerTiming != nil {
+			w.Header().Add("Server-Timing", m.ServerTiming.header(time.Since(started)))
+		}
+		if m.Envelope != nil && isJSONResponse(mockResp) {
+			var body interface{}
+			if err := json.Unmarshal([]byte(mockResp.ResponseBody), &body); err != nil {
+				continue
+			}
+			wrapped, err := buildEnvelope(m.Envelope, map[string]interface{}{envelopeBody: body}, data)
+			if err != nil {
+				return err
+			}
+			encoded, err := json.Marshal(wrapped)
+			if err != nil {
+				return err
+			}
+			mockResp.ResponseBody = string(encoded)
+		}
+	}
+	return nil
+}
+
+func (m *serverTimingMetric) header(elapsed time.Duration) string {
+	if m.Duration > 0 {
+		elapsed = time.Duration(m.Duration)
+	}
+	value := m.Name + ";dur=" + strconv.FormatFloat(float64(elapsed.Microseconds())/1000, 'f', -1, 64)
+	if m.Description != "" {
+		value += ";desc=" + strconv.Quote(m.Description)
+	}
+	return value
+}
+
+func isJSONResponse(mockResp *MockResponse) bool {
+	contentType := mockResp.contentType
+	for name, value := range parseHeaders(mockResp.Headers) {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+	}
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// buildEnvelope copies an envelope with the placeholders, such as "$body",
+// replaced by their values and its other strings rendered.
+func buildEnvelope(envelope interface{}, values map[string]interface{}, data templateData) (interface{}, error) {
+	switch v := envelope.(type) {
+	case string:
+		if value, ok := values[v]; ok {
+			return value, nil
+		}
+		rendered, err := renderString("mutator-envelope", v, data)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering envelope: %v", err)
+		}
+		return rendered, nil
+	case map[string]interface{}:
+		wrapped := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			value, err := buildEnvelope(child, values, data)
+			if err != nil {
+				return nil, err
+			}
+			wrapped[key] = value
+		}
+		return wrapped, nil
+	case []interface{}:
+		wrapped := make([]interface{}, len(v))
+		for i, child := range v {
+			value, err := buildEnvelope(child, values, data)
+			if err != nil {
+				return nil, err
+			}
+			wrapped[i] = value
+		}
+		return wrapped, nil
+	}
+	return envelope, nil
+}
+
+func listResponseMutatorsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	responseMutatorsMu.RLock()
+	mutators := responseMutators
+	responseMutatorsMu.RUnlock()
+	if mutators == nil {
+		mutators = []responseMutator{}
+	}
+	writeJSON(w, http.StatusOK, mutators)
+}
+
+// putResponseMutatorsHandler replaces all mutators.
+func putResponseMutatorsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var mutators []responseMutator
+	if err := json.NewDecoder(r.Body).Decode(&mutators); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid mutators: " + err.Error()})
+		return
+	}
+	if err := setResponseMutators(mutators); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Response mutators replaced: %d", len(mutators))
+	listResponseMutatorsHandler(w, r, nil)
+}
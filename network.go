@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// networkProfile simulates a network: each request waits out the latency
+// plus up to jitter, the response is sent at the bandwidth in bytes per
+// second, and a fraction of connections are dropped without an answer.
+type networkProfile struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Latency     jsonDuration `json:"latency,omitempty"`
+	Jitter      jsonDuration `json:"jitter,omitempty"`
+	Bandwidth   byteSize     `json:"bandwidth,omitempty"`
+	DropRate    float64      `json:"dropRate,omitempty"`
+}
+
+// networkRule applies a profile to the paths matching a glob.
+type networkRule struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+}
+
+var networkPresets = []networkProfile{
+	{Name: "2g", Description: "EDGE-class mobile network", Latency: jsonDuration(400 * time.Millisecond), Jitter: jsonDuration(150 * time.Millisecond), Bandwidth: 30 << 10, DropRate: 0.02},
+	{Name: "3g", Description: "HSPA mobile network", Latency: jsonDuration(150 * time.Millisecond), Jitter: jsonDuration(60 * time.Millisecond), Bandwidth: 200 << 10, DropRate: 0.01},
+	{Name: "4g", Description: "LTE mobile network", Latency: jsonDuration(50 * time.Millisecond), Jitter: jsonDuration(20 * time.Millisecond), Bandwidth: 2 << 20, DropRate: 0.002},
+	{Name: "satellite", Description: "Geostationary satellite link", Latency: jsonDuration(600 * time.Millisecond), Jitter: jsonDuration(100 * time.Millisecond), Bandwidth: 250 << 10, DropRate: 0.03},
+	{Name: "lossy-wifi", Description: "Congested Wi-Fi dropping connections", Latency: jsonDuration(30 * time.Millisecond), Jitter: jsonDuration(200 * time.Millisecond), Bandwidth: 1 << 20, DropRate: 0.1},
+	{Name: "offline", Description: "Every connection is dropped", DropRate: 1},
+}
+
+type networkRegistry struct {
+	mu       sync.RWMutex
+	profiles map[string]networkProfile
+	active   string
+	rules    []networkRule
+}
+
+var network = newNetworkRegistry()
+
+func newNetworkRegistry() *networkRegistry {
+	reg := &networkRegistry{profiles: make(map[string]networkProfile)}
+	for _, preset := range networkPresets {
+		reg.profiles[preset.Name] = preset
+	}
+	return reg
+}
+
+func (reg *networkRegistry) define(def networkProfile) error {
+	def.Name = strings.ToLower(strings.TrimSpace(def.Name))
+	if def.Name == "" || def.Name == "none" {
+		return fmt.Errorf("a network profile name other than none is required")
+	}
+	if def.DropRate < 0 || def.DropRate > 1 {
+		return fmt.Errorf("network profile %s: dropRate must be between 0 and 1", def.Name)
+	}
+	if def.Latency < 0 || def.Jitter < 0 || def.Bandwidth < 0 {
+		return fmt.Errorf("network profile %s: latency, jitter and bandwidth must not be negative", def.Name)
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.profiles[def.Name] = def
+	return nil
+}
+
+// configure sets the profile applied to all requests and the per-path rules.
+func (reg *networkRegistry) configure(active string, rules []networkRule) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	active = strings.ToLower(strings.TrimSpace(active))
+	if active == "none" {
+		active = ""
+	}
+	if _, ok := reg.profiles[active]; !ok && active != "" {
+		return fmt.Errorf("unknown network profile %q", active)
+	}
+	for i := range rules {
+		rules[i].Profile = strings.ToLower(strings.TrimSpace(rules[i].Profile))
+		if _, err := path.Match(rules[i].Path, "/"); err != nil || rules[i].Path == "" {
+			return fmt.Errorf("rule %d: invalid path pattern %q", i, rules[i].Path)
+		}
+		if _, ok := reg.profiles[rules[i].Profile]; !ok && rules[i].Profile != "none" {
+			return fmt.Errorf("rule %d: unknown network profile %q", i, rules[i].Profile)
+		}
+	}
+	reg.active = active
+	reg.rules = rules
+	return nil
+}
+
+// lookup returns the profile a request runs under: the X-Mock-Network header
+// when present, then the first rule matching its path, then the active
+// profile. "none" turns shaping off.
+func (reg *networkRegistry) lookup(r *http.Request) (networkProfile, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	name := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Mock-Network")))
+	if name == "" {
+		name = reg.active
+		for _, rule := range reg.rules {
+			if matched, _ := path.Match(rule.Path, r.URL.Path); matched {
+				name = rule.Profile
+				break
+			}
+		}
+	}
+	def, ok := reg.profiles[name]
+	return def, ok
+}
+
+func (reg *networkRegistry) snapshot() (string, []networkRule, []networkProfile) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	list := make([]networkProfile, 0, len(reg.profiles))
+	for _, def := range reg.profiles {
+		list = append(list, def)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	rules := append([]networkRule{}, reg.rules...)
+	return reg.active, rules, list
+}
+
+// loadNetworkProfiles defines the profiles of a JSON file holding an array
+// of them and activates the initial one, if any.
+func loadNetworkProfiles(file, active string) error {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading network profiles: %v", err)
+		}
+		var defined []networkProfile
+		if err := json.Unmarshal(data, &defined); err != nil {
+			return fmt.Errorf("invalid network profiles: %v", err)
+		}
+		for _, def := range defined {
+			if err := network.define(def); err != nil {
+				return err
+			}
+		}
+	}
+	if active != "" {
+		return network.configure(active, nil)
+	}
+	return nil
+}
+
+// shapeTraffic applies the request's network profile. It returns the writer
+// to answer through, throttled to the profile's bandwidth, and false when
+// the client disconnected during the latency. Dropped connections abort the
+// handler.
+func shapeTraffic(w http.ResponseWriter, r *http.Request, data templateData) (http.ResponseWriter, bool) {
+	def, ok := network.lookup(r)
+	if !ok {
+		return w, true
+	}
+	delay := time.Duration(def.Latency)
+	if def.Jitter > 0 {
+		delay += time.Duration(data.rand.Int63n(int64(def.Jitter)))
+	}
+	if delay > 0 && !sleepContext(r.Context(), delay) {
+		return w, false
+	}
+	if def.DropRate > 0 && data.rand.Float64() < def.DropRate {
+		requestLogf(r, "Dropping connection for %s %s (network profile %s)", r.Method, r.URL.Path, def.Name)
+		panic(http.ErrAbortHandler)
+	}
+	if def.Bandwidth > 0 {
+		return &throttledWriter{ResponseWriter: w, ctx: r.Context(), rate: int(def.Bandwidth)}, true
+	}
+	return w, true
+}
+
+// throttledWriter writes at most rate bytes per second, in chunks flushed
+// ten times a second.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx  context.Context
+	rate int
+}
+
+func (t *throttledWriter) Write(b []byte) (int, error) {
+	chunk := t.rate / 10
+	if chunk < 1 {
+		chunk = 1
+	}
+	controller := http.NewResponseController(t.ResponseWriter)
+	written := 0
+	for len(b) > 0 {
+		n := min(chunk, len(b))
+		m, err := t.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+		controller.Flush()
+		if len(b) > 0 && !sleepContext(t.ctx, time.Duration(n)*time.Second/time.Duration(t.rate)) {
+			return written, t.ctx.Err()
+		}
+	}
+	return written, nil
+}
+
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func getNetworkHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	active, rules, list := network.snapshot()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"active": active, "rules": rules, "profiles": list})
+}
+
+// setNetworkHandler sets the active profile and the per-path rules.
+func setNetworkHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body struct {
+		Active string        `json:"active"`
+		Rules  []networkRule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid network settings: " + err.Error()})
+		return
+	}
+	if err := network.configure(body.Active, body.Rules); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Network shaping set: active %q, %d rules", body.Active, len(body.Rules))
+	getNetworkHandler(w, r, nil)
+}
+
+func resetNetworkHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	network.configure("", nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func putNetworkProfileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var def networkProfile
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid network profile: " + err.Error()})
+		return
+	}
+	def.Name = ps.ByName("name")
+	if err := network.define(def); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	def.Name = strings.ToLower(strings.TrimSpace(def.Name))
+	writeJSON(w, http.StatusOK, def)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const oauthPathPrefix = "/__oauth/"
+
+type oauthIssuer struct {
+	issuer         string
+	key            *jwtKey
+	tokenTTL       time.Duration
+	defaultSubject string
+	clients        map[string]string
+	claims         *template.Template
+
+	mu     sync.Mutex
+	grants map[string]oauthGrant
+}
+
+type oauthGrant struct {
+	Kind        string
+	ClientID    string
+	Subject     string
+	Scope       string
+	Nonce       string
+	RedirectURI string
+	ExpiresAt   time.Time
+}
+
+type oauthClaimsData struct {
+	GrantType string
+	ClientID  string
+	Subject   string
+	Scope     string
+	Form      map[string]string
+}
+
+func newOAuthIssuer() (*oauthIssuer, error) {
+	key, err := newJWTKey(
+		envString("OAUTH_KEY_ID", ""),
+		envString("OAUTH_SIGNING_ALG", "RS256"),
+		envString("OAUTH_SIGNING_KEY_FILE", ""),
+		envString("OAUTH_SIGNING_SECRET", ""),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error loading signing key: %v", err)
+	}
+
+	claims, err := parseTemplate("oauth-claims", envString("OAUTH_CLAIMS_TEMPLATE", "{}"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing claims template: %v", err)
+	}
+
+	return &oauthIssuer{
+		issuer:         strings.TrimSuffix(envString("OAUTH_ISSUER", "http://localhost:8080/__oauth"), "/"),
+		key:            key,
+		tokenTTL:       envDuration("OAUTH_TOKEN_TTL", time.Hour),
+		defaultSubject: envString("OAUTH_DEFAULT_SUBJECT", "mock-user"),
+		clients:        parseOAuthClients(envString("OAUTH_CLIENTS", "")),
+		claims:         claims,
+		grants:         make(map[string]oauthGrant),
+	}, nil
+}
+
+func parseOAuthClients(value string) map[string]string {
+	clients := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, ":", 2)
+		if len(kv) == 2 {
+			clients[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		} else {
+			clients[entry] = ""
+		}
+	}
+	return clients
+}
+
+func (o *oauthIssuer) router() *httprouter.Router {
+	router := httprouter.New()
+	router.GET(oauthPathPrefix+".well-known/openid-configuration", o.discoveryHandler)
+	router.GET(oauthPathPrefix+"jwks.json", o.jwksHandler)
+	router.GET(oauthPathPrefix+"authorize", o.authorizeHandler)
+	router.POST(oauthPathPrefix+"token", o.tokenHandler)
+	router.GET(oauthPathPrefix+"userinfo", o.userinfoHandler)
+	return router
+}
+
+func (o *oauthIssuer) discoveryHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                o.issuer,
+		"authorization_endpoint":                o.issuer + "/authorize",
+		"token_endpoint":                        o.issuer + "/token",
+		"userinfo_endpoint":                     o.issuer + "/userinfo",
+		"jwks_uri":                              o.issuer + "/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials", "password", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{o.key.Algorithm},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (o *oauthIssuer) jwksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	keys := []interface{}{}
+	if jwk := o.key.jwk(); jwk != nil {
+		keys = append(keys, jwk)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+func (o *oauthIssuer) authorizeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	params := redirectURI.Query()
+	if state := query.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+
+	if query.Get("response_type") != "code" {
+		params.Set("error", "unsupported_response_type")
+	} else if _, known := o.clients[query.Get("client_id")]; len(o.clients) > 0 && !known {
+		params.Set("error", "unauthorized_client")
+	} else {
+		subject := query.Get("login_hint")
+		if subject == "" {
+			subject = o.defaultSubject
+		}
+		code := newUUID()
+		o.storeGrant(code, oauthGrant{
+			Kind:        "code",
+			ClientID:    query.Get("client_id"),
+			Subject:     subject,
+			Scope:       query.Get("scope"),
+			Nonce:       query.Get("nonce"),
+			RedirectURI: query.Get("redirect_uri"),
+			ExpiresAt:   time.Now().Add(5 * time.Minute),
+		})
+		params.Set("code", code)
+	}
+
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (o *oauthIssuer) tokenHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+	if len(o.clients) > 0 {
+		if secret, known := o.clients[clientID]; !known || secret != clientSecret {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "unknown client or bad credentials")
+			return
+		}
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	grant := oauthGrant{ClientID: clientID, Scope: r.PostForm.Get("scope")}
+
+	switch grantType {
+	case "client_credentials":
+		grant.Subject = clientID
+	case "password":
+		grant.Subject = r.PostForm.Get("username")
+		if grant.Subject == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "username is required")
+			return
+		}
+	case "authorization_code", "refresh_token":
+		token := r.PostForm.Get("code")
+		kind := "code"
+		if grantType == "refresh_token" {
+			token = r.PostForm.Get("refresh_token")
+			kind = "refresh"
+		}
+		stored, found := o.takeGrant(token, kind)
+		if !found || stored.ClientID != clientID {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired grant")
+			return
+		}
+		if kind == "code" && stored.RedirectURI != r.PostForm.Get("redirect_uri") {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+			return
+		}
+		grant = stored
+		if scope := r.PostForm.Get("scope"); scope != "" && kind == "refresh" {
+			grant.Scope = scope
+		}
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant_type: "+grantType)
+		return
+	}
+
+	data := oauthClaimsData{
+		GrantType: grantType,
+		ClientID:  grant.ClientID,
+		Subject:   grant.Subject,
+		Scope:     grant.Scope,
+		Form:      make(map[string]string),
+	}
+	for key := range r.PostForm {
+		data.Form[key] = r.PostForm.Get(key)
+	}
+
+	resp, err := o.issueTokens(grant, data)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "token issuance failed")
+		log.Printf("OAuth token issuance error: %v", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (o *oauthIssuer) issueTokens(grant oauthGrant, data oauthClaimsData) (map[string]interface{}, error) {
+	extra, err := o.renderClaims(data)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":       o.issuer,
+		"sub":       grant.Subject,
+		"aud":       grant.ClientID,
+		"client_id": grant.ClientID,
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"exp":       now.Add(o.tokenTTL).Unix(),
+		"jti":       newUUID(),
+	}
+	if grant.Scope != "" {
+		claims["scope"] = grant.Scope
+	}
+	for key, value := range extra {
+		claims[key] = value
+	}
+
+	accessToken, err := o.key.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(o.tokenTTL.Seconds()),
+	}
+	if grant.Scope != "" {
+		resp["scope"] = grant.Scope
+	}
+
+	if data.GrantType != "client_credentials" {
+		refreshToken := newUUID()
+		refreshGrant := grant
+		refreshGrant.Kind = "refresh"
+		refreshGrant.ExpiresAt = now.Add(24 * time.Hour)
+		o.storeGrant(refreshToken, refreshGrant)
+		resp["refresh_token"] = refreshToken
+	}
+
+	if hasScope(grant.Scope, "openid") {
+		idClaims := map[string]interface{}{
+			"iss": o.issuer,
+			"sub": grant.Subject,
+			"aud": grant.ClientID,
+			"iat": now.Unix(),
+			"exp": now.Add(o.tokenTTL).Unix(),
+		}
+		if grant.Nonce != "" {
+			idClaims["nonce"] = grant.Nonce
+		}
+		for key, value := range extra {
+			idClaims[key] = value
+		}
+		idToken, err := o.key.sign(idClaims)
+		if err != nil {
+			return nil, err
+		}
+		resp["id_token"] = idToken
+	}
+
+	return resp, nil
+}
+
+func (o *oauthIssuer) renderClaims(data oauthClaimsData) (map[string]interface{}, error) {
+	rendered, err := executeTemplate(o.claims, data)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering claims template: %v", err)
+	}
+
+	extra := make(map[string]interface{})
+	if strings.TrimSpace(rendered) == "" {
+		return extra, nil
+	}
+	if err := json.Unmarshal([]byte(rendered), &extra); err != nil {
+		return nil, fmt.Errorf("claims template did not produce a JSON object: %v", err)
+	}
+	return extra, nil
+}
+
+func (o *oauthIssuer) userinfoHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	claims, err := o.key.verify(stripBearer(r.Header.Get("Authorization")))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, claims)
+}
+
+func (o *oauthIssuer) storeGrant(token string, grant oauthGrant) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	for key, existing := range o.grants {
+		if now.After(existing.ExpiresAt) {
+			delete(o.grants, key)
+		}
+	}
+	o.grants[token] = grant
+}
+
+func (o *oauthIssuer) takeGrant(token, kind string) (oauthGrant, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	grant, found := o.grants[token]
+	if !found || grant.Kind != kind || time.Now().After(grant.ExpiresAt) {
+		return oauthGrant{}, false
+	}
+	delete(o.grants, token)
+	return grant, true
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type mockOptions struct {
+	Webhooks []webhookOptions     `json:"webhooks,omitempty"`
+	Events   []string             `json:"events,omitempty"`
+	Kafka    []kafkaEventOptions  `json:"kafka,omitempty"`
+	AMQP     []amqpMessageOptions `json:"amqp,omitempty"`
+	Mirror   mirrorOptions        `json:"mirror,omitempty"`
+
+	RateLimit      *rateLimitOptions      `json:"rateLimit,omitempty"`
+	CircuitBreaker *circuitBreakerOptions `json:"circuitBreaker,omitempty"`
+	StatusSequence *statusSequenceOptions `json:"statusSequence,omitempty"`
+	Experiment     *experimentOptions     `json:"experiment,omitempty"`
+	Idempotency    *idempotencyOptions    `json:"idempotency,omitempty"`
+	MaxBodySize    byteSize               `json:"maxBodySize,omitempty"`
+	// RejectBeforeBody sends the mock's response to requests waiting for
+	// 100 Continue, before they upload the body.
+	RejectBeforeBody bool `json:"rejectBeforeBody,omitempty"`
+
+	// Charset transcodes the body from UTF-8 and names it in Content-Type.
+	Charset string `json:"charset,omitempty"`
+	// ContentEncoding compresses the body and sets Content-Encoding.
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	// Base64Body stores the body as base64 of the bytes to send, which
+	// Charset and ContentEncoding then only label.
+	Base64Body bool `json:"base64Body,omitempty"`
+
+	RequestSchema json.RawMessage `json:"requestSchema,omitempty"`
+	Script        string          `json:"script,omitempty"`
+
+	Query     *queryMatchOptions   `json:"query,omitempty"`
+	Match     *requestMatchOptions `json:"match,omitempty"`
+	Matcher   *pluginRef           `json:"matcher,omitempty"`
+	Responder *pluginRef           `json:"responder,omitempty"`
+
+	Protobuf *protobufOptions `json:"protobuf,omitempty"`
+	SOAP     *soapOptions     `json:"soap,omitempty"`
+	GraphQL  *graphqlOptions  `json:"graphql,omitempty"`
+	Upstream *upstreamOptions `json:"upstream,omitempty"`
+	Table    *tableOptions    `json:"table,omitempty"`
+	Download *downloadOptions `json:"download,omitempty"`
+
+	RenderCache *renderCacheOptions `json:"renderCache,omitempty"`
+	Pagination  *paginationOptions  `json:"pagination,omitempty"`
+
+	Log *logOptions `json:"log,omitempty"`
+}
+
+func parseMockOptions(raw sql.NullString) (mockOptions, error) {
+	var opts mockOptions
+	if !raw.Valid || raw.String == "" {
+		return opts, nil
+	}
+	if err := json.Unmarshal([]byte(raw.String), &opts); err != nil {
+		return opts, fmt.Errorf("invalid mock options: %v", err)
+	}
+	return opts, nil
+}
+
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = jsonDuration(time.Duration(v) * time.Millisecond)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = jsonDuration(parsed)
+	default:
+		return fmt.Errorf("duration must be a string like \"1.5s\" or milliseconds")
+	}
+	return nil
+}
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type byteSize int64
+
+func (b *byteSize) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*b = byteSize(v)
+	case string:
+		parsed, err := parseByteSize(v)
+		if err != nil {
+			return err
+		}
+		*b = byteSize(parsed)
+	default:
+		return fmt.Errorf("size must be a number of bytes or a string like \"512KB\"")
+	}
+	return nil
+}
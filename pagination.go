@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// paginationOptions describe the collection a mock pages through. The page
+// a request asks for is read from its query, and the response gets the
+// Link and total count headers of that page.
+type paginationOptions struct {
+	// Total is the size of the collection; datasets count their records.
+	Total int `json:"total,omitempty"`
+	// Style is "page" (page and per_page, the default), "offset" (offset
+	// and limit) or "cursor" (cursor and limit).
+	Style       string `json:"style,omitempty"`
+	PageSize    int    `json:"pageSize,omitempty"`
+	MaxPageSize int    `json:"maxPageSize,omitempty"`
+	PageParam   string `json:"pageParam,omitempty"`
+	SizeParam   string `json:"sizeParam,omitempty"`
+	// TotalHeader names the total count header, X-Total-Count by default;
+	// "-" leaves it out.
+	TotalHeader string `json:"totalHeader,omitempty"`
+
+	// Dataset serves pages of the JSON array in the response body instead
+	// of the whole body.
+	Dataset bool `json:"dataset,omitempty"`
+	// SortBy orders the records by a field or JSON pointer, ties keeping
+	// their order in the dataset; Order "desc" reverses it. Requests can
+	// sort otherwise with SortParam, by the Sortable fields if given.
+	SortBy    string   `json:"sortBy,omitempty"`
+	Order     string   `json:"order,omitempty"`
+	SortParam string   `json:"sortParam,omitempty"`
+	Sortable  []string `json:"sortable,omitempty"`
+	// Filters are the fields requests can filter records by, with query
+	// parameters of the same names.
+	Filters []string `json:"filters,omitempty"`
+	// SearchParam searches the Search fields, or every text of a record
+	// without them, case-insensitively.
+	SearchParam string   `json:"searchParam,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	// Fuzzy matches misspelt words too and ranks the records found by how
+	// well they match, unless the request sorts them.
+	Fuzzy bool `json:"fuzzy,omitempty"`
+	// ItemsField wraps a page in an object holding it under this name, with
+	// the total and the next cursor.
+	ItemsField string `json:"itemsField,omitempty"`
+}
+
+// pageInfo is the page a request asked for, available to templates as
+// .Page.
+type pageInfo struct {
+	Number int
+	Size   int
+	Offset int
+	Total  int
+	Pages  int
+	// Items is the number of items on the page, and Indexes their 1-based
+	// positions in the collection.
+	Items   int
+	Indexes []int
+	HasPrev bool
+	HasNext bool
+	// NextCursor is what to pass for the next page in the cursor style.
+	NextCursor string
+}
+
+// pageCursor marks where a page ends: the position of its last record and,
+// for sorted datasets, that record's sort values, so a page follows on from
+// the same record even after records were added before it.
+type pageCursor struct {
+	Offset int           `json:"offset"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+const defaultPageSize = 20
+
+var (
+	paginationStyles = []string{"page", "offset", "cursor"}
+	paginationOrders = []string{"asc", "desc"}
+)
+
+// pageRequestError is a mistake in a request's paging, sorting or filtering
+// parameters, answered with 400 Bad Request.
+type pageRequestError struct{ message string }
+
+func (e pageRequestError) Error() string { return e.message }
+
+var errInvalidCursor = pageRequestError{"invalid cursor"}
+
+func (c pageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageCursor(value string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.Offset < 0 {
+		return cursor, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+func (o *paginationOptions) params() (page, size string) {
+	page, size = o.PageParam, o.SizeParam
+	switch o.Style {
+	case "offset", "cursor":
+		if page == "" {
+			page = o.Style
+		}
+		if size == "" {
+			size = "limit"
+		}
+	default:
+		if page == "" {
+			page = "page"
+		}
+		if size == "" {
+			size = "per_page"
+		}
+	}
+	return page, size
+}
+
+// queryRules leaves the paging, sorting, filtering and search parameters
+// out of the comparison of query strings, so a mock answers every page.
+func (o *paginationOptions) queryRules(rules *queryMatchOptions) *queryMatchOptions {
+	pageParam, sizeParam := o.params()
+	extended := &queryMatchOptions{Ignore: []string{pageParam, sizeParam}}
+	if o.Dataset {
+		sortParam, searchParam := o.datasetParams()
+		extended.Ignore = append(extended.Ignore, sortParam, searchParam)
+		extended.Ignore = append(extended.Ignore, o.Filters...)
+	}
+	if rules != nil {
+		extended.Only = rules.Only
+		extended.Ignore = append(extended.Ignore, rules.Ignore...)
+	}
+	return extended
+}
+
+// requestedPage works out the page of a request, recovering from missing or
+// invalid query parameters the way most APIs do: with the first page and
+// the default size. Only an invalid cursor is an error. cursorOffset finds
+// where the page after a cursor starts.
+func (o *paginationOptions) requestedPage(query url.Values, total int, cursorOffset func(pageCursor) int) (*pageInfo, error) {
+	pageParam, sizeParam := o.params()
+	size := o.PageSize
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if n, err := strconv.Atoi(query.Get(sizeParam)); err == nil && n > 0 {
+		size = n
+	}
+	if o.MaxPageSize > 0 && size > o.MaxPageSize {
+		size = o.MaxPageSize
+	}
+
+	page := &pageInfo{Size: size, Total: max(total, 0)}
+	page.Pages = (page.Total + size - 1) / size
+	switch o.Style {
+	case "cursor":
+		if value := query.Get(pageParam); value != "" {
+			cursor, err := decodePageCursor(value)
+			if err != nil {
+				return nil, err
+			}
+			page.Offset = cursorOffset(cursor)
+		}
+		page.Number = page.Offset/size + 1
+	case "offset":
+		if n, err := strconv.Atoi(query.Get(pageParam)); err == nil && n > 0 {
+			page.Offset = n
+		}
+		page.Number = page.Offset/size + 1
+	default:
+		page.Number = 1
+		if n, err := strconv.Atoi(query.Get(pageParam)); err == nil && n > 1 {
+			page.Number = n
+		}
+		page.Offset = (page.Number - 1) * size
+	}
+	page.Items = min(max(page.Total-page.Offset, 0), size)
+	page.Indexes = make([]int, page.Items)
+	for i := range page.Indexes {
+		page.Indexes[i] = page.Offset + i + 1
+	}
+	page.HasPrev = page.Offset > 0 && page.Total > 0
+	page.HasNext = page.Offset+size < page.Total
+	if page.HasNext && o.Style == "cursor" {
+		page.NextCursor = pageCursor{Offset: page.Offset + size}.encode()
+	}
+	return page, nil
+}
+
+// linkHeader builds an RFC 8288 Link header with the first, prev, next and
+// last pages, keeping the request's other query parameters. Cursors only
+// lead forwards, so the cursor style has first and next links.
+func (o *paginationOptions) linkHeader(r *http.Request, page *pageInfo) string {
+	pageParam, sizeParam := o.params()
+	base := url.URL{Scheme: requestScheme(r), Host: r.Host, Path: r.URL.Path}
+	link := func(rel string, set func(url.Values)) string {
+		query := r.URL.Query()
+		set(query)
+		target := base
+		target.RawQuery = query.Encode()
+		return "<" + target.String() + `>; rel="` + rel + `"`
+	}
+	at := func(number, offset int) func(url.Values) {
+		return func(query url.Values) {
+			if o.Style == "offset" {
+				query.Set(pageParam, strconv.Itoa(offset))
+				query.Set(sizeParam, strconv.Itoa(page.Size))
+			} else {
+				query.Set(pageParam, strconv.Itoa(number))
+			}
+		}
+	}
+
+	if o.Style == "cursor" {
+		var links []string
+		if page.HasPrev {
+			links = append(links, link("first", func(query url.Values) { query.Del(pageParam) }))
+		}
+		if page.NextCursor != "" {
+			links = append(links, link("next", func(query url.Values) { query.Set(pageParam, page.NextCursor) }))
+		}
+		return strings.Join(links, ", ")
+	}
+
+	last := max(page.Pages, 1)
+	var links []string
+	if page.HasPrev {
+		links = append(links, link("first", at(1, 0)))
+		prev := min(page.Number-1, last)
+		links = append(links, link("prev", at(prev, max(min(page.Offset-page.Size, (last-1)*page.Size), 0))))
+	}
+	if page.HasNext {
+		links = append(links, link("next", at(page.Number+1, page.Offset+page.Size)))
+		links = append(links, link("last", at(last, (last-1)*page.Size)))
+	}
+	return strings.Join(links, ", ")
+}
+
+// setHeaders sets the Link and total count headers of a page. Headers of
+// the mock win.
+func (o *paginationOptions) setHeaders(w http.ResponseWriter, r *http.Request, page *pageInfo) {
+	if link := o.linkHeader(r, page); link != "" {
+		w.Header().Set("Link", link)
+	}
+	if name := o.TotalHeader; name != "-" {
+		if name == "" {
+			name = "X-Total-Count"
+		}
+		w.Header().Set(name, strconv.Itoa(page.Total))
+	}
+}
+
+// applyPagination sets the headers of the page a request asked for and
+// returns the page for templates. Datasets are paged once rendered, by
+// paginateDataset. An invalid cursor is answered with 400 Bad Request.
+func applyPagination(w http.ResponseWriter, r *http.Request, opts *paginationOptions, data templateData) (*pageInfo, bool) {
+	if opts == nil || opts.Dataset {
+		return nil, true
+	}
+	page, err := opts.requestedPage(data.Query, opts.Total, func(c pageCursor) int { return c.Offset })
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return nil, false
+	}
+	opts.setHeaders(w, r, page)
+	return page, true
+}
+
+// requestScheme is the scheme the client used, as told by a proxy in front
+// of the router when there is one.
+func requestScheme(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
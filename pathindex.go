@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Mock paths may be patterns: a segment ":name" matches any one segment, a
+// last segment "*name" (or "*") the rest of the path, and a path starting
+// with "~" is a regular expression the whole path must match. Literal paths
+// are found by the database's index; patterns are kept in memory in a radix
+// tree, which turns a request path into the patterns it matches in time
+// proportional to the length of the path rather than the number of mocks.
+// The lookup then reads the mocks of those patterns like literal ones.
+
+const (
+	literalPath = iota
+	paramPath
+	wildcardPath
+	regexpPath
+)
+
+var (
+	pathPatterns = envBool("PATH_PATTERNS", true)
+
+	pathIndex = &pathPatternIndex{entries: make(map[int]pathIndexEntry)}
+
+	pathIndexPatterns = expvar.NewInt("path_index_patterns")
+)
+
+func init() {
+	onMockChange(func(ids []int) {
+		if err := pathIndex.reload(ids); err != nil {
+			log.Printf("Error indexing path patterns: %v", err)
+		}
+	})
+}
+
+// pathKind tells literal paths from the kinds of patterns, least specific
+// last. Paths with a query string are literal.
+func pathKind(path string) int {
+	if !pathPatterns {
+		return literalPath
+	}
+	if strings.HasPrefix(path, "~") {
+		return regexpPath
+	}
+	if strings.Contains(path, "?") {
+		return literalPath
+	}
+	kind := literalPath
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case i == len(segments)-1 && strings.HasPrefix(segment, "*"):
+			return wildcardPath
+		case len(segment) > 1 && segment[0] == ':':
+			kind = paramPath
+		}
+	}
+	return kind
+}
+
+// compilePathRegexp compiles a "~" path, anchored at both ends.
+func compilePathRegexp(path string) (*regexp.Regexp, error) {
+	expr := "^(?:" + strings.TrimPrefix(path, "~") + ")$"
+	if pathCaseInsensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// pathParams returns the segments and named regexp groups a pattern
+// captured from a request path, in the case of the request.
+func pathParams(pattern, path string) map[string]string {
+	if pathCollapseSlashes {
+		for strings.Contains(path, "//") {
+			path = strings.ReplaceAll(path, "//", "/")
+		}
+	}
+	switch pathKind(pattern) {
+	case regexpPath:
+		re, err := compilePathRegexp(pattern)
+		if err != nil {
+			return nil
+		}
+		match := re.FindStringSubmatch(path)
+		if match == nil {
+			return nil
+		}
+		params := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				params[name] = match[i]
+			}
+		}
+		return params
+	case paramPath, wildcardPath:
+		params := make(map[string]string)
+		patternSegments := strings.Split(pattern, "/")
+		segments := strings.Split(path, "/")
+		for i, segment := range patternSegments {
+			if i >= len(segments) {
+				break
+			}
+			if i == len(patternSegments)-1 && strings.HasPrefix(segment, "*") {
+				if name := segment[1:]; name != "" {
+					params[name] = strings.Join(segments[i:], "/")
+				}
+				break
+			}
+			if len(segment) > 1 && segment[0] == ':' {
+				params[segment[1:]] = segments[i]
+			}
+		}
+		return params
+	}
+	return nil
+}
+
+// pathNode is a node of the radix tree. The static prefixes of patterns
+// are shared along the edges; a node's param and wildcard children stand
+// for the pattern segments following it, and its methods hold the patterns
+// ending there by method.
+type pathNode struct {
+	prefix   string
+	children []*pathNode
+	param    *pathNode
+	wildcard *pathNode
+	// regexps are the "~" patterns whose literal prefix ends at the node.
+	regexps []pathRegexp
+	methods map[string][]string
+}
+
+type pathRegexp struct {
+	pattern string
+	method  string
+	re      *regexp.Regexp
+}
+
+// insertStatic walks, or grows, the edges spelling s below n and returns
+// the node where s ends, splitting an edge that ends past it.
+func (n *pathNode) insertStatic(s string) *pathNode {
+	for s != "" {
+		var child *pathNode
+		index := -1
+		for i, c := range n.children {
+			if c.prefix[0] == s[0] {
+				child, index = c, i
+				break
+			}
+		}
+		if child == nil {
+			child = &pathNode{prefix: s}
+			n.children = append(n.children, child)
+			return child
+		}
+		common := 0
+		for common < len(s) && common < len(child.prefix) && s[common] == child.prefix[common] {
+			common++
+		}
+		if common < len(child.prefix) {
+			split := &pathNode{prefix: child.prefix[:common], children: []*pathNode{child}}
+			child.prefix = child.prefix[common:]
+			n.children[index] = split
+			child = split
+		}
+		n, s = child, s[common:]
+	}
+	return n
+}
+
+func (n *pathNode) addMethod(method, pattern string) {
+	if n.methods == nil {
+		n.methods = make(map[string][]string)
+	}
+	n.methods[method] = append(n.methods[method], pattern)
+}
+
+// insert adds the pattern of a mock path for a method. In case-insensitive
+// mode the tree holds, and matches return, lowercased patterns, as compared
+// with lowercased request paths.
+func (n *pathNode) insert(path, method string) error {
+	pattern := path
+	if pathCaseInsensitive {
+		pattern = strings.ToLower(path)
+	}
+	if pathKind(path) == regexpPath {
+		re, err := compilePathRegexp(path)
+		if err != nil {
+			return err
+		}
+		// A case-insensitive regexp has no literal prefix beyond its
+		// first letter, so take the prefix of the case-sensitive one.
+		plain, err := regexp.Compile("^(?:" + strings.TrimPrefix(path, "~") + ")$")
+		if err != nil {
+			return err
+		}
+		prefix, _ := plain.LiteralPrefix()
+		if pathCaseInsensitive {
+			prefix = strings.ToLower(prefix)
+		}
+		node := n.insertStatic(prefix)
+		node.regexps = append(node.regexps, pathRegexp{pattern: pattern, method: method, re: re})
+		return nil
+	}
+
+	node := n
+	static := ""
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if i > 0 {
+			static += "/"
+		}
+		switch {
+		case i == len(segments)-1 && strings.HasPrefix(segment, "*"):
+			node = node.insertStatic(static)
+			if node.wildcard == nil {
+				node.wildcard = &pathNode{}
+			}
+			node.wildcard.addMethod(method, pattern)
+			return nil
+		case len(segment) > 1 && segment[0] == ':':
+			node = node.insertStatic(static)
+			static = ""
+			if node.param == nil {
+				node.param = &pathNode{}
+			}
+			node = node.param
+		default:
+			static += segment
+		}
+	}
+	node.insertStatic(static).addMethod(method, pattern)
+	return nil
+}
+
+// collect appends the patterns for method (or ANY) matching path, of which
+// rest is left after the edges down to n.
+func (n *pathNode) collect(path, rest, method string, matched []string) []string {
+	for _, r := range n.regexps {
+		if (r.method == method || r.method == anyMethod) && r.re.MatchString(path) {
+			matched = append(matched, r.pattern)
+		}
+	}
+	if rest == "" {
+		matched = n.appendMethod(method, matched)
+	}
+	if n.wildcard != nil {
+		matched = n.wildcard.appendMethod(method, matched)
+	}
+	if n.param != nil && rest != "" && rest[0] != '/' {
+		end := strings.IndexByte(rest, '/')
+		if end < 0 {
+			end = len(rest)
+		}
+		matched = n.param.collect(path, rest[end:], method, matched)
+	}
+	if rest != "" {
+		for _, child := range n.children {
+			if child.prefix[0] == rest[0] {
+				if strings.HasPrefix(rest, child.prefix) {
+					matched = child.collect(path, rest[len(child.prefix):], method, matched)
+				}
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func (n *pathNode) appendMethod(method string, matched []string) []string {
+	matched = append(matched, n.methods[method]...)
+	if method != anyMethod {
+		matched = append(matched, n.methods[anyMethod]...)
+	}
+	return matched
+}
+
+type pathIndexEntry struct {
+	path   string
+	method string
+}
+
+// pathPatternIndex holds the patterns of enabled mocks. The tree is
+// rebuilt from the entries when mocks change and swapped in whole, so
+// lookups never wait for a change.
+type pathPatternIndex struct {
+	mu      sync.Mutex
+	entries map[int]pathIndexEntry
+	root    atomic.Pointer[pathNode]
+}
+
+// match returns the patterns matching any of paths for method, spelled as
+// the lookup compares them with the path column.
+func (x *pathPatternIndex) match(paths []string, method string) []string {
+	root := x.root.Load()
+	if root == nil {
+		return nil
+	}
+	var matched []string
+	for _, path := range paths {
+		matched = root.collect(path, path, method, matched)
+	}
+	return matched
+}
+
+// reload reads the patterns of the given mocks again, or of all mocks
+// without ids, and rebuilds the tree.
+func (x *pathPatternIndex) reload(ids []int) error {
+	if db == nil || !pathPatterns {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	query := `SELECT id, path, method FROM return.mock_responses
+		WHERE enabled AND deleted_at IS NULL
+		  AND (path LIKE '~%' OR path LIKE '%/:%' OR path LIKE '%/*%')`
+	var args []interface{}
+	if ids != nil {
+		query += " AND id = ANY($1)"
+		args = append(args, pq.Array(ids))
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	found := make(map[int]pathIndexEntry)
+	for rows.Next() {
+		var id int
+		var entry pathIndexEntry
+		if err := rows.Scan(&id, &entry.path, &entry.method); err != nil {
+			return err
+		}
+		if pathKind(entry.path) != literalPath {
+			found[id] = entry
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if ids == nil {
+		x.entries = found
+		x.rebuild()
+		return nil
+	}
+	// Most changes leave the paths alone; the tree is only rebuilt, which
+	// takes about a second for 100k patterns, when a pattern changed.
+	changed := false
+	for _, id := range ids {
+		old, had := x.entries[id]
+		entry, has := found[id]
+		if had != has || old != entry {
+			changed = true
+		}
+		if has {
+			x.entries[id] = entry
+		} else {
+			delete(x.entries, id)
+		}
+	}
+	if changed {
+		x.rebuild()
+	}
+	return nil
+}
+
+// rebuild builds the tree of the entries; x.mu is held. An invalid
+// regexp leaves its mocks out, logged.
+func (x *pathPatternIndex) rebuild() {
+	root := &pathNode{}
+	seen := make(map[pathIndexEntry]bool)
+	for id, entry := range x.entries {
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		if err := root.insert(entry.path, entry.method); err != nil {
+			log.Printf("Mock %d has an invalid path pattern %q: %v", id, entry.path, err)
+		}
+	}
+	x.root.Store(root)
+	pathIndexPatterns.Set(int64(len(seen)))
+}
+
+// set replaces the entries, for building an index without a database.
+func (x *pathPatternIndex) set(entries map[int]pathIndexEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries = entries
+	x.rebuild()
+}
+
+// preferSpecificPaths keeps the candidates with the most specific kind of
+// path: literal paths over parameters, parameters over wildcards and
+// wildcards over regexps, and then the patterns with the longest literal
+// prefix, so /users/:id beats /:resource/:id.
+func preferSpecificPaths(candidates []*MockResponse) []*MockResponse {
+	best := regexpPath
+	for _, candidate := range candidates {
+		best = min(best, pathKind(candidate.Path))
+	}
+	candidates = preferScoped(candidates, func(m *MockResponse) bool { return pathKind(m.Path) == best })
+	if best != paramPath && best != wildcardPath {
+		return candidates
+	}
+	longest := 0
+	for _, candidate := range candidates {
+		longest = max(longest, staticPrefixLen(candidate.Path))
+	}
+	return preferScoped(candidates, func(m *MockResponse) bool { return staticPrefixLen(m.Path) == longest })
+}
+
+// staticPrefixLen is the length of a pattern before its first parameter or
+// wildcard.
+func staticPrefixLen(pattern string) int {
+	if i := strings.Index(pattern, "/:"); i >= 0 {
+		pattern = pattern[:i]
+	}
+	if i := strings.Index(pattern, "/*"); i >= 0 {
+		pattern = pattern[:i]
+	}
+	return len(pattern)
+}
+
+// lintPathPattern returns the problems of a mock path used as a pattern.
+func lintPathPattern(path string) []string {
+	if !pathPatterns {
+		return nil
+	}
+	if strings.HasPrefix(path, "~") {
+		if _, err := compilePathRegexp(path); err != nil {
+			return []string{fmt.Sprintf("invalid regexp: %v", err)}
+		}
+		return nil
+	}
+	var problems []string
+	base, _, hasQuery := strings.Cut(path, "?")
+	segments := strings.Split(base, "/")
+	for i, segment := range segments {
+		isParam := len(segment) > 1 && segment[0] == ':'
+		isWildcard := strings.HasPrefix(segment, "*")
+		switch {
+		case isWildcard && i < len(segments)-1:
+			problems = append(problems, fmt.Sprintf("wildcard %q must be the last segment; it is matched literally", segment))
+		case (isParam || isWildcard) && hasQuery:
+			problems = append(problems, fmt.Sprintf("%q is matched literally in a path with a query string", segment))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,53 @@
+package main
+
+import "strings"
+
+var (
+	pathIgnoreTrailingSlash = envBool("PATH_IGNORE_TRAILING_SLASH", false)
+	pathCaseInsensitive     = envBool("PATH_CASE_INSENSITIVE", false)
+	pathCollapseSlashes     = envBool("PATH_COLLAPSE_SLASHES", false)
+)
+
+func normalizePath(path string) string {
+	if pathCollapseSlashes {
+		for strings.Contains(path, "//") {
+			path = strings.ReplaceAll(path, "//", "/")
+		}
+	}
+	if pathIgnoreTrailingSlash && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+	if pathCaseInsensitive {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// lookupPaths returns the stored paths that fullPath (path plus optional
+// query string) may match under the configured normalization rules.
+func lookupPaths(fullPath string) []string {
+	path, query, hasQuery := strings.Cut(fullPath, "?")
+	if hasQuery {
+		query = "?" + query
+		if pathCaseInsensitive {
+			query = strings.ToLower(query)
+		}
+	}
+
+	path = normalizePath(path)
+	paths := []string{path + query}
+	if pathIgnoreTrailingSlash && path != "/" {
+		paths = append(paths, path+"/"+query)
+	}
+	return paths
+}
+
+func pathColumn() string {
+	if pathCaseInsensitive {
+		return "lower(path)"
+	}
+	return "path"
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"plugin"
+	"sync"
+)
+
+// Plugins are built with `go build -buildmode=plugin` and may export any of
+// these variables:
+//
+//	var Matchers = map[string]func(r *http.Request, body []byte, config json.RawMessage) (bool, error){...}
+//	var Responders = map[string]func(r *http.Request, body []byte, config json.RawMessage) (int, http.Header, []byte, error){...}
+//	var Middlewares = map[string]func(config json.RawMessage) (func(w http.ResponseWriter, r *http.Request) bool, error){...}
+//
+// Only standard library types cross the boundary, so plugins don't need to
+// import anything from the router.
+type matcherFunc = func(r *http.Request, body []byte, config json.RawMessage) (bool, error)
+type responderFunc = func(r *http.Request, body []byte, config json.RawMessage) (int, http.Header, []byte, error)
+
+type pluginRef struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+var (
+	pluginsMu  sync.RWMutex
+	matchers   = make(map[string]matcherFunc)
+	responders = make(map[string]responderFunc)
+)
+
+func registerMatcher(name string, fn matcherFunc) error {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if _, exists := matchers[name]; exists {
+		return fmt.Errorf("matcher %q is already registered", name)
+	}
+	matchers[name] = fn
+	return nil
+}
+
+func registerResponder(name string, fn responderFunc) error {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if _, exists := responders[name]; exists {
+		return fmt.Errorf("responder %q is already registered", name)
+	}
+	responders[name] = fn
+	return nil
+}
+
+func loadPlugins(paths []string) error {
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening plugin %s: %v", path, err)
+		}
+
+		var found bool
+		if sym, err := p.Lookup("Matchers"); err == nil {
+			registered, ok := sym.(*map[string]matcherFunc)
+			if !ok {
+				return fmt.Errorf("plugin %s: Matchers has type %T", path, sym)
+			}
+			for name, fn := range *registered {
+				if err := registerMatcher(name, fn); err != nil {
+					return fmt.Errorf("plugin %s: %v", path, err)
+				}
+			}
+			found = true
+		}
+		if sym, err := p.Lookup("Responders"); err == nil {
+			registered, ok := sym.(*map[string]responderFunc)
+			if !ok {
+				return fmt.Errorf("plugin %s: Responders has type %T", path, sym)
+			}
+			for name, fn := range *registered {
+				if err := registerResponder(name, fn); err != nil {
+					return fmt.Errorf("plugin %s: %v", path, err)
+				}
+			}
+			found = true
+		}
+		if sym, err := p.Lookup("Middlewares"); err == nil {
+			registered, ok := sym.(*map[string]middlewareFactory)
+			if !ok {
+				return fmt.Errorf("plugin %s: Middlewares has type %T", path, sym)
+			}
+			for name, factory := range *registered {
+				if err := registerMiddleware(name, factory); err != nil {
+					return fmt.Errorf("plugin %s: %v", path, err)
+				}
+			}
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("plugin %s exports no Matchers, Responders or Middlewares", path)
+		}
+		fmt.Println("Loaded plugin", path)
+	}
+	return nil
+}
+
+func runMatcher(ref *pluginRef, r *http.Request, body string) (bool, error) {
+	pluginsMu.RLock()
+	fn, ok := matchers[ref.Name]
+	pluginsMu.RUnlock()
+	if !ok {
+		return false, fmt.Errorf("unknown matcher %q", ref.Name)
+	}
+	return fn(r, []byte(body), ref.Config)
+}
+
+func runResponder(ref *pluginRef, mockResp *MockResponse, r *http.Request, body string) error {
+	pluginsMu.RLock()
+	fn, ok := responders[ref.Name]
+	pluginsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown responder %q", ref.Name)
+	}
+
+	status, header, responseBody, err := fn(r, []byte(body), ref.Config)
+	if err != nil {
+		return err
+	}
+	override := responseOverride{Status: status, Headers: flattenValues(header)}
+	if responseBody != nil {
+		override.Body = string(responseBody)
+	}
+	return applyResponseOverride(mockResp, override)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A transaction pooler such as PgBouncer hands each transaction, or each
+// statement outside one, a server connection of its own. Nothing may rely on
+// state kept by a server session then: named prepared statements, LISTEN,
+// session settings. The router uses none of them, but lib/pq parses a query
+// with parameters and binds it in two round trips, which a pooler may send
+// to different servers. binary_parameters=yes sends them in one.
+const (
+	poolModeAuto        = "auto"
+	poolModeSession     = "session"
+	poolModeTransaction = "transaction"
+)
+
+var (
+	dbPoolMode = strings.ToLower(envString("DB_POOL_MODE", poolModeAuto))
+
+	dbMaxOpenConns    = envInt("DB_MAX_OPEN_CONNS", 10)
+	dbMaxIdleConns    = envInt("DB_MAX_IDLE_CONNS", 5)
+	dbConnMaxLifetime = envDuration("DB_CONN_MAX_LIFETIME", 15*time.Minute)
+	dbConnMaxIdleTime = envDuration("DB_CONN_MAX_IDLE_TIME", 3*time.Minute)
+)
+
+// configurePool sizes the connection pool of the primary or the replica.
+func configurePool(conn *sql.DB) {
+	conn.SetMaxOpenConns(dbMaxOpenConns)
+	conn.SetMaxIdleConns(dbMaxIdleConns)
+	conn.SetConnMaxLifetime(dbConnMaxLifetime)
+	conn.SetConnMaxIdleTime(dbConnMaxIdleTime)
+}
+
+// poolerConnStr adjusts a connection string for transaction pooling.
+func poolerConnStr(conn string) string {
+	if strings.Contains(conn, "binary_parameters=") {
+		return conn
+	}
+	return conn + " binary_parameters=yes"
+}
+
+// resolvePoolMode decides whether db sits behind a transaction pooler,
+// detecting it unless DB_POOL_MODE says so.
+func resolvePoolMode(ctx context.Context, db *sql.DB) (string, error) {
+	switch dbPoolMode {
+	case poolModeSession, poolModeTransaction:
+		return dbPoolMode, nil
+	case poolModeAuto:
+	default:
+		return "", fmt.Errorf("invalid DB_POOL_MODE %q; use auto, session or transaction", dbPoolMode)
+	}
+	pooled, err := detectTransactionPooling(ctx, db)
+	if err != nil {
+		return "", fmt.Errorf("detecting connection pooling: %v", err)
+	}
+	if pooled {
+		return poolModeTransaction, nil
+	}
+	return poolModeSession, nil
+}
+
+// detectTransactionPooling checks whether a client connection keeps its
+// server session. One connection notes its server's PID, a second holds a
+// transaction open, and the first one asks again: without a pooler, or with
+// session pooling, it gets the same PID back at once. A transaction pooler
+// may give the held server to the second connection, answer the first one
+// from another server, or keep it waiting for a free one.
+func detectTransactionPooling(ctx context.Context, db *sql.DB) (bool, error) {
+	first, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer first.Close()
+	second, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer second.Close()
+
+	var before, held, after int
+	if err := first.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&before); err != nil {
+		return false, err
+	}
+	tx, err := second.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&held); err != nil {
+		return false, err
+	}
+	if held == before {
+		return true, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	err = first.QueryRowContext(waitCtx, "SELECT pg_backend_pid()").Scan(&after)
+	if errors.Is(err, context.DeadlineExceeded) || waitCtx.Err() != nil {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return after != before, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type faultOptions struct {
+	Latency     jsonDuration `json:"latency,omitempty"`
+	Jitter      jsonDuration `json:"jitter,omitempty"`
+	ErrorRate   float64      `json:"errorRate,omitempty"`
+	ErrorStatus int          `json:"errorStatus,omitempty"`
+	ErrorBody   string       `json:"errorBody,omitempty"`
+}
+
+type profile struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Faults      faultOptions `json:"faults,omitempty"`
+}
+
+type profileRegistry struct {
+	mu       sync.RWMutex
+	profiles map[string]profile
+	active   string
+}
+
+var profiles = &profileRegistry{profiles: make(map[string]profile)}
+
+// loadProfiles reads profile definitions from a JSON file holding an array
+// of profiles and activates the initial one, if any.
+func loadProfiles(path, active string) error {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading profiles: %v", err)
+		}
+		var defined []profile
+		if err := json.Unmarshal(data, &defined); err != nil {
+			return fmt.Errorf("invalid profiles: %v", err)
+		}
+		for _, p := range defined {
+			if err := profiles.define(p); err != nil {
+				return err
+			}
+		}
+	}
+	if active != "" {
+		return profiles.activate(active)
+	}
+	return nil
+}
+
+func (p *profileRegistry) define(def profile) error {
+	def.Name = strings.TrimSpace(def.Name)
+	if def.Name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if def.Faults.ErrorRate < 0 || def.Faults.ErrorRate > 1 {
+		return fmt.Errorf("profile %s: errorRate must be between 0 and 1", def.Name)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profiles[def.Name] = def
+	return nil
+}
+
+func (p *profileRegistry) activate(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.profiles[name]; !ok && name != "" {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	p.active = name
+	return nil
+}
+
+func (p *profileRegistry) lookup(name string) (profile, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	def, ok := p.profiles[name]
+	return def, ok
+}
+
+func (p *profileRegistry) activeName() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.active
+}
+
+// requestProfile returns the profile a request runs under: the X-Mock-Profile
+// header when present, otherwise the active profile.
+func requestProfile(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get("X-Mock-Profile")); name != "" {
+		return name
+	}
+	return profiles.activeName()
+}
+
+// injectFaults applies the latency and error rate of the request's profile.
+// It returns false when it answered the request with an injected error.
+func injectFaults(w http.ResponseWriter, r *http.Request, data templateData) bool {
+	def, ok := profiles.lookup(data.Profile)
+	if !ok {
+		return true
+	}
+	faults := def.Faults
+
+	delay := time.Duration(faults.Latency)
+	if faults.Jitter > 0 {
+		delay += time.Duration(data.rand.Int63n(int64(faults.Jitter)))
+	}
+	if delay > 0 && !sleepContext(r.Context(), delay) {
+		return false
+	}
+
+	if faults.ErrorRate <= 0 || data.rand.Float64() >= faults.ErrorRate {
+		return true
+	}
+	status := faults.ErrorStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if faults.ErrorBody != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(faults.ErrorBody))
+		return false
+	}
+	writeJSON(w, status, map[string]string{"error": "fault injected by profile " + def.Name})
+	return false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func listProfilesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	list, active := profiles.snapshot()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"active": active, "profiles": list})
+}
+
+func putProfileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var def profile
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid profile: " + err.Error()})
+		return
+	}
+	def.Name = ps.ByName("name")
+	if err := profiles.define(def); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Profile %s defined", def.Name)
+	writeJSON(w, http.StatusOK, def)
+}
+
+func deleteProfileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	profiles.mu.Lock()
+	_, ok := profiles.profiles[name]
+	delete(profiles.profiles, name)
+	if profiles.active == name {
+		profiles.active = ""
+	}
+	profiles.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "profile not found"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func activateProfileHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+		return
+	}
+	if err := profiles.activate(body.Name); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Profile %q activated", body.Name)
+	writeJSON(w, http.StatusOK, map[string]string{"active": body.Name})
+}
+
+func deactivateProfileHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	profiles.activate("")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *profileRegistry) snapshot() ([]profile, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	list := make([]profile, 0, len(p.profiles))
+	for _, def := range p.profiles {
+		list = append(list, def)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, p.active
+}
+
+func (p *profileRegistry) restore(list []profile, active string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.profiles = make(map[string]profile, len(list))
+	for _, def := range list {
+		p.profiles[def.Name] = def
+	}
+	p.active = active
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufOptions name the message types a mock decodes its request body
+// from and encodes its JSON response body into.
+type protobufOptions struct {
+	Request  string `json:"request,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+const protobufContentType = "application/x-protobuf"
+
+// protoTypes holds the registered descriptors; nil when none are.
+var protoTypes *protoregistry.Files
+
+// loadProtoDescriptors registers the messages of .proto sources, compiled
+// against the import paths, and of binary FileDescriptorSets as written by
+// `protoc --descriptor_set_out --include_imports` or `buf build`.
+func loadProtoDescriptors(sources, importPaths, descriptorSets []string) error {
+	if len(sources) == 0 && len(descriptorSets) == 0 {
+		return nil
+	}
+	registry := new(protoregistry.Files)
+	register := func(fd protoreflect.FileDescriptor) error {
+		if _, err := registry.FindFileByPath(fd.Path()); err == nil {
+			return nil
+		}
+		return registry.RegisterFile(fd)
+	}
+
+	if len(sources) > 0 {
+		names, paths, err := protoSourceNames(sources, importPaths)
+		if err != nil {
+			return err
+		}
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: paths}),
+		}
+		files, err := compiler.Compile(context.Background(), names...)
+		if err != nil {
+			return fmt.Errorf("error compiling proto files: %v", err)
+		}
+		for _, fd := range files {
+			if err := register(fd); err != nil {
+				return fmt.Errorf("error registering %s: %v", fd.Path(), err)
+			}
+		}
+	}
+
+	for _, path := range descriptorSets {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return fmt.Errorf("%s: invalid descriptor set: %v", path, err)
+		}
+		files, err := protodesc.NewFiles(&set)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		var registerErr error
+		files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			registerErr = register(fd)
+			return registerErr == nil
+		})
+		if registerErr != nil {
+			return fmt.Errorf("%s: %v", path, registerErr)
+		}
+	}
+
+	protoTypes = registry
+	fmt.Printf("Protobuf descriptors loaded: %d messages\n", len(protoMessageNames()))
+	return nil
+}
+
+// protoSourceNames turns source paths into names relative to an import path.
+// Without import paths, the directory of each source is used.
+func protoSourceNames(sources, importPaths []string) ([]string, []string, error) {
+	paths := importPaths
+	if len(paths) == 0 {
+		for _, source := range sources {
+			if dir := filepath.Dir(source); !slices.Contains(paths, dir) {
+				paths = append(paths, dir)
+			}
+		}
+	}
+	var names []string
+	for _, source := range sources {
+		name := ""
+		for _, dir := range paths {
+			if rel, err := filepath.Rel(dir, source); err == nil && !strings.HasPrefix(rel, "..") {
+				name = filepath.ToSlash(rel)
+				break
+			}
+		}
+		if name == "" {
+			return nil, nil, fmt.Errorf("%s is not below any of PROTO_IMPORT_PATHS", source)
+		}
+		names = append(names, name)
+	}
+	return names, paths, nil
+}
+
+func findProtoMessage(name string) (protoreflect.MessageDescriptor, error) {
+	if protoTypes == nil {
+		return nil, fmt.Errorf("no protobuf descriptors are registered")
+	}
+	desc, err := protoTypes.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("unknown message type %s", name)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", name)
+	}
+	return message, nil
+}
+
+func protoMessageNames() []string {
+	var names []string
+	var collect func(protoreflect.MessageDescriptors)
+	collect = func(messages protoreflect.MessageDescriptors) {
+		for i := 0; i < messages.Len(); i++ {
+			message := messages.Get(i)
+			if !message.IsMapEntry() {
+				names = append(names, string(message.FullName()))
+			}
+			collect(message.Messages())
+		}
+	}
+	protoTypes.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		collect(fd.Messages())
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// protobufToJSON decodes a binary message into its JSON form, with field
+// names as written in the .proto file.
+func protobufToJSON(messageType string, body []byte) (string, error) {
+	desc, err := findProtoMessage(messageType)
+	if err != nil {
+		return "", err
+	}
+	message := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(body, message); err != nil {
+		return "", fmt.Errorf("invalid %s message: %v", messageType, err)
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func jsonToProtobuf(messageType string, body string) ([]byte, error) {
+	desc, err := findProtoMessage(messageType)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(desc)
+	if err := protojson.Unmarshal([]byte(body), message); err != nil {
+		return nil, fmt.Errorf("response is not a valid %s: %v", messageType, err)
+	}
+	return proto.Marshal(message)
+}
+
+func isProtobufMediaType(mediaType string) bool {
+	switch mediaType {
+	case protobufContentType, "application/protobuf", "application/vnd.google.protobuf", "application/x-google-protobuf":
+		return true
+	}
+	return false
+}
+
+// requestMessageType returns the message type a request names for its
+// protobuf body, through a messageType parameter of its Content-Type or an
+// X-Protobuf-Message header.
+func requestMessageType(header http.Header) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !isProtobufMediaType(mediaType) {
+		return ""
+	}
+	if name := params["messagetype"]; name != "" {
+		return name
+	}
+	return strings.TrimSpace(header.Get("X-Protobuf-Message"))
+}
+
+// decodeProtobufRequest returns the JSON form of a protobuf request body
+// that names its message type, so it goes through the same matching as JSON
+// bodies. ok is false for any other request.
+func decodeProtobufRequest(header http.Header, body string) (string, bool, error) {
+	if protoTypes == nil || body == "" {
+		return "", false, nil
+	}
+	messageType := requestMessageType(header)
+	if messageType == "" {
+		return "", false, nil
+	}
+	decoded, err := protobufToJSON(messageType, []byte(body))
+	return decoded, err == nil, err
+}
+
+// decodeMockProtobuf decodes the request body as the mock's request type for
+// mocks that declare one when the client did not name it.
+func decodeMockProtobuf(mockResp *MockResponse, data templateData) (templateData, bool) {
+	pb := mockResp.Options.Protobuf
+	if pb == nil || pb.Request == "" || data.JSON != nil || data.Body == "" {
+		return data, true
+	}
+	decoded, err := protobufToJSON(pb.Request, []byte(data.Body))
+	if err != nil {
+		return data, false
+	}
+	json.Unmarshal([]byte(decoded), &data.JSON)
+	mockResp.requestJSON = data.JSON
+	return data, true
+}
+
+// encodeProtobufResponse turns the mock's JSON response body into the binary
+// encoding of its response type.
+func encodeProtobufResponse(mockResp *MockResponse) error {
+	pb := mockResp.Options.Protobuf
+	if pb == nil || pb.Response == "" {
+		return nil
+	}
+	body, err := jsonToProtobuf(pb.Response, mockResp.ResponseBody)
+	if err != nil {
+		return err
+	}
+	mockResp.ResponseBody = string(body)
+	if mockResp.contentType == "" {
+		mockResp.contentType = protobufContentType
+	}
+	return nil
+}
+
+func protobufMessagesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if protoTypes == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"messages": []string{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"messages": protoMessageNames()})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// proxyTransform rewrites the upstream responses of proxied requests that
+// match its path glob and method.
+type proxyTransform struct {
+	Path   string `json:"path"`
+	Method string `json:"method,omitempty"`
+	// Status maps upstream status codes, or classes such as "5xx", or "*",
+	// to the status to send instead.
+	Status map[string]int `json:"status,omitempty"`
+	// Set replaces or adds the JSON fields selected by JSONPaths; string
+	// values are templates rendered with the request.
+	Set           map[string]interface{} `json:"set,omitempty"`
+	Remove        []string               `json:"remove,omitempty"`
+	Headers       map[string]string      `json:"headers,omitempty"`
+	RemoveHeaders []string               `json:"removeHeaders,omitempty"`
+}
+
+func (t *proxyTransform) validate() error {
+	if t.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if _, err := path.Match(t.Path, "/"); err != nil {
+		return fmt.Errorf("invalid path pattern %q: %v", t.Path, err)
+	}
+	for code := range t.Status {
+		if code != "*" && !statusPattern(code, 0) {
+			if _, err := strconv.Atoi(code); err != nil {
+				return fmt.Errorf("invalid status %q; use a code, a class like 5xx or *", code)
+			}
+		}
+	}
+	for expr := range t.Set {
+		if _, err := parseJSONPath(expr); err != nil {
+			return err
+		}
+	}
+	for _, expr := range t.Remove {
+		if _, err := parseJSONPath(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *proxyTransform) matches(r *http.Request) bool {
+	if t.Method != "" && !strings.EqualFold(t.Method, r.Method) {
+		return false
+	}
+	matched, _ := path.Match(t.Path, r.URL.Path)
+	return matched
+}
+
+func (t *proxyTransform) editsBody() bool {
+	return len(t.Set) > 0 || len(t.Remove) > 0
+}
+
+// statusPattern reports whether code is a class like "4xx", and, for a
+// non-zero status, whether the status is in it.
+func statusPattern(code string, status int) bool {
+	if len(code) != 3 || !strings.HasSuffix(strings.ToLower(code), "xx") || code[0] < '1' || code[0] > '5' {
+		return false
+	}
+	return status == 0 || status/100 == int(code[0]-'0')
+}
+
+func (t *proxyTransform) mapStatus(status int) int {
+	if to, ok := t.Status[strconv.Itoa(status)]; ok {
+		return to
+	}
+	for code, to := range t.Status {
+		if statusPattern(code, status) {
+			return to
+		}
+	}
+	if to, ok := t.Status["*"]; ok {
+		return to
+	}
+	return status
+}
+
+var (
+	proxyUpstream *url.URL
+
+	proxyTransformsMu sync.RWMutex
+	proxyTransforms   []proxyTransform
+)
+
+// initProxy enables passthrough of requests no mock matches to upstream,
+// with the transforms of the given JSON file.
+func initProxy(upstream, transformsFile string) error {
+	if upstream == "" {
+		return nil
+	}
+	target, err := url.Parse(upstream)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return fmt.Errorf("invalid PROXY_UPSTREAM %q", upstream)
+	}
+	if transformsFile != "" {
+		data, err := os.ReadFile(transformsFile)
+		if err != nil {
+			return fmt.Errorf("error reading proxy transforms: %v", err)
+		}
+		var transforms []proxyTransform
+		if err := json.Unmarshal(data, &transforms); err != nil {
+			return fmt.Errorf("invalid proxy transforms: %v", err)
+		}
+		if err := setProxyTransforms(transforms); err != nil {
+			return err
+		}
+	}
+	proxyUpstream = target
+	fmt.Println("Unmatched requests are proxied to", target)
+	return nil
+}
+
+func setProxyTransforms(transforms []proxyTransform) error {
+	for i := range transforms {
+		if err := transforms[i].validate(); err != nil {
+			return fmt.Errorf("transform %d: %v", i, err)
+		}
+	}
+	proxyTransformsMu.Lock()
+	proxyTransforms = transforms
+	proxyTransformsMu.Unlock()
+	return nil
+}
+
+func matchingProxyTransforms(r *http.Request) []proxyTransform {
+	proxyTransformsMu.RLock()
+	defer proxyTransformsMu.RUnlock()
+	var matched []proxyTransform
+	for _, t := range proxyTransforms {
+		if t.matches(r) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+// proxyRequest passes a request no mock matched through to PROXY_UPSTREAM,
+// rewriting the response with the transforms for its route.
+func proxyRequest(w http.ResponseWriter, r *http.Request, requestBody string, data templateData) {
+	transforms := matchingProxyTransforms(r)
+	editsBody := false
+	for _, t := range transforms {
+		editsBody = editsBody || t.editsBody()
+	}
+
+	r.Body = io.NopCloser(strings.NewReader(requestBody))
+	r.ContentLength = int64(len(requestBody))
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(proxyUpstream)
+			pr.SetXForwarded()
+			if editsBody {
+				// Bodies are rewritten as they come, so ask for them uncompressed.
+				pr.Out.Header.Del("Accept-Encoding")
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			return transformProxyResponse(resp, transforms, data)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			requestLogf(r, "Proxying to %s failed: %v", proxyUpstream, err)
+			http.Error(w, "Upstream unavailable", http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func transformProxyResponse(resp *http.Response, transforms []proxyTransform, data templateData) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+	for _, t := range transforms {
+		for _, name := range t.RemoveHeaders {
+			resp.Header.Del(name)
+		}
+		for name, value := range t.Headers {
+			rendered, err := renderString("proxy-header", value, data)
+			if err != nil {
+				return fmt.Errorf("error rendering header %s: %v", name, err)
+			}
+			resp.Header.Set(name, rendered)
+		}
+		if status := t.mapStatus(resp.StatusCode); status != resp.StatusCode {
+			resp.StatusCode = status
+			resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		}
+	}
+
+	var edits []proxyTransform
+	for _, t := range transforms {
+		if t.editsBody() {
+			edits = append(edits, t)
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if len(edits) == 0 || !isJSON || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		log.Printf("Proxied response for %s is not valid JSON; not transformed: %v", resp.Request.URL.Path, err)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+	for _, t := range edits {
+		if err := t.editBody(doc, data); err != nil {
+			return err
+		}
+	}
+	if body, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func (t *proxyTransform) editBody(doc interface{}, data templateData) error {
+	exprs := make([]string, 0, len(t.Set))
+	for expr := range t.Set {
+		exprs = append(exprs, expr)
+	}
+	sort.Strings(exprs)
+	for _, expr := range exprs {
+		steps, _ := parseJSONPath(expr)
+		value := t.Set[expr]
+		if s, ok := value.(string); ok {
+			rendered, err := renderString("proxy-set", s, data)
+			if err != nil {
+				return fmt.Errorf("error rendering %s: %v", expr, err)
+			}
+			value = rendered
+		}
+		jsonPathSet(doc, steps, value)
+	}
+	for _, expr := range t.Remove {
+		steps, _ := parseJSONPath(expr)
+		jsonPathRemove(doc, steps)
+	}
+	return nil
+}
+
+func listProxyTransformsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	proxyTransformsMu.RLock()
+	transforms := proxyTransforms
+	proxyTransformsMu.RUnlock()
+	if transforms == nil {
+		transforms = []proxyTransform{}
+	}
+	upstream := ""
+	if proxyUpstream != nil {
+		upstream = proxyUpstream.String()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"upstream": upstream, "transforms": transforms})
+}
+
+// putProxyTransformsHandler replaces all transforms.
+func putProxyTransformsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var transforms []proxyTransform
+	if err := json.NewDecoder(r.Body).Decode(&transforms); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid transforms: " + err.Error()})
+		return
+	}
+	if err := setProxyTransforms(transforms); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Proxy transforms replaced: %d", len(transforms))
+	listProxyTransformsHandler(w, r, nil)
+}
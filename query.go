@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+type queryMatchOptions struct {
+	Ignore []string `json:"ignore,omitempty"`
+	Only   []string `json:"only,omitempty"`
+}
+
+func matchesParamName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pathCaseInsensitive {
+			pattern = strings.ToLower(pattern)
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *queryMatchOptions) considers(name string) bool {
+	if len(q.Only) > 0 && !matchesParamName(q.Only, name) {
+		return false
+	}
+	return !matchesParamName(q.Ignore, name)
+}
+
+func (q *queryMatchOptions) filter(rawQuery string) (url.Values, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	for name := range values {
+		if !q.considers(name) {
+			delete(values, name)
+		}
+	}
+	return values, nil
+}
+
+// matches reports whether two query strings are equal once the parameters
+// excluded by the rules are dropped. Values of a parameter must appear in the
+// same order.
+func (q *queryMatchOptions) matches(storedQuery, requestQuery string) bool {
+	stored, err := q.filter(storedQuery)
+	if err != nil {
+		return false
+	}
+	request, err := q.filter(requestQuery)
+	if err != nil || len(stored) != len(request) {
+		return false
+	}
+	for name, values := range stored {
+		if !slices.Equal(values, request[name]) {
+			return false
+		}
+	}
+	return true
+}
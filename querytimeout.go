@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dbQueryTimeout bounds the queries run for a request: the mock lookup and
+// CRUD records. They also end when the client disconnects.
+var (
+	dbQueryTimeout = envDuration("DB_QUERY_TIMEOUT", 5*time.Second)
+
+	dbQueryTimeoutsTotal   = expvar.NewMap("db_query_timeouts_total")
+	dbQueriesCanceledTotal = expvar.NewMap("db_queries_canceled_total")
+)
+
+// requestQueryContext returns the context for the queries of a request.
+func requestQueryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if dbQueryTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), dbQueryTimeout)
+}
+
+// queryError returns context.DeadlineExceeded or context.Canceled for a
+// query that failed because its context ended, counting it under kind, and
+// err otherwise. Drivers report a canceled query in their own words.
+func queryError(ctx context.Context, kind string, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		dbQueryTimeoutsTotal.Add(kind, 1)
+		return context.DeadlineExceeded
+	case context.Canceled:
+		dbQueriesCanceledTotal.Add(kind, 1)
+		return context.Canceled
+	}
+	return err
+}
+
+// writeQueryError answers a request whose query failed: 504 when it timed
+// out, nothing when the client is gone, and 500 otherwise.
+func writeQueryError(w http.ResponseWriter, r *http.Request, what string, err error) {
+	switch err {
+	case context.DeadlineExceeded:
+		writeRouterError(w, r, "Database timeout", http.StatusGatewayTimeout)
+		requestLogf(r, "%s timed out after %s", what, dbQueryTimeout)
+	case context.Canceled:
+		requestLogf(r, "Client disconnected during the %s", strings.ToLower(what))
+	default:
+		writeRouterError(w, r, "Internal server error", http.StatusInternalServerError)
+		requestLogf(r, "%s failed: %v", what, err)
+	}
+}
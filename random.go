@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rnd.Int63n(n)
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rnd.Float64()
+}
+
+func (l *lockedRand) Read(p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rnd.Read(p)
+}
+
+type seedRegistry struct {
+	mu         sync.Mutex
+	workspaces map[string]int64
+	sources    map[string]*seededSource
+}
+
+type seededSource struct {
+	rand *lockedRand
+	used time.Time
+}
+
+// seedHeaderPrefix keys the sources of X-Mock-Seed values, which clients
+// pick freely and are dropped once idle.
+const seedHeaderPrefix = "seed:"
+
+var seeds = &seedRegistry{
+	workspaces: parseWorkspaceSeeds(envString("MOCK_SEEDS", "")),
+	sources:    make(map[string]*seededSource),
+}
+
+var unseededRand = &lockedRand{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func parseWorkspaceSeeds(value string) map[string]int64 {
+	result := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(kv) == 2 {
+			result[strings.TrimSpace(kv[0])] = seedValue(strings.TrimSpace(kv[1]))
+		}
+	}
+	return result
+}
+
+func seedValue(value string) int64 {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return int64(h.Sum64())
+}
+
+func requestRand(r *http.Request) *lockedRand {
+	if header := strings.TrimSpace(r.Header.Get("X-Mock-Seed")); header != "" {
+		return seeds.source(seedHeaderPrefix+header, seedValue(header))
+	}
+
+	workspace := requestWorkspace(r)
+	seeds.mu.Lock()
+	seed, ok := seeds.workspaces[workspace]
+	seeds.mu.Unlock()
+	if ok {
+		return seeds.source("workspace:"+workspace, seed)
+	}
+	return unseededRand
+}
+
+func (s *seedRegistry) source(key string, seed int64) *lockedRand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.sources[key]
+	if !ok {
+		src = &seededSource{rand: &lockedRand{rnd: rand.New(rand.NewSource(seed))}}
+		s.sources[key] = src
+	}
+	src.used = time.Now()
+	return src.rand
+}
+
+// expire drops the sources of X-Mock-Seed values unused for ttl; a seed
+// sent again afterwards starts its sequence over.
+func (s *seedRegistry) expire(now time.Time, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, src := range s.sources {
+		if strings.HasPrefix(key, seedHeaderPrefix) && now.Sub(src.used) > ttl {
+			delete(s.sources, key)
+		}
+	}
+}
+
+func (s *seedRegistry) setWorkspace(workspace string, seed *int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seed == nil {
+		delete(s.workspaces, workspace)
+	} else {
+		s.workspaces[workspace] = *seed
+	}
+	delete(s.sources, "workspace:"+workspace)
+}
+
+func (s *seedRegistry) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sources = make(map[string]*seededSource)
+}
+
+func randomTemplateFuncs(rnd *lockedRand) map[string]interface{} {
+	return map[string]interface{}{
+		"uuid": func() string {
+			var b [16]byte
+			rnd.Read(b[:])
+			return formatUUID(b)
+		},
+		"randInt": func(min, max int) (int, error) {
+			if max < min {
+				return 0, fmt.Errorf("randInt max must not be less than min")
+			}
+			return min + int(rnd.Int63n(int64(max-min)+1)), nil
+		},
+		"randFloat": func() float64 {
+			return rnd.Float64()
+		},
+		"randChoice": func(items ...interface{}) (interface{}, error) {
+			if len(items) == 0 {
+				return nil, fmt.Errorf("randChoice requires at least one item")
+			}
+			return items[rnd.Int63n(int64(len(items)))], nil
+		},
+		"randString": func(n int) string {
+			const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = letters[rnd.Int63n(int64(len(letters)))]
+			}
+			return string(b)
+		},
+	}
+}
+
+func pickWeighted(candidates []*MockResponse, rnd *lockedRand) *MockResponse {
+	total := 0
+	for _, c := range candidates {
+		if c.Weight > 0 {
+			total += c.Weight
+		}
+	}
+	if total == 0 {
+		return candidates[0]
+	}
+
+	n := int(rnd.Int63n(int64(total)))
+	for _, c := range candidates {
+		if c.Weight <= 0 {
+			continue
+		}
+		if n < c.Weight {
+			return c
+		}
+		n -= c.Weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+type seedSettings struct {
+	Workspace string `json:"workspace"`
+	Seed      *int64 `json:"seed"`
+}
+
+func setSeedHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var settings seedSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+		return
+	}
+	if settings.Workspace == "" {
+		settings.Workspace = "default"
+	}
+
+	seeds.setWorkspace(settings.Workspace, settings.Seed)
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func resetSeedsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	seeds.reset()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type rateLimitOptions struct {
+	Limit     int          `json:"limit"`
+	Window    jsonDuration `json:"window"`
+	Scope     string       `json:"scope,omitempty"`
+	KeyHeader string       `json:"keyHeader,omitempty"`
+	Body      string       `json:"body,omitempty"`
+	// Headers is the style of the quota headers: "x-ratelimit" (the
+	// default), "ietf" for the RateLimit-* fields of the IETF draft, "both"
+	// or "none".
+	Headers string `json:"headers,omitempty"`
+}
+
+var rateLimitHeaderStyles = []string{"x-ratelimit", "ietf", "both", "none"}
+
+type rateWindow struct {
+	start time.Time
+	end   time.Time
+	count int
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	swept   time.Time
+	windows map[string]*rateWindow
+}
+
+var rateLimits = &rateLimiter{windows: make(map[string]*rateWindow)}
+
+func rateLimitKey(opts *rateLimitOptions, mockResp *MockResponse, data templateData) string {
+	key := data.Workspace + "|" + data.Session + "|"
+	if opts.Scope == "path" {
+		key += "path:" + data.Method + " " + data.Path
+	} else {
+		key += "mock:" + strconv.Itoa(mockResp.ID)
+	}
+	if opts.KeyHeader != "" {
+		key += "|" + data.Header.Get(opts.KeyHeader)
+	}
+	return key
+}
+
+func (l *rateLimiter) hit(key string, limit int, window time.Duration, now time.Time) (remaining int, reset time.Time, allowed bool) {
+	if sharedState != nil {
+		remaining, reset, allowed, err := sharedRateHit(key, limit, window, now)
+		if err == nil {
+			return remaining, reset, allowed
+		}
+		sharedStateFailed("rateLimit", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > time.Minute || now.Before(l.swept) {
+		for k, w := range l.windows {
+			if !now.Before(w.end) {
+				delete(l.windows, k)
+			}
+		}
+		l.swept = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.end) || now.Before(w.start) {
+		w = &rateWindow{start: now, end: now.Add(window)}
+		l.windows[key] = w
+	}
+	if w.count >= limit {
+		return 0, w.end, false
+	}
+	w.count++
+	return limit - w.count, w.end, true
+}
+
+func (l *rateLimiter) reset(workspace string) {
+	if sharedState != nil {
+		sharedDelete(sharedRateKind, workspaceKeyPrefix(workspace, "|"))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if workspace == "" {
+		l.windows = make(map[string]*rateWindow)
+		return
+	}
+	for key := range l.windows {
+		if strings.HasPrefix(key, workspace+"|") {
+			delete(l.windows, key)
+		}
+	}
+}
+
+func (l *rateLimiter) resetSession(workspace, session string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.windows {
+		if strings.HasPrefix(key, workspace+"|"+session+"|") {
+			delete(l.windows, key)
+		}
+	}
+}
+
+func enforceRateLimit(w http.ResponseWriter, mockResp *MockResponse, data templateData) bool {
+	opts := mockResp.Options.RateLimit
+	if opts == nil || opts.Limit <= 0 || opts.Window <= 0 {
+		return true
+	}
+
+	remaining, reset, allowed := rateLimits.hit(rateLimitKey(opts, mockResp, data), opts.Limit, time.Duration(opts.Window), data.Now)
+	retryAfter := int(math.Ceil(reset.Sub(data.Now).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	setRateLimitHeaders(w.Header(), opts, remaining, reset, retryAfter)
+	if allowed {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	if opts.Body != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(opts.Body))
+		return false
+	}
+	writeJSON(w, http.StatusTooManyRequests, map[string]string{
+		"error": fmt.Sprintf("rate limit of %d requests per %v exceeded", opts.Limit, time.Duration(opts.Window)),
+	})
+	return false
+}
+
+// setRateLimitHeaders announces the quota in the style the mock asks for.
+// The IETF fields give the reset in seconds from now rather than as a
+// timestamp.
+func setRateLimitHeaders(header http.Header, opts *rateLimitOptions, remaining int, reset time.Time, resetIn int) {
+	style := opts.Headers
+	if style == "" || style == "x-ratelimit" || style == "both" {
+		header.Set("X-RateLimit-Limit", strconv.Itoa(opts.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}
+	if style == "ietf" || style == "both" {
+		header.Set("RateLimit-Limit", strconv.Itoa(opts.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(resetIn))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", opts.Limit, int(math.Ceil(time.Duration(opts.Window).Seconds()))))
+	}
+}
+
+func resetRateLimitsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	rateLimits.reset(r.URL.Query().Get("workspace"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type rateWindowSnapshot struct {
+	Key   string    `json:"key"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int       `json:"count"`
+}
+
+func (l *rateLimiter) snapshot() []rateWindowSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	windows := make([]rateWindowSnapshot, 0, len(l.windows))
+	for key, w := range l.windows {
+		windows = append(windows, rateWindowSnapshot{Key: key, Start: w.start, End: w.end, Count: w.count})
+	}
+	return windows
+}
+
+func (l *rateLimiter) restore(windows []rateWindowSnapshot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.windows = make(map[string]*rateWindow, len(windows))
+	for _, w := range windows {
+		l.windows[w.Key] = &rateWindow{start: w.Start, end: w.End, count: w.Count}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			panicsTotal.Add(1)
+			requestLogf(r, "Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+
+			if rec.status != 0 {
+				return
+			}
+			writeJSON(rec, http.StatusInternalServerError, map[string]string{
+				"error":     "internal server error",
+				"requestId": requestID(r),
+			})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// redactionRules mask personal data in requests before they are journaled,
+// streamed to tails or logged.
+type redactionRules struct {
+	// JSONPaths select the fields of JSON bodies to mask, e.g. $.card.number
+	// or $..password.
+	JSONPaths []string `json:"jsonPaths,omitempty"`
+	// Patterns are regular expressions masked in paths, header values and
+	// bodies; with capture groups only the groups are masked.
+	Patterns []string `json:"patterns,omitempty"`
+	// Headers are masked whole.
+	Headers []string `json:"headers,omitempty"`
+	// QueryParams are masked in paths and form bodies.
+	QueryParams []string `json:"queryParams,omitempty"`
+
+	paths    [][]jsonPathStep
+	patterns []*regexp.Regexp
+}
+
+var redaction redactionRules
+
+// loadRedaction reads the rules of the REDACTION_CONFIG file.
+func loadRedaction(file string) error {
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading redaction config: %v", err)
+	}
+	var rules redactionRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("invalid redaction config: %v", err)
+	}
+	for _, expr := range rules.JSONPaths {
+		steps, err := parseJSONPath(expr)
+		if err != nil {
+			return err
+		}
+		rules.paths = append(rules.paths, steps)
+	}
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		rules.patterns = append(rules.patterns, re)
+	}
+	redaction = rules
+	fmt.Printf("Redacting %d JSON paths, %d patterns, %d headers and %d query parameters\n",
+		len(rules.paths), len(rules.patterns), len(rules.Headers), len(rules.QueryParams))
+	return nil
+}
+
+func (rules *redactionRules) empty() bool {
+	return len(rules.paths) == 0 && len(rules.patterns) == 0 && len(rules.Headers) == 0 && len(rules.QueryParams) == 0
+}
+
+func (rules *redactionRules) header(name string) bool {
+	for _, redacted := range rules.Headers {
+		if strings.EqualFold(name, redacted) {
+			return true
+		}
+	}
+	return false
+}
+
+// query masks the listed parameters of a raw query string.
+func (rules *redactionRules) query(raw string) string {
+	if len(rules.QueryParams) == 0 || raw == "" {
+		return raw
+	}
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		name, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		for _, param := range rules.QueryParams {
+			if name == param {
+				parts[i] = url.QueryEscape(name) + "=" + url.QueryEscape(redactedValue)
+			}
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (rules *redactionRules) path(fullPath string) string {
+	base, query, ok := strings.Cut(fullPath, "?")
+	if ok {
+		fullPath = base + "?" + rules.query(query)
+	}
+	return redact(fullPath, rules.patterns)
+}
+
+// body masks the JSON paths of JSON bodies, the query parameters of form
+// bodies and then the patterns. Redacted JSON bodies are re-encoded.
+func (rules *redactionRules) body(body string, contentType string) string {
+	if body == "" {
+		return body
+	}
+	if len(rules.paths) > 0 {
+		var doc interface{}
+		if json.Unmarshal([]byte(body), &doc) == nil {
+			masked := 0
+			for _, steps := range rules.paths {
+				masked += jsonPathMask(doc, steps, redactedValue)
+			}
+			if masked > 0 {
+				if data, err := json.Marshal(doc); err == nil {
+					body = string(data)
+				}
+			}
+		}
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		body = rules.query(body)
+	}
+	return redact(body, rules.patterns)
+}
+
+// jsonPathMask replaces the existing values the path selects.
+func jsonPathMask(doc interface{}, steps []jsonPathStep, value interface{}) int {
+	masked := 0
+	jsonPathUpdate(doc, steps, func(parent interface{}, step jsonPathStep) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[step.key]; ok {
+				p[step.key] = value
+				masked++
+			}
+		case []interface{}:
+			p[step.index] = value
+			masked++
+		}
+	})
+	return masked
+}
+
+// redactEntry masks a journal entry before it is stored, streamed or logged.
+func redactEntry(entry *journalEntry) {
+	if redaction.empty() {
+		return
+	}
+	entry.Path = redaction.path(entry.Path)
+	entry.Body = redaction.body(entry.Body, entry.Headers.Get("Content-Type"))
+	redactHeaders(entry.Headers)
+	if entry.ResponseRecorded {
+		entry.ResponseBody = redaction.body(entry.ResponseBody, entry.ResponseHeaders.Get("Content-Type"))
+	}
+	redactHeaders(entry.ResponseHeaders)
+}
+
+func redactHeaders(header http.Header) {
+	for name, values := range header {
+		for i, value := range values {
+			if redaction.header(name) {
+				values[i] = redactedValue
+			} else {
+				values[i] = redact(value, redaction.patterns)
+			}
+		}
+	}
+}
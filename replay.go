@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+type replayFilter struct {
+	IDs        []int64   `json:"ids,omitempty"`
+	Workspace  string    `json:"workspace,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	PathPrefix string    `json:"pathPrefix,omitempty"`
+	MockID     int       `json:"mockId,omitempty"`
+	Session    string    `json:"session,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+	Until      time.Time `json:"until,omitempty"`
+	Limit      int       `json:"limit,omitempty"`
+}
+
+type replayOptions struct {
+	Target      string       `json:"target"`
+	Concurrency int          `json:"concurrency,omitempty"`
+	Rate        float64      `json:"rate,omitempty"`
+	Timeout     jsonDuration `json:"timeout,omitempty"`
+	Filter      replayFilter `json:"filter"`
+}
+
+// rateInterval returns the time between requests sent at rate per second, 0
+// for no limit. Rates too high for a ticker to keep are rejected.
+func rateInterval(rate float64) (time.Duration, error) {
+	if rate <= 0 {
+		return 0, nil
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval <= 0 {
+		return 0, fmt.Errorf("rate %g is too high; use at most %d requests per second or 0 for no limit", rate, int(time.Second))
+	}
+	return interval, nil
+}
+
+type replayRequest struct {
+	ID         int64
+	Method     string
+	Path       string
+	Headers    http.Header
+	Body       string
+	StatusCode int
+}
+
+type replayResult struct {
+	Total            int            `json:"total"`
+	Succeeded        int            `json:"succeeded"`
+	Failed           int            `json:"failed"`
+	StatusMismatches int            `json:"statusMismatches"`
+	StatusCodes      map[string]int `json:"statusCodes"`
+	Errors           []string       `json:"errors,omitempty"`
+	Duration         jsonDuration   `json:"duration"`
+}
+
+const maxReplayErrors = 20
+
+// journalConditions turns a filter into a WHERE clause over the journal.
+func journalConditions(filter replayFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	if len(filter.IDs) > 0 {
+		add("id = ANY(?)", pq.Array(filter.IDs))
+	}
+	if filter.Workspace != "" {
+		add("workspace = ?", filter.Workspace)
+	}
+	if filter.Method != "" {
+		add("method = ?", strings.ToUpper(filter.Method))
+	}
+	if filter.Path != "" {
+		add("path = ?", filter.Path)
+	}
+	if filter.PathPrefix != "" {
+		add("starts_with(path, ?)", filter.PathPrefix)
+	}
+	if filter.MockID != 0 {
+		add("mock_id = ?", filter.MockID)
+	}
+	if filter.Session != "" {
+		header := http.CanonicalHeaderKey(envString("STATE_SESSION_HEADER", "X-Mock-Session"))
+		selector, _ := json.Marshal(map[string][]string{header: {filter.Session}})
+		add("headers @> ?::jsonb", string(selector))
+	}
+	if !filter.Since.IsZero() {
+		add("received_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("received_at < ?", filter.Until)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func loadReplayRequests(ctx context.Context, filter replayFilter) ([]replayRequest, error) {
+	if err := flushJournal(ctx); err != nil {
+		return nil, err
+	}
+	where, args := journalConditions(filter)
+	query := "SELECT id, method, path, headers, body, status_code FROM return.request_journal" + where +
+		" ORDER BY received_at, id"
+	if filter.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(filter.Limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []replayRequest
+	for rows.Next() {
+		var req replayRequest
+		var headers, body []byte
+		var status sql.NullInt64
+		if err := rows.Scan(&req.ID, &req.Method, &req.Path, &headers, &body, &status); err != nil {
+			return nil, err
+		}
+		if len(headers) > 0 {
+			if err := json.Unmarshal(headers, &req.Headers); err != nil {
+				return nil, fmt.Errorf("journal entry %d: invalid headers: %v", req.ID, err)
+			}
+		}
+		req.Body = string(body)
+		req.StatusCode = int(status.Int64)
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+func replay(ctx context.Context, opts replayOptions, requests []replayRequest) *replayResult {
+	started := time.Now()
+	target := strings.TrimSuffix(opts.Target, "/")
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	client := &http.Client{
+		Timeout: time.Duration(opts.Timeout),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if client.Timeout <= 0 {
+		client.Timeout = 30 * time.Second
+	}
+
+	var ticker *time.Ticker
+	if interval, _ := rateInterval(opts.Rate); interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+
+	result := &replayResult{StatusCodes: make(map[string]int)}
+	var mu sync.Mutex
+	record := func(req replayRequest, status int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		result.Total++
+		if err != nil {
+			result.Failed++
+			if len(result.Errors) < maxReplayErrors {
+				result.Errors = append(result.Errors, fmt.Sprintf("journal entry %d: %v", req.ID, err))
+			}
+			return
+		}
+		result.Succeeded++
+		result.StatusCodes[strconv.Itoa(status)]++
+		if req.StatusCode != 0 && req.StatusCode != status {
+			result.StatusMismatches++
+		}
+	}
+
+	queue := make(chan replayRequest)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range queue {
+				status, err := sendReplayRequest(ctx, client, target, req)
+				record(req, status, err)
+			}
+		}()
+	}
+
+feed:
+	for _, req := range requests {
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		select {
+		case queue <- req:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	result.Duration = jsonDuration(time.Since(started))
+	return result
+}
+
+func sendReplayRequest(ctx context.Context, client *http.Client, target string, req replayRequest) (int, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, target+req.Path, strings.NewReader(req.Body))
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range req.Headers {
+		httpReq.Header[key] = values
+	}
+	for _, name := range hopByHopHeaders {
+		httpReq.Header.Del(name)
+	}
+	httpReq.Header.Del("Content-Length")
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func replayHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var opts replayOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid replay request: " + err.Error()})
+		return
+	}
+	if opts.Target == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "target is required"})
+		return
+	}
+	if _, err := rateInterval(opts.Rate); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	requests, err := loadReplayRequests(r.Context(), opts.Filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error loading journal"})
+		log.Printf("Error loading journal for replay: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, replay(r.Context(), opts, requests))
+}
+
+func runReplayCommand(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	var opts replayOptions
+	var ids, since, until string
+	flags.StringVar(&opts.Target, "target", "", "base URL requests are replayed against (required)")
+	flags.IntVar(&opts.Concurrency, "concurrency", 1, "number of requests in flight")
+	flags.Float64Var(&opts.Rate, "rate", 0, "maximum requests per second (0 means unlimited)")
+	flags.DurationVar((*time.Duration)(&opts.Timeout), "timeout", 30*time.Second, "timeout of each request")
+	flags.StringVar(&ids, "ids", "", "comma-separated journal entry IDs")
+	flags.StringVar(&opts.Filter.Workspace, "workspace", "", "only replay requests of this workspace")
+	flags.StringVar(&opts.Filter.Method, "method", "", "only replay requests with this method")
+	flags.StringVar(&opts.Filter.PathPrefix, "path-prefix", "", "only replay requests whose path starts with this prefix")
+	flags.IntVar(&opts.Filter.MockID, "mock-id", 0, "only replay requests served by this mock")
+	flags.StringVar(&opts.Filter.Session, "session", "", "only replay requests sent with this session header")
+	flags.StringVar(&since, "since", "", "only replay requests received at or after this RFC3339 time")
+	flags.StringVar(&until, "until", "", "only replay requests received before this RFC3339 time")
+	flags.IntVar(&opts.Filter.Limit, "limit", 0, "maximum number of requests to replay")
+	flags.Parse(args)
+
+	if opts.Target == "" {
+		fmt.Fprintln(os.Stderr, "replay: -target is required")
+		flags.Usage()
+		return 2
+	}
+	if _, err := rateInterval(opts.Rate); err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		return 2
+	}
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: invalid id %q\n", id)
+			return 2
+		}
+		opts.Filter.IDs = append(opts.Filter.IDs, parsed)
+	}
+	for _, bound := range []struct {
+		value string
+		dest  *time.Time
+	}{{since, &opts.Filter.Since}, {until, &opts.Filter.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: invalid time %q: %v\n", bound.value, err)
+			return 2
+		}
+		*bound.dest = parsed
+	}
+
+	if err := initDB(); err != nil {
+		fmt.Fprintln(os.Stderr, "Database initialization failed:", err)
+		return 1
+	}
+	defer db.Close()
+
+	requests, err := loadReplayRequests(context.Background(), opts.Filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading journal:", err)
+		return 1
+	}
+	result := replay(context.Background(), opts, requests)
+
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(output))
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
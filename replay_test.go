@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReplayRejectsRatesTooHigh(t *testing.T) {
+	for _, rate := range []float64{1e10, 1e300} {
+		if _, err := rateInterval(rate); err == nil {
+			t.Errorf("rate %g was accepted", rate)
+		}
+
+		body := `{"target": "http://localhost:1", "rate": ` + strconv.FormatFloat(rate, 'g', -1, 64) + `}`
+		w := httptest.NewRecorder()
+		replayHandler(w, httptest.NewRequest(http.MethodPost, "/__admin/journal/replay", strings.NewReader(body)), nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("rate %g: status %d, want 400", rate, w.Code)
+		}
+
+		// Callers that skip validation get no limit rather than a panic.
+		replay(context.Background(), replayOptions{Target: "http://localhost:1", Rate: rate}, nil)
+	}
+	if interval, err := rateInterval(1e9); err != nil || interval != 1 {
+		t.Errorf("rate 1e9: interval %v, error %v", interval, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// replicaDB serves mock lookups from a read replica while it keeps up with
+// the primary. Lookups go to the primary when the replica lags more than
+// maxLag, fails, or may not have the latest mock changes yet.
+type replicaDB struct {
+	db     *sql.DB
+	maxLag time.Duration
+
+	mu      sync.Mutex
+	healthy bool
+	lag     time.Duration
+	// avoidUntil keeps reads on the primary after mocks changed, so tests
+	// that create a mock and call it right away see it.
+	avoidUntil time.Time
+}
+
+var (
+	replica *replicaDB
+
+	dbReadsTotal      = expvar.NewMap("db_reads_total")
+	replicaLagSeconds = expvar.NewFloat("db_replica_lag_seconds")
+)
+
+// initReplica connects to the replica of DB_READ_DSN and starts checking
+// its lag.
+func initReplica(dsn string, maxLag, interval time.Duration) error {
+	if dsn == "" {
+		return nil
+	}
+	if dbPoolMode == poolModeTransaction {
+		dsn = poolerConnStr(dsn)
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return fmt.Errorf("error connecting to the read replica: %v", err)
+	}
+	configurePool(conn)
+
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	replica = &replicaDB{db: conn, maxLag: maxLag}
+	replica.check()
+	onMockChange(func([]int) { replica.avoid() })
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			replica.check()
+		}
+	}()
+	fmt.Printf("Mock lookups are served from the read replica while it lags less than %s\n", maxLag)
+	return nil
+}
+
+// check measures the replica's lag: zero when it has replayed all the WAL
+// it received, otherwise the age of the last replayed transaction.
+func (r *replicaDB) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var seconds float64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT CASE
+			WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END
+	`).Scan(&seconds)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wasHealthy := r.healthy
+	if err != nil {
+		r.healthy = false
+		if wasHealthy {
+			log.Printf("Read replica unavailable, using the primary: %v", err)
+		}
+		return
+	}
+	r.lag = time.Duration(seconds * float64(time.Second))
+	r.healthy = r.lag <= r.maxLag
+	replicaLagSeconds.Set(seconds)
+	if wasHealthy && !r.healthy {
+		log.Printf("Read replica lags %s, using the primary", r.lag.Round(time.Millisecond))
+	} else if !wasHealthy && r.healthy {
+		log.Printf("Read replica caught up, serving mock lookups from it again")
+	}
+}
+
+// avoid sends reads to the primary for the time the replica may lag.
+func (r *replicaDB) avoid() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.avoidUntil = time.Now().Add(max(r.lag, time.Second))
+}
+
+func (r *replicaDB) usable() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.healthy && time.Now().After(r.avoidUntil)
+}
+
+func (r *replicaDB) failed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.healthy {
+		log.Printf("Read replica query failed, using the primary until the next check: %v", err)
+	}
+	r.healthy = false
+}
+
+// queryRead runs a lookup on the replica when it is usable and on the
+// primary otherwise, or when the replica fails.
+func queryRead(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if replica != nil && replica.usable() {
+		rows, err := replica.db.QueryContext(ctx, query, args...)
+		if err == nil {
+			dbReadsTotal.Add("replica", 1)
+			return rows, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		replica.failed(err)
+	}
+	dbReadsTotal.Add("primary", 1)
+	return db.QueryContext(ctx, query, args...)
+}
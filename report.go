@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// testReport summarises the requests of a test run from the journal: the
+// mocks they hit, the expected mocks they never hit and the requests no mock
+// answered.
+type testReport struct {
+	GeneratedAt time.Time  `json:"generatedAt"`
+	Workspace   string     `json:"workspace,omitempty"`
+	Session     string     `json:"session,omitempty"`
+	Since       *time.Time `json:"since,omitempty"`
+	Until       *time.Time `json:"until,omitempty"`
+	Labels      []string   `json:"labels,omitempty"`
+	Requests    int64      `json:"requests"`
+	// Passed is true when every expected mock was hit and every request
+	// matched a mock.
+	Passed     bool           `json:"passed"`
+	HitMocks   []reportedMock `json:"hitMocks"`
+	UnhitMocks []reportedMock `json:"unhitMocks"`
+	// UnmatchedTotal counts the unmatched requests; UnmatchedRequests lists
+	// the first maxReportedRequests of them.
+	UnmatchedTotal    int64              `json:"unmatchedTotal"`
+	UnmatchedRequests []unmatchedRequest `json:"unmatchedRequests"`
+}
+
+type reportedMock struct {
+	ID        int               `json:"id"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Workspace string            `json:"workspace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Hits      int64             `json:"hits"`
+	LastHit   *time.Time        `json:"lastHit,omitempty"`
+	// Deleted marks hit mocks that have been deleted or trashed since.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+type unmatchedRequest struct {
+	JournalID  int64     `json:"journalId"`
+	RequestID  string    `json:"requestId,omitempty"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	StatusCode int       `json:"statusCode"`
+}
+
+const maxReportedRequests = 1000
+
+// reportScope reads the run a report covers from its query: journaled
+// requests of a workspace, a session or a time range, and the mocks with
+// the label selectors as the ones expected to be hit.
+func reportScope(query url.Values) (replayFilter, mockFilter, error) {
+	filter := replayFilter{Workspace: query.Get("workspace"), Session: query.Get("session")}
+	for _, bound := range []struct {
+		name string
+		time *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := query.Get(bound.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return replayFilter{}, mockFilter{}, adminRequestError(bound.name + " must be an RFC3339 time")
+			}
+			*bound.time = parsed
+		}
+	}
+
+	expected := mockFilter{conditions: []string{"deleted_at IS NULL", "enabled"}}
+	if filter.Workspace != "" {
+		// Mocks without a workspace answer in every workspace.
+		expected.add("(workspace IS NULL OR workspace = ?)", filter.Workspace)
+	}
+	if err := expected.addLabels(query["label"]); err != nil {
+		return replayFilter{}, mockFilter{}, adminRequestError(err.Error())
+	}
+	return filter, expected, nil
+}
+
+// journalWhere adds a condition to the journal conditions of a filter.
+func journalWhere(filter replayFilter, condition string) (string, []interface{}) {
+	where, args := journalConditions(filter)
+	if where == "" {
+		return " WHERE " + condition, args
+	}
+	return where + " AND " + condition, args
+}
+
+func buildTestReport(ctx context.Context, filter replayFilter, expected mockFilter) (*testReport, error) {
+	if err := flushJournal(ctx); err != nil {
+		return nil, err
+	}
+	report := &testReport{
+		GeneratedAt:       time.Now().UTC(),
+		Workspace:         filter.Workspace,
+		Session:           filter.Session,
+		UnhitMocks:        []reportedMock{},
+		UnmatchedRequests: []unmatchedRequest{},
+	}
+	if !filter.Since.IsZero() {
+		report.Since = &filter.Since
+	}
+	if !filter.Until.IsZero() {
+		report.Until = &filter.Until
+	}
+
+	where, args := journalConditions(filter)
+	err := db.QueryRowContext(ctx, "SELECT count(*), count(*) FILTER (WHERE mock_id IS NULL) FROM return.request_journal"+where, args...).
+		Scan(&report.Requests, &report.UnmatchedTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT h.mock_id, COALESCE(m.method, ''), COALESCE(m.path, ''), COALESCE(m.workspace, ''), m.labels::text,
+		       h.hits, h.last_hit, m.id IS NULL OR m.deleted_at IS NOT NULL
+		FROM (SELECT mock_id, count(*) AS hits, max(received_at) AS last_hit
+		      FROM return.request_journal`+where+`
+		      GROUP BY mock_id HAVING mock_id IS NOT NULL) h
+		LEFT JOIN return.mock_responses m ON m.id = h.mock_id
+		ORDER BY h.hits DESC, h.mock_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	if report.HitMocks, err = scanReportedMocks(rows, true); err != nil {
+		return nil, err
+	}
+	hit := make(map[int]bool, len(report.HitMocks))
+	for _, mock := range report.HitMocks {
+		hit[mock.ID] = true
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT id, method, path, COALESCE(workspace, ''), labels::text FROM return.mock_responses"+
+		expected.where()+" ORDER BY id", expected.args...)
+	if err != nil {
+		return nil, err
+	}
+	expectedMocks, err := scanReportedMocks(rows, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, mock := range expectedMocks {
+		if !hit[mock.ID] {
+			report.UnhitMocks = append(report.UnhitMocks, mock)
+		}
+	}
+
+	where, args = journalWhere(filter, "mock_id IS NULL")
+	rows, err = db.QueryContext(ctx, "SELECT id, COALESCE(request_id, ''), received_at, method, path, COALESCE(status_code, 0) FROM return.request_journal"+
+		where+" ORDER BY received_at, id LIMIT "+strconv.Itoa(maxReportedRequests), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var request unmatchedRequest
+		if err := rows.Scan(&request.JournalID, &request.RequestID, &request.ReceivedAt, &request.Method, &request.Path, &request.StatusCode); err != nil {
+			return nil, err
+		}
+		report.UnmatchedRequests = append(report.UnmatchedRequests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report.Passed = len(report.UnhitMocks) == 0 && report.UnmatchedTotal == 0
+	return report, nil
+}
+
+// scanReportedMocks reads mocks, with their hits and whether they are gone
+// when withHits is set.
+func scanReportedMocks(rows *sql.Rows, withHits bool) ([]reportedMock, error) {
+	defer rows.Close()
+	mocks := []reportedMock{}
+	for rows.Next() {
+		var mock reportedMock
+		var labels sql.NullString
+		var lastHit sql.NullTime
+		dest := []interface{}{&mock.ID, &mock.Method, &mock.Path, &mock.Workspace, &labels}
+		if withHits {
+			dest = append(dest, &mock.Hits, &lastHit, &mock.Deleted)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if labels.Valid {
+			json.Unmarshal([]byte(labels.String), &mock.Labels)
+		}
+		if lastHit.Valid {
+			mock.LastHit = &lastHit.Time
+		}
+		mocks = append(mocks, mock)
+	}
+	return mocks, rows.Err()
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Mock report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f4f4f4; }
+.passed { color: #1a7f37; }
+.failed { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>Mock report {{if .Passed}}<span class="passed">passed</span>{{else}}<span class="failed">failed</span>{{end}}</h1>
+<p>
+Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}
+{{- with .Workspace}} · workspace {{.}}{{end}}
+{{- with .Session}} · session {{.}}{{end}}
+{{- with .Since}} · since {{.Format "2006-01-02 15:04:05 MST"}}{{end}}
+{{- with .Until}} · until {{.Format "2006-01-02 15:04:05 MST"}}{{end}}
+{{- range .Labels}} · label {{.}}{{end}}
+</p>
+<p>{{.Requests}} requests, {{len .HitMocks}} mocks hit, {{len .UnhitMocks}} expected mocks never hit, {{.UnmatchedTotal}} unmatched requests.</p>
+
+<h2>Expected mocks never hit</h2>
+{{if .UnhitMocks}}<table>
+<tr><th>ID</th><th>Method</th><th>Path</th><th>Workspace</th></tr>
+{{range .UnhitMocks}}<tr><td>{{.ID}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.Workspace}}</td></tr>
+{{end}}</table>{{else}}<p>None.</p>{{end}}
+
+<h2>Unmatched requests</h2>
+{{if .UnmatchedRequests}}<table>
+<tr><th>Received</th><th>Method</th><th>Path</th><th>Status</th><th>Request ID</th></tr>
+{{range .UnmatchedRequests}}<tr><td>{{.ReceivedAt.Format "15:04:05.000"}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.StatusCode}}</td><td>{{.RequestID}}</td></tr>
+{{end}}</table>
+{{if lt (len .UnmatchedRequests) .UnmatchedTotal}}<p>Showing the first {{len .UnmatchedRequests}}.</p>{{end}}{{else}}<p>None.</p>{{end}}
+
+<h2>Mocks hit</h2>
+{{if .HitMocks}}<table>
+<tr><th>ID</th><th>Method</th><th>Path</th><th>Workspace</th><th>Hits</th><th>Last hit</th></tr>
+{{range .HitMocks}}<tr><td>{{.ID}}{{if .Deleted}} (deleted){{end}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.Workspace}}</td><td>{{.Hits}}</td><td>{{with .LastHit}}{{.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p>None.</p>{{end}}
+</body>
+</html>
+`))
+
+// reportHandler serves the report of a test run as JSON or, with
+// format=html, as a page to keep as a CI artifact.
+func reportHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !journalEnabled {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "the request journal is not enabled; set JOURNAL_ENABLED=true"})
+		return
+	}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "html" {
+		writeAdminError(w, adminRequestError("format must be json or html"), "error building report")
+		return
+	}
+	filter, expected, err := reportScope(query)
+	if err != nil {
+		writeAdminError(w, err, "error building report")
+		return
+	}
+	report, err := buildTestReport(r.Context(), filter, expected)
+	if err != nil {
+		writeAdminError(w, err, "error building report")
+		return
+	}
+	report.Labels = query["label"]
+
+	if format != "html" {
+		w.Header().Set("Content-Disposition", `attachment; filename="mock-report.json"`)
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="mock-report.html"`)
+	if err := reportTemplate.Execute(w, report); err != nil {
+		requestLogf(r, "Error rendering report: %v", err)
+	}
+}
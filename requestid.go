@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type requestIDKey struct{}
+
+var (
+	requestIDHeader = http.CanonicalHeaderKey(envString("REQUEST_ID_HEADER", "X-Request-Id"))
+	requestIDEcho   = envBool("REQUEST_ID_ECHO", true)
+)
+
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if id == "" {
+			id = newUUID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		if requestIDEcho {
+			w.Header().Set(requestIDHeader, id)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return r.Header.Get(requestIDHeader)
+}
+
+func requestLogf(r *http.Request, format string, args ...interface{}) {
+	if id := requestID(r); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Output(2, fmt.Sprintf(format, args...))
+}
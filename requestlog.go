@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// logLevel is how much of a request is logged once it is answered: nothing
+// (quiet), one summary line, or the line with headers and bodies (verbose).
+type logLevel string
+
+const (
+	logQuiet   logLevel = "quiet"
+	logSummary logLevel = "summary"
+	logVerbose logLevel = "verbose"
+)
+
+func (l *logLevel) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	level, err := parseLogLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	switch level := logLevel(strings.ToLower(strings.TrimSpace(s))); level {
+	case "":
+		return "", nil
+	case logQuiet, logSummary, logVerbose:
+		return level, nil
+	}
+	return "", fmt.Errorf("invalid log level %q; use quiet, summary or verbose", s)
+}
+
+// logOptions override the request logging of one mock.
+type logOptions struct {
+	Level logLevel `json:"level,omitempty"`
+	// Redact lists further patterns masked in this mock's logged bodies.
+	Redact []string `json:"redact,omitempty"`
+}
+
+// requestLogConfig is read from the LOG_CONFIG file.
+type requestLogConfig struct {
+	Level        logLevel `json:"level,omitempty"`
+	VerbosePaths []string `json:"verbosePaths,omitempty"`
+	// Redact lists regular expressions masked in logged bodies and header
+	// values; with capture groups only the groups are masked.
+	Redact        []string `json:"redact,omitempty"`
+	RedactHeaders []string `json:"redactHeaders,omitempty"`
+	// MaxBody caps the logged bytes of each body; 0 logs them whole.
+	MaxBody byteSize `json:"maxBody,omitempty"`
+}
+
+const redactedValue = "[REDACTED]"
+
+var (
+	requestLog = requestLogConfig{
+		Level:         logQuiet,
+		RedactHeaders: []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"},
+		MaxBody:       4 << 10,
+	}
+	requestLogRedact []*regexp.Regexp
+
+	// mockRedactPatterns caches the compiled patterns of mock options.
+	mockRedactPatterns sync.Map
+)
+
+// loadRequestLogging reads the LOG_CONFIG file, if any; a LOG_REQUESTS
+// level wins over the file's.
+func loadRequestLogging(file, level string) error {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading log config: %v", err)
+		}
+		if err := json.Unmarshal(data, &requestLog); err != nil {
+			return fmt.Errorf("invalid log config: %v", err)
+		}
+	}
+	if level != "" {
+		parsed, err := parseLogLevel(level)
+		if err != nil {
+			return err
+		}
+		requestLog.Level = parsed
+	}
+	if requestLog.Level == "" {
+		requestLog.Level = logQuiet
+	}
+	for _, pattern := range requestLog.VerbosePaths {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("invalid verbose path %q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range requestLog.Redact {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		requestLogRedact = append(requestLogRedact, re)
+	}
+	return nil
+}
+
+// requestLogLevel returns the level of a request: the matched mock's, then
+// verbose for the paths of verbosePaths, then the configured level.
+func requestLogLevel(r *http.Request, opts *logOptions) logLevel {
+	if opts != nil && opts.Level != "" {
+		return opts.Level
+	}
+	for _, pattern := range requestLog.VerbosePaths {
+		if matched, _ := path.Match(pattern, r.URL.Path); matched {
+			return logVerbose
+		}
+	}
+	return requestLog.Level
+}
+
+// bodyCapture is how much of the response body a request at the level keeps
+// for its log line.
+func bodyCapture(level logLevel) int {
+	if level != logVerbose {
+		return 0
+	}
+	if requestLog.MaxBody <= 0 {
+		return math.MaxInt
+	}
+	return int(requestLog.MaxBody)
+}
+
+func redactPatterns(opts *logOptions) []*regexp.Regexp {
+	if opts == nil || len(opts.Redact) == 0 {
+		return requestLogRedact
+	}
+	patterns := append([]*regexp.Regexp{}, requestLogRedact...)
+	for _, pattern := range opts.Redact {
+		if cached, ok := mockRedactPatterns.Load(pattern); ok {
+			patterns = append(patterns, cached.(*regexp.Regexp))
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			// Mask what cannot be checked rather than leak it.
+			re = regexp.MustCompile(`(?s).+`)
+		}
+		mockRedactPatterns.Store(pattern, re)
+		patterns = append(patterns, re)
+	}
+	return patterns
+}
+
+// redact masks the matches of the patterns, or only their capture groups
+// when they have some, as in "password":"([^"]*)".
+func redact(s string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		if re.NumSubexp() == 0 {
+			s = re.ReplaceAllLiteralString(s, redactedValue)
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, match := range re.FindAllStringSubmatchIndex(s, -1) {
+			for i := 2; i < len(match); i += 2 {
+				if match[i] < last {
+					continue
+				}
+				b.WriteString(s[last:match[i]])
+				b.WriteString(redactedValue)
+				last = match[i+1]
+			}
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s
+}
+
+func logBody(body string, patterns []*regexp.Regexp) string {
+	if body == "" {
+		return "(empty)"
+	}
+	limit := int(requestLog.MaxBody)
+	truncated := ""
+	if limit > 0 && len(body) > limit {
+		truncated = fmt.Sprintf(" ... (%d bytes)", len(body))
+		body = body[:limit]
+	}
+	// Captured and truncated bodies may end inside a character.
+	for i := 1; i < utf8.UTFMax && !utf8.ValidString(body); i++ {
+		body = body[:len(body)-1]
+	}
+	if !utf8.ValidString(body) {
+		return fmt.Sprintf("(%d binary bytes)", len(body))
+	}
+	return redact(body, patterns) + truncated
+}
+
+func logHeaders(b *strings.Builder, prefix string, header http.Header, patterns []*regexp.Regexp) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hidden := redaction.header(name)
+		for _, redacted := range requestLog.RedactHeaders {
+			hidden = hidden || strings.EqualFold(name, redacted)
+		}
+		for _, value := range header[name] {
+			if hidden {
+				value = redactedValue
+			}
+			fmt.Fprintf(b, "\n  %s %s: %s", prefix, name, redact(value, patterns))
+		}
+	}
+}
+
+// logRequest writes the log line of an answered request at its level.
+func logRequest(r *http.Request, entry *journalEntry, rec *statusRecorder, opts *logOptions) {
+	level := requestLogLevel(r, opts)
+	if level == logQuiet {
+		return
+	}
+	patterns := redactPatterns(opts)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s -> %d", entry.Method, redact(entry.Path, patterns), rec.status)
+	if entry.MockID != 0 {
+		fmt.Fprintf(&b, " (mock %d, %s)", entry.MockID, entry.Duration.Round(time.Microsecond))
+	} else {
+		fmt.Fprintf(&b, " (%s)", entry.Duration.Round(time.Microsecond))
+	}
+	if level == logVerbose {
+		// The entry's path, headers and body were redacted for the journal.
+		logHeaders(&b, ">", entry.Headers, patterns)
+		fmt.Fprintf(&b, "\n  > %s", logBody(entry.Body, patterns))
+		responsePatterns := append(append([]*regexp.Regexp{}, patterns...), redaction.patterns...)
+		logHeaders(&b, "<", rec.Header(), responsePatterns)
+		captured := rec.captured
+		if limit := bodyCapture(level); len(captured) > limit {
+			// The rest was kept for the journal.
+			captured = captured[:limit]
+		}
+		body := redaction.body(string(captured), rec.Header().Get("Content-Type"))
+		if rec.written > len(captured) {
+			fmt.Fprintf(&b, "\n  < %s ... (%d bytes)", logBody(body, patterns), rec.written)
+		} else {
+			fmt.Fprintf(&b, "\n  < %s", logBody(body, patterns))
+		}
+	}
+	requestLogf(r, "%s", b.String())
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var resetTargets = []string{"counters", "state", "journal", "crud"}
+
+func deleteWorkspaceRows(ctx context.Context, table, workspace string) (int64, error) {
+	query := "DELETE FROM return." + table
+	var args []interface{}
+	if workspace != "" {
+		query += " WHERE workspace = $1"
+		args = append(args, workspace)
+	}
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// selectedResetTargets returns the given targets, or all of them when none
+// are given.
+func selectedResetTargets(targets []string) map[string]bool {
+	selected := make(map[string]bool)
+	for _, target := range targets {
+		if target = strings.TrimSpace(target); target != "" {
+			selected[target] = true
+		}
+	}
+	if len(selected) == 0 {
+		for _, target := range resetTargets {
+			selected[target] = true
+		}
+	}
+	return selected
+}
+
+// resetMemoryState clears the selected targets kept in memory, which other
+// instances have to clear too.
+func resetMemoryState(selected map[string]bool, workspace string) {
+	if selected["counters"] {
+		resetStatusSequences(workspace)
+		rateLimits.reset(workspace)
+		breakers.close(workspace, "")
+		idempotencyKeys.reset(workspace)
+	}
+	if selected["state"] {
+		sessionStore.reset(workspace)
+	}
+}
+
+// resetState clears the given targets (all of them when none are given),
+// optionally only for one workspace, and reports what was cleared.
+func resetState(ctx context.Context, targets []string, workspace string) (map[string]interface{}, error) {
+	selected := selectedResetTargets(targets)
+	for target := range selected {
+		known := false
+		for _, candidate := range resetTargets {
+			known = known || candidate == target
+		}
+		if !known {
+			return nil, adminRequestError("unknown reset target " + target + "; use " + strings.Join(resetTargets, ", "))
+		}
+	}
+
+	result := map[string]interface{}{}
+	var cleared []string
+	resetMemoryState(selected, workspace)
+	if selected["counters"] {
+		cleared = append(cleared, "counters")
+	}
+	if selected["state"] {
+		cleared = append(cleared, "state")
+	}
+	if selected["journal"] {
+		deleted, err := deleteWorkspaceRows(ctx, "request_journal", workspace)
+		if err != nil {
+			return nil, fmt.Errorf("clearing journal: %v", err)
+		}
+		result["journalEntries"] = deleted
+		cleared = append(cleared, "journal")
+	}
+	if selected["crud"] {
+		deleted, err := deleteWorkspaceRows(ctx, "crud_records", workspace)
+		if err != nil {
+			return nil, fmt.Errorf("clearing CRUD records: %v", err)
+		}
+		result["crudRecords"] = deleted
+		cleared = append(cleared, "crud")
+	}
+
+	publishClusterEvent(clusterEvent{Type: clusterResetEvent, Targets: cleared, Workspace: workspace})
+	result["reset"] = cleared
+	if workspace != "" {
+		result["workspace"] = workspace
+	}
+	return result, nil
+}
+
+// resetHandler returns the router to a clean slate without touching mocks.
+// only=counters,state,journal,crud picks what is cleared (everything by
+// default) and workspace limits the reset to one workspace.
+func resetHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	var targets []string
+	for _, value := range query["only"] {
+		targets = append(targets, strings.Split(value, ",")...)
+	}
+	result, err := resetState(r.Context(), targets, query.Get("workspace"))
+	if err != nil {
+		writeAdminError(w, err, "error resetting state")
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
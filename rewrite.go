@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// requestRewrite normalizes the requests that match its conditions before
+// mocks are looked up, so clients configured differently can share mocks.
+type requestRewrite struct {
+	// Path is a path glob; a rule with StripPrefix and no Path applies to the
+	// paths below the prefix.
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+	// When maps header names to regular expressions their values must match.
+	When map[string]string `json:"when,omitempty"`
+
+	StripPrefix   string            `json:"stripPrefix,omitempty"`
+	AddPrefix     string            `json:"addPrefix,omitempty"`
+	SetHeaders    map[string]string `json:"setHeaders,omitempty"`
+	RenameHeaders map[string]string `json:"renameHeaders,omitempty"`
+	RemoveHeaders []string          `json:"removeHeaders,omitempty"`
+	// RemoveFields are JSONPaths of fields deleted from JSON bodies.
+	RemoveFields []string `json:"removeFields,omitempty"`
+
+	when map[string]*regexp.Regexp
+}
+
+func (rw *requestRewrite) validate() error {
+	if rw.Path != "" {
+		if _, err := path.Match(rw.Path, "/"); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %v", rw.Path, err)
+		}
+	}
+	if rw.StripPrefix != "" && !strings.HasPrefix(rw.StripPrefix, "/") || rw.AddPrefix != "" && !strings.HasPrefix(rw.AddPrefix, "/") {
+		return fmt.Errorf("prefixes must start with /")
+	}
+	rw.when = make(map[string]*regexp.Regexp, len(rw.When))
+	for name, pattern := range rw.When {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for header %s: %v", name, err)
+		}
+		rw.when[name] = re
+	}
+	for _, expr := range rw.RemoveFields {
+		if _, err := parseJSONPath(expr); err != nil {
+			return err
+		}
+	}
+	if rw.StripPrefix == "" && rw.AddPrefix == "" && len(rw.SetHeaders) == 0 && len(rw.RenameHeaders) == 0 &&
+		len(rw.RemoveHeaders) == 0 && len(rw.RemoveFields) == 0 {
+		return fmt.Errorf("the rule changes nothing")
+	}
+	return nil
+}
+
+func (rw *requestRewrite) matches(r *http.Request) bool {
+	if rw.Method != "" && !strings.EqualFold(rw.Method, r.Method) {
+		return false
+	}
+	if rw.Path != "" {
+		if matched, _ := path.Match(rw.Path, r.URL.Path); !matched {
+			return false
+		}
+	} else if rw.StripPrefix != "" && stripPathPrefix(r.URL.Path, rw.StripPrefix) == r.URL.Path {
+		return false
+	}
+	for name, re := range rw.when {
+		if !re.MatchString(r.Header.Get(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// stripPathPrefix removes prefix from a path when it ends at a segment
+// boundary, and returns the path unchanged otherwise.
+func stripPathPrefix(urlPath, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	rest, ok := strings.CutPrefix(urlPath, prefix)
+	if !ok || rest != "" && rest[0] != '/' {
+		return urlPath
+	}
+	if rest == "" {
+		return "/"
+	}
+	return rest
+}
+
+// apply rewrites the path and headers of a request.
+func (rw *requestRewrite) apply(r *http.Request) {
+	if rw.StripPrefix != "" {
+		r.URL.Path = stripPathPrefix(r.URL.Path, rw.StripPrefix)
+	}
+	if rw.AddPrefix != "" {
+		r.URL.Path = strings.TrimSuffix(rw.AddPrefix, "/") + r.URL.Path
+	}
+	if rw.StripPrefix != "" || rw.AddPrefix != "" {
+		r.URL.RawPath = ""
+	}
+	for from, to := range rw.RenameHeaders {
+		if values := r.Header.Values(from); len(values) > 0 {
+			r.Header.Del(from)
+			r.Header[http.CanonicalHeaderKey(to)] = values
+		}
+	}
+	for _, name := range rw.RemoveHeaders {
+		r.Header.Del(name)
+	}
+	for name, value := range rw.SetHeaders {
+		r.Header.Set(name, value)
+	}
+}
+
+var (
+	requestRewritesMu sync.RWMutex
+	requestRewrites   []requestRewrite
+)
+
+// loadRequestRewrites reads the initial rules from a JSON file.
+func loadRequestRewrites(file string) error {
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading request rewrites: %v", err)
+	}
+	var rewrites []requestRewrite
+	if err := json.Unmarshal(data, &rewrites); err != nil {
+		return fmt.Errorf("invalid request rewrites: %v", err)
+	}
+	if err := setRequestRewrites(rewrites); err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d request rewrites from %s\n", len(rewrites), file)
+	return nil
+}
+
+func setRequestRewrites(rewrites []requestRewrite) error {
+	for i := range rewrites {
+		if err := rewrites[i].validate(); err != nil {
+			return fmt.Errorf("rewrite %d: %v", i, err)
+		}
+	}
+	requestRewritesMu.Lock()
+	requestRewrites = rewrites
+	requestRewritesMu.Unlock()
+	return nil
+}
+
+// rewriteRequest applies the rules in order to the path and headers of a
+// request, each rule to the request as the previous ones left it. The rules
+// that applied are returned for rewriteBody.
+func rewriteRequest(r *http.Request) []requestRewrite {
+	requestRewritesMu.RLock()
+	defer requestRewritesMu.RUnlock()
+	var matched []requestRewrite
+	for _, rw := range requestRewrites {
+		if rw.matches(r) {
+			rw.apply(r)
+			matched = append(matched, rw)
+		}
+	}
+	return matched
+}
+
+// rewriteBody deletes the fields of a JSON body the rules remove. Other
+// bodies, and encoded ones, are returned as they are.
+func rewriteBody(r *http.Request, body string, rewrites []requestRewrite) string {
+	var exprs []string
+	for _, rw := range rewrites {
+		exprs = append(exprs, rw.RemoveFields...)
+	}
+	if len(exprs) == 0 || body == "" || r.Header.Get("Content-Encoding") != "" {
+		return body
+	}
+	var doc interface{}
+	if err := json.Unmarshal(bytesOf(body), &doc); err != nil {
+		return body
+	}
+	removed := 0
+	for _, expr := range exprs {
+		steps, _ := parseJSONPath(expr)
+		removed += jsonPathRemove(doc, steps)
+	}
+	if removed == 0 {
+		return body
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	r.ContentLength = int64(len(data))
+	return string(data)
+}
+
+func listRequestRewritesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	requestRewritesMu.RLock()
+	rewrites := requestRewrites
+	requestRewritesMu.RUnlock()
+	if rewrites == nil {
+		rewrites = []requestRewrite{}
+	}
+	writeJSON(w, http.StatusOK, rewrites)
+}
+
+// putRequestRewritesHandler replaces all rules.
+func putRequestRewritesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var rewrites []requestRewrite
+	if err := json.NewDecoder(r.Body).Decode(&rewrites); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid rewrites: " + err.Error()})
+		return
+	}
+	if err := setRequestRewrites(rewrites); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Request rewrites replaced: %d", len(rewrites))
+	listRequestRewritesHandler(w, r, nil)
+}
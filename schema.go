@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+type schemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+var compiledSchemas sync.Map
+
+func compileSchema(schema string) (*jsonschema.Schema, error) {
+	if cached, ok := compiledSchemas.Load(schema); ok {
+		return cached.(*jsonschema.Schema), nil
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema references are not supported: %s", url)
+	}
+	if err := compiler.AddResource("request.json", strings.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	compiled, err := compiler.Compile("request.json")
+	if err != nil {
+		return nil, err
+	}
+
+	compiledSchemas.Store(schema, compiled)
+	return compiled, nil
+}
+
+func validateBody(schema string, body string) ([]schemaViolation, error) {
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %v", err)
+	}
+
+	var doc interface{}
+	if strings.TrimSpace(body) != "" {
+		decoder := json.NewDecoder(strings.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return []schemaViolation{{Path: "", Message: "request body is not valid JSON"}}, nil
+		}
+	}
+
+	err = compiled.Validate(doc)
+	if err == nil {
+		return nil, nil
+	}
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil, err
+	}
+	return collectViolations(validationErr, nil), nil
+}
+
+func collectViolations(ve *jsonschema.ValidationError, violations []schemaViolation) []schemaViolation {
+	if len(ve.Causes) == 0 {
+		return append(violations, schemaViolation{Path: ve.InstanceLocation, Message: ve.Message})
+	}
+	for _, cause := range ve.Causes {
+		violations = collectViolations(cause, violations)
+	}
+	return violations
+}
+
+func getPathSchema(ctx context.Context, path, method string) (string, error) {
+	var schema string
+	err := db.QueryRowContext(ctx, `
+		SELECT schema
+		FROM return.request_schemas
+		WHERE path = $1
+		  AND (method IS NULL OR method = $2)
+		ORDER BY method IS NULL
+		LIMIT 1
+	`, path, method).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return schema, err
+}
+
+var pathSchemasEnabled = envBool("REQUEST_SCHEMAS_ENABLED", false)
+
+func enforceSchema(w http.ResponseWriter, r *http.Request, entry *journalEntry, schema, body string) bool {
+	violations, err := validateBody(schema, body)
+	if err != nil {
+		writeRouterError(w, r, "Internal server error", http.StatusInternalServerError)
+		log.Printf("Schema validation error for %s %s: %v", entry.Method, entry.Path, err)
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	entry.Violations = violations
+	writeValidationError(w, r, "request body failed schema validation", violations)
+	return false
+}
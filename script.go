@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+var (
+	scriptTimeout      = envDuration("SCRIPT_TIMEOUT", time.Second)
+	scriptMaxCallStack = envInt("SCRIPT_MAX_CALL_STACK", 256)
+	// scriptMaxStringSize bounds the strings a script builds in a single
+	// call; 0 disables the check.
+	scriptMaxStringSize = envSize("SCRIPT_MAX_STRING_SIZE", 16<<20)
+
+	compiledScripts sync.Map
+)
+
+type responseOverride struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    interface{}       `json:"body"`
+}
+
+func compileScript(source string) (*goja.Program, error) {
+	if cached, ok := compiledScripts.Load(source); ok {
+		return cached.(*goja.Program), nil
+	}
+	program, err := goja.Compile("mock-script", source, true)
+	if err != nil {
+		return nil, err
+	}
+	compiledScripts.Store(source, program)
+	return program, nil
+}
+
+func runMockScript(mockResp *MockResponse, data templateData) error {
+	program, err := compileScript(mockResp.Options.Script)
+	if err != nil {
+		return fmt.Errorf("error compiling script: %v", err)
+	}
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(scriptMaxCallStack)
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	request := map[string]interface{}{
+		"method":    data.Method,
+		"path":      data.Path,
+		"query":     flattenValues(data.Query),
+		"headers":   flattenValues(data.Header),
+		"body":      data.Body,
+		"json":      data.JSON,
+		"workspace": data.Workspace,
+		"session":   data.Session,
+		"now":       data.Now.UnixMilli(),
+	}
+	session := data.Workspace + "/" + data.Session
+	globals := map[string]interface{}{
+		"request": request,
+		"state": map[string]interface{}{
+			"get": func(key string) interface{} {
+				value, _ := sessionStore.get(session, key)
+				return value
+			},
+			"set": func(key string, value interface{}) {
+				sessionStore.set(session, key, value)
+			},
+		},
+		"console": map[string]interface{}{
+			"log": func(args ...interface{}) {
+				log.Printf("Script of mock %d: %s", mockResp.ID, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+			},
+		},
+	}
+	for name, value := range globals {
+		if err := vm.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	guardScriptAllocations(vm)
+	timer := time.AfterFunc(scriptTimeout, func() {
+		vm.Interrupt(fmt.Sprintf("script exceeded %v", scriptTimeout))
+	})
+	defer timer.Stop()
+
+	if _, err := vm.RunProgram(program); err != nil {
+		return fmt.Errorf("error running script: %v", err)
+	}
+	handle, ok := goja.AssertFunction(vm.Get("handle"))
+	if !ok {
+		return fmt.Errorf("script must define a handle(request) function")
+	}
+	result, err := handle(goja.Undefined(), vm.Get("request"))
+	if err != nil {
+		return fmt.Errorf("error running script: %v", err)
+	}
+
+	var resp responseOverride
+	if result != nil && !goja.IsUndefined(result) && !goja.IsNull(result) {
+		if err := vm.ExportTo(result, &resp); err != nil {
+			return fmt.Errorf("script returned an invalid response: %v", err)
+		}
+	}
+	if err := applyResponseOverride(mockResp, resp); err != nil {
+		return fmt.Errorf("script returned an invalid response: %v", err)
+	}
+	return nil
+}
+
+// guardScriptAllocations makes the built-ins that build a string of any
+// length in a single call throw when it would exceed SCRIPT_MAX_STRING_SIZE.
+// The check is the script's own, unlike a look at the process heap, and
+// catches what SCRIPT_TIMEOUT cannot: an interrupt only takes effect
+// between statements, after such a call has allocated.
+func guardScriptAllocations(vm *goja.Runtime) {
+	if scriptMaxStringSize <= 0 {
+		return
+	}
+	guard := func(prototype, name string, size func(call goja.FunctionCall) int64) {
+		object := vm.Get(prototype).ToObject(vm).Get("prototype").ToObject(vm)
+		original, ok := goja.AssertFunction(object.Get(name))
+		if !ok {
+			return
+		}
+		object.Set(name, func(call goja.FunctionCall) goja.Value {
+			if size(call) > scriptMaxStringSize {
+				panic(vm.NewGoError(fmt.Errorf("%s.%s would build a string over %d bytes", prototype, name, scriptMaxStringSize)))
+			}
+			result, err := original(call.This, call.Arguments...)
+			if err != nil {
+				panic(err)
+			}
+			return result
+		})
+	}
+	length := func(value goja.Value) int64 {
+		if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+			return 0
+		}
+		return int64(len(value.String()))
+	}
+	padded := func(call goja.FunctionCall) int64 {
+		return max(call.Argument(0).ToInteger(), 0) * max(length(call.Argument(1)), 1)
+	}
+	guard("String", "repeat", func(call goja.FunctionCall) int64 {
+		return length(call.This) * max(call.Argument(0).ToInteger(), 0)
+	})
+	guard("String", "padStart", padded)
+	guard("String", "padEnd", padded)
+	guard("Array", "join", func(call goja.FunctionCall) int64 {
+		separator := int64(1)
+		if sep := call.Argument(0); !goja.IsUndefined(sep) {
+			separator = length(sep)
+		}
+		return call.This.ToObject(vm).Get("length").ToInteger() * separator
+	})
+}
+
+func applyResponseOverride(mockResp *MockResponse, resp responseOverride) error {
+	if resp.Status != 0 {
+		if resp.Status < 100 || resp.Status > 999 {
+			return fmt.Errorf("invalid status %d", resp.Status)
+		}
+		mockResp.ResponseStatusCode = resp.Status
+	}
+
+	switch body := resp.Body.(type) {
+	case nil:
+	case string:
+		mockResp.ResponseBody = body
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("body cannot be encoded: %v", err)
+		}
+		mockResp.ResponseBody = string(encoded)
+	}
+
+	if len(resp.Headers) > 0 {
+		headers := parseHeaders(mockResp.Headers)
+		for key, value := range resp.Headers {
+			headers[key] = value
+		}
+		keys := make([]string, 0, len(headers))
+		for key := range headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = key + "=" + headers[key]
+		}
+		mockResp.Headers = sql.NullString{String: strings.Join(pairs, "; "), Valid: true}
+	}
+	return nil
+}
+
+func flattenValues(values map[string][]string) map[string]string {
+	flat := make(map[string]string, len(values))
+	for key, list := range values {
+		if len(list) > 0 {
+			flat[key] = list[0]
+		}
+	}
+	return flat
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// encryptedPrefix marks the values of a secrets file.
+const encryptedPrefix = "enc:"
+
+// secrets are referenced by name from templates and signing keys, so the
+// database holds no plaintext keys. They come from a file of values
+// encrypted with SECRETS_KEY, or from SECRET_* environment variables.
+var secrets = make(map[string]string)
+
+// loadSecrets decrypts the secrets file at path.
+func loadSecrets(path string) error {
+	if path == "" {
+		return nil
+	}
+	encrypted, err := readSecretsFile(path)
+	if err != nil {
+		return err
+	}
+	key, err := secretsKey()
+	if err != nil {
+		return err
+	}
+	for name, value := range encrypted {
+		plain, err := decryptSecret(key, name, value)
+		if err != nil {
+			return fmt.Errorf("secret %s: %v", name, err)
+		}
+		secrets[name] = plain
+	}
+	fmt.Printf("Loaded %d secrets from %s\n", len(secrets), path)
+	return nil
+}
+
+func readSecretsFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets: %v", err)
+	}
+	var encrypted map[string]string
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return nil, fmt.Errorf("invalid secrets file: %v", err)
+	}
+	if encrypted == nil {
+		encrypted = make(map[string]string)
+	}
+	return encrypted, nil
+}
+
+// secretsKey returns the AES-256 key of SECRETS_KEY, or of the file
+// SECRETS_KEY_FILE names, as base64.
+func secretsKey() ([]byte, error) {
+	encoded := envString("SECRETS_KEY", "")
+	if file := envString("SECRETS_KEY_FILE", ""); encoded == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading secrets key: %v", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("the secrets key is not set; set SECRETS_KEY or SECRETS_KEY_FILE")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("the secrets key must be 32 bytes in base64")
+	}
+	return key, nil
+}
+
+// encryptSecret seals a value with AES-GCM, bound to its name so values
+// cannot be swapped between names.
+func encryptSecret(key []byte, name, plain string) (string, error) {
+	aead, err := secretsCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), []byte(name))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(key []byte, name, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return "", fmt.Errorf("value is not encrypted; use mock-db-router secrets set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %v", err)
+	}
+	aead, err := secretsCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("decryption failed; wrong key?")
+	}
+	return string(plain), nil
+}
+
+func secretsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretEnvName is the environment variable a secret is read from when the
+// secrets file has no such name: "stripe-key" is SECRET_STRIPE_KEY.
+func secretEnvName(name string) string {
+	return "SECRET_" + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// lookupSecret returns a secret by name. Only SECRET_* variables are
+// visible, so templates cannot read the router's own configuration.
+func lookupSecret(name string) (string, error) {
+	if value, ok := secrets[name]; ok {
+		return value, nil
+	}
+	if value, ok := os.LookupEnv(secretEnvName(name)); ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown secret %q", name)
+}
+
+// runSecretsCommand manages secrets files: keygen prints a new key, and set
+// stores a value read from stdin.
+func runSecretsCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: mock-db-router secrets keygen")
+		fmt.Fprintln(os.Stderr, "       mock-db-router secrets set [-file secrets.json] name < value")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	switch args[0] {
+	case "keygen":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			fmt.Fprintln(os.Stderr, "secrets:", err)
+			return 1
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return 0
+	case "set":
+		flags := flag.NewFlagSet("secrets set", flag.ExitOnError)
+		file := flags.String("file", envString("SECRETS_FILE", "secrets.json"), "secrets file to update")
+		flags.Usage = usage
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			usage()
+			return 2
+		}
+		if err := setSecret(*file, flags.Arg(0), os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, "secrets:", err)
+			return 1
+		}
+		return 0
+	}
+	usage()
+	return 2
+}
+
+func setSecret(file, name string, input io.Reader) error {
+	key, err := secretsKey()
+	if err != nil {
+		return err
+	}
+	encrypted := make(map[string]string)
+	if _, err := os.Stat(file); err == nil {
+		if encrypted, err = readSecretsFile(file); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	value, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptSecret(key, name, strings.TrimSuffix(strings.TrimSuffix(string(value), "\n"), "\r"))
+	if err != nil {
+		return err
+	}
+	encrypted[name] = sealed
+	data, err := json.MarshalIndent(encrypted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0o600)
+}
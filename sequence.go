@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type statusStep struct {
+	Status     int          `json:"status"`
+	RetryAfter jsonDuration `json:"retryAfter,omitempty"`
+}
+
+func (s *statusStep) UnmarshalJSON(data []byte) error {
+	if status, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+		*s = statusStep{Status: status}
+		return nil
+	}
+	type plain statusStep
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+type statusSequenceOptions struct {
+	Steps []statusStep `json:"steps"`
+	Loop  bool         `json:"loop,omitempty"`
+}
+
+var (
+	sequencesMu sync.Mutex
+	sequences   = make(map[string]int)
+)
+
+func nextStatusStep(mockResp *MockResponse, data templateData) (statusStep, bool) {
+	opts := mockResp.Options.StatusSequence
+	if opts == nil || len(opts.Steps) == 0 {
+		return statusStep{}, false
+	}
+
+	key := data.Workspace + "|" + data.Session + "|" + strconv.Itoa(mockResp.ID)
+	attempt, shared := 0, false
+	if sharedState != nil {
+		next, err := sharedNextAttempt(key, sessionStore.ttl)
+		if err == nil {
+			attempt, shared = next, true
+		} else {
+			sharedStateFailed("sequence", err)
+		}
+	}
+	if !shared {
+		sequencesMu.Lock()
+		attempt = sequences[key]
+		sequences[key] = attempt + 1
+		sequencesMu.Unlock()
+	}
+
+	if opts.Loop {
+		attempt %= len(opts.Steps)
+	} else if attempt >= len(opts.Steps) {
+		attempt = len(opts.Steps) - 1
+	}
+	return opts.Steps[attempt], true
+}
+
+func applyStatusSequence(w http.ResponseWriter, mockResp *MockResponse, data templateData) {
+	step, ok := nextStatusStep(mockResp, data)
+	if !ok {
+		return
+	}
+	if step.Status != 0 {
+		mockResp.ResponseStatusCode = step.Status
+	}
+	if step.RetryAfter > 0 {
+		seconds := int(math.Ceil(time.Duration(step.RetryAfter).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
+
+func resetStatusSequences(workspace string) {
+	if sharedState != nil {
+		sharedDelete(sharedSequenceKind, workspaceKeyPrefix(workspace, "|"))
+	}
+	sequencesMu.Lock()
+	defer sequencesMu.Unlock()
+	for key := range sequences {
+		if workspace == "" || strings.HasPrefix(key, workspace+"|") {
+			delete(sequences, key)
+		}
+	}
+}
+
+func resetSessionSequences(workspace, session string) {
+	sequencesMu.Lock()
+	defer sequencesMu.Unlock()
+	for key := range sequences {
+		if strings.HasPrefix(key, workspace+"|"+session+"|") {
+			delete(sequences, key)
+		}
+	}
+}
+
+func resetStatusSequencesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	resetStatusSequences(r.URL.Query().Get("workspace"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func snapshotStatusSequences() map[string]int {
+	sequencesMu.Lock()
+	defer sequencesMu.Unlock()
+
+	counters := make(map[string]int, len(sequences))
+	for key, attempt := range sequences {
+		counters[key] = attempt
+	}
+	return counters
+}
+
+func restoreStatusSequences(counters map[string]int) {
+	sequencesMu.Lock()
+	defer sequencesMu.Unlock()
+
+	sequences = make(map[string]int, len(counters))
+	for key, attempt := range counters {
+		sequences[key] = attempt
+	}
+}
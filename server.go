@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const systemdListenFDsStart = 3
+
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       envDuration("SERVER_READ_TIMEOUT", time.Minute),
+		ReadHeaderTimeout: envDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		WriteTimeout:      envDuration("SERVER_WRITE_TIMEOUT", 2*time.Minute),
+		IdleTimeout:       envDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    int(envSize("SERVER_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes)),
+	}
+	server.SetKeepAlivesEnabled(envBool("SERVER_KEEP_ALIVE", true))
+	return server
+}
+
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("socket %s is already in use", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error removing stale socket %s: %v", path, err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := strconv.ParseUint(envString("UNIX_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("invalid UNIX_SOCKET_MODE: %v", err)
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error setting socket permissions: %v", err)
+	}
+	return l, nil
+}
+
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := systemdListenFDsStart; fd < systemdListenFDsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(fd))
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket activation: fd %d: %v", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func serve(l net.Listener, handler http.Handler) error {
+	return newHTTPServer(l.Addr().String(), handler).Serve(l)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// With STATE_BACKEND=redis, session state, status sequence positions and
+// rate limit windows are kept in the Redis server of REDIS_URL instead of
+// each instance's memory, so a stateful mock behind a load balancer answers
+// the same whichever instance gets the request. Every update is a single
+// atomic Redis operation. Keys expire after STATE_TTL without use, or with
+// their rate limit window; when Redis cannot be reached, instances fall back
+// to their own memory until it is back.
+const (
+	stateBackendMemory = "memory"
+	stateBackendRedis  = "redis"
+
+	sharedStateKind    = "state:"
+	sharedSequenceKind = "seq:"
+	sharedRateKind     = "rate:"
+)
+
+var (
+	stateBackend       = strings.ToLower(envString("STATE_BACKEND", stateBackendMemory))
+	sharedStatePrefix  = envString("REDIS_STATE_PREFIX", "mock-db-router:")
+	sharedStateTimeout = envDuration("REDIS_STATE_TIMEOUT", time.Second)
+
+	sharedState *redis.Client
+
+	sharedStateErrorsTotal = expvar.NewMap("shared_state_errors_total")
+)
+
+// rateHitScript counts a request in a fixed window, starting a new window
+// when the last one ended, and returns what is left, the window's end in
+// milliseconds and whether the request is allowed. Times come from the
+// router, which honours clock control.
+var rateHitScript = redis.NewScript(`
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local w = redis.call('HMGET', KEYS[1], 'start', 'end', 'count')
+local start, stop, count = tonumber(w[1]), tonumber(w[2]), tonumber(w[3])
+if not stop or now >= stop or now < start then
+  start, stop, count = now, now + window, 0
+  redis.call('HSET', KEYS[1], 'start', start, 'end', stop, 'count', 0)
+  redis.call('PEXPIRE', KEYS[1], window + 60000)
+end
+if count >= limit then
+  return {0, stop, 0}
+end
+count = redis.call('HINCRBY', KEYS[1], 'count', 1)
+return {limit - count, stop, 1}
+`)
+
+// initSharedState selects where the state of stateful mocks is kept; Redis
+// requires REDIS_URL.
+func initSharedState() error {
+	switch stateBackend {
+	case stateBackendMemory:
+		return nil
+	case stateBackendRedis:
+		if clusterRedis == nil {
+			return fmt.Errorf("STATE_BACKEND=redis requires REDIS_URL")
+		}
+		sharedState = clusterRedis
+		fmt.Printf("Keeping session state, status sequences and rate limit windows in Redis under %s\n", sharedStatePrefix)
+		return nil
+	default:
+		return fmt.Errorf("unknown STATE_BACKEND %q; use %s or %s", stateBackend, stateBackendMemory, stateBackendRedis)
+	}
+}
+
+func sharedStateContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), sharedStateTimeout)
+}
+
+// sharedStateFailed counts and logs a failed Redis operation, whose caller
+// then uses its memory.
+func sharedStateFailed(operation string, err error) {
+	sharedStateErrorsTotal.Add(operation, 1)
+	log.Printf("Error with shared %s state in Redis: %v", operation, err)
+}
+
+func sharedKey(kind, key string) string {
+	return sharedStatePrefix + kind + key
+}
+
+func sharedGetState(session, key string) (interface{}, bool, error) {
+	ctx, cancel := sharedStateContext()
+	defer cancel()
+	raw, err := sharedState.HGet(ctx, sharedKey(sharedStateKind, session), key).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func sharedSetState(session, key string, value interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := sharedStateContext()
+	defer cancel()
+	redisKey := sharedKey(sharedStateKind, session)
+	_, err = sharedState.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisKey, key, raw)
+		pipe.PExpire(ctx, redisKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// sharedTouchState keeps a session's state for another STATE_TTL.
+func sharedTouchState(session string, ttl time.Duration) error {
+	ctx, cancel := sharedStateContext()
+	defer cancel()
+	return sharedState.PExpire(ctx, sharedKey(sharedStateKind, session), ttl).Err()
+}
+
+// sharedNextAttempt counts a request of a status sequence and returns how
+// many came before it.
+func sharedNextAttempt(key string, ttl time.Duration) (int, error) {
+	ctx, cancel := sharedStateContext()
+	defer cancel()
+	redisKey := sharedKey(sharedSequenceKind, key)
+	var incr *redis.IntCmd
+	_, err := sharedState.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, redisKey)
+		pipe.PExpire(ctx, redisKey, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(incr.Val()) - 1, nil
+}
+
+func sharedRateHit(key string, limit int, window time.Duration, now time.Time) (int, time.Time, bool, error) {
+	ctx, cancel := sharedStateContext()
+	defer cancel()
+	result, err := rateHitScript.Run(ctx, sharedState, []string{sharedKey(sharedRateKind, key)},
+		now.UnixMilli(), window.Milliseconds(), limit).Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	return int(result[0]), time.UnixMilli(result[1]), result[2] == 1, nil
+}
+
+// sharedKeys lists the keys of a kind starting with prefix, without the
+// router's key prefix, and returns them with their full names.
+func sharedKeys(ctx context.Context, kind, prefix string) (keys, full []string, err error) {
+	iter := sharedState.Scan(ctx, 0, sharedKey(kind, redisGlobEscape(prefix))+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		full = append(full, iter.Val())
+		keys = append(keys, strings.TrimPrefix(iter.Val(), sharedKey(kind, "")))
+	}
+	return keys, full, iter.Err()
+}
+
+// sharedDelete deletes the keys of a kind starting with prefix.
+func sharedDelete(kind, prefix string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*sharedStateTimeout)
+	defer cancel()
+	_, full, err := sharedKeys(ctx, kind, prefix)
+	if err == nil && len(full) > 0 {
+		err = sharedState.Unlink(ctx, full...).Err()
+	}
+	if err != nil {
+		sharedStateFailed("reset", err)
+	}
+}
+
+// workspaceKeyPrefix is the prefix of the keys of a workspace, or of all keys
+// without one.
+func workspaceKeyPrefix(workspace, separator string) string {
+	if workspace == "" {
+		return ""
+	}
+	return workspace + separator
+}
+
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sharedSnapshot reads the shared state for a snapshot.
+func sharedSnapshot() (sessions map[string]map[string]interface{}, sequences map[string]int, windows []rateWindowSnapshot, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*sharedStateTimeout)
+	defer cancel()
+
+	sessions = make(map[string]map[string]interface{})
+	keys, full, err := sharedKeys(ctx, sharedStateKind, "")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i, key := range keys {
+		fields, err := sharedState.HGetAll(ctx, full[i]).Result()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		values := make(map[string]interface{}, len(fields))
+		for field, raw := range fields {
+			var value interface{}
+			if json.Unmarshal([]byte(raw), &value) == nil {
+				values[field] = value
+			}
+		}
+		sessions[key] = values
+	}
+
+	sequences = make(map[string]int)
+	keys, full, err = sharedKeys(ctx, sharedSequenceKind, "")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i, key := range keys {
+		attempt, err := sharedState.Get(ctx, full[i]).Int()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sequences[key] = attempt
+	}
+
+	keys, full, err = sharedKeys(ctx, sharedRateKind, "")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i, key := range keys {
+		w, err := sharedState.HMGet(ctx, full[i], "start", "end", "count").Result()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		start, _ := strconv.ParseInt(fmt.Sprint(w[0]), 10, 64)
+		end, _ := strconv.ParseInt(fmt.Sprint(w[1]), 10, 64)
+		count, _ := strconv.Atoi(fmt.Sprint(w[2]))
+		windows = append(windows, rateWindowSnapshot{Key: key, Start: time.UnixMilli(start), End: time.UnixMilli(end), Count: count})
+	}
+	return sessions, sequences, windows, nil
+}
+
+// sharedRestore replaces the shared state with a snapshot's.
+func sharedRestore(sessions map[string]map[string]interface{}, sequences map[string]int, windows []rateWindowSnapshot, ttl time.Duration) error {
+	for _, kind := range []string{sharedStateKind, sharedSequenceKind, sharedRateKind} {
+		sharedDelete(kind, "")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*sharedStateTimeout)
+	defer cancel()
+	_, err := sharedState.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for session, values := range sessions {
+			key := sharedKey(sharedStateKind, session)
+			for field, value := range values {
+				raw, err := json.Marshal(value)
+				if err != nil {
+					return err
+				}
+				pipe.HSet(ctx, key, field, raw)
+			}
+			pipe.PExpire(ctx, key, ttl)
+		}
+		for key, attempt := range sequences {
+			pipe.Set(ctx, sharedKey(sharedSequenceKind, key), attempt, ttl)
+		}
+		for _, w := range windows {
+			key := sharedKey(sharedRateKind, w.Key)
+			pipe.HSet(ctx, key, "start", w.Start.UnixMilli(), "end", w.End.UnixMilli(), "count", w.Count)
+			pipe.PExpire(ctx, key, w.End.Sub(w.Start)+time.Minute)
+		}
+		return nil
+	})
+	return err
+}
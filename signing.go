@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+func hmacSHA256Sum(key, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// hmacSHA256 returns the hex HMAC-SHA256 of payload, the form most webhook
+// signatures take.
+func hmacSHA256(key, payload string) string {
+	return hex.EncodeToString(hmacSHA256Sum(key, payload))
+}
+
+func hmacSHA256Base64(key, payload string) string {
+	return base64.StdEncoding.EncodeToString(hmacSHA256Sum(key, payload))
+}
+
+// signPayload signs payload with a key of TEMPLATE_JWT_KEYS, as its
+// algorithm does for JWTs: HMAC for HS*, PKCS #1 v1.5 for RS* and r || s for
+// ES*. The signature is returned as base64.
+func signPayload(keyName, payload string) (string, error) {
+	key, ok := templateJWTKeys[keyName]
+	if !ok {
+		return "", fmt.Errorf("unknown signing key %q", keyName)
+	}
+	signature, err := key.signature([]byte(payload))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
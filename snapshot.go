@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const snapshotVersion = 1
+
+type crudRecordSnapshot struct {
+	ID         int64           `json:"id"`
+	Workspace  string          `json:"workspace"`
+	Session    string          `json:"session,omitempty"`
+	Collection string          `json:"collection"`
+	RecordID   string          `json:"recordId"`
+	Body       json.RawMessage `json:"body"`
+	CreatedAt  *time.Time      `json:"createdAt,omitempty"`
+	UpdatedAt  *time.Time      `json:"updatedAt,omitempty"`
+}
+
+type clockSnapshot struct {
+	Offset jsonDuration `json:"offset"`
+	Frozen *time.Time   `json:"frozen,omitempty"`
+}
+
+// mockSnapshot is the complete state of the router: stored mocks (trashed
+// ones included), CRUD records and the in-memory scenario state and counters.
+type mockSnapshot struct {
+	Version         int                               `json:"version"`
+	CreatedAt       time.Time                         `json:"createdAt"`
+	Mocks           []mockDefinition                  `json:"mocks"`
+	CRUDRecords     []crudRecordSnapshot              `json:"crudRecords"`
+	Sessions        map[string]map[string]interface{} `json:"sessions"`
+	StatusSequences map[string]int                    `json:"statusSequences"`
+	RateLimits      []rateWindowSnapshot              `json:"rateLimits"`
+	CircuitBreakers []circuitState                    `json:"circuitBreakers"`
+	Profiles        []profile                         `json:"profiles"`
+	ActiveProfile   string                            `json:"activeProfile,omitempty"`
+	Clock           clockSnapshot                     `json:"clock"`
+}
+
+func takeSnapshot(ctx context.Context) (*mockSnapshot, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	snap := &mockSnapshot{Version: snapshotVersion, CreatedAt: time.Now().UTC()}
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+mockDefinitionColumns+" FROM return.mock_responses ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		def, err := scanMockDefinition(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snap.Mocks = append(snap.Mocks, def)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT id, workspace, session, collection, record_id, body::text, created_at, updated_at
+		FROM return.crud_records
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var record crudRecordSnapshot
+		var body string
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&record.ID, &record.Workspace, &record.Session, &record.Collection, &record.RecordID, &body, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		record.Body = json.RawMessage(body)
+		if createdAt.Valid {
+			record.CreatedAt = &createdAt.Time
+		}
+		if updatedAt.Valid {
+			record.UpdatedAt = &updatedAt.Time
+		}
+		snap.CRUDRecords = append(snap.CRUDRecords, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if sharedState != nil {
+		if snap.Sessions, snap.StatusSequences, snap.RateLimits, err = sharedSnapshot(); err != nil {
+			return nil, fmt.Errorf("reading shared state: %v", err)
+		}
+	} else {
+		snap.Sessions = sessionStore.snapshot()
+		snap.StatusSequences = snapshotStatusSequences()
+		snap.RateLimits = rateLimits.snapshot()
+	}
+	snap.CircuitBreakers = breakers.snapshot()
+	snap.Profiles, snap.ActiveProfile = profiles.snapshot()
+
+	clock.mu.RLock()
+	snap.Clock.Offset = jsonDuration(clock.offset)
+	if !clock.frozen.IsZero() {
+		frozen := clock.frozen
+		snap.Clock.Frozen = &frozen
+	}
+	clock.mu.RUnlock()
+	return snap, nil
+}
+
+func restoreMockRow(ctx context.Context, tx *sql.Tx, def mockDefinition) error {
+	if err := def.normalize(); err != nil {
+		return fmt.Errorf("mock %d: %v", def.ID, err)
+	}
+	var requestBodyHash sql.NullString
+	if len(def.RequestBody) > 0 {
+		hash, err := bodyHash(string(def.RequestBody))
+		if err != nil {
+			return fmt.Errorf("mock %d: %v", def.ID, err)
+		}
+		requestBodyHash = nullIfEmpty(hash)
+	}
+	createdAt := time.Now()
+	if def.CreatedAt != nil {
+		createdAt = *def.CreatedAt
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO return.mock_responses
+			(id, path, method, request_body, request_body_hash, response_body, response_status_code, headers,
+			 is_template, weight, host, workspace, match_expression, options, labels, enabled, created_at, deleted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+	`, def.ID, def.Path, def.Method, nullIfEmpty(string(def.RequestBody)), requestBodyHash, def.ResponseBody, def.StatusCode,
+		nullIfEmpty(def.Headers), def.IsTemplate, *def.Weight, nullIfEmpty(def.Host), nullIfEmpty(def.Workspace),
+		nullIfEmpty(def.MatchExpression), nullIfEmpty(string(def.Options)), labelsColumn(def.Labels), *def.Enabled,
+		createdAt, def.DeletedAt)
+	return err
+}
+
+// restoreSnapshot replaces all mocks and CRUD records in one transaction and
+// then swaps in the in-memory state. IDs are kept, so journal entries and
+// bookmarks keep pointing at the same mocks.
+func restoreSnapshot(ctx context.Context, snap *mockSnapshot) error {
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	for _, def := range snap.Profiles {
+		if def.Name == "" {
+			return fmt.Errorf("snapshot contains a profile without a name")
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM return.mock_responses"); err != nil {
+		return err
+	}
+	for _, def := range snap.Mocks {
+		if err := restoreMockRow(ctx, tx, def); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM return.crud_records"); err != nil {
+		return err
+	}
+	for _, record := range snap.CRUDRecords {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO return.crud_records (id, workspace, session, collection, record_id, body, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, CURRENT_TIMESTAMP), COALESCE($8, CURRENT_TIMESTAMP))
+		`, record.ID, record.Workspace, record.Session, record.Collection, record.RecordID, string(record.Body), record.CreatedAt, record.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("CRUD record %d: %v", record.ID, err)
+		}
+	}
+	for _, table := range []string{"mock_responses", "crud_records"} {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			SELECT setval(pg_get_serial_sequence('return.%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false)
+			FROM return.%[1]s
+		`, table))
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	mocksChanged()
+
+	if sharedState != nil {
+		if err := sharedRestore(snap.Sessions, snap.StatusSequences, snap.RateLimits, sessionStore.ttl); err != nil {
+			return fmt.Errorf("writing shared state: %v", err)
+		}
+	}
+	sessionStore.restore(snap.Sessions)
+	restoreStatusSequences(snap.StatusSequences)
+	rateLimits.restore(snap.RateLimits)
+	breakers.restore(snap.CircuitBreakers)
+	profiles.restore(snap.Profiles, snap.ActiveProfile)
+	var frozen time.Time
+	if snap.Clock.Frozen != nil {
+		frozen = *snap.Clock.Frozen
+	}
+	clock.set(time.Duration(snap.Clock.Offset), frozen)
+	return nil
+}
+
+func getSnapshotHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	snap, err := takeSnapshot(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error taking snapshot"})
+		log.Printf("Error taking snapshot: %v", err)
+		return
+	}
+	filename := "mock-snapshot-" + snap.CreatedAt.Format("20060102-150405") + ".json"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	writeJSON(w, http.StatusOK, snap)
+}
+
+func restoreSnapshotHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var snap mockSnapshot
+	if err := json.NewDecoder(r.Body).Decode(&snap); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid snapshot: " + err.Error()})
+		return
+	}
+	if err := restoreSnapshot(r.Context(), &snap); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "error restoring snapshot: " + err.Error()})
+		log.Printf("Error restoring snapshot: %v", err)
+		return
+	}
+	log.Printf("Restored snapshot from %s with %d mocks", snap.CreatedAt.Format(time.RFC3339), len(snap.Mocks))
+	writeJSON(w, http.StatusOK, map[string]int{"mocks": len(snap.Mocks), "crudRecords": len(snap.CRUDRecords)})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	soap11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// soapOptions select requests by the SOAP operation in their envelope and
+// can answer them with a SOAP Fault instead of the response body.
+type soapOptions struct {
+	// Operation is the local name of the first element inside the SOAP
+	// Body: the operation for RPC style, the input element for document style.
+	Operation string `json:"operation,omitempty"`
+	// Version is "1.1" or "1.2"; by default the version of the request.
+	Version string     `json:"version,omitempty"`
+	Fault   *soapFault `json:"fault,omitempty"`
+}
+
+// soapFault fields are templates rendered with the request.
+type soapFault struct {
+	Code    string `json:"code,omitempty"`
+	Subcode string `json:"subcode,omitempty"`
+	String  string `json:"string,omitempty"`
+	Actor   string `json:"actor,omitempty"`
+	// Detail is raw XML placed inside the fault's detail element.
+	Detail string `json:"detail,omitempty"`
+}
+
+// soapOperation returns the local name of the first element inside the
+// envelope's Body, or "" when the body is not a SOAP envelope.
+func soapOperation(body string) string {
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	depth := 0
+	inBody := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1 && t.Name.Local != "Envelope":
+				return ""
+			case depth == 2 && t.Name.Local == "Body":
+				inBody = true
+			case depth == 3 && inBody:
+				return t.Name.Local
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				inBody = false
+			}
+			depth--
+		}
+	}
+}
+
+func (o *soapOptions) matches(data templateData) bool {
+	return o.Operation == "" || soapOperation(data.Body) == o.Operation
+}
+
+func soapOperationKey(opts *soapOptions) string {
+	if opts == nil {
+		return ""
+	}
+	return opts.Operation
+}
+
+func soapVersion(opts *soapOptions, header http.Header) string {
+	if opts.Version != "" {
+		return opts.Version
+	}
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "application/soap+xml" {
+		return "1.2"
+	}
+	return "1.1"
+}
+
+// soapFaultCode qualifies a fault code, translating between the SOAP 1.1
+// names (Client, Server) and the SOAP 1.2 ones (Sender, Receiver).
+func soapFaultCode(code, version string) string {
+	if code == "" {
+		code = "Server"
+	}
+	if strings.Contains(code, ":") {
+		return code
+	}
+	if version == "1.2" {
+		switch code {
+		case "Server":
+			code = "Receiver"
+		case "Client":
+			code = "Sender"
+		}
+	} else {
+		switch code {
+		case "Receiver":
+			code = "Server"
+		case "Sender":
+			code = "Client"
+		}
+	}
+	return "soap:" + code
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// renderSOAPFault replaces the mock's response with its SOAP Fault. Faults
+// are sent with status 500, as SOAP requires, unless the mock sets another
+// error status.
+func renderSOAPFault(mockResp *MockResponse, data templateData) error {
+	opts := mockResp.Options.SOAP
+	fault := opts.Fault
+	fields := map[string]string{"code": fault.Code, "subcode": fault.Subcode, "string": fault.String, "actor": fault.Actor, "detail": fault.Detail}
+	for name, text := range fields {
+		rendered, err := renderString("soap fault "+name, text, data)
+		if err != nil {
+			return fmt.Errorf("error rendering fault %s: %v", name, err)
+		}
+		fields[name] = strings.TrimSpace(rendered)
+	}
+	if fields["string"] == "" {
+		fields["string"] = "Internal error"
+	}
+
+	version := soapVersion(opts, data.Header)
+	code := soapFaultCode(fields["code"], version)
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	if version == "1.2" {
+		b.WriteString(`<soap:Envelope xmlns:soap="` + soap12Namespace + `"><soap:Body><soap:Fault>`)
+		b.WriteString(`<soap:Code><soap:Value>` + xmlEscape(code) + `</soap:Value>`)
+		if fields["subcode"] != "" {
+			b.WriteString(`<soap:Subcode><soap:Value>` + xmlEscape(fields["subcode"]) + `</soap:Value></soap:Subcode>`)
+		}
+		b.WriteString(`</soap:Code>`)
+		b.WriteString(`<soap:Reason><soap:Text xml:lang="en">` + xmlEscape(fields["string"]) + `</soap:Text></soap:Reason>`)
+		if fields["actor"] != "" {
+			b.WriteString(`<soap:Role>` + xmlEscape(fields["actor"]) + `</soap:Role>`)
+		}
+		if fields["detail"] != "" {
+			b.WriteString(`<soap:Detail>` + fields["detail"] + `</soap:Detail>`)
+		}
+		mockResp.contentType = "application/soap+xml; charset=utf-8"
+	} else {
+		b.WriteString(`<soap:Envelope xmlns:soap="` + soap11Namespace + `"><soap:Body><soap:Fault>`)
+		b.WriteString(`<faultcode>` + xmlEscape(code) + `</faultcode>`)
+		b.WriteString(`<faultstring>` + xmlEscape(fields["string"]) + `</faultstring>`)
+		if fields["actor"] != "" {
+			b.WriteString(`<faultactor>` + xmlEscape(fields["actor"]) + `</faultactor>`)
+		}
+		if fields["detail"] != "" {
+			b.WriteString(`<detail>` + fields["detail"] + `</detail>`)
+		}
+		mockResp.contentType = "text/xml; charset=utf-8"
+	}
+	b.WriteString(`</soap:Fault></soap:Body></soap:Envelope>`)
+
+	mockResp.ResponseBody = b.String()
+	if mockResp.ResponseStatusCode < 400 {
+		mockResp.ResponseStatusCode = http.StatusInternalServerError
+	}
+	return nil
+}
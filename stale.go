@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// staleMock is a mock that has not served a request for the report period.
+// Mocks never hit count from their creation.
+type staleMock struct {
+	ID        int               `json:"id"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Workspace string            `json:"workspace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt *time.Time        `json:"createdAt,omitempty"`
+	LastHit   *time.Time        `json:"lastHit,omitempty"`
+}
+
+const staleMockColumns = `id, method, path, COALESCE(workspace, ''), labels::text, created_at, last_hit_at`
+
+// staleMockFilter selects mocks not hit in the last `days` days. Mocks
+// managed by GitOps or Kubernetes sync are left out unless asked for, since
+// the next sync would bring them back.
+func staleMockFilter(query url.Values) (mockFilter, int, error) {
+	days, err := strconv.Atoi(query.Get("days"))
+	if err != nil || days <= 0 {
+		return mockFilter{}, 0, adminRequestError("days must be a positive number")
+	}
+	filter := mockFilter{conditions: []string{"deleted_at IS NULL"}}
+	filter.add("COALESCE(last_hit_at, created_at, '-infinity') < now() - make_interval(days => ?)", days)
+	if workspace := query.Get("workspace"); workspace != "" {
+		filter.add("workspace = ?", workspace)
+	}
+	if err := filter.addLabels(query["label"]); err != nil {
+		return mockFilter{}, 0, adminRequestError(err.Error())
+	}
+	if query.Get("includeManaged") != "true" {
+		filter.add("labels->>? IS NULL", managedByLabel)
+	}
+	return filter, days, nil
+}
+
+func scanStaleMocks(rows *sql.Rows) ([]staleMock, error) {
+	defer rows.Close()
+	mocks := []staleMock{}
+	for rows.Next() {
+		var mock staleMock
+		var labels sql.NullString
+		var createdAt, lastHit sql.NullTime
+		if err := rows.Scan(&mock.ID, &mock.Method, &mock.Path, &mock.Workspace, &labels, &createdAt, &lastHit); err != nil {
+			return nil, err
+		}
+		if labels.Valid {
+			json.Unmarshal([]byte(labels.String), &mock.Labels)
+		}
+		if createdAt.Valid {
+			mock.CreatedAt = &createdAt.Time
+		}
+		if lastHit.Valid {
+			mock.LastHit = &lastHit.Time
+		}
+		mocks = append(mocks, mock)
+	}
+	return mocks, rows.Err()
+}
+
+func findStaleMocks(ctx context.Context, filter mockFilter) ([]staleMock, error) {
+	// Hits not yet written would otherwise make recently used mocks look stale.
+	if err := mockStats.flush(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT "+staleMockColumns+" FROM return.mock_responses"+filter.where()+
+		" ORDER BY COALESCE(last_hit_at, created_at), id", filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanStaleMocks(rows)
+}
+
+func trashStaleMocks(ctx context.Context, filter mockFilter) ([]staleMock, error) {
+	if err := mockStats.flush(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "UPDATE return.mock_responses SET deleted_at = now()"+filter.where()+
+		" RETURNING "+staleMockColumns, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	mocks, err := scanStaleMocks(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(mocks) > 0 {
+		ids := make([]int, len(mocks))
+		for i, mock := range mocks {
+			ids[i] = mock.ID
+		}
+		mocksChanged(ids...)
+	}
+	return mocks, nil
+}
+
+func staleMocksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	filter, days, err := staleMockFilter(r.URL.Query())
+	if err != nil {
+		writeAdminError(w, err, "error loading stale mocks")
+		return
+	}
+	mocks, err := findStaleMocks(r.Context(), filter)
+	if err != nil {
+		writeAdminError(w, err, "error loading stale mocks")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"days": days, "mocks": mocks})
+}
+
+// deleteStaleMocksHandler moves stale mocks to the trash. It only acts with
+// confirm=true, so a mistyped request cannot wipe a shared database; the
+// same query without confirm is what GET reports.
+func deleteStaleMocksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	filter, days, err := staleMockFilter(query)
+	if err != nil {
+		writeAdminError(w, err, "error deleting stale mocks")
+		return
+	}
+	if query.Get("confirm") != "true" {
+		writeAdminError(w, adminRequestError("confirm=true is required; review the mocks with GET first"), "error deleting stale mocks")
+		return
+	}
+	mocks, err := trashStaleMocks(r.Context(), filter)
+	if err != nil {
+		writeAdminError(w, err, "error deleting stale mocks")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"days": days, "mocks": mocks})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type sessionState struct {
+	workspace string
+	session   string
+	values    map[string]interface{}
+	touched   time.Time
+}
+
+type stateStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*sessionState
+}
+
+var sessionStore = &stateStore{
+	ttl:      envDuration("STATE_TTL", time.Hour),
+	sessions: make(map[string]*sessionState),
+}
+
+func requestSession(r *http.Request) string {
+	if session := strings.TrimSpace(r.Header.Get(envString("STATE_SESSION_HEADER", "X-Mock-Session"))); session != "" {
+		return session
+	}
+	if cookie, err := r.Cookie(envString("STATE_SESSION_COOKIE", "mock_session")); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (s *stateStore) get(session, key string) (interface{}, bool) {
+	if sharedState != nil {
+		value, ok, err := sharedGetState(session, key)
+		if err == nil {
+			return value, ok
+		}
+		sharedStateFailed("session", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.lookup(session, false)
+	if state == nil {
+		return nil, false
+	}
+	value, ok := state.values[key]
+	return value, ok
+}
+
+func (s *stateStore) set(session, key string, value interface{}) {
+	if sharedState != nil {
+		err := sharedSetState(session, key, value, s.ttl)
+		if err == nil {
+			return
+		}
+		sharedStateFailed("session", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lookup(session, true).values[key] = value
+}
+
+func (s *stateStore) lookup(session string, create bool) *sessionState {
+	now := time.Now()
+	state, ok := s.sessions[session]
+	if !ok {
+		if !create {
+			return nil
+		}
+		state = &sessionState{values: make(map[string]interface{})}
+		s.sessions[session] = state
+	}
+	state.touched = now
+	return state
+}
+
+// touch marks a named session as in use, so that its state, CRUD records
+// and counters are kept until it has been idle for STATE_TTL.
+func (s *stateStore) touch(workspace, session string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.lookup(workspace+"/"+session, true)
+	state.workspace, state.session = workspace, session
+	if sharedState != nil {
+		go func() {
+			if err := sharedTouchState(workspace+"/"+session, s.ttl); err != nil {
+				sharedStateFailed("session", err)
+			}
+		}()
+	}
+}
+
+func (s *stateStore) expire(now time.Time) []*sessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []*sessionState
+	for id, state := range s.sessions {
+		if now.Sub(state.touched) > s.ttl {
+			delete(s.sessions, id)
+			expired = append(expired, state)
+		}
+	}
+	return expired
+}
+
+// forgetSession drops everything scoped to a session besides its state:
+// status sequence positions, rate limit windows, circuit breakers,
+// idempotency keys and CRUD records.
+func forgetSession(workspace, session string) {
+	resetSessionSequences(workspace, session)
+	rateLimits.resetSession(workspace, session)
+	breakers.closeSession(workspace, session)
+	idempotencyKeys.resetSession(workspace, session)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM return.crud_records WHERE workspace = $1 AND session = $2", workspace, session); err != nil {
+		log.Printf("Error deleting CRUD records of session %s: %v", session, err)
+	}
+}
+
+func startSessionSweeper() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			seeds.expire(now, sessionStore.ttl)
+			for _, state := range sessionStore.expire(now) {
+				if state.session != "" {
+					forgetSession(state.workspace, state.session)
+				}
+			}
+		}
+	}()
+}
+
+func stateTemplateFuncs(workspace, session string) map[string]interface{} {
+	session = workspace + "/" + session
+
+	return map[string]interface{}{
+		"getState": func(key string, fallback ...interface{}) interface{} {
+			if value, ok := sessionStore.get(session, key); ok {
+				return value
+			}
+			if len(fallback) > 0 {
+				return fallback[0]
+			}
+			return ""
+		},
+		"setState": func(key string, value interface{}) string {
+			sessionStore.set(session, key, value)
+			return ""
+		},
+	}
+}
+
+func (s *stateStore) reset(workspace string) {
+	if sharedState != nil {
+		sharedDelete(sharedStateKind, workspaceKeyPrefix(workspace, "/"))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.sessions {
+		if workspace == "" || strings.HasPrefix(id, workspace+"/") {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *stateStore) snapshot() map[string]map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make(map[string]map[string]interface{}, len(s.sessions))
+	for id, state := range s.sessions {
+		values := make(map[string]interface{}, len(state.values))
+		for key, value := range state.values {
+			values[key] = value
+		}
+		sessions[id] = values
+	}
+	return sessions
+}
+
+func (s *stateStore) restore(sessions map[string]map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sessions = make(map[string]*sessionState, len(sessions))
+	for id, values := range sessions {
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		s.sessions[id] = &sessionState{values: values, touched: now}
+	}
+}
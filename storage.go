@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+)
+
+const (
+	storagePostgres         = "postgres"
+	storageEmbeddedPostgres = "embedded-postgres"
+)
+
+//go:embed create_db_script.sql
+var schemaScript string
+
+// storageMode returns the --storage flag among the arguments, or STORAGE.
+func storageMode(args []string) string {
+	mode := envString("STORAGE", storagePostgres)
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "storage" || !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		mode = value
+	}
+	return mode
+}
+
+// startEmbeddedPostgres runs a private Postgres server for local development
+// and points the router at it. Unless EMBEDDED_POSTGRES_DATA names a data
+// directory to keep, the database lives in a temporary directory and is gone
+// once the router stops. The binaries are downloaded on first use and cached.
+func startEmbeddedPostgres() (stop func(), err error) {
+	port := envInt("EMBEDDED_POSTGRES_PORT", 5433)
+	runtimeDir, err := os.MkdirTemp("", "mock-db-router-postgres-")
+	if err != nil {
+		return nil, err
+	}
+	dataDir := envString("EMBEDDED_POSTGRES_DATA", "")
+	if dataDir == "" {
+		dataDir = filepath.Join(runtimeDir, "data")
+	} else if dataDir, err = filepath.Abs(dataDir); err != nil {
+		return nil, err
+	}
+
+	config := embeddedpostgres.DefaultConfig().
+		Port(uint32(port)).
+		Database("mockdb").
+		RuntimePath(runtimeDir).
+		DataPath(dataDir).
+		StartTimeout(time.Minute).
+		Logger(io.Discard)
+	fmt.Printf("Starting embedded Postgres on port %d (first start downloads it)...\n", port)
+	server := embeddedpostgres.NewDatabase(config)
+	if err := server.Start(); err != nil {
+		os.RemoveAll(runtimeDir)
+		return nil, fmt.Errorf("error starting embedded Postgres: %v", err)
+	}
+
+	dbConnStr = fmt.Sprintf("host=localhost port=%d user=postgres password=postgres dbname=mockdb sslmode=disable", port)
+	stop = func() {
+		if err := server.Stop(); err != nil {
+			log.Printf("Error stopping embedded Postgres: %v", err)
+		}
+		os.RemoveAll(runtimeDir)
+	}
+
+	// Stop the server with the router, or it would outlive it.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stop()
+		os.Exit(0)
+	}()
+	return stop, nil
+}
+
+// createSchema creates the tables of create_db_script.sql, in the return
+// schema the router queries.
+func createSchema(ctx context.Context) error {
+	script := `CREATE SCHEMA IF NOT EXISTS "return";` + "\n" + strings.ReplaceAll(schemaScript, "public.", "return.")
+	if _, err := db.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("error creating schema: %v", err)
+	}
+	return nil
+}
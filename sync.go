@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Mocks owned by an external source carry these labels; reconciling a source
+// only ever touches the mocks labeled with its name.
+const (
+	managedByLabel  = "managed-by"
+	managedKeyLabel = "managed-key"
+)
+
+type syncResult struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+// fingerprint serializes the parts of a definition that affect matching and
+// responses, with JSON columns canonicalized so that formatting differences
+// between a file and the database don't count as changes.
+func (d mockDefinition) fingerprint() (string, error) {
+	d.ID, d.CreatedAt, d.DeletedAt = 0, nil, nil
+	for _, field := range []*json.RawMessage{&d.RequestBody, &d.Options} {
+		if len(*field) == 0 {
+			continue
+		}
+		canonical, err := canonicalJSON(string(*field))
+		if err != nil {
+			return "", err
+		}
+		*field = canonical
+	}
+	data, err := json.Marshal(d)
+	return string(data), err
+}
+
+// reconcileMocks makes the stored mocks of a source match desired, keyed by a
+// source-specific identifier: new keys are created, changed ones updated and
+// keys that disappeared are moved to the trash.
+func reconcileMocks(ctx context.Context, source string, desired map[string]mockDefinition) (syncResult, error) {
+	var result syncResult
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+mockDefinitionColumns+`
+		FROM return.mock_responses
+		WHERE labels->>$1 = $2 AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`, managedByLabel, source)
+	if err != nil {
+		return result, err
+	}
+	existing := make(map[string]mockDefinition)
+	var duplicates []int
+	for rows.Next() {
+		def, err := scanMockDefinition(rows)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		key := def.Labels[managedKeyLabel]
+		if _, seen := existing[key]; seen || key == "" {
+			duplicates = append(duplicates, def.ID)
+			continue
+		}
+		existing[key] = def
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		def := desired[key]
+		labels := make(map[string]string, len(def.Labels)+2)
+		for name, value := range def.Labels {
+			labels[name] = value
+		}
+		labels[managedByLabel] = source
+		labels[managedKeyLabel] = key
+		def.Labels = labels
+		if err := def.normalize(); err != nil {
+			return result, fmt.Errorf("%s: %v", key, err)
+		}
+
+		current, ok := existing[key]
+		delete(existing, key)
+		if !ok {
+			if err := insertMock(ctx, tx, &def); err != nil {
+				return result, fmt.Errorf("%s: %v", key, err)
+			}
+			result.Created++
+			continue
+		}
+
+		def.ID = current.ID
+		want, err := def.fingerprint()
+		if err != nil {
+			return result, fmt.Errorf("%s: %v", key, err)
+		}
+		have, err := current.fingerprint()
+		if err != nil {
+			return result, fmt.Errorf("mock %d: %v", current.ID, err)
+		}
+		if want == have {
+			result.Unchanged++
+			continue
+		}
+		if _, err := updateMock(ctx, tx, &def); err != nil {
+			return result, fmt.Errorf("%s: %v", key, err)
+		}
+		result.Updated++
+	}
+
+	for _, def := range existing {
+		duplicates = append(duplicates, def.ID)
+	}
+	for _, id := range duplicates {
+		if _, err := tx.ExecContext(ctx, "UPDATE return.mock_responses SET deleted_at = now() WHERE id = $1", id); err != nil {
+			return result, err
+		}
+		result.Deleted++
+	}
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	if result.Created+result.Updated+result.Deleted > 0 {
+		mocksChanged()
+	}
+	return result, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	csvContentType  = "text/csv"
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// tableOptions control how a mock's JSON rows are rendered as a CSV or XLSX
+// response.
+type tableOptions struct {
+	// Format is "csv" or "xlsx"; by default it follows the mock's
+	// Content-Type.
+	Format string `json:"format,omitempty"`
+	// Columns picks and orders the fields of object rows; by default the
+	// fields of the first row, in order.
+	Columns []string `json:"columns,omitempty"`
+	// NoHeader leaves out the row of column names.
+	NoHeader bool `json:"noHeader,omitempty"`
+	// Delimiter separates CSV fields; a comma by default.
+	Delimiter string `json:"delimiter,omitempty"`
+	// Sheet names the XLSX worksheet; Sheet1 by default.
+	Sheet string `json:"sheet,omitempty"`
+	// Filename sends the response as an attachment with this name.
+	Filename string `json:"filename,omitempty"`
+}
+
+func tableFormat(mockResp *MockResponse) string {
+	if opts := mockResp.Options.Table; opts != nil && opts.Format != "" {
+		return strings.ToLower(opts.Format)
+	}
+	var contentType string
+	for name, value := range parseHeaders(mockResp.Headers) {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case csvContentType:
+		return "csv"
+	case xlsxContentType:
+		return "xlsx"
+	}
+	return ""
+}
+
+// encodeTableResponse renders a response body holding a JSON array of rows
+// as CSV or XLSX, when the mock has table options or its Content-Type names
+// one of them. Rows are objects, or arrays of cell values.
+func encodeTableResponse(mockResp *MockResponse) error {
+	format := tableFormat(mockResp)
+	if format == "" {
+		return nil
+	}
+	opts := mockResp.Options.Table
+	if opts == nil {
+		opts = &tableOptions{}
+	}
+	rows, err := tableRows(mockResp.ResponseBody, opts)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	switch format {
+	case "csv":
+		body, err = encodeCSV(rows, opts)
+		mockResp.contentType = csvContentType
+	case "xlsx":
+		body, err = encodeXLSX(rows, opts)
+		mockResp.contentType = xlsxContentType
+	default:
+		return fmt.Errorf("unknown table format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	mockResp.ResponseBody = string(body)
+	mockResp.filename = opts.Filename
+	return nil
+}
+
+// tableRows turns a JSON array of rows into cells, with the header row
+// first unless opts leave it out.
+func tableRows(body string, opts *tableOptions) ([][]interface{}, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &items); err != nil {
+		return nil, fmt.Errorf("table response body is not a JSON array: %v", err)
+	}
+
+	columns := opts.Columns
+	if len(columns) == 0 && len(items) > 0 && isJSONObject(items[0]) {
+		keys, err := objectKeys(items[0])
+		if err != nil {
+			return nil, fmt.Errorf("row 1: %v", err)
+		}
+		columns = keys
+	}
+
+	var rows [][]interface{}
+	if len(columns) > 0 && !opts.NoHeader {
+		header := make([]interface{}, len(columns))
+		for i, column := range columns {
+			header[i] = column
+		}
+		rows = append(rows, header)
+	}
+	for i, item := range items {
+		decoder := json.NewDecoder(bytes.NewReader(item))
+		decoder.UseNumber()
+		var row []interface{}
+		if isJSONObject(item) {
+			var fields map[string]interface{}
+			if err := decoder.Decode(&fields); err != nil {
+				return nil, fmt.Errorf("row %d: %v", i+1, err)
+			}
+			row = make([]interface{}, len(columns))
+			for j, column := range columns {
+				row[j] = fields[column]
+			}
+		} else if err := decoder.Decode(&row); err != nil {
+			return nil, fmt.Errorf("row %d is neither an object nor an array", i+1)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func isJSONObject(raw json.RawMessage) bool {
+	return len(bytes.TrimSpace(raw)) > 0 && bytes.TrimSpace(raw)[0] == '{'
+}
+
+// objectKeys returns the keys of a JSON object in the order they appear.
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, token.(string))
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// cellText formats a cell value; nested objects and arrays become JSON.
+func cellText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func encodeCSV(rows [][]interface{}, opts *tableOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if opts.Delimiter != "" {
+		delimiter := []rune(opts.Delimiter)
+		if len(delimiter) != 1 {
+			return nil, fmt.Errorf("CSV delimiter %q is not a single character", opts.Delimiter)
+		}
+		w.Comma = delimiter[0]
+	}
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = cellText(value)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// encodeXLSX writes rows as the single worksheet of a minimal workbook.
+// Numbers and booleans keep their cell types, everything else is text.
+func encodeXLSX(rows [][]interface{}, opts *tableOptions) ([]byte, error) {
+	sheet := opts.Sheet
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+
+	var data bytes.Buffer
+	data.WriteString(xml.Header)
+	data.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&data, `<row r="%d">`, i+1)
+		for j, value := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			switch v := value.(type) {
+			case nil:
+			case json.Number:
+				fmt.Fprintf(&data, `<c r="%s"><v>%s</v></c>`, ref, v)
+			case bool:
+				cell := "0"
+				if v {
+					cell = "1"
+				}
+				fmt.Fprintf(&data, `<c r="%s" t="b"><v>%s</v></c>`, ref, cell)
+			default:
+				fmt.Fprintf(&data, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xmlText(cellText(v)))
+			}
+		}
+		data.WriteString(`</row>`)
+	}
+	data.WriteString(`</sheetData></worksheet>`)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + xmlText(sheet) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+		{"xl/worksheets/sheet1.xml", data.String()},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xlsxColumn returns the column letters for a zero-based index: A, B, ...,
+// Z, AA, AB, ...
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+func xmlText(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
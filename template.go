@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var templateFuncs = template.FuncMap{
+	"now":       clock.now,
+	"uuid":      newUUID,
+	"dict":      dict,
+	"toJSON":    toJSON,
+	"seq":       seq,
+	"jwtSign":   jwtSign,
+	"jwtDecode": jwtDecode,
+	"jwtVerify": jwtVerify,
+	"secret":    lookupSecret,
+
+	"hmacSHA256":       hmacSHA256,
+	"hmacSHA256Base64": hmacSHA256Base64,
+	"sign":             signPayload,
+}
+
+type templateData struct {
+	RequestID string
+	Host      string
+	ClientIP  string
+	Country   string
+	Method    string
+	Path      string
+	Query     url.Values
+	Header    http.Header
+	Body      string
+	JSON      interface{}
+	Workspace string
+	Session   string
+	Profile   string
+	Now       time.Time
+	// Args holds the arguments of the GraphQL field a resolver answers.
+	Args map[string]interface{}
+	// PathParams holds what the segments and groups of a path pattern
+	// captured.
+	PathParams map[string]string
+	// Upstream is the response fetched for mocks with options.upstream.
+	Upstream *upstreamResponse
+	// Page is the page asked for from mocks with options.pagination.
+	Page *pageInfo
+	// Variant is the experiment arm served by mocks with
+	// options.experiment.
+	Variant string
+	// Payload is the rendered body, for header templates of mocks, webhooks
+	// and messages to sign.
+	Payload string
+
+	rand *lockedRand
+}
+
+func newTemplateData(r *http.Request, requestBody string) templateData {
+	data := templateData{
+		RequestID: requestID(r),
+		Host:      r.Host,
+		ClientIP:  clientIP(r),
+		Country:   requestCountry(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.Query(),
+		Header:    r.Header,
+		Body:      requestBody,
+		Workspace: requestWorkspace(r),
+		Session:   requestSession(r),
+		Profile:   requestProfile(r),
+		Now:       requestNow(r),
+		rand:      requestRand(r),
+	}
+	if requestBody != "" {
+		json.Unmarshal(bytesOf(requestBody), &data.JSON)
+	}
+	return data
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).
+		Funcs(templateFuncs).
+		Funcs(randomTemplateFuncs(unseededRand)).
+		Option("missingkey=zero").
+		Parse(text)
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func renderString(name, text string, data templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	compiled, err := compileTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := compiled.Clone()
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(tmpl.Funcs(requestTemplateFuncs(data)), data)
+}
+
+func requestTemplateFuncs(data templateData) template.FuncMap {
+	funcs := template.FuncMap{
+		"now": func() time.Time { return data.Now },
+	}
+	for name, fn := range stateTemplateFuncs(data.Workspace, data.Session) {
+		funcs[name] = fn
+	}
+	for name, fn := range randomTemplateFuncs(data.rand) {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// renderMockResponse renders the body and headers of a templated mock, or
+// takes them from the render cache for mocks with options.renderCache.
+func renderMockResponse(mockResp *MockResponse, data templateData) error {
+	var cacheKey string
+	if opts := mockResp.Options.RenderCache; opts != nil {
+		cacheKey = renderCacheKey(mockResp, data, opts)
+		if entry, ok := cachedRender(cacheKey); ok {
+			mockResp.ResponseBody = entry.body
+			mockResp.Headers = entry.headers
+			return nil
+		}
+	}
+
+	body, err := renderString("body", mockResp.ResponseBody, data)
+	if err != nil {
+		return fmt.Errorf("error rendering response body: %v", err)
+	}
+	mockResp.ResponseBody = body
+	data.Payload = body
+
+	if mockResp.Headers.Valid {
+		headers, err := renderString("headers", mockResp.Headers.String, data)
+		if err != nil {
+			return fmt.Errorf("error rendering headers: %v", err)
+		}
+		mockResp.Headers = sql.NullString{String: headers, Valid: true}
+	}
+
+	if cacheKey != "" {
+		entry := renderCacheEntry{body: mockResp.ResponseBody, headers: mockResp.Headers}
+		if ttl := time.Duration(mockResp.Options.RenderCache.TTL); ttl > 0 {
+			entry.expires = time.Now().Add(ttl)
+		}
+		cacheRender(cacheKey, entry)
+	}
+	return nil
+}
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return formatUUID(b)
+}
+
+func formatUUID(b [16]byte) string {
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict requires an even number of arguments")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %T", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// seq returns 1 to n, to range over when generating n rows or items.
+func seq(n int) ([]int, error) {
+	if n < 0 || n > 100000 {
+		return nil, fmt.Errorf("seq: %d is out of range", n)
+	}
+	items := make([]int, n)
+	for i := range items {
+		items[i] = i + 1
+	}
+	return items, nil
+}
+
+func jwtSign(keyName string, claims interface{}) (string, error) {
+	key, ok := templateJWTKeys[keyName]
+	if !ok {
+		return "", fmt.Errorf("unknown JWT key %q", keyName)
+	}
+
+	var payload map[string]interface{}
+	switch c := claims.(type) {
+	case map[string]interface{}:
+		payload = c
+	case string:
+		if err := json.Unmarshal([]byte(c), &payload); err != nil {
+			return "", fmt.Errorf("jwtSign claims must be a JSON object: %v", err)
+		}
+	default:
+		return "", fmt.Errorf("jwtSign claims must be a dict or JSON string, got %T", claims)
+	}
+	return key.sign(payload)
+}
+
+func jwtDecode(token string) map[string]interface{} {
+	_, claims, err := decodeJWT(stripBearer(token))
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	return claims
+}
+
+func jwtVerify(keyName, token string) map[string]interface{} {
+	key, ok := templateJWTKeys[keyName]
+	if !ok {
+		return map[string]interface{}{}
+	}
+	claims, err := key.verify(stripBearer(token))
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	return claims
+}
+
+func stripBearer(token string) string {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		return strings.TrimSpace(token[7:])
+	}
+	return token
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Parsing a template costs far more than executing it, so templates are
+// parsed once per text and cloned for each request, which only copies the
+// function table the request-bound functions are swapped into.
+var (
+	templateCacheSize = envInt("TEMPLATE_CACHE_SIZE", 1000)
+	renderCacheSize   = envInt("RENDER_CACHE_SIZE", 1000)
+
+	compiledTemplatesMu sync.Mutex
+	compiledTemplates   = make(map[string]compiledTemplate)
+
+	renderCacheMu sync.Mutex
+	renderCache   = make(map[string]renderCacheEntry)
+
+	templateCacheTotal = expvar.NewMap("template_cache_total")
+	renderCacheTotal   = expvar.NewMap("render_cache_total")
+)
+
+type compiledTemplate struct {
+	tmpl *template.Template
+	err  error
+}
+
+// renderCacheOptions reuse a mock's rendered body and headers for requests
+// with the same method, path, query, body, workspace, session, profile and
+// upstream response. The output must depend on nothing else: templates
+// using now, random values or session state would serve stale results.
+type renderCacheOptions struct {
+	// TTL bounds how long a rendering is reused; until the mock changes by
+	// default.
+	TTL jsonDuration `json:"ttl,omitempty"`
+	// Headers adds the values of these request headers to the key.
+	Headers []string `json:"headers,omitempty"`
+}
+
+type renderCacheEntry struct {
+	body    string
+	headers sql.NullString
+	expires time.Time
+}
+
+func init() {
+	onMockChange(func(ids []int) {
+		flushRenderCache()
+		go precompileTemplates(ids)
+	})
+}
+
+// compileTemplate returns the parsed template of text, parsing it on first
+// use. Parse errors are kept too, so a broken template is not parsed again
+// by every request.
+func compileTemplate(name, text string) (*template.Template, error) {
+	key := name + "\x00" + text
+	compiledTemplatesMu.Lock()
+	compiled, ok := compiledTemplates[key]
+	compiledTemplatesMu.Unlock()
+	if ok {
+		templateCacheTotal.Add("hits", 1)
+		return compiled.tmpl, compiled.err
+	}
+	templateCacheTotal.Add("misses", 1)
+
+	tmpl, err := template.New(name).
+		Funcs(templateFuncs).
+		Funcs(requestTemplateFuncs(templateData{})).
+		Option("missingkey=zero").
+		Parse(text)
+	if err != nil {
+		templateCacheTotal.Add("errors", 1)
+		tmpl = nil
+	}
+	compiledTemplatesMu.Lock()
+	if len(compiledTemplates) >= templateCacheSize {
+		clear(compiledTemplates)
+	}
+	compiledTemplates[key] = compiledTemplate{tmpl: tmpl, err: err}
+	compiledTemplatesMu.Unlock()
+	return tmpl, err
+}
+
+// precompileTemplates parses the templates of the given mocks, or of all
+// enabled templated mocks without ids, so their first requests do not pay
+// for it.
+func precompileTemplates(ids []int) int {
+	if db == nil || templateCacheSize <= 0 {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	query := `SELECT id, response_body, headers FROM return.mock_responses
+		WHERE is_template AND enabled AND deleted_at IS NULL`
+	args := []interface{}{}
+	if ids != nil {
+		query += " AND id = ANY($1)"
+		args = append(args, pq.Array(ids))
+	}
+	// Two templates per mock: leave room for the others in the cache.
+	query += " ORDER BY id LIMIT " + strconv.Itoa(templateCacheSize/2)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error precompiling templates: %v", err)
+		return 0
+	}
+	defer rows.Close()
+	compiled := 0
+	for rows.Next() {
+		var id int
+		var body string
+		var headers sql.NullString
+		if err := rows.Scan(&id, &body, &headers); err != nil {
+			log.Printf("Error precompiling templates: %v", err)
+			return compiled
+		}
+		if _, err := compileTemplate("body", body); err != nil && strings.Contains(body, "{{") {
+			log.Printf("Mock %d has an invalid response body template: %v", id, err)
+		}
+		if headers.Valid && strings.Contains(headers.String, "{{") {
+			if _, err := compileTemplate("headers", headers.String); err != nil {
+				log.Printf("Mock %d has an invalid headers template: %v", id, err)
+			}
+		}
+		compiled++
+	}
+	return compiled
+}
+
+// renderCacheKey hashes what a cached rendering depends on.
+func renderCacheKey(mockResp *MockResponse, data templateData, opts *renderCacheOptions) string {
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	write(strconv.Itoa(mockResp.ID))
+	write(mockResp.ResponseBody)
+	write(mockResp.Headers.String)
+	write(data.Method)
+	write(data.Path)
+	write(data.Query.Encode())
+	write(data.Body)
+	write(data.Workspace)
+	write(data.Session)
+	write(data.Profile)
+	write(data.Variant)
+	for _, name := range opts.Headers {
+		for _, value := range data.Header.Values(name) {
+			write(value)
+		}
+		write(http.CanonicalHeaderKey(name))
+	}
+	if data.Upstream != nil {
+		write(strconv.Itoa(data.Upstream.Status))
+		write(data.Upstream.Body)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func cachedRender(key string) (renderCacheEntry, bool) {
+	renderCacheMu.Lock()
+	defer renderCacheMu.Unlock()
+	entry, ok := renderCache[key]
+	if ok && !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		delete(renderCache, key)
+		ok = false
+	}
+	if ok {
+		renderCacheTotal.Add("hits", 1)
+	} else {
+		renderCacheTotal.Add("misses", 1)
+	}
+	return entry, ok
+}
+
+func cacheRender(key string, entry renderCacheEntry) {
+	if renderCacheSize <= 0 {
+		return
+	}
+	renderCacheMu.Lock()
+	defer renderCacheMu.Unlock()
+	if len(renderCache) >= renderCacheSize {
+		now := time.Now()
+		for k, cached := range renderCache {
+			if !cached.expires.IsZero() && now.After(cached.expires) {
+				delete(renderCache, k)
+			}
+		}
+		for k := range renderCache {
+			if len(renderCache) < renderCacheSize {
+				break
+			}
+			delete(renderCache, k)
+		}
+	}
+	renderCache[key] = entry
+}
+
+// flushRenderCache drops the cached renderings and returns how many there
+// were.
+func flushRenderCache() int {
+	renderCacheMu.Lock()
+	defer renderCacheMu.Unlock()
+	flushed := len(renderCache)
+	clear(renderCache)
+	return flushed
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeWindow holds when the request's time, which honours clock control and
+// X-Mock-Time, falls in all of its parts.
+type timeWindow struct {
+	// Timezone is an IANA name such as "Europe/Istanbul"; MATCH_TIMEZONE by
+	// default.
+	Timezone string `json:"timezone,omitempty"`
+	// Days are weekdays and ranges of them, such as "mon-fri" or "sat".
+	Days []string `json:"days,omitempty"`
+	// From and To are "15:04" times of day; To is exclusive, and a window
+	// with To before From spans midnight.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Cron is a five-field cron expression the minute must match.
+	Cron string `json:"cron,omitempty"`
+}
+
+var (
+	matchTimezone = envString("MATCH_TIMEZONE", "Local")
+
+	locations     sync.Map
+	compiledCrons sync.Map
+)
+
+var weekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+var months = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = matchTimezone
+	}
+	if cached, ok := locations.Load(name); ok {
+		return cached.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+func (tw *timeWindow) validate() error {
+	if _, err := loadLocation(tw.Timezone); err != nil {
+		return err
+	}
+	if _, err := parseWeekdays(tw.Days); err != nil {
+		return err
+	}
+	if (tw.From == "") != (tw.To == "") {
+		return fmt.Errorf("from and to must be given together")
+	}
+	for _, value := range []string{tw.From, tw.To} {
+		if _, err := parseTimeOfDay(value); err != nil {
+			return err
+		}
+	}
+	if tw.Cron != "" {
+		if _, err := compileCron(tw.Cron); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *timeWindow) matches(now time.Time) (bool, error) {
+	loc, err := loadLocation(tw.Timezone)
+	if err != nil {
+		return false, err
+	}
+	now = now.In(loc)
+	if len(tw.Days) > 0 {
+		days, err := parseWeekdays(tw.Days)
+		if err != nil {
+			return false, err
+		}
+		if !days[now.Weekday()] {
+			return false, nil
+		}
+	}
+	if tw.From != "" || tw.To != "" {
+		from, err := parseTimeOfDay(tw.From)
+		if err != nil {
+			return false, err
+		}
+		to, err := parseTimeOfDay(tw.To)
+		if err != nil {
+			return false, err
+		}
+		minute := now.Hour()*60 + now.Minute()
+		if from <= to && (minute < from || minute >= to) || from > to && minute < from && minute >= to {
+			return false, nil
+		}
+	}
+	if tw.Cron != "" {
+		cron, err := compileCron(tw.Cron)
+		if err != nil {
+			return false, err
+		}
+		if !cron.matches(now) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchesTimeWindows reports whether now is in any of the windows.
+func matchesTimeWindows(windows []timeWindow, now time.Time) (bool, error) {
+	for i := range windows {
+		matched, err := windows[i].matches(now)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+// parseTimeOfDay returns the minutes since midnight of a "15:04" time; "24:00"
+// is the end of the day.
+func parseTimeOfDay(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q; use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseWeekdays(values []string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+	for _, value := range values {
+		first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "-")
+		if !isRange {
+			last = first
+		}
+		from, fromOK := weekdays[first]
+		to, toOK := weekdays[last]
+		if !fromOK || !toOK {
+			return nil, fmt.Errorf("invalid day %q; use mon, tue, ... or ranges like mon-fri", value)
+		}
+		// Ranges may wrap around the week, as in "fri-mon".
+		for day := from; ; day = (day + 1) % 7 {
+			days[time.Weekday(day)] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// cronSchedule is a parsed "minute hour day-of-month month day-of-week"
+// expression.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// domAny and dowAny note a "*" day field; when both day fields are
+	// restricted, either one matching is enough, as in cron.
+	domAny, dowAny bool
+}
+
+func compileCron(expr string) (*cronSchedule, error) {
+	if cached, ok := compiledCrons.Load(expr); ok {
+		return cached.(*cronSchedule), nil
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: want 5 fields", expr)
+	}
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron minute: %v", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron hour: %v", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron day of month: %v", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, months); err != nil {
+		return nil, fmt.Errorf("invalid cron month: %v", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdays); err != nil {
+		return nil, fmt.Errorf("invalid cron day of week: %v", err)
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	compiledCrons.Store(expr, &c)
+	return &c, nil
+}
+
+// parseCronField parses a comma-separated list of values, "a-b" ranges and
+// "*", each optionally with a "/step".
+func parseCronField(field string, min, max int, names map[string]int) (map[int]bool, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		base, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		from, to := min, max
+		if base != "*" {
+			first, last, isRange := strings.Cut(base, "-")
+			var err error
+			if from, err = value(first); err != nil {
+				return nil, err
+			}
+			to = from
+			if isRange {
+				if to, err = value(last); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				to = max
+			}
+			if to < from {
+				return nil, fmt.Errorf("range %q is backwards", base)
+			}
+		}
+		for n := from; n <= to; n += step {
+			set[n] = true
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
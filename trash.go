@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var trashRetention = envDuration("MOCK_TRASH_RETENTION", 7*24*time.Hour)
+
+func listTrashHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT `+mockDefinitionColumns+`
+		FROM return.mock_responses
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC
+	`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error loading trash"})
+		log.Printf("Error listing trashed mocks: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	mocks := []mockDefinition{}
+	for rows.Next() {
+		def, err := scanMockDefinition(rows)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error loading trash"})
+			log.Printf("Error listing trashed mocks: %v", err)
+			return
+		}
+		mocks = append(mocks, def)
+	}
+	if err := rows.Err(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error loading trash"})
+		log.Printf("Error listing trashed mocks: %v", err)
+		return
+	}
+
+	response := map[string]interface{}{"mocks": mocks}
+	if trashRetention > 0 {
+		response["retention"] = jsonDuration(trashRetention)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// restoreMockHandler takes a mock out of the trash. Like creating a mock, it
+// is refused with 409 when the mock would overlap another one, unless
+// force=true is given.
+func restoreMockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, ok := mockIDParam(w, ps)
+	if !ok {
+		return
+	}
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error restoring mock"})
+		log.Printf("Error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(r.Context(), `
+		SELECT `+mockDefinitionColumns+`
+		FROM return.mock_responses
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		FOR UPDATE
+	`, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error restoring mock"})
+		log.Printf("Error loading trashed mock %d: %v", id, err)
+		return
+	}
+	var def mockDefinition
+	found := rows.Next()
+	if found {
+		def, err = scanMockDefinition(rows)
+	}
+	rows.Close()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error restoring mock"})
+		log.Printf("Error loading trashed mock %d: %v", id, err)
+		return
+	}
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "mock not found in trash"})
+		return
+	}
+
+	var conflicts []mockConflict
+	if *def.Enabled {
+		ids, err := findMockConflicts(r.Context(), tx, def)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error checking for conflicts"})
+			log.Printf("Error checking mock conflicts: %v", err)
+			return
+		}
+		if len(ids) > 0 {
+			conflicts = append(conflicts, mockConflict{Path: def.Path, Method: def.Method, IDs: ids})
+			if !force {
+				writeJSON(w, http.StatusConflict, map[string]interface{}{
+					"error":     "mock would overlap existing mocks for the same requests; retry with force=true to restore it anyway",
+					"conflicts": conflicts,
+				})
+				return
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(r.Context(), "UPDATE return.mock_responses SET deleted_at = NULL WHERE id = $1", id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error restoring mock"})
+		log.Printf("Error restoring mock %d: %v", id, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error restoring mock"})
+		log.Printf("Error committing restore of mock %d: %v", id, err)
+		return
+	}
+	mocksChanged(id)
+
+	def.DeletedAt = nil
+	response := map[string]interface{}{"mock": def}
+	if len(conflicts) > 0 {
+		response["conflicts"] = conflicts
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func purgeMockHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, ok := mockIDParam(w, ps)
+	if !ok {
+		return
+	}
+	result, err := db.ExecContext(r.Context(), "DELETE FROM return.mock_responses WHERE id = $1 AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error purging mock"})
+		log.Printf("Error purging mock %d: %v", id, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "mock not found in trash"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func emptyTrashHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	result, err := db.ExecContext(r.Context(), "DELETE FROM return.mock_responses WHERE deleted_at IS NOT NULL")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error emptying trash"})
+		log.Printf("Error emptying trash: %v", err)
+		return
+	}
+	affected, _ := result.RowsAffected()
+	writeJSON(w, http.StatusOK, map[string]int64{"purged": affected})
+}
+
+func purgeExpiredMocks(retention time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM return.mock_responses
+		WHERE deleted_at < now() - $1 * interval '1 second'
+	`, retention.Seconds())
+	if err != nil {
+		log.Printf("Error purging trashed mocks: %v", err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		log.Printf("Purged %d mocks trashed more than %s ago", affected, retention)
+	}
+}
+
+// startTrashPurger permanently deletes mocks that have been in the trash for
+// longer than the retention period, checking once at startup and then hourly.
+func startTrashPurger(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			purgeExpiredMocks(retention)
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstreamOptions fetch part or all of a mock's response from another URL
+// when the mock is served. The response is available to templates and
+// scripts as .Upstream; a mock with an empty response body sends it as is.
+type upstreamOptions struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// ForwardHeaders copies request headers, such as Authorization, to the fetch.
+	ForwardHeaders []string     `json:"forwardHeaders,omitempty"`
+	Timeout        jsonDuration `json:"timeout,omitempty"`
+	// CacheTTL keeps successful responses per method, URL and body.
+	CacheTTL jsonDuration `json:"cacheTTL,omitempty"`
+	// Merge overlays the mock's JSON response body on the upstream JSON.
+	Merge bool `json:"merge,omitempty"`
+	// IgnoreErrors serves the mock without .Upstream when the fetch fails
+	// instead of answering 502.
+	IgnoreErrors bool `json:"ignoreErrors,omitempty"`
+}
+
+type upstreamResponse struct {
+	Status int
+	Header http.Header
+	Body   string
+	JSON   interface{}
+}
+
+// copy keeps cached responses unchanged by templates, scripts and merges.
+func (u *upstreamResponse) copy() *upstreamResponse {
+	copied := *u
+	copied.Header = u.Header.Clone()
+	copied.JSON = cloneJSON(u.JSON)
+	return &copied
+}
+
+type upstreamCacheEntry struct {
+	response *upstreamResponse
+	expires  time.Time
+}
+
+const maxUpstreamCacheEntries = 1000
+
+var (
+	upstreamClient = &http.Client{Timeout: envDuration("UPSTREAM_TIMEOUT", 10*time.Second)}
+
+	upstreamCacheMu sync.Mutex
+	upstreamCache   = make(map[string]upstreamCacheEntry)
+)
+
+func cachedUpstream(key string) *upstreamResponse {
+	upstreamCacheMu.Lock()
+	defer upstreamCacheMu.Unlock()
+	entry, ok := upstreamCache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(upstreamCache, key)
+		return nil
+	}
+	return entry.response
+}
+
+func cacheUpstream(key string, response *upstreamResponse, ttl time.Duration) {
+	upstreamCacheMu.Lock()
+	defer upstreamCacheMu.Unlock()
+	if len(upstreamCache) >= maxUpstreamCacheEntries {
+		now := time.Now()
+		for k, entry := range upstreamCache {
+			if now.After(entry.expires) {
+				delete(upstreamCache, k)
+			}
+		}
+		for k := range upstreamCache {
+			if len(upstreamCache) < maxUpstreamCacheEntries {
+				break
+			}
+			delete(upstreamCache, k)
+		}
+	}
+	upstreamCache[key] = upstreamCacheEntry{response: response, expires: time.Now().Add(ttl)}
+}
+
+// fetchUpstream renders the fetch with the request's data and performs it,
+// or returns a cached response.
+func fetchUpstream(opts *upstreamOptions, data templateData, r *http.Request) (*upstreamResponse, error) {
+	url, err := renderString("upstream-url", opts.URL, data)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering URL: %v", err)
+	}
+	body, err := renderString("upstream-body", opts.Body, data)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering body: %v", err)
+	}
+	method := strings.ToUpper(opts.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	key := method + " " + url + "\n" + body
+	if opts.CacheTTL > 0 {
+		if cached := cachedUpstream(key); cached != nil {
+			return cached.copy(), nil
+		}
+	}
+
+	ctx := r.Context()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.Timeout))
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range opts.ForwardHeaders {
+		for _, value := range r.Header.Values(name) {
+			req.Header.Add(name, value)
+		}
+	}
+	for name, value := range opts.Headers {
+		rendered, err := renderString("upstream-header", value, data)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering header %s: %v", name, err)
+		}
+		req.Header.Set(name, rendered)
+	}
+
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(io.LimitReader(resp.Body, maxRequestBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned %d", method, url, resp.StatusCode)
+	}
+
+	fetched := &upstreamResponse{Status: resp.StatusCode, Header: resp.Header, Body: string(responseBody)}
+	json.Unmarshal(responseBody, &fetched.JSON)
+	if opts.CacheTTL > 0 {
+		cacheUpstream(key, fetched, time.Duration(opts.CacheTTL))
+		return fetched.copy(), nil
+	}
+	return fetched, nil
+}
+
+// applyUpstream builds the response of a mock without a body, or with merge,
+// from the fetched response. It runs after templating so merged bodies can
+// use .Upstream too.
+func applyUpstream(mockResp *MockResponse, upstream *upstreamResponse) error {
+	if upstream == nil {
+		return nil
+	}
+	if mockResp.Options.Upstream.Merge && mockResp.ResponseBody != "" {
+		var overlay interface{}
+		if err := json.Unmarshal([]byte(mockResp.ResponseBody), &overlay); err != nil {
+			return fmt.Errorf("response body to merge is not JSON: %v", err)
+		}
+		if upstream.JSON == nil {
+			return fmt.Errorf("upstream response is not JSON")
+		}
+		merged, err := json.Marshal(mergeJSON(upstream.JSON, overlay))
+		if err != nil {
+			return err
+		}
+		mockResp.ResponseBody = string(merged)
+	} else if mockResp.ResponseBody == "" {
+		mockResp.ResponseBody = upstream.Body
+		if mockResp.contentType == "" {
+			mockResp.contentType = upstream.Header.Get("Content-Type")
+		}
+	}
+	return nil
+}
+
+// mergeJSON overlays objects key by key; any other overlay value replaces
+// the base, and null removes the key.
+func mergeJSON(base, overlay interface{}) interface{} {
+	baseObject, ok := base.(map[string]interface{})
+	overlayObject, overlayOK := overlay.(map[string]interface{})
+	if !ok || !overlayOK {
+		return overlay
+	}
+	for key, value := range overlayObject {
+		if value == nil {
+			delete(baseObject, key)
+			continue
+		}
+		baseObject[key] = mergeJSON(baseObject[key], value)
+	}
+	return baseObject
+}
+
+// cloneJSON copies a decoded JSON document.
+func cloneJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = cloneJSON(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = cloneJSON(item)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// requestExpectation describes how many journaled requests a verification
+// expects. With nothing set, at least one request is expected.
+type requestExpectation struct {
+	Count   *int64 `json:"count,omitempty"`
+	AtLeast *int64 `json:"atLeast,omitempty"`
+	AtMost  *int64 `json:"atMost,omitempty"`
+}
+
+func (e requestExpectation) check(n int64) (bool, string) {
+	if e.Count != nil {
+		if n != *e.Count {
+			return false, fmt.Sprintf("expected exactly %d requests, got %d", *e.Count, n)
+		}
+		return true, ""
+	}
+	atLeast := e.AtLeast
+	if atLeast == nil && e.AtMost == nil {
+		one := int64(1)
+		atLeast = &one
+	}
+	if atLeast != nil && n < *atLeast {
+		return false, fmt.Sprintf("expected at least %d requests, got %d", *atLeast, n)
+	}
+	if e.AtMost != nil && n > *e.AtMost {
+		return false, fmt.Sprintf("expected at most %d requests, got %d", *e.AtMost, n)
+	}
+	return true, ""
+}
+
+func countJournalRequests(ctx context.Context, filter replayFilter) (int64, error) {
+	if err := flushJournal(ctx); err != nil {
+		return 0, err
+	}
+	where, args := journalConditions(filter)
+	var count int64
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM return.request_journal"+where, args...).Scan(&count)
+	return count, err
+}
+
+// maxAssertionFailures bounds the failures reported per assertion; every
+// request is still checked and counted.
+const maxAssertionFailures = 10
+
+// requestAssertion checks a value sent in the body or a header of each
+// request a verification counts.
+type requestAssertion struct {
+	Pointer string  `json:"pointer,omitempty"`
+	Header  string  `json:"header,omitempty"`
+	Equals  *string `json:"equals,omitempty"`
+	Matches string  `json:"matches,omitempty"`
+	Exists  *bool   `json:"exists,omitempty"`
+
+	equals  interface{}
+	matches *regexp.Regexp
+}
+
+type assertionResult struct {
+	OK       bool               `json:"ok"`
+	Passed   int64              `json:"passed"`
+	Failed   int64              `json:"failed"`
+	Failures []assertionFailure `json:"failures,omitempty"`
+}
+
+type assertionFailure struct {
+	JournalID int64  `json:"journalId"`
+	RequestID string `json:"requestId,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+	Message   string `json:"message"`
+}
+
+// verifyRequest selects journaled requests, as the journal filters do, and
+// says how many are expected and what each must have sent.
+type verifyRequest struct {
+	Workspace  string    `json:"workspace,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	PathPrefix string    `json:"pathPrefix,omitempty"`
+	MockID     int       `json:"mockId,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+	requestExpectation
+	Assertions []*requestAssertion `json:"assertions,omitempty"`
+}
+
+type verifyResult struct {
+	OK         bool              `json:"ok"`
+	Count      int64             `json:"count"`
+	Message    string            `json:"message,omitempty"`
+	Assertions []assertionResult `json:"assertions,omitempty"`
+}
+
+// verifyJournal counts the journaled requests of a verification and checks
+// them against its expectation and assertions.
+func verifyJournal(ctx context.Context, req verifyRequest) (verifyResult, error) {
+	filter := replayFilter{
+		Workspace:  req.Workspace,
+		Method:     req.Method,
+		Path:       req.Path,
+		PathPrefix: req.PathPrefix,
+		MockID:     req.MockID,
+		Since:      req.Since,
+	}
+	count, err := countJournalRequests(ctx, filter)
+	if err != nil {
+		return verifyResult{}, err
+	}
+	result := verifyResult{Count: count}
+	result.OK, result.Message = req.requestExpectation.check(count)
+	if len(req.Assertions) == 0 {
+		return result, nil
+	}
+	if result.Assertions, err = checkJournalAssertions(ctx, filter, req.Assertions); err != nil {
+		return verifyResult{}, err
+	}
+	for i, assertion := range result.Assertions {
+		if !assertion.OK && result.OK {
+			result.OK = false
+			result.Message = fmt.Sprintf("assertion %d failed for %d of %d requests: %s", i, assertion.Failed, assertion.Passed+assertion.Failed, assertion.Failures[0].Message)
+		}
+	}
+	return result, nil
+}
+
+// verifyHandler is the REST form of the gRPC Verify call.
+func verifyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !journalEnabled {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "the request journal is not enabled; set JOURNAL_ENABLED=true"})
+		return
+	}
+	var req verifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	result, err := verifyJournal(r.Context(), req)
+	if err != nil {
+		writeAdminError(w, err, "error verifying requests")
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *requestAssertion) compile() error {
+	if a.Pointer != "" && !strings.HasPrefix(a.Pointer, "/") {
+		return fmt.Errorf("pointer %q must start with /", a.Pointer)
+	}
+	if a.Equals != nil {
+		if err := json.Unmarshal([]byte(*a.Equals), &a.equals); err != nil {
+			a.equals = *a.Equals
+		}
+	}
+	if a.Matches != "" {
+		var err error
+		if a.matches, err = regexp.Compile(a.Matches); err != nil {
+			return fmt.Errorf("invalid matches pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+func (a *requestAssertion) target() string {
+	if a.Header != "" {
+		return "header " + a.Header
+	}
+	if a.Pointer == "" {
+		return "body"
+	}
+	return "body " + a.Pointer
+}
+
+// check returns what is wrong with a request, or "" when it passes, along
+// with the value it sent.
+func (a *requestAssertion) check(headers http.Header, body string) (actual interface{}, problem string) {
+	var found bool
+	if a.Header != "" {
+		values := headers.Values(a.Header)
+		if found = len(values) > 0; found {
+			actual = strings.Join(values, ", ")
+		}
+	} else {
+		var doc interface{}
+		if err := json.Unmarshal(bytesOf(body), &doc); err != nil {
+			if a.Pointer != "" || body == "" {
+				if a.Exists != nil && !*a.Exists {
+					return nil, ""
+				}
+				return nil, "body is not JSON"
+			}
+			doc = body
+		}
+		actual, found = lookupJSONPointer(doc, a.Pointer)
+	}
+
+	target := a.target()
+	if a.Exists != nil && !*a.Exists {
+		if found {
+			return actual, target + " is present"
+		}
+		return nil, ""
+	}
+	if !found {
+		return nil, target + " is missing"
+	}
+	expected := a.equals
+	if a.Header != "" && a.Equals != nil {
+		// Header values are text, even when they look like JSON.
+		expected = *a.Equals
+	}
+	if a.Equals != nil && !reflect.DeepEqual(actual, expected) {
+		encoded, _ := json.Marshal(expected)
+		return actual, fmt.Sprintf("%s is not %s", target, encoded)
+	}
+	if a.matches != nil {
+		text, ok := actual.(string)
+		if a.Header == "" && a.Pointer == "" {
+			text, ok = body, true
+		}
+		if !ok {
+			encoded, _ := json.Marshal(actual)
+			text = string(encoded)
+		}
+		if !a.matches.MatchString(text) {
+			return actual, fmt.Sprintf("%s does not match %s", target, a.Matches)
+		}
+	}
+	return actual, ""
+}
+
+// checkJournalAssertions checks the assertions against all the journaled
+// requests of a filter, the oldest first, streaming them from the database.
+func checkJournalAssertions(ctx context.Context, filter replayFilter, assertions []*requestAssertion) ([]assertionResult, error) {
+	for i, assertion := range assertions {
+		if err := assertion.compile(); err != nil {
+			return nil, adminRequestError(fmt.Sprintf("assertion %d: %v", i, err))
+		}
+	}
+	where, args := journalConditions(filter)
+	rows, err := db.QueryContext(ctx, "SELECT id, COALESCE(request_id, ''), headers, body FROM return.request_journal"+where+
+		" ORDER BY received_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]assertionResult, len(assertions))
+	for rows.Next() {
+		var id int64
+		var requestID string
+		var rawHeaders []byte
+		var body sql.NullString
+		if err := rows.Scan(&id, &requestID, &rawHeaders, &body); err != nil {
+			return nil, err
+		}
+		var headers http.Header
+		if len(rawHeaders) > 0 {
+			json.Unmarshal(rawHeaders, &headers)
+		}
+		for i, assertion := range assertions {
+			actual, problem := assertion.check(headers, body.String)
+			if problem == "" {
+				results[i].Passed++
+				continue
+			}
+			results[i].Failed++
+			if len(results[i].Failures) < maxAssertionFailures {
+				failure := assertionFailure{JournalID: id, RequestID: requestID, Message: problem}
+				if actual != nil {
+					encoded, _ := json.Marshal(actual)
+					failure.Actual = string(encoded)
+				}
+				results[i].Failures = append(results[i].Failures, failure)
+			}
+		}
+	}
+	for i := range results {
+		results[i].OK = results[i].Failed == 0
+	}
+	return results, rows.Err()
+}
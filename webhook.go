@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type webhookOptions struct {
+	URL          string            `json:"url"`
+	Method       string            `json:"method,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	Delay        jsonDuration      `json:"delay,omitempty"`
+	Retries      int               `json:"retries,omitempty"`
+	RetryBackoff jsonDuration      `json:"retryBackoff,omitempty"`
+}
+
+type webhookRequest struct {
+	method  string
+	url     string
+	headers map[string]string
+	body    string
+	delay   time.Duration
+	retries int
+	backoff time.Duration
+}
+
+var webhookClient = &http.Client{Timeout: envDuration("WEBHOOK_TIMEOUT", 10*time.Second)}
+
+func fireWebhooks(mockResp *MockResponse, data templateData) {
+	for i, hook := range mockResp.Options.Webhooks {
+		req, err := renderWebhook(hook, data)
+		if err != nil {
+			log.Printf("Webhook %d of mock %d not sent: %v", i, mockResp.ID, err)
+			continue
+		}
+		go req.send(mockResp.ID)
+	}
+}
+
+func renderWebhook(hook webhookOptions, data templateData) (*webhookRequest, error) {
+	url, err := renderString("webhook-url", hook.URL, data)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering URL: %v", err)
+	}
+	body, err := renderString("webhook-body", hook.Body, data)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering body: %v", err)
+	}
+
+	data.Payload = body
+	headers := make(map[string]string, len(hook.Headers))
+	for key, value := range hook.Headers {
+		rendered, err := renderString("webhook-header", value, data)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering header %s: %v", key, err)
+		}
+		headers[key] = rendered
+	}
+	if _, ok := headers[requestIDHeader]; !ok && data.RequestID != "" {
+		headers[requestIDHeader] = data.RequestID
+	}
+
+	method := strings.ToUpper(hook.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	backoff := time.Duration(hook.RetryBackoff)
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	return &webhookRequest{
+		method:  method,
+		url:     url,
+		headers: headers,
+		body:    body,
+		delay:   time.Duration(hook.Delay),
+		retries: hook.Retries,
+		backoff: backoff,
+	}, nil
+}
+
+func (h *webhookRequest) send(mockID int) {
+	time.Sleep(h.delay)
+
+	backoff := h.backoff
+	for attempt := 0; ; attempt++ {
+		err := h.attempt()
+		if err == nil {
+			return
+		}
+		if attempt >= h.retries {
+			log.Printf("Webhook %s %s for mock %d failed after %d attempts: %v", h.method, h.url, mockID, attempt+1, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (h *webhookRequest) attempt() error {
+	req, err := http.NewRequest(h.method, h.url, strings.NewReader(h.body))
+	if err != nil {
+		return err
+	}
+	for key, value := range h.headers {
+		req.Header.Set(key, value)
+	}
+	if req.Header.Get("Content-Type") == "" && h.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
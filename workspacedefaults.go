@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// workspaceDefaults make the responses of a workspace look like the
+// platform it stands in for: headers on every response, and errors of the
+// router itself in the platform's error format.
+type workspaceDefaults struct {
+	Workspace string `json:"workspace"`
+	// Headers are set on every response unless the mock sets them itself.
+	Headers map[string]string `json:"headers,omitempty"`
+	// ErrorBody is a template for the router's errors, such as 404 for
+	// unmatched requests, 500 and validation failures, with .Status,
+	// .Message and .Violations next to the request's fields.
+	ErrorBody        string `json:"errorBody,omitempty"`
+	ErrorContentType string `json:"errorContentType,omitempty"`
+}
+
+// errorTemplateData is what an error body template is rendered with.
+type errorTemplateData struct {
+	templateData
+	Status     int
+	Message    string
+	Violations interface{}
+}
+
+type workspaceDefaultsRegistry struct {
+	mu       sync.RWMutex
+	defaults map[string]workspaceDefaults
+}
+
+var workspaceSettings = &workspaceDefaultsRegistry{defaults: make(map[string]workspaceDefaults)}
+
+// loadWorkspaceDefaults reads the defaults of workspaces from a JSON file
+// holding an array of them.
+func loadWorkspaceDefaults(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading workspace defaults: %v", err)
+	}
+	var defined []workspaceDefaults
+	if err := json.Unmarshal(data, &defined); err != nil {
+		return fmt.Errorf("invalid workspace defaults: %v", err)
+	}
+	for _, def := range defined {
+		if err := workspaceSettings.define(def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *workspaceDefaultsRegistry) define(def workspaceDefaults) error {
+	def.Workspace = strings.TrimSpace(def.Workspace)
+	if def.Workspace == "" {
+		return fmt.Errorf("workspace is required")
+	}
+	if def.ErrorBody != "" {
+		if _, err := compileTemplate("workspace-error", def.ErrorBody); err != nil {
+			return fmt.Errorf("workspace %s: invalid errorBody: %v", def.Workspace, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaults[def.Workspace] = def
+	return nil
+}
+
+func (s *workspaceDefaultsRegistry) lookup(workspace string) (workspaceDefaults, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	def, ok := s.defaults[workspace]
+	return def, ok
+}
+
+// applyWorkspaceHeaders sets the default headers of the request's workspace,
+// before anything else writes headers so that the mock's own win.
+func applyWorkspaceHeaders(w http.ResponseWriter, r *http.Request) {
+	def, ok := workspaceSettings.lookup(requestWorkspace(r))
+	if !ok {
+		return
+	}
+	for name, value := range def.Headers {
+		w.Header().Set(name, value)
+	}
+}
+
+// writeRouterError answers with an error of the router itself, in the
+// workspace's error format when it has one and as plain text otherwise.
+func writeRouterError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !writeWorkspaceError(w, r, status, message, nil) {
+		http.Error(w, message, status)
+	}
+}
+
+// writeValidationError answers a request that failed validation, in the
+// workspace's error format when it has one.
+func writeValidationError(w http.ResponseWriter, r *http.Request, message string, violations interface{}) {
+	if !writeWorkspaceError(w, r, http.StatusBadRequest, message, violations) {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": message, "violations": violations})
+	}
+}
+
+// writeWorkspaceError renders the workspace's error body. It returns false
+// when the workspace has none, or it failed to render.
+func writeWorkspaceError(w http.ResponseWriter, r *http.Request, status int, message string, violations interface{}) bool {
+	def, ok := workspaceSettings.lookup(requestWorkspace(r))
+	if !ok || def.ErrorBody == "" {
+		return false
+	}
+	tmpl, err := compileTemplate("workspace-error", def.ErrorBody)
+	if err == nil {
+		tmpl, err = tmpl.Clone()
+	}
+	var body string
+	if err == nil {
+		data := errorTemplateData{templateData: newTemplateData(r, ""), Status: status, Message: message, Violations: violations}
+		body, err = executeTemplate(tmpl.Funcs(requestTemplateFuncs(data.templateData)), data)
+	}
+	if err != nil {
+		requestLogf(r, "Error body of workspace %s failed: %v", def.Workspace, err)
+		return false
+	}
+	contentType := def.ErrorContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+	return true
+}
+
+func listWorkspaceDefaultsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	workspaceSettings.mu.RLock()
+	list := make([]workspaceDefaults, 0, len(workspaceSettings.defaults))
+	for _, def := range workspaceSettings.defaults {
+		list = append(list, def)
+	}
+	workspaceSettings.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Workspace < list[j].Workspace })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func putWorkspaceDefaultsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var def workspaceDefaults
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid workspace defaults: " + err.Error()})
+		return
+	}
+	def.Workspace = ps.ByName("workspace")
+	if err := workspaceSettings.define(def); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Defaults of workspace %s defined", def.Workspace)
+	writeJSON(w, http.StatusOK, def)
+}
+
+func deleteWorkspaceDefaultsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	workspace := ps.ByName("workspace")
+	workspaceSettings.mu.Lock()
+	_, ok := workspaceSettings.defaults[workspace]
+	delete(workspaceSettings.defaults, workspace)
+	workspaceSettings.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "workspace has no defaults"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	wsdlSOAP11Namespace = "http://schemas.xmlsoap.org/wsdl/soap/"
+	wsdlSOAP12Namespace = "http://schemas.xmlsoap.org/wsdl/soap12/"
+)
+
+// The subset of WSDL 1.1 and XML Schema needed to scaffold mocks.
+type wsdlDefinitions struct {
+	TargetNamespace string         `xml:"targetNamespace,attr"`
+	Schemas         []xsdSchema    `xml:"types>schema"`
+	Messages        []wsdlMessage  `xml:"message"`
+	PortTypes       []wsdlPortType `xml:"portType"`
+	Bindings        []wsdlBinding  `xml:"binding"`
+	Services        []wsdlService  `xml:"service"`
+}
+
+type wsdlMessage struct {
+	Name  string `xml:"name,attr"`
+	Parts []struct {
+		Name    string `xml:"name,attr"`
+		Element string `xml:"element,attr"`
+		Type    string `xml:"type,attr"`
+	} `xml:"part"`
+}
+
+type wsdlPortType struct {
+	Name       string `xml:"name,attr"`
+	Operations []struct {
+		Name  string `xml:"name,attr"`
+		Input struct {
+			Message string `xml:"message,attr"`
+		} `xml:"input"`
+		Output struct {
+			Message string `xml:"message,attr"`
+		} `xml:"output"`
+	} `xml:"operation"`
+}
+
+type wsdlBinding struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+	SOAP []struct {
+		XMLName xml.Name
+		Style   string `xml:"style,attr"`
+	} `xml:"binding"`
+	Operations []struct {
+		Name string `xml:"name,attr"`
+		SOAP []struct {
+			Style string `xml:"style,attr"`
+		} `xml:"operation"`
+		Input struct {
+			Body []struct {
+				Namespace string `xml:"namespace,attr"`
+			} `xml:"body"`
+		} `xml:"input"`
+	} `xml:"operation"`
+}
+
+type wsdlService struct {
+	Name  string `xml:"name,attr"`
+	Ports []struct {
+		Binding string `xml:"binding,attr"`
+		Address []struct {
+			XMLName  xml.Name
+			Location string `xml:"location,attr"`
+		} `xml:"address"`
+	} `xml:"port"`
+}
+
+type xsdSchema struct {
+	TargetNamespace    string           `xml:"targetNamespace,attr"`
+	ElementFormDefault string           `xml:"elementFormDefault,attr"`
+	Elements           []xsdElement     `xml:"element"`
+	ComplexTypes       []xsdComplexType `xml:"complexType"`
+	SimpleTypes        []xsdSimpleType  `xml:"simpleType"`
+}
+
+type xsdElement struct {
+	Name        string          `xml:"name,attr"`
+	Type        string          `xml:"type,attr"`
+	Ref         string          `xml:"ref,attr"`
+	MinOccurs   string          `xml:"minOccurs,attr"`
+	ComplexType *xsdComplexType `xml:"complexType"`
+	SimpleType  *xsdSimpleType  `xml:"simpleType"`
+}
+
+type xsdComplexType struct {
+	Name     string       `xml:"name,attr"`
+	Sequence []xsdElement `xml:"sequence>element"`
+	All      []xsdElement `xml:"all>element"`
+	Choice   []xsdElement `xml:"choice>element"`
+	Extends  *struct {
+		Base     string       `xml:"base,attr"`
+		Sequence []xsdElement `xml:"sequence>element"`
+	} `xml:"complexContent>extension"`
+}
+
+type xsdSimpleType struct {
+	Name        string `xml:"name,attr"`
+	Restriction struct {
+		Base         string `xml:"base,attr"`
+		Enumerations []struct {
+			Value string `xml:"value,attr"`
+		} `xml:"enumeration"`
+	} `xml:"restriction"`
+}
+
+func localName(qname string) string {
+	if i := strings.LastIndex(qname, ":"); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
+
+// wsdlSampler writes sample XML for schema elements and types.
+type wsdlSampler struct {
+	elements     map[string]xsdElement
+	complexTypes map[string]xsdComplexType
+	simpleTypes  map[string]xsdSimpleType
+	namespace    map[string]string
+	qualified    map[string]bool
+}
+
+func newWSDLSampler(defs *wsdlDefinitions) *wsdlSampler {
+	s := &wsdlSampler{
+		elements:     make(map[string]xsdElement),
+		complexTypes: make(map[string]xsdComplexType),
+		simpleTypes:  make(map[string]xsdSimpleType),
+		namespace:    make(map[string]string),
+		qualified:    make(map[string]bool),
+	}
+	for _, schema := range defs.Schemas {
+		for _, element := range schema.Elements {
+			s.elements[element.Name] = element
+			s.namespace[element.Name] = schema.TargetNamespace
+			s.qualified[element.Name] = schema.ElementFormDefault == "qualified"
+		}
+		for _, complexType := range schema.ComplexTypes {
+			s.complexTypes[complexType.Name] = complexType
+		}
+		for _, simpleType := range schema.SimpleTypes {
+			s.simpleTypes[simpleType.Name] = simpleType
+		}
+	}
+	return s
+}
+
+func sampleValue(xsdType string) string {
+	switch localName(xsdType) {
+	case "int", "integer", "long", "short", "byte", "decimal", "nonNegativeInteger", "positiveInteger",
+		"unsignedInt", "unsignedLong", "unsignedShort", "unsignedByte":
+		return "0"
+	case "double", "float":
+		return "0.0"
+	case "boolean":
+		return "false"
+	case "dateTime":
+		return "2024-01-01T00:00:00Z"
+	case "date":
+		return "2024-01-01"
+	case "time":
+		return "00:00:00"
+	}
+	return "string"
+}
+
+// writeElement writes element with a sample value; children are prefixed
+// when their schema qualifies local elements. depth stops recursive types.
+func (s *wsdlSampler) writeElement(b *strings.Builder, element xsdElement, prefix string, depth int) {
+	if element.Ref != "" {
+		if referenced, ok := s.elements[localName(element.Ref)]; ok {
+			element = referenced
+		}
+	}
+	b.WriteString("<" + prefix + element.Name + ">")
+	s.writeContent(b, element, prefix, depth)
+	b.WriteString("</" + prefix + element.Name + ">")
+}
+
+func (s *wsdlSampler) writeContent(b *strings.Builder, element xsdElement, prefix string, depth int) {
+	if depth > 8 {
+		return
+	}
+	if element.ComplexType != nil {
+		s.writeComplex(b, *element.ComplexType, prefix, depth)
+		return
+	}
+	if element.SimpleType != nil {
+		b.WriteString(xmlEscape(s.simpleSample(*element.SimpleType)))
+		return
+	}
+	if complexType, ok := s.complexTypes[localName(element.Type)]; ok {
+		s.writeComplex(b, complexType, prefix, depth)
+		return
+	}
+	if simpleType, ok := s.simpleTypes[localName(element.Type)]; ok {
+		b.WriteString(xmlEscape(s.simpleSample(simpleType)))
+		return
+	}
+	b.WriteString(sampleValue(element.Type))
+}
+
+func (s *wsdlSampler) simpleSample(simpleType xsdSimpleType) string {
+	if len(simpleType.Restriction.Enumerations) > 0 {
+		return simpleType.Restriction.Enumerations[0].Value
+	}
+	return sampleValue(simpleType.Restriction.Base)
+}
+
+func (s *wsdlSampler) writeComplex(b *strings.Builder, complexType xsdComplexType, prefix string, depth int) {
+	children := append(append([]xsdElement{}, complexType.Sequence...), complexType.All...)
+	if len(complexType.Choice) > 0 {
+		children = append(children, complexType.Choice[0])
+	}
+	if ext := complexType.Extends; ext != nil {
+		if base, ok := s.complexTypes[localName(ext.Base)]; ok {
+			s.writeComplex(b, base, prefix, depth+1)
+		}
+		children = append(children, ext.Sequence...)
+	}
+	for _, child := range children {
+		s.writeElement(b, child, prefix, depth+1)
+	}
+}
+
+// wsdlOperation is one operation of a SOAP binding.
+type wsdlOperation struct {
+	service, name, bodyElement, version, path string
+	response                                  string
+}
+
+// parseWSDL lists the operations of every SOAP port of the document, with a
+// sample response envelope for each.
+func parseWSDL(data []byte) ([]wsdlOperation, error) {
+	var defs wsdlDefinitions
+	if err := xml.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("invalid WSDL: %v", err)
+	}
+	sampler := newWSDLSampler(&defs)
+	messages := make(map[string]wsdlMessage)
+	for _, message := range defs.Messages {
+		messages[message.Name] = message
+	}
+	portTypes := make(map[string]wsdlPortType)
+	for _, portType := range defs.PortTypes {
+		portTypes[portType.Name] = portType
+	}
+	bindings := make(map[string]wsdlBinding)
+	for _, binding := range defs.Bindings {
+		bindings[binding.Name] = binding
+	}
+
+	var operations []wsdlOperation
+	seen := make(map[string]bool)
+	for _, service := range defs.Services {
+		for _, port := range service.Ports {
+			binding, ok := bindings[localName(port.Binding)]
+			if !ok || len(port.Address) == 0 || len(binding.SOAP) == 0 {
+				continue
+			}
+			version := ""
+			switch port.Address[0].XMLName.Space {
+			case wsdlSOAP11Namespace:
+				version = "1.1"
+			case wsdlSOAP12Namespace:
+				version = "1.2"
+			default:
+				continue
+			}
+			address, err := url.Parse(port.Address[0].Location)
+			if err != nil {
+				return nil, fmt.Errorf("port of service %s: invalid address: %v", service.Name, err)
+			}
+			path := address.Path
+			if path == "" {
+				path = "/"
+			}
+			portType := portTypes[localName(binding.Type)]
+
+			for _, bindingOp := range binding.Operations {
+				key := version + " " + path + " " + bindingOp.Name
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				style := binding.SOAP[0].Style
+				if len(bindingOp.SOAP) > 0 && bindingOp.SOAP[0].Style != "" {
+					style = bindingOp.SOAP[0].Style
+				}
+				namespace := defs.TargetNamespace
+				if len(bindingOp.Input.Body) > 0 && bindingOp.Input.Body[0].Namespace != "" {
+					namespace = bindingOp.Input.Body[0].Namespace
+				}
+				op := wsdlOperation{service: service.Name, name: bindingOp.Name, bodyElement: bindingOp.Name, version: version, path: path}
+				for _, portOp := range portType.Operations {
+					if portOp.Name != bindingOp.Name {
+						continue
+					}
+					input, output := messages[localName(portOp.Input.Message)], messages[localName(portOp.Output.Message)]
+					if style != "rpc" && len(input.Parts) > 0 && input.Parts[0].Element != "" {
+						op.bodyElement = localName(input.Parts[0].Element)
+					}
+					op.response = sampler.responseBody(output, style, bindingOp.Name, namespace)
+				}
+				operations = append(operations, op)
+			}
+		}
+	}
+	if len(operations) == 0 {
+		return nil, fmt.Errorf("WSDL has no SOAP operations")
+	}
+	return operations, nil
+}
+
+func (s *wsdlSampler) responseBody(output wsdlMessage, style, operation, namespace string) string {
+	var b strings.Builder
+	if style == "rpc" {
+		b.WriteString(`<tns:` + operation + `Response xmlns:tns="` + xmlEscape(namespace) + `">`)
+		for _, part := range output.Parts {
+			b.WriteString("<" + part.Name + ">")
+			s.writeContent(&b, xsdElement{Name: part.Name, Type: part.Type}, "", 0)
+			b.WriteString("</" + part.Name + ">")
+		}
+		b.WriteString(`</tns:` + operation + `Response>`)
+		return b.String()
+	}
+	for _, part := range output.Parts {
+		name := localName(part.Element)
+		element, ok := s.elements[name]
+		if !ok {
+			continue
+		}
+		childPrefix := ""
+		if s.qualified[name] {
+			childPrefix = "tns:"
+		}
+		b.WriteString(`<tns:` + name + ` xmlns:tns="` + xmlEscape(s.namespace[name]) + `">`)
+		s.writeContent(&b, element, childPrefix, 0)
+		b.WriteString(`</tns:` + name + `>`)
+	}
+	return b.String()
+}
+
+func soapEnvelope(version, body string) string {
+	namespace := soap11Namespace
+	if version == "1.2" {
+		namespace = soap12Namespace
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<soap:Envelope xmlns:soap="` + namespace + `"><soap:Body>` + body + `</soap:Body></soap:Envelope>`
+}
+
+// wsdlMockDefinitions scaffolds one mock per operation, matched on the
+// operation's body element and answering with a sample response envelope.
+func wsdlMockDefinitions(operations []wsdlOperation, pathOverride, workspace string) []mockDefinition {
+	var defs []mockDefinition
+	for _, op := range operations {
+		path := op.path
+		if pathOverride != "" {
+			path = pathOverride
+		}
+		contentType := "text/xml"
+		if op.version == "1.2" {
+			contentType = "application/soap+xml"
+		}
+		options, _ := json.Marshal(map[string]interface{}{"soap": soapOptions{Operation: op.bodyElement, Version: op.version}})
+		defs = append(defs, mockDefinition{
+			Path:         path,
+			Method:       http.MethodPost,
+			ResponseBody: soapEnvelope(op.version, op.response),
+			Headers:      "Content-Type=" + contentType,
+			Workspace:    workspace,
+			Options:      options,
+			Labels:       map[string]string{"wsdl-service": op.service, "soap-operation": op.name},
+		})
+	}
+	sort.SliceStable(defs, func(i, j int) bool { return defs[i].Path < defs[j].Path })
+	return defs
+}
+
+// importWSDLHandler creates operation mocks from the WSDL in the request
+// body. With dryRun=true the mocks are returned without being saved.
+func importWSDLHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "error reading WSDL"})
+		return
+	}
+	operations, err := parseWSDL(data)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	query := r.URL.Query()
+	defs := wsdlMockDefinitions(operations, query.Get("path"), query.Get("workspace"))
+	if dryRun, _ := strconv.ParseBool(query.Get("dryRun")); dryRun {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"mocks": defs})
+		return
+	}
+
+	force, _ := strconv.ParseBool(query.Get("force"))
+	conflicts, err := createMocks(r.Context(), defs, force)
+	if err != nil {
+		writeAdminError(w, err, "error saving mocks")
+		return
+	}
+	response := map[string]interface{}{"mocks": defs}
+	if len(conflicts) > 0 {
+		response["conflicts"] = conflicts
+	}
+	writeJSON(w, http.StatusCreated, response)
+}